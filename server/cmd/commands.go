@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/services"
+)
+
+const usage = `Usage:
+  server                                                   run the GraphQL server
+  server apikey create -user <username> -role <role> [-name <name>]
+  server apikey list
+  server apikey revoke -id <id>
+  server user set-role -user <username> -role <role>
+  server search claim -user <username>
+  server search migrate-titles
+  server export -user <username> [-search <id>] [-format csv|json|markdown] [-out <file>]
+  server bundle export -user <username> [-search <id>] [-out <file>]
+  server bundle import -user <username> -in <file> [-conflict skip|overwrite|duplicate] [-dry-run]
+  server schema print [-out <file>] [-federation]
+  server schema check [-baseline <file>]
+
+Roles are reader, editor and admin.
+`
+
+// commands are keyed by their words; the arguments after them are flags
+var commands = map[string]func(svc *services.CharacterServiceImpl, args []string) error{
+	"apikey create":         createAPIKey,
+	"apikey list":           listAPIKeys,
+	"apikey revoke":         revokeAPIKey,
+	"user set-role":         setUserRole,
+	"search claim":          claimSearches,
+	"search migrate-titles": migrateSearchTitles,
+	"export":                exportSearches,
+	"bundle export":         exportBundle,
+	"bundle import":         importBundle,
+}
+
+// schemaCommands only need the schema, so they run without a database
+var schemaCommands = map[string]func(args []string) error{
+	"schema print": printSchema,
+	"schema check": checkSchema,
+}
+
+// runCommand - Runs an admin command against the configured database and returns the exit code
+func runCommand(args []string) int {
+	if len(args) >= 2 {
+		if run, ok := schemaCommands[strings.Join(args[:2], " ")]; ok {
+			if err := run(args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+			return 0
+		}
+	}
+
+	var run func(svc *services.CharacterServiceImpl, args []string) error
+	var flags []string
+	for words := 2; words >= 1 && run == nil; words-- {
+		if len(args) >= words {
+			run, flags = commands[strings.Join(args[:words], " ")], args[words:]
+		}
+	}
+
+	if run == nil {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	// Exports and bundles fetch characters from the SWAPI if they're no longer cached
+	svc, err := services.NewService(services.NewSWAPIClient(&http.Client{}, swapiBaseURL))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = svc.Close(ctx)
+	}()
+
+	if err := run(svc, flags); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// createAPIKey - Creates an API key and prints it; it can't be shown again
+func createAPIKey(svc *services.CharacterServiceImpl, args []string) error {
+	flags := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+	username := flags.String("user", "", "username the key acts as")
+	roleName := flags.String("role", string(services.RoleReader), "role the key is limited to")
+	name := flags.String("name", "", "name to tell the key apart")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		return fmt.Errorf("-user is required")
+	}
+
+	role, err := services.ParseRole(*roleName)
+	if err != nil {
+		return err
+	}
+
+	key, apiKey, err := svc.CreateAPIKey(*username, *name, role)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created API key %s (%s) for %s\n", apiKey.ID, apiKey.Role, *username)
+	fmt.Printf("Key: %s\n", key)
+	fmt.Println("Store the key now, it can't be shown again.")
+
+	return nil
+}
+
+// listAPIKeys - Prints all API keys without the keys themselves
+func listAPIKeys(svc *services.CharacterServiceImpl, args []string) error {
+	apiKeys, err := svc.GetAPIKeys()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPREFIX\tUSER ID\tROLE\tCREATED\tREVOKED")
+	for _, apiKey := range apiKeys {
+		revoked := "-"
+		if apiKey.RevokedAt != nil {
+			revoked = apiKey.RevokedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", apiKey.ID, apiKey.Name, apiKey.Prefix, apiKey.UserID, apiKey.Role, apiKey.CreatedAt.Format(time.RFC3339), revoked)
+	}
+
+	return w.Flush()
+}
+
+// revokeAPIKey - Revokes an API key
+func revokeAPIKey(svc *services.CharacterServiceImpl, args []string) error {
+	flags := flag.NewFlagSet("apikey revoke", flag.ContinueOnError)
+	id := flags.String("id", "", "ID of the key to revoke")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *id == "" {
+		return fmt.Errorf("-id is required")
+	}
+
+	result, err := svc.RevokeAPIKey(*id)
+	if err != nil {
+		return err
+	}
+
+	if !result {
+		return fmt.Errorf("API key %s not found or already revoked", *id)
+	}
+
+	fmt.Printf("Revoked API key %s\n", *id)
+	return nil
+}
+
+// setUserRole - Sets the role of a user
+func setUserRole(svc *services.CharacterServiceImpl, args []string) error {
+	flags := flag.NewFlagSet("user set-role", flag.ContinueOnError)
+	username := flags.String("user", "", "username to change")
+	roleName := flags.String("role", "", "new role")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		return fmt.Errorf("-user is required")
+	}
+
+	role, err := services.ParseRole(*roleName)
+	if err != nil {
+		return err
+	}
+
+	result, err := svc.SetUserRole(*username, role)
+	if err != nil {
+		return err
+	}
+
+	if !result {
+		return fmt.Errorf("user %s not found", *username)
+	}
+
+	fmt.Printf("Set the role of %s to %s\n", *username, role)
+	return nil
+}
+
+// claimSearches - Gives the searches saved before accounts existed, which have no owner, to a user
+func claimSearches(svc *services.CharacterServiceImpl, args []string) error {
+	flags := flag.NewFlagSet("search claim", flag.ContinueOnError)
+	username := flags.String("user", "", "username the searches are given to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		return fmt.Errorf("-user is required")
+	}
+
+	claimed, err := svc.ClaimOwnerlessSearches(*username)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Gave %d saved searches without an owner to %s\n", claimed, *username)
+	return nil
+}
+
+// migrateSearchTitles - Removes the cleared search titles that older versions stored as ""
+func migrateSearchTitles(svc *services.CharacterServiceImpl, args []string) error {
+	flags := flag.NewFlagSet("search migrate-titles", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	removed, err := svc.RemoveEmptySearchTitles()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed the empty title of %d searches\n", removed)
+	return nil
+}
+
+// exportSearches - Writes a saved search of a user, or all of them, to a file or stdout
+func exportSearches(svc *services.CharacterServiceImpl, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	username := flags.String("user", "", "username whose searches are exported")
+	searchID := flags.String("search", "", "ID of the search to export; all saved searches if empty")
+	formatName := flags.String("format", string(services.ExportFormatCSV), "csv, json or markdown")
+	out := flags.String("out", "", "file to write to; stdout if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	format, err := services.ParseExportFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	user, err := lookupUser(svc, *username)
+	if err != nil {
+		return err
+	}
+
+	exports, err := svc.ExportSearches(user.ID, *searchID)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := services.WriteExport(w, format, exports); err != nil {
+		return err
+	}
+
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d searches to %s\n", len(exports), *out)
+	}
+	return nil
+}
+
+// lookupUser - Gets a user by username, returning an error if there is none
+func lookupUser(svc *services.CharacterServiceImpl, username string) (*services.User, error) {
+	if username == "" {
+		return nil, fmt.Errorf("-user is required")
+	}
+
+	user, err := svc.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return nil, fmt.Errorf("user %s not found", username)
+	}
+
+	return user, nil
+}
+
+// exportBundle - Writes a saved search of a user, or all of them, to a bundle that can be imported elsewhere
+func exportBundle(svc *services.CharacterServiceImpl, args []string) error {
+	flags := flag.NewFlagSet("bundle export", flag.ContinueOnError)
+	username := flags.String("user", "", "username whose searches are exported")
+	searchID := flags.String("search", "", "ID of the search to export; all saved searches if empty")
+	out := flags.String("out", "", "file to write to; stdout if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	user, err := lookupUser(svc, *username)
+	if err != nil {
+		return err
+	}
+
+	bundle, err := svc.ExportBundle(user.ID, *searchID)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := services.WriteBundle(w, bundle); err != nil {
+		return err
+	}
+
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d searches to %s\n", len(bundle.Searches), *out)
+	}
+	return nil
+}
+
+// importBundle - Imports the searches of a bundle for a user and prints what was done
+func importBundle(svc *services.CharacterServiceImpl, args []string) error {
+	flags := flag.NewFlagSet("bundle import", flag.ContinueOnError)
+	username := flags.String("user", "", "username the searches are imported for")
+	in := flags.String("in", "", "bundle file to import")
+	conflictName := flags.String("conflict", "skip", "what to do with searches the user already has: skip, overwrite or duplicate")
+	dryRun := flags.Bool("dry-run", false, "only print what would be imported")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *in == "" {
+		return fmt.Errorf("-in is required")
+	}
+
+	conflict, err := services.ParseImportConflict(*conflictName)
+	if err != nil {
+		return err
+	}
+
+	user, err := lookupUser(svc, *username)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	bundle, err := services.ReadBundle(file)
+	if err != nil {
+		return err
+	}
+
+	report, err := svc.ImportSearches(user.ID, *bundle, conflict, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tSEARCH\tBUNDLE ID\tSEARCH ID")
+	for _, search := range report.Searches {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", search.Action, search.SearchKey, search.SourceID, search.SearchID)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if report.DryRun {
+		fmt.Print("Dry run, nothing was imported. Would have ")
+	} else {
+		fmt.Print("Imported: ")
+	}
+	fmt.Printf("%d created, %d overwritten, %d skipped\n", report.Created, report.Overwritten, report.Skipped)
+	return nil
+}
+
+// currentSchema - Returns the SDL of the schema the server runs, or the SDL it serves as a federation subgraph
+func currentSchema(federation bool) (string, error) {
+	schema, err := services.NewSchema(&services.CharacterServiceImpl{})
+	if err != nil {
+		return "", err
+	}
+	if federation {
+		return services.PrintSubgraphSchema(&schema), nil
+	}
+	return services.PrintSchema(&schema), nil
+}
+
+// printSchema - Writes the schema in SDL to a file or stdout, e.g. to update schema.graphql
+func printSchema(args []string) error {
+	flags := flag.NewFlagSet("schema print", flag.ContinueOnError)
+	out := flags.String("out", "", "file to write to; stdout if empty")
+	federation := flags.Bool("federation", false, "print the SDL of the federation subgraph, with the @key of every entity")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	sdl, err := currentSchema(*federation)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err := fmt.Print(sdl)
+		return err
+	}
+	return os.WriteFile(*out, []byte(sdl), 0o644)
+}
+
+// checkSchema - Prints the changes of the schema that can break clients of the baseline, failing if there are any
+func checkSchema(args []string) error {
+	flags := flag.NewFlagSet("schema check", flag.ContinueOnError)
+	baselinePath := flags.String("baseline", "schema.graphql", "SDL of the schema clients are written against")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	baseline, err := os.ReadFile(*baselinePath)
+	if err != nil {
+		return err
+	}
+
+	sdl, err := currentSchema(false)
+	if err != nil {
+		return err
+	}
+
+	changes, err := services.FindBreakingChanges(string(baseline), sdl)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("%d breaking changes from %s", len(changes), *baselinePath)
+	}
+
+	if sdl != string(baseline) {
+		fmt.Printf("No breaking changes; run `go run ./cmd/ schema print -out %s` to update the baseline\n", *baselinePath)
+	} else {
+		fmt.Println("No changes")
+	}
+	return nil
+}
@@ -16,14 +16,44 @@ import (
 	"github.com/caarlos0/env/v10"
 )
 
+// Base URL of the Star Wars API
+const swapiBaseURL = "https://swapi.dev/api"
+
 type Config struct {
 	Pretty   bool   `env:"PRETTY" envDefault:"true"`
 	GraphiQL bool   `env:"GRAPHIQL" envDefault:"true"`
 	Port     string `env:"PORT" envDefault:"8080"`
+	// Hides the details of internal, storage and upstream errors from clients
+	Production bool `env:"PRODUCTION" envDefault:"false"`
+	// Serves the schema as an Apollo Federation v2 subgraph, with _service and _entities
+	Federation bool `env:"FEDERATION" envDefault:"false"`
+	// Comma separated list of origins allowed to call the API from a browser
+	CORSAllowedOrigins []string `env:"CORS_ALLOWED_ORIGINS" envSeparator:"," envDefault:"http://localhost:8081,http://localhost:3000"`
+	// Limits of GraphQL operations, checked before they run; 0 disables a limit
+	MaxQueryDepth int `env:"MAX_QUERY_DEPTH" envDefault:"10"`
+	MaxQueryCost  int `env:"MAX_QUERY_COST" envDefault:"1000"`
+	QueryListSize int `env:"QUERY_LIST_SIZE" envDefault:"10"`
+	// Costs of fields overriding the defaults, e.g. "Film.characters:20,Query.film:8"
+	QueryFieldCosts map[string]int `env:"QUERY_FIELD_COSTS"`
+	// Manifest of the operations the UI sends, e.g. persisted-queries.json
+	PersistedQueriesManifest string `env:"PERSISTED_QUERIES_MANIFEST"`
+	// Only runs the operations of the manifest; turn on in production
+	PersistedQueriesOnly bool `env:"PERSISTED_QUERIES_ONLY" envDefault:"false"`
+	// Number of queries registered by clients kept in memory
+	PersistedQueriesCacheSize int `env:"PERSISTED_QUERIES_CACHE_SIZE" envDefault:"1000"`
+	// Most operations a batch may have; 0 turns batching off
+	MaxBatchSize int `env:"MAX_BATCH_SIZE" envDefault:"10"`
+	// Largest request body the GraphQL endpoint reads, in bytes; 0 turns the limit off
+	MaxRequestBodyBytes int64 `env:"MAX_REQUEST_BODY_BYTES" envDefault:"1048576"`
 }
 
 // Entry point of the application
+// Runs the server, or an admin command if one is given, e.g. `apikey create`
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// Load environment variables into config
 	cfg, err := NewConfig()
 	if err != nil {
@@ -32,17 +62,53 @@ func main() {
 	}
 
 	// Create a new SWAPI client
-	swapiApiClient := services.NewSWAPIClient(&http.Client{}, "https://swapi.dev/api")
+	swapiApiClient := services.NewSWAPIClient(&http.Client{}, swapiBaseURL)
 	// Create a new service
 	svc, err := services.NewService(swapiApiClient)
 	if err != nil {
 		fmt.Printf("%+v\n", err)
 		return
 	}
+	// Watch the database so the service can switch to and from degraded mode
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	go svc.MonitorStorage(monitorCtx)
+	// Re-run saved searches that have webhooks and notify them of changes
+	go svc.WatchSearches(monitorCtx)
+
+	limits := services.QueryLimits{
+		MaxDepth:        cfg.MaxQueryDepth,
+		MaxCost:         cfg.MaxQueryCost,
+		DefaultListSize: cfg.QueryListSize,
+		FieldCosts:      cfg.QueryFieldCosts,
+	}
+	persisted, err := services.NewPersistedQueries(services.PersistedQueryConfig{
+		ManifestPath:  cfg.PersistedQueriesManifest,
+		AllowlistOnly: cfg.PersistedQueriesOnly,
+		CacheSize:     cfg.PersistedQueriesCacheSize,
+	})
+	if err != nil {
+		fmt.Printf("%+v\n", err)
+		return
+	}
+
 	// Create a new handler
-	h := services.NewHandler(services.HandlerConfig{Pretty: cfg.Pretty, GraphiQL: cfg.GraphiQL}, svc)
+	handlerConfig := services.HandlerConfig{Pretty: cfg.Pretty, GraphiQL: cfg.GraphiQL, Production: cfg.Production, Federation: cfg.Federation}
+	h := services.NewHandler(handlerConfig, svc)
 	srv := internal.NewServer(internal.ServerConfig{
-		Port: cfg.Port,
+		Port:           cfg.Port,
+		AllowedOrigins: cfg.CORSAllowedOrigins,
+		ReadyCheck:     svc.Ready,
+		Production:     cfg.Production,
+		Middleware:     []func(http.Handler) http.Handler{services.NewBodyLimitMiddleware(cfg.MaxRequestBodyBytes), services.NewAuthMiddleware(handlerConfig, svc), services.NewLoaderMiddleware(svc), services.NewBatchMiddleware(cfg.MaxBatchSize), services.NewPersistedQueryMiddleware(persisted), services.NewQueryLimitMiddleware(handlerConfig, svc, limits)},
+		Routes: map[string]http.Handler{
+			"/shared/": services.NewSharedSearchHandler(handlerConfig, svc),
+			"/export":  services.NewAuthMiddleware(handlerConfig, svc)(services.NewExportHandler(handlerConfig, svc)),
+			// SDL of the schema, e.g. for code generators
+			"/schema.graphql": services.NewSchemaHandler(svc),
+			// graphql-ws WebSocket endpoint of the subscriptions
+			"/subscriptions": services.NewAuthMiddleware(handlerConfig, svc)(services.NewSubscriptionHandler(handlerConfig, svc, limits, persisted)),
+		},
 	}, h)
 
 	// Start the server in a separate goroutine
@@ -68,6 +134,13 @@ func main() {
 		log.Fatalf("Failed to shutdown server: %v", err)
 	}
 
+	stopMonitor()
+
+	// Close the database connections once in-flight requests have finished
+	if err := svc.Close(ctx); err != nil {
+		log.Fatalf("Failed to disconnect from database: %v", err)
+	}
+
 	fmt.Println("Server gracefully stopped")
 }
 
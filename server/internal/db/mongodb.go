@@ -2,31 +2,158 @@ package db
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
+// ErrUnreachable is returned when the database could not be reached within the configured retries
+var ErrUnreachable = errors.New("database unreachable")
+
 // MongoDB is a struct that holds the MongoDB client and database information.
 type MongoDB struct {
 	Client   *mongo.Client
 	Database *mongo.Database
 }
 
-// NewMongoDB creates a new instance of MongoDB with the provided connection string, database name, and collection name.
-func NewMongoDB(connectionString, dbName string) (*MongoDB, error) {
-	clientOptions := options.Client().ApplyURI(connectionString)
-	client, err := mongo.Connect(context.Background(), clientOptions)
+// Config holds the connection, pooling and retry settings for MongoDB.
+type Config struct {
+	ConnectionString       string
+	DBName                 string
+	MaxPoolSize            uint64
+	MinPoolSize            uint64
+	MaxConnIdleTime        time.Duration
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	OperationTimeout       time.Duration
+	ReadConcern            string // e.g. local, majority; empty uses the driver default
+	WriteConcern           string // e.g. majority, 1; empty uses the driver default
+	ConnectRetries         int
+	ConnectBackoff         time.Duration
+	ConnectMaxBackoff      time.Duration
+}
+
+// NewMongoDB creates a new instance of MongoDB with the provided config.
+// The connection is verified with a ping and retried with exponential backoff
+// so the server can start while the database is still booting.
+// If the database still can't be reached after all retries, the returned MongoDB
+// is kept alongside an error wrapping ErrUnreachable; the client reconnects on its
+// own once the database comes back.
+func NewMongoDB(cfg Config) (*MongoDB, error) {
+	clientOptions, err := clientOptions(cfg)
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
 
-	database := client.Database(dbName)
+	client, err := mongo.Connect(context.Background(), clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mongo client: %w", err)
+	}
 
-	return &MongoDB{
+	mongoDB := &MongoDB{
 		Client:   client,
-		Database: database,
-	}, nil
+		Database: client.Database(cfg.DBName),
+	}
+
+	if err := connectWithRetry(cfg, mongoDB.Ping, time.Sleep); err != nil {
+		return mongoDB, err
+	}
+
+	return mongoDB, nil
+}
+
+// connectWithRetry pings until the database answers, waiting between attempts with exponential
+// backoff capped at ConnectMaxBackoff, and returns an error wrapping ErrUnreachable once the retries run out.
+// The ping and the sleep are passed in so tests can run it without a database or real waits.
+func connectWithRetry(cfg Config, ping func(ctx context.Context) error, sleep func(time.Duration)) error {
+	var err error
+	backoff := cfg.ConnectBackoff
+	for attempt := 0; ; attempt++ {
+		err = ping(context.Background())
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= cfg.ConnectRetries {
+			break
+		}
+
+		fmt.Printf("failed to reach database (attempt %d of %d), retrying in %s: %v\n", attempt+1, cfg.ConnectRetries+1, backoff, err)
+		sleep(backoff)
+
+		// Double the wait between attempts up to the configured maximum
+		backoff *= 2
+		if cfg.ConnectMaxBackoff > 0 && backoff > cfg.ConnectMaxBackoff {
+			backoff = cfg.ConnectMaxBackoff
+		}
+	}
+
+	return fmt.Errorf("%w after %d attempts: %v", ErrUnreachable, cfg.ConnectRetries+1, err)
+}
+
+// Ping checks that the database is reachable; used for the readiness check
+func (m *MongoDB) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	return m.Client.Ping(ctx, readpref.Primary())
+}
+
+// Disconnect closes all pooled connections to the database
+func (m *MongoDB) Disconnect(ctx context.Context) error {
+	return m.Client.Disconnect(ctx)
+}
+
+// clientOptions builds the driver options from the config
+func clientOptions(cfg Config) (*options.ClientOptions, error) {
+	clientOptions := options.Client().ApplyURI(cfg.ConnectionString)
+
+	if cfg.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(cfg.MaxPoolSize)
+	}
+	if cfg.MinPoolSize > 0 {
+		clientOptions.SetMinPoolSize(cfg.MinPoolSize)
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		clientOptions.SetMaxConnIdleTime(cfg.MaxConnIdleTime)
+	}
+	if cfg.ConnectTimeout > 0 {
+		clientOptions.SetConnectTimeout(cfg.ConnectTimeout)
+	}
+	if cfg.ServerSelectionTimeout > 0 {
+		clientOptions.SetServerSelectionTimeout(cfg.ServerSelectionTimeout)
+	}
+	if cfg.OperationTimeout > 0 {
+		clientOptions.SetTimeout(cfg.OperationTimeout)
+	}
+
+	if cfg.ReadConcern != "" {
+		clientOptions.SetReadConcern(readconcern.New(readconcern.Level(cfg.ReadConcern)))
+	}
+
+	if cfg.WriteConcern != "" {
+		// Write concern is either "majority", a tag set name, or the number of acknowledging nodes
+		var wc *writeconcern.WriteConcern
+		if w, err := strconv.Atoi(cfg.WriteConcern); err == nil {
+			wc = writeconcern.New(writeconcern.W(w))
+		} else if cfg.WriteConcern == "majority" {
+			wc = writeconcern.Majority()
+		} else {
+			wc = writeconcern.Custom(cfg.WriteConcern)
+		}
+		clientOptions.SetWriteConcern(wc)
+	}
+
+	if err := clientOptions.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid database options: %w", err)
+	}
+
+	return clientOptions, nil
 }
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectWithRetry(t *testing.T) {
+	errDown := errors.New("connection refused")
+
+	tests := []struct {
+		name string
+		cfg  Config
+		// Number of pings that fail before the database answers; -1 if it never does
+		failures     int
+		wantAttempts int
+		wantDelays   []time.Duration
+		wantErr      bool
+	}{
+		{
+			name:         "reachable right away",
+			cfg:          Config{ConnectRetries: 3, ConnectBackoff: 100 * time.Millisecond},
+			failures:     0,
+			wantAttempts: 1,
+			wantDelays:   nil,
+		},
+		{
+			name:         "reachable after backing off",
+			cfg:          Config{ConnectRetries: 5, ConnectBackoff: 100 * time.Millisecond},
+			failures:     3,
+			wantAttempts: 4,
+			wantDelays:   []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond},
+		},
+		{
+			name:         "backoff is capped",
+			cfg:          Config{ConnectRetries: 5, ConnectBackoff: time.Second, ConnectMaxBackoff: 3 * time.Second},
+			failures:     4,
+			wantAttempts: 5,
+			wantDelays:   []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			name:         "unreachable after every retry",
+			cfg:          Config{ConnectRetries: 2, ConnectBackoff: time.Second, ConnectMaxBackoff: 1500 * time.Millisecond},
+			failures:     -1,
+			wantAttempts: 3,
+			wantDelays:   []time.Duration{time.Second, 1500 * time.Millisecond},
+			wantErr:      true,
+		},
+		{
+			name:         "no retries",
+			cfg:          Config{ConnectRetries: 0, ConnectBackoff: time.Second},
+			failures:     -1,
+			wantAttempts: 1,
+			wantDelays:   nil,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			ping := func(ctx context.Context) error {
+				attempts++
+				if tt.failures < 0 || attempts <= tt.failures {
+					return errDown
+				}
+				return nil
+			}
+			var delays []time.Duration
+			sleep := func(d time.Duration) {
+				delays = append(delays, d)
+			}
+
+			err := connectWithRetry(tt.cfg, ping, sleep)
+			require.Equal(t, tt.wantAttempts, attempts, "database should be pinged once per attempt")
+			require.Equal(t, tt.wantDelays, delays, "attempts should back off exponentially up to the maximum")
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrUnreachable, "running out of retries should be reported as unreachable")
+				require.Contains(t, err.Error(), errDown.Error(), "the last ping error should be kept")
+			} else {
+				require.NoError(t, err, "error should be nil")
+			}
+		})
+	}
+}
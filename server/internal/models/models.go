@@ -7,10 +7,161 @@ import (
 )
 
 type SearchModel struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	SearchKey  string             `bson:"searchKey"`
-	Characters []string           `bson:"characters"`
-	ExpiresAt  *time.Time         `bson:"expiresAt"`
+	ID primitive.ObjectID `bson:"_id"`
+	// ID of the user who made the search; empty for searches made anonymously
+	Owner      string     `bson:"owner,omitempty"`
+	SearchKey  string     `bson:"searchKey"`
+	Characters []string   `bson:"characters"`
+	ExpiresAt  *time.Time `bson:"expiresAt"`
+	Title      string     `bson:"title,omitempty"`
+	Notes      string     `bson:"notes,omitempty"`
+	Tags       []string   `bson:"tags,omitempty"`
+	// Saved searches either never expire or are pinned until expiresAt
+	Saved bool `bson:"saved,omitempty"`
+	// Set when the search is soft-deleted; expiresAt then marks the end of the retention window
+	DeletedAt *time.Time `bson:"deletedAt,omitempty"`
+	// The expiresAt value to put back on restore; nil means the search never expired
+	RestoreExpiresAt *time.Time `bson:"restoreExpiresAt,omitempty"`
+	// Frozen copy of the hydrated result, captured when the search is first saved
+	Snapshot *SearchSnapshot `bson:"snapshot,omitempty"`
+	// Result of the last re-run waiting to be accepted as the new snapshot
+	PendingRerun *SearchSnapshot `bson:"pendingRerun,omitempty"`
+	// Result of the last re-run of the webhook watcher, to tell a new change from one already reported
+	WatchedResult *SearchSnapshot `bson:"watchedResult,omitempty"`
+	// Read-only links to the search, including expired and revoked ones
+	ShareLinks []ShareLinkModel `bson:"shareLinks,omitempty"`
+}
+
+type ShareLinkModel struct {
+	ID string `bson:"id"`
+	// nil if the link only ends when it's revoked
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt"`
+	RevokedAt *time.Time `bson:"revokedAt,omitempty"`
+}
+
+// IsActive - Checks if the share link can still be used
+func (l ShareLinkModel) IsActive(now time.Time) bool {
+	return l.RevokedAt == nil && (l.ExpiresAt == nil || l.ExpiresAt.After(now))
+}
+
+type SearchSnapshot struct {
+	TakenAt    time.Time           `bson:"takenAt"`
+	Characters []SnapshotCharacter `bson:"characters"`
+}
+
+type SnapshotCharacter struct {
+	ID            string   `bson:"id"`
+	Name          string   `bson:"name"`
+	Films         []string `bson:"films"`
+	VehicleModels []string `bson:"vehicleModels"`
+	// IDs of the films and vehicles; empty in snapshots taken before they were stored
+	FilmIDs    []string `bson:"filmIDs,omitempty"`
+	VehicleIDs []string `bson:"vehicleIDs,omitempty"`
+}
+
+// IsSaved - Checks if the search was saved; searches saved before pinning existed only have no expiration
+func (s SearchModel) IsSaved() bool {
+	return s.Saved || s.ExpiresAt == nil
+}
+
+type CollectionModel struct {
+	ID primitive.ObjectID `bson:"_id"`
+	// ID of the user the collection belongs to
+	Owner       string `bson:"owner"`
+	Name        string `bson:"name"`
+	Description string `bson:"description,omitempty"`
+	// Ordered list of the saved searches in the collection
+	SearchIDs []string `bson:"searchIDs"`
+	// Position of the collection in the list of collections
+	Position  int       `bson:"position"`
+	CreatedAt time.Time `bson:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+type UserModel struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	Username string             `bson:"username"`
+	// PBKDF2-SHA256 hash of the password with a random per-user salt
+	PasswordHash []byte `bson:"passwordHash"`
+	Salt         []byte `bson:"salt"`
+	Iterations   int    `bson:"iterations"`
+	// One of reader, editor or admin; empty for users created before roles existed, who are editors
+	Role      string    `bson:"role,omitempty"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+type APIKeyModel struct {
+	ID primitive.ObjectID `bson:"_id"`
+	// Name given by the admin, e.g. the script or service using the key
+	Name string `bson:"name"`
+	// First characters of the key so admins can tell keys apart; the key itself is never stored
+	Prefix string `bson:"prefix"`
+	// SHA-256 of the key
+	KeyHash string `bson:"keyHash"`
+	// The user the key acts as and the role it's limited to
+	UserID    string     `bson:"userID"`
+	Role      string     `bson:"role"`
+	CreatedAt time.Time  `bson:"createdAt"`
+	RevokedAt *time.Time `bson:"revokedAt,omitempty"`
+}
+
+type SessionModel struct {
+	// SHA-256 of the session token; the token itself is only known to the client
+	TokenHash string    `bson:"tokenHash"`
+	UserID    string    `bson:"userID"`
+	CreatedAt time.Time `bson:"createdAt"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// AuditModel is an entry of the append-only audit log, written for every mutation
+type AuditModel struct {
+	ID primitive.ObjectID `bson:"_id"`
+	// The user who made the mutation; empty for anonymous requests
+	ActorID   string `bson:"actorID,omitempty"`
+	ActorName string `bson:"actorName,omitempty"`
+	Operation string `bson:"operation"`
+	// The search or collection the mutation changed, if any
+	TargetID  string                 `bson:"targetID,omitempty"`
+	Arguments map[string]interface{} `bson:"arguments,omitempty"`
+	// State of the target before and after the mutation
+	Before interface{} `bson:"before,omitempty"`
+	After  interface{} `bson:"after,omitempty"`
+	// Set if the mutation failed
+	Error     string    `bson:"error,omitempty"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+// WebhookModel is a URL that is notified when the results of the owner's saved searches change
+type WebhookModel struct {
+	ID    primitive.ObjectID `bson:"_id"`
+	Owner string             `bson:"owner"`
+	URL   string             `bson:"url"`
+	// Key the payloads are signed with; shown to the owner only when the webhook is created
+	Secret string `bson:"secret"`
+	// Only these saved searches are watched; all of them if empty
+	SearchIDs []string  `bson:"searchIDs,omitempty"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+// WebhookDeliveryModel is the log of a payload sent to a webhook, with every attempt made
+type WebhookDeliveryModel struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	WebhookID string             `bson:"webhookID"`
+	SearchID  string             `bson:"searchID"`
+	Event     string             `bson:"event"`
+	Payload   string             `bson:"payload"`
+	Attempts  []WebhookAttempt   `bson:"attempts"`
+	Succeeded bool               `bson:"succeeded"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
+
+// WebhookAttempt is one try to deliver a payload
+type WebhookAttempt struct {
+	At time.Time `bson:"at"`
+	// 0 if no response was received
+	StatusCode int    `bson:"statusCode,omitempty"`
+	Error      string `bson:"error,omitempty"`
 }
 
 type CharacterModel struct {
@@ -22,13 +173,23 @@ type CharacterModel struct {
 }
 
 type FilmModel struct {
-	ID        string    `bson:"id"`
-	Title     string    `bson:"title"`
-	CreatedAt time.Time `bson:"createdAt"`
+	ID          string    `bson:"id"`
+	Title       string    `bson:"title"`
+	EpisodeID   int       `bson:"episodeID,omitempty"`
+	Director    string    `bson:"director,omitempty"`
+	ReleaseDate string    `bson:"releaseDate,omitempty"`
+	Characters  []string  `bson:"characters,omitempty"`
+	Vehicles    []string  `bson:"vehicles,omitempty"`
+	CreatedAt   time.Time `bson:"createdAt"`
 }
 
 type VehicleModel struct {
-	ID        string    `bson:"id"`
-	Model     string    `bson:"model"`
-	CreatedAt time.Time `bson:"createdAt"`
+	ID           string    `bson:"id"`
+	Name         string    `bson:"name,omitempty"`
+	Model        string    `bson:"model"`
+	Manufacturer string    `bson:"manufacturer,omitempty"`
+	VehicleClass string    `bson:"vehicleClass,omitempty"`
+	Pilots       []string  `bson:"pilots,omitempty"`
+	Films        []string  `bson:"films,omitempty"`
+	CreatedAt    time.Time `bson:"createdAt"`
 }
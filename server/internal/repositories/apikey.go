@@ -0,0 +1,113 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	APIKeyCollection = "apiKeys"
+)
+
+type APIKeyRepositoryImpl struct {
+	db *mongo.Database
+}
+
+// NewAPIKeyRepository - Creates a new APIKeyRepositoryImpl
+func NewAPIKeyRepository(cfg Config) (*APIKeyRepositoryImpl, error) {
+
+	collection := cfg.DB.Collection(APIKeyCollection)
+
+	// Define the index model
+	// Keys are looked up by their hash on every request
+	indexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "keyHash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	// CreateOne is a no-op if the index already exists
+	_, err := collection.Indexes().CreateOne(context.TODO(), indexModel)
+	if err != nil {
+		return nil, err
+	}
+
+	return &APIKeyRepositoryImpl{
+		db: cfg.DB,
+	}, nil
+}
+
+// AddAPIKey - Adds a new API key to the database
+func (r *APIKeyRepositoryImpl) AddAPIKey(apiKey models.APIKeyModel) (string, error) {
+	collection := r.db.Collection(APIKeyCollection)
+
+	result, err := collection.InsertOne(context.TODO(), apiKey)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// GetAPIKeyByHash - Returns the API key with the given hash if it hasn't been revoked
+func (r *APIKeyRepositoryImpl) GetAPIKeyByHash(keyHash string) (*models.APIKeyModel, error) {
+	collection := r.db.Collection(APIKeyCollection)
+
+	filter := bson.D{{Key: "keyHash", Value: keyHash}, {Key: "revokedAt", Value: nil}}
+
+	var result models.APIKeyModel
+	err := collection.FindOne(context.TODO(), filter).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetAPIKeys - Returns all API keys, including revoked ones, oldest first
+func (r *APIKeyRepositoryImpl) GetAPIKeys() ([]models.APIKeyModel, error) {
+	collection := r.db.Collection(APIKeyCollection)
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := collection.Find(context.TODO(), bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiKeys []models.APIKeyModel
+	if err = cursor.All(context.TODO(), &apiKeys); err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
+}
+
+// RevokeAPIKey - Revokes an API key so it can no longer be used
+// Revoked keys are kept so they still show up when listing keys
+func (r *APIKeyRepositoryImpl) RevokeAPIKey(apiKeyID string) (bool, error) {
+	collection := r.db.Collection(APIKeyCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(apiKeyID)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.D{{Key: "_id", Value: objectID}, {Key: "revokedAt", Value: nil}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: time.Now()}}}}
+
+	result, err := collection.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
@@ -0,0 +1,118 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	AuditCollection = "auditLog"
+	// How long audit log entries are kept if no retention is configured
+	DefaultAuditRetention = 90 * 24 * time.Hour
+)
+
+type AuditRepositoryImpl struct {
+	db *mongo.Database
+}
+
+// NewAuditRepository - Creates a new AuditRepositoryImpl
+// Entries are removed by a TTL index once they are older than the retention
+func NewAuditRepository(cfg Config) (*AuditRepositoryImpl, error) {
+
+	collection := cfg.DB.Collection(AuditCollection)
+
+	retention := cfg.AuditRetention
+	if retention <= 0 {
+		retention = DefaultAuditRetention
+	}
+	expireAfterSeconds := int32(retention / time.Second)
+
+	if err := dropChangedTTLIndex(collection, "createdAt_1", expireAfterSeconds); err != nil {
+		return nil, err
+	}
+
+	// CreateMany is a no-op for indexes that already exist
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "createdAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(expireAfterSeconds),
+		},
+		{
+			Keys: bson.D{{Key: "actorID", Value: 1}, {Key: "_id", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "targetID", Value: 1}, {Key: "_id", Value: -1}},
+		},
+	}
+	if _, err := collection.Indexes().CreateMany(context.TODO(), indexModels); err != nil {
+		return nil, err
+	}
+
+	return &AuditRepositoryImpl{
+		db: cfg.DB,
+	}, nil
+}
+
+// AddAuditEntry - Appends an entry to the audit log
+func (r *AuditRepositoryImpl) AddAuditEntry(entry models.AuditModel) error {
+	collection := r.db.Collection(AuditCollection)
+
+	_, err := collection.InsertOne(context.TODO(), entry)
+	return err
+}
+
+// GetAuditEntries - Returns up to limit entries matching the filter, newest first
+func (r *AuditRepositoryImpl) GetAuditEntries(auditFilter AuditFilter, limit int) ([]models.AuditModel, error) {
+	collection := r.db.Collection(AuditCollection)
+
+	filter := bson.D{}
+	if auditFilter.ActorID != "" {
+		filter = append(filter, bson.E{Key: "actorID", Value: auditFilter.ActorID})
+	}
+	if auditFilter.Operation != "" {
+		filter = append(filter, bson.E{Key: "operation", Value: auditFilter.Operation})
+	}
+	if auditFilter.TargetID != "" {
+		filter = append(filter, bson.E{Key: "targetID", Value: auditFilter.TargetID})
+	}
+
+	createdAt := bson.D{}
+	if auditFilter.Since != nil {
+		createdAt = append(createdAt, bson.E{Key: "$gte", Value: *auditFilter.Since})
+	}
+	if auditFilter.Until != nil {
+		createdAt = append(createdAt, bson.E{Key: "$lt", Value: *auditFilter.Until})
+	}
+	if len(createdAt) > 0 {
+		filter = append(filter, bson.E{Key: "createdAt", Value: createdAt})
+	}
+
+	// ObjectIDs increase over time, so paging by ID keeps the order stable while entries are added
+	if auditFilter.BeforeID != "" {
+		objectID, err := primitive.ObjectIDFromHex(auditFilter.BeforeID)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$lt", Value: objectID}}})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := collection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []models.AuditModel
+	if err = cursor.All(context.TODO(), &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -76,6 +76,15 @@ func NewCharacterRepository(cfg Config) (*CharacterRepositoryImpl, error) {
 		}
 	}
 
+	// Characters are looked up by ID, many at a time when GraphQL resolvers are batched
+	// CreateOne is a no-op if the index already exists
+	_, err = collection.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "id", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	return &CharacterRepositoryImpl{
 		db: cfg.DB,
 	}, nil
@@ -114,3 +123,20 @@ func (r *CharacterRepositoryImpl) GetCharacter(id string) (*models.CharacterMode
 
 	return &character, nil
 }
+
+// GetCharacters - Gets the characters with the given IDs in one query; IDs that aren't cached are left out
+func (r *CharacterRepositoryImpl) GetCharacters(ids []string) ([]models.CharacterModel, error) {
+	collection := r.db.Collection(CharacterCollection)
+
+	cursor, err := collection.Find(context.TODO(), bson.M{"id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+
+	var characters []models.CharacterModel
+	if err = cursor.All(context.TODO(), &characters); err != nil {
+		return nil, err
+	}
+
+	return characters, nil
+}
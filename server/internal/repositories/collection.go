@@ -0,0 +1,214 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	CollectionCollection = "collections"
+)
+
+type CollectionRepositoryImpl struct {
+	db *mongo.Database
+}
+
+// NewCollectionRepository - Creates a new CollectionRepositoryImpl
+func NewCollectionRepository(cfg Config) (*CollectionRepositoryImpl, error) {
+
+	collection := cfg.DB.Collection(CollectionCollection)
+
+	// Define the index model
+	// Collections are always listed per user by position
+	indexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "owner", Value: 1}, {Key: "position", Value: 1}},
+	}
+
+	// CreateOne is a no-op if the index already exists
+	_, err := collection.Indexes().CreateOne(context.TODO(), indexModel)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CollectionRepositoryImpl{
+		db: cfg.DB,
+	}, nil
+}
+
+// AddCollection - Adds a collection to the end of the list of collections
+func (r *CollectionRepositoryImpl) AddCollection(newCollection models.CollectionModel) (string, error) {
+	collection := r.db.Collection(CollectionCollection)
+
+	// Find the owner's last position so the new collection is placed after it
+	opts := options.FindOne().SetSort(bson.D{{Key: "position", Value: -1}})
+	var last models.CollectionModel
+	err := collection.FindOne(context.TODO(), bson.D{{Key: "owner", Value: newCollection.Owner}}, opts).Decode(&last)
+	switch {
+	case err == nil:
+		newCollection.Position = last.Position + 1
+	case err == mongo.ErrNoDocuments:
+		newCollection.Position = 0
+	default:
+		return "", err
+	}
+
+	now := time.Now()
+	newCollection.CreatedAt = now
+	newCollection.UpdatedAt = now
+	if newCollection.SearchIDs == nil {
+		newCollection.SearchIDs = []string{}
+	}
+
+	result, err := collection.InsertOne(context.TODO(), newCollection)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// GetCollections - Returns the collections of a user ordered by position
+func (r *CollectionRepositoryImpl) GetCollections(owner string) ([]models.CollectionModel, error) {
+	collection := r.db.Collection(CollectionCollection)
+
+	opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "createdAt", Value: 1}})
+	cursor, err := collection.Find(context.TODO(), bson.D{{Key: "owner", Value: owner}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var collections []models.CollectionModel
+	if err = cursor.All(context.TODO(), &collections); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// GetCollection - Returns a collection by ID
+func (r *CollectionRepositoryImpl) GetCollection(collectionID string) (*models.CollectionModel, error) {
+	collection := r.db.Collection(CollectionCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(collectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.CollectionModel
+	err = collection.FindOne(context.TODO(), bson.D{{Key: "_id", Value: objectID}}).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// UpdateCollection - Updates the name, description and ordered searches of a collection
+func (r *CollectionRepositoryImpl) UpdateCollection(collectionID string, collectionUpdate CollectionUpdate) (*models.CollectionModel, error) {
+	set := bson.D{{Key: "updatedAt", Value: time.Now()}}
+	if collectionUpdate.Name != nil {
+		set = append(set, bson.E{Key: "name", Value: *collectionUpdate.Name})
+	}
+	if collectionUpdate.Description != nil {
+		set = append(set, bson.E{Key: "description", Value: *collectionUpdate.Description})
+	}
+	if collectionUpdate.SearchIDs != nil {
+		set = append(set, bson.E{Key: "searchIDs", Value: collectionUpdate.SearchIDs})
+	}
+
+	return r.findOneAndUpdate(collectionID, bson.D{{Key: "$set", Value: set}})
+}
+
+// DeleteCollection - Deletes a collection; the searches in it are not affected
+func (r *CollectionRepositoryImpl) DeleteCollection(collectionID string) (bool, error) {
+	collection := r.db.Collection(CollectionCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(collectionID)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := collection.DeleteOne(context.TODO(), bson.D{{Key: "_id", Value: objectID}})
+	if err != nil {
+		return false, err
+	}
+
+	return result.DeletedCount > 0, nil
+}
+
+// AddSearchToCollection - Appends a search to a collection if it's not in it yet
+func (r *CollectionRepositoryImpl) AddSearchToCollection(collectionID string, searchID string) (*models.CollectionModel, error) {
+	update := bson.D{
+		{Key: "$addToSet", Value: bson.D{{Key: "searchIDs", Value: searchID}}},
+		{Key: "$set", Value: bson.D{{Key: "updatedAt", Value: time.Now()}}},
+	}
+
+	return r.findOneAndUpdate(collectionID, update)
+}
+
+// RemoveSearchFromCollection - Removes a search from a collection
+func (r *CollectionRepositoryImpl) RemoveSearchFromCollection(collectionID string, searchID string) (*models.CollectionModel, error) {
+	update := bson.D{
+		{Key: "$pull", Value: bson.D{{Key: "searchIDs", Value: searchID}}},
+		{Key: "$set", Value: bson.D{{Key: "updatedAt", Value: time.Now()}}},
+	}
+
+	return r.findOneAndUpdate(collectionID, update)
+}
+
+// SetCollectionPositions - Sets the position of each collection to its index in the given list
+func (r *CollectionRepositoryImpl) SetCollectionPositions(collectionIDs []string) error {
+	collection := r.db.Collection(CollectionCollection)
+
+	var writes []mongo.WriteModel
+	for position, collectionID := range collectionIDs {
+		objectID, err := primitive.ObjectIDFromHex(collectionID)
+		if err != nil {
+			return err
+		}
+
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: objectID}}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{{Key: "position", Value: position}}}}))
+	}
+
+	if len(writes) == 0 {
+		return nil
+	}
+
+	_, err := collection.BulkWrite(context.TODO(), writes)
+	return err
+}
+
+// findOneAndUpdate - Applies the update to a collection and returns the updated collection
+func (r *CollectionRepositoryImpl) findOneAndUpdate(collectionID string, update bson.D) (*models.CollectionModel, error) {
+	collection := r.db.Collection(CollectionCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(collectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var result models.CollectionModel
+	err = collection.FindOneAndUpdate(context.TODO(), bson.D{{Key: "_id", Value: objectID}}, update, opts).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -75,6 +75,15 @@ func NewFilmRepository(cfg Config) (*FilmRepositoryImpl, error) {
 		}
 	}
 
+	// Films are looked up by ID, many at a time when GraphQL resolvers are batched
+	// CreateOne is a no-op if the index already exists
+	_, err = collection.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "id", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	return &FilmRepositoryImpl{
 		db: cfg.DB,
 	}, nil
@@ -113,3 +122,20 @@ func (r *FilmRepositoryImpl) GetFilm(id string) (*models.FilmModel, error) {
 
 	return &film, nil
 }
+
+// GetFilms - Gets the films with the given IDs in one query; IDs that aren't cached are left out
+func (r *FilmRepositoryImpl) GetFilms(ids []string) ([]models.FilmModel, error) {
+	collection := r.db.Collection(FilmCollection)
+
+	cursor, err := collection.Find(context.TODO(), bson.M{"id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+
+	var films []models.FilmModel
+	if err = cursor.All(context.TODO(), &films); err != nil {
+		return nil, err
+	}
+
+	return films, nil
+}
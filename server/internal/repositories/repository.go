@@ -1,10 +1,14 @@
 package repositories
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"alvinlucillo/swapi-app/internal/models"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -13,15 +17,55 @@ var (
 )
 
 type Repository struct {
-	VehicleRepository   VehicleRepository
-	FilmRepository      FilmRepository
-	SearchRepository    SearchRepository
-	CharacterRepository CharacterRepository
+	VehicleRepository    VehicleRepository
+	FilmRepository       FilmRepository
+	SearchRepository     SearchRepository
+	CharacterRepository  CharacterRepository
+	CollectionRepository CollectionRepository
+	UserRepository       UserRepository
+	SessionRepository    SessionRepository
+	APIKeyRepository     APIKeyRepository
+	AuditRepository      AuditRepository
+	WebhookRepository    WebhookRepository
 }
 
 type Config struct {
 	DocumentTTL int32
-	DB          *mongo.Database
+	// How long a search is kept before it expires unless it's saved
+	SearchTTL time.Duration
+	// How long soft-deleted searches can be restored before they are purged
+	SearchDeleteRetention time.Duration
+	// How long audit log entries are kept
+	AuditRetention time.Duration
+	// How long webhook deliveries are kept
+	WebhookDeliveryRetention time.Duration
+	DB                       *mongo.Database
+}
+
+// dropChangedTTLIndex - Drops a TTL index if its expiration differs from the configured one,
+// so it can be created again with the new expiration
+func dropChangedTTLIndex(collection *mongo.Collection, name string, expireAfterSeconds int32) error {
+	cursor, err := collection.Indexes().List(context.TODO())
+	if err != nil {
+		return err
+	}
+	var indexes []bson.M
+	if err = cursor.All(context.TODO(), &indexes); err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if index["name"] != name {
+			continue
+		}
+		if current, ok := index["expireAfterSeconds"].(int32); ok && current != expireAfterSeconds {
+			if _, err := collection.Indexes().DropOne(context.TODO(), name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
 
 func NewRepository(cfg Config) (*Repository, error) {
@@ -49,32 +93,209 @@ func NewRepository(cfg Config) (*Repository, error) {
 		return nil, err
 	}
 
+	collectionRepository, err := NewCollectionRepository(cfg)
+	if err != nil {
+		fmt.Printf("%+v\n", err)
+		return nil, err
+	}
+
+	userRepository, err := NewUserRepository(cfg)
+	if err != nil {
+		fmt.Printf("%+v\n", err)
+		return nil, err
+	}
+
+	sessionRepository, err := NewSessionRepository(cfg)
+	if err != nil {
+		fmt.Printf("%+v\n", err)
+		return nil, err
+	}
+
+	apiKeyRepository, err := NewAPIKeyRepository(cfg)
+	if err != nil {
+		fmt.Printf("%+v\n", err)
+		return nil, err
+	}
+
+	auditRepository, err := NewAuditRepository(cfg)
+	if err != nil {
+		fmt.Printf("%+v\n", err)
+		return nil, err
+	}
+
+	webhookRepository, err := NewWebhookRepository(cfg)
+	if err != nil {
+		fmt.Printf("%+v\n", err)
+		return nil, err
+	}
+
 	return &Repository{
-		VehicleRepository:   vehicleRepository,
-		FilmRepository:      filmRepository,
-		SearchRepository:    searchRepository,
-		CharacterRepository: characterRepository,
+		VehicleRepository:    vehicleRepository,
+		FilmRepository:       filmRepository,
+		SearchRepository:     searchRepository,
+		CharacterRepository:  characterRepository,
+		CollectionRepository: collectionRepository,
+		UserRepository:       userRepository,
+		SessionRepository:    sessionRepository,
+		APIKeyRepository:     apiKeyRepository,
+		AuditRepository:      auditRepository,
+		WebhookRepository:    webhookRepository,
 	}, nil
 }
 
 type VehicleRepository interface {
 	AddVehicle(newVehicle models.VehicleModel) (string, error)
 	GetVehicle(id string) (*models.VehicleModel, error)
+	GetVehicles(ids []string) ([]models.VehicleModel, error)
 }
 
 type FilmRepository interface {
 	AddFilm(newVehicle models.FilmModel) (string, error)
 	GetFilm(id string) (*models.FilmModel, error)
+	GetFilms(ids []string) ([]models.FilmModel, error)
 }
 
 type SearchRepository interface {
 	AddSearch(newVehicle models.SearchModel) (string, error)
-	GetSearches() ([]models.SearchModel, error)
+	GetSearches(filter SearchFilter) ([]models.SearchModel, error)
+	UpdateSearch(id string, update SearchUpdate) (*models.SearchModel, error)
 	RemoveExpiration(id string) (bool, error)
+	PinSearch(id string, expiresAt time.Time) (bool, error)
+	ExtendExpiration(id string, expiresAt time.Time) (*models.SearchModel, error)
+	ExtendExpirationBy(id string, ttl time.Duration) (*models.SearchModel, error)
+	GetExpiringSearches(filter SearchFilter, before time.Time) ([]models.SearchModel, error)
+	AddExpiration(id string) (bool, error)
 	GetSearchesByID(id string) (*models.SearchModel, error)
+	GetSearchesByIDs(ids []string) ([]models.SearchModel, error)
+	DeleteSearch(id string) (bool, error)
+	RestoreSearch(id string) (bool, error)
+	GetDeletedSearches(filter SearchFilter) ([]models.SearchModel, error)
+	SetSnapshot(id string, snapshot models.SearchSnapshot) (bool, error)
+	SetPendingRerun(id string, rerun models.SearchSnapshot) (bool, error)
+	AcceptPendingRerun(id string) (bool, error)
+	SetWatchedResult(id string, result models.SearchSnapshot) (bool, error)
+	GetSearch(id string) (*models.SearchModel, error)
+	UpsertSearch(search models.SearchModel) error
+	GetSearchPage(query SearchPageQuery) ([]models.SearchModel, int64, error)
+	AddShareLink(id string, link models.ShareLinkModel) (bool, error)
+	GetShareLinks(id string) ([]models.ShareLinkModel, error)
+	RevokeShareLink(id string, linkID string) (bool, error)
+	ClaimOwnerlessSearches(owner string) (int64, error)
+	RemoveEmptyTitles() (int64, error)
+}
+
+// SearchFilter narrows down the searches returned by GetSearches, GetExpiringSearches and GetDeletedSearches
+type SearchFilter struct {
+	// Only searches of this user; required, since searches made anonymously are never listed
+	Owner string
+	// Only searches with this tag
+	Tag string
+	// Only searches whose search key contains this, ignoring case
+	SearchKeyContains string
+	// Only searches created at or after CreatedAfter and before CreatedBefore
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// SearchSort is the field saved searches are ordered by in GetSearchPage
+// Searches with the same value are ordered by when they were created
+type SearchSort string
+
+const (
+	SearchSortCreated   SearchSort = "created"
+	SearchSortTitle     SearchSort = "title"
+	SearchSortSearchKey SearchSort = "searchKey"
+)
+
+// SearchPageQuery selects a page of saved searches
+type SearchPageQuery struct {
+	Filter     SearchFilter
+	Sort       SearchSort
+	Descending bool
+	// Last search of the previous page; nil for the first page
+	After *SearchCursor
+	Limit int
+}
+
+// SearchCursor is the position of a search in the sort order: the value of the sort field and its ID
+type SearchCursor struct {
+	Value string
+	ID    primitive.ObjectID
+}
+
+// SearchUpdate holds the user-editable fields of a search; nil fields are left unchanged
+type SearchUpdate struct {
+	Title *string
+	Notes *string
+	Tags  []string
 }
 
 type CharacterRepository interface {
 	GetCharacter(id string) (*models.CharacterModel, error)
+	GetCharacters(ids []string) ([]models.CharacterModel, error)
 	AddCharacter(newCharacter models.CharacterModel) (string, error)
 }
+
+type CollectionRepository interface {
+	AddCollection(newCollection models.CollectionModel) (string, error)
+	GetCollections(owner string) ([]models.CollectionModel, error)
+	GetCollection(id string) (*models.CollectionModel, error)
+	UpdateCollection(id string, update CollectionUpdate) (*models.CollectionModel, error)
+	DeleteCollection(id string) (bool, error)
+	AddSearchToCollection(id string, searchID string) (*models.CollectionModel, error)
+	RemoveSearchFromCollection(id string, searchID string) (*models.CollectionModel, error)
+	SetCollectionPositions(ids []string) error
+}
+
+// CollectionUpdate holds the editable fields of a collection; nil fields are left unchanged
+type CollectionUpdate struct {
+	Name        *string
+	Description *string
+	SearchIDs   []string
+}
+
+type UserRepository interface {
+	AddUser(newUser models.UserModel) (string, error)
+	GetUser(id string) (*models.UserModel, error)
+	GetUserByUsername(username string) (*models.UserModel, error)
+	SetUserRole(username string, role string) (bool, error)
+}
+
+type SessionRepository interface {
+	AddSession(newSession models.SessionModel) error
+	GetSession(tokenHash string) (*models.SessionModel, error)
+	DeleteSession(tokenHash string) (bool, error)
+}
+
+type APIKeyRepository interface {
+	AddAPIKey(newAPIKey models.APIKeyModel) (string, error)
+	GetAPIKeyByHash(keyHash string) (*models.APIKeyModel, error)
+	GetAPIKeys() ([]models.APIKeyModel, error)
+	RevokeAPIKey(id string) (bool, error)
+}
+
+// AuditRepository is append-only: entries are never changed and only removed by the retention policy
+type AuditRepository interface {
+	AddAuditEntry(entry models.AuditModel) error
+	GetAuditEntries(filter AuditFilter, limit int) ([]models.AuditModel, error)
+}
+
+// AuditFilter narrows down the audit log entries returned by GetAuditEntries
+type AuditFilter struct {
+	ActorID   string
+	Operation string
+	TargetID  string
+	Since     *time.Time
+	Until     *time.Time
+	// Only entries older than the entry with this ID, for paging
+	BeforeID string
+}
+
+type WebhookRepository interface {
+	AddWebhook(webhook models.WebhookModel) (string, error)
+	GetWebhooks(owner string) ([]models.WebhookModel, error)
+	GetAllWebhooks() ([]models.WebhookModel, error)
+	DeleteWebhook(id string, owner string) (bool, error)
+	AddDelivery(delivery models.WebhookDeliveryModel) error
+	GetDeliveries(webhookID string, limit int) ([]models.WebhookDeliveryModel, error)
+}
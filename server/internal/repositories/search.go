@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"time"
 
 	"alvinlucillo/swapi-app/internal/models"
@@ -15,12 +16,24 @@ import (
 
 var (
 	SearchCollection = "searches"
+	// How long a search is kept before it expires unless it's saved
+	DefaultSearchTTL = 1 * time.Hour
+	// How long a deleted search can be restored if no retention is configured
+	DefaultSearchDeleteRetention = 7 * 24 * time.Hour
 )
 
 type SearchRepositoryImpl struct {
-	db *mongo.Database
+	db              *mongo.Database
+	searchTTL       time.Duration
+	deleteRetention time.Duration
 }
 
+// savedFilter matches searches that were saved, whether or not they are pinned until a date
+var savedFilter = bson.E{Key: "$or", Value: bson.A{
+	bson.D{{Key: "expiresAt", Value: nil}},
+	bson.D{{Key: "saved", Value: true}},
+}}
+
 func NewSearchRepository(cfg Config) (*SearchRepositoryImpl, error) {
 
 	collection := cfg.DB.Collection(SearchCollection)
@@ -58,8 +71,44 @@ func NewSearchRepository(cfg Config) (*SearchRepositoryImpl, error) {
 		}
 	}
 
+	// Searches are always listed per user
+	// The compound indexes back the sort orders of GetSearchPage and use the same collation as its queries
+	// CreateMany is a no-op for indexes that already exist
+	_, err = collection.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "owner", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetCollation(searchCollation),
+		},
+		{
+			Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "title", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetCollation(searchCollation),
+		},
+		{
+			Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "searchKey", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetCollation(searchCollation),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	searchTTL := cfg.SearchTTL
+	if searchTTL <= 0 {
+		searchTTL = DefaultSearchTTL
+	}
+
+	deleteRetention := cfg.SearchDeleteRetention
+	if deleteRetention <= 0 {
+		deleteRetention = DefaultSearchDeleteRetention
+	}
+
 	return &SearchRepositoryImpl{
-		db: cfg.DB,
+		db:              cfg.DB,
+		searchTTL:       searchTTL,
+		deleteRetention: deleteRetention,
 	}, nil
 }
 
@@ -67,8 +116,8 @@ func NewSearchRepository(cfg Config) (*SearchRepositoryImpl, error) {
 func (r *SearchRepositoryImpl) AddSearch(search models.SearchModel) (string, error) {
 	collection := r.db.Collection(SearchCollection)
 
-	// Document by default will expire 1 hour after creation
-	t := time.Now().Add(1 * time.Hour)
+	// Document by default will expire after the configured search TTL
+	t := time.Now().Add(r.searchTTL)
 	search.ExpiresAt = &t
 
 	result, err := collection.InsertOne(context.TODO(), search)
@@ -80,12 +129,139 @@ func (r *SearchRepositoryImpl) AddSearch(search models.SearchModel) (string, err
 	return result.InsertedID.(primitive.ObjectID).Hex(), nil
 }
 
-// GetSearches - Returns all searches that have not expired
-func (r *SearchRepositoryImpl) GetSearches() ([]models.SearchModel, error) {
+// searchCollation sorts and compares titles and search keys alphabetically, ignoring case
+var searchCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// searchSortFields are the document fields of the sort orders
+var searchSortFields = map[SearchSort]string{
+	SearchSortCreated:   "_id",
+	SearchSortTitle:     "title",
+	SearchSortSearchKey: "searchKey",
+}
+
+// apply - Adds the conditions of the search filter to a query filter
+func (f SearchFilter) apply(filter bson.D) bson.D {
+	filter = append(filter, bson.E{Key: "owner", Value: f.Owner})
+	if f.Tag != "" {
+		// Matches if the tag is one of the elements of the tags array
+		filter = append(filter, bson.E{Key: "tags", Value: f.Tag})
+	}
+	if f.SearchKeyContains != "" {
+		filter = append(filter, bson.E{Key: "searchKey", Value: primitive.Regex{
+			Pattern: regexp.QuoteMeta(f.SearchKeyContains),
+			Options: "i",
+		}})
+	}
+
+	// The ID of a search holds the time it was created
+	created := bson.D{}
+	if f.CreatedAfter != nil {
+		created = append(created, bson.E{Key: "$gte", Value: primitive.NewObjectIDFromTimestamp(*f.CreatedAfter)})
+	}
+	if f.CreatedBefore != nil {
+		created = append(created, bson.E{Key: "$lt", Value: primitive.NewObjectIDFromTimestamp(*f.CreatedBefore)})
+	}
+	if len(created) > 0 {
+		filter = append(filter, bson.E{Key: "_id", Value: created})
+	}
+
+	return filter
+}
+
+// GetSearchPage - Returns a page of saved searches in the given order and the number of searches matching the filter
+// Pages are found by keyset rather than offset, so later pages are as fast as the first
+func (r *SearchRepositoryImpl) GetSearchPage(query SearchPageQuery) ([]models.SearchModel, int64, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	field, ok := searchSortFields[query.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown search sort %q", query.Sort)
+	}
+
+	filter := query.Filter.apply(bson.D{savedFilter, {Key: "deletedAt", Value: nil}})
+
+	total, err := collection.CountDocuments(context.Background(), filter, options.Count().SetCollation(searchCollation))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if query.After != nil {
+		// Both filters may use $or, so they're combined with $and
+		filter = bson.D{{Key: "$and", Value: bson.A{filter, afterCursor(field, query.Descending, *query.After)}}}
+	}
+
+	direction := 1
+	if query.Descending {
+		direction = -1
+	}
+	sort := bson.D{{Key: field, Value: direction}}
+	if field != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: direction})
+	}
+
+	opts := options.Find().
+		SetSort(sort).
+		SetLimit(int64(query.Limit)).
+		SetCollation(searchCollation)
+	cursor, err := collection.Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var searches []models.SearchModel
+	if err = cursor.All(context.Background(), &searches); err != nil {
+		return nil, 0, err
+	}
+
+	return searches, total, nil
+}
+
+// afterCursor - Returns the filter matching the searches that come after the cursor in the sort order
+// Searches without a title sort before every title, since missing fields sort first
+// An empty cursor value stands for a missing title; titles are never stored empty, see UpdateSearch
+func afterCursor(field string, descending bool, after SearchCursor) bson.D {
+	next := "$gt"
+	if descending {
+		next = "$lt"
+	}
+
+	if field == "_id" {
+		return bson.D{{Key: "_id", Value: bson.D{{Key: next, Value: after.ID}}}}
+	}
+
+	// Searches with the same value are ordered by ID
+	var value interface{} = after.Value
+	if after.Value == "" {
+		value = nil
+	}
+	sameValue := bson.D{{Key: field, Value: value}, {Key: "_id", Value: bson.D{{Key: next, Value: after.ID}}}}
+
+	conditions := bson.A{sameValue}
+	switch {
+	case after.Value == "" && !descending:
+		// Every search with a value comes after the ones without
+		conditions = append(conditions, bson.D{{Key: field, Value: bson.D{{Key: "$gt", Value: ""}}}})
+	case after.Value == "" && descending:
+		// Nothing sorts below a missing value
+	case !descending:
+		conditions = append(conditions, bson.D{{Key: field, Value: bson.D{{Key: next, Value: after.Value}}}})
+	default:
+		// Searches without a value come last when sorting in descending order
+		conditions = append(conditions,
+			bson.D{{Key: field, Value: bson.D{{Key: next, Value: after.Value}}}},
+			bson.D{{Key: field, Value: nil}},
+		)
+	}
+
+	return bson.D{{Key: "$or", Value: conditions}}
+}
+
+// GetSearches - Returns all saved searches matching the filter
+func (r *SearchRepositoryImpl) GetSearches(searchFilter SearchFilter) ([]models.SearchModel, error) {
 	collection := r.db.Collection(SearchCollection)
 
-	// Find all documents with nil expiresAt
-	filter := bson.D{{Key: "expiresAt", Value: nil}}
+	// Find all saved documents that are not deleted
+	filter := searchFilter.apply(bson.D{savedFilter, {Key: "deletedAt", Value: nil}})
 	cursor, err := collection.Find(context.Background(), filter)
 	if err != nil {
 		return nil, err
@@ -109,10 +285,30 @@ func (r *SearchRepositoryImpl) RemoveExpiration(searchID string) (bool, error) {
 		return false, err
 	}
 
-	filter := bson.D{{Key: "_id", Value: objectID}}
+	filter := bson.D{{Key: "_id", Value: objectID}, {Key: "deletedAt", Value: nil}}
 
 	// Set the expiresAt field to nil
-	update := bson.D{{Key: "$set", Value: bson.D{{Key: "expiresAt", Value: nil}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "expiresAt", Value: nil}, {Key: "saved", Value: true}}}}
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// PinSearch - Saves a search until the given time instead of indefinitely
+func (r *SearchRepositoryImpl) PinSearch(searchID string, expiresAt time.Time) (bool, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(searchID)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.D{{Key: "_id", Value: objectID}, {Key: "deletedAt", Value: nil}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "expiresAt", Value: expiresAt}, {Key: "saved", Value: true}}}}
 
 	result, err := collection.UpdateOne(context.Background(), filter, update)
 	if err != nil {
@@ -122,6 +318,79 @@ func (r *SearchRepositoryImpl) RemoveExpiration(searchID string) (bool, error) {
 	return result.ModifiedCount > 0, nil
 }
 
+// ExtendExpiration - Moves the expiration of a search that has one to the given time
+func (r *SearchRepositoryImpl) ExtendExpiration(searchID string, expiresAt time.Time) (*models.SearchModel, error) {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "expiresAt", Value: expiresAt}}}}
+
+	return r.updateExpiring(searchID, update)
+}
+
+// ExtendExpirationBy - Pushes back the expiration of a search that has one by the given duration
+// Searches whose expiration already passed but were not purged yet are extended from now
+func (r *SearchRepositoryImpl) ExtendExpirationBy(searchID string, ttl time.Duration) (*models.SearchModel, error) {
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "expiresAt", Value: bson.D{{Key: "$add", Value: bson.A{
+				bson.D{{Key: "$max", Value: bson.A{"$expiresAt", time.Now()}}},
+				ttl.Milliseconds(),
+			}}}},
+		}}},
+	}
+
+	return r.updateExpiring(searchID, update)
+}
+
+// updateExpiring - Applies the update to a search that is not deleted and has an expiration
+func (r *SearchRepositoryImpl) updateExpiring(searchID string, update interface{}) (*models.SearchModel, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(searchID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.D{
+		{Key: "_id", Value: objectID},
+		{Key: "expiresAt", Value: bson.D{{Key: "$ne", Value: nil}}},
+		{Key: "deletedAt", Value: nil},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var search models.SearchModel
+	err = collection.FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&search)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &search, nil
+}
+
+// GetExpiringSearches - Returns the searches that will expire before the given time, soonest first
+func (r *SearchRepositoryImpl) GetExpiringSearches(searchFilter SearchFilter, before time.Time) ([]models.SearchModel, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	filter := searchFilter.apply(bson.D{
+		{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now()}, {Key: "$lte", Value: before}}},
+		{Key: "deletedAt", Value: nil},
+	})
+	opts := options.Find().SetSort(bson.D{{Key: "expiresAt", Value: 1}})
+
+	cursor, err := collection.Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var searches []models.SearchModel
+	if err = cursor.All(context.Background(), &searches); err != nil {
+		return nil, err
+	}
+
+	return searches, nil
+}
+
 // GetSearchByID - Returns a search by ID
 func (r *SearchRepositoryImpl) GetSearchesByID(searchID string) (*models.SearchModel, error) {
 	collection := r.db.Collection(SearchCollection)
@@ -132,7 +401,8 @@ func (r *SearchRepositoryImpl) GetSearchesByID(searchID string) (*models.SearchM
 		return nil, err
 	}
 
-	filter := bson.D{{Key: "_id", Value: objectID}}
+	// Deleted searches are only reachable through RestoreSearch
+	filter := bson.D{{Key: "_id", Value: objectID}, {Key: "deletedAt", Value: nil}}
 
 	var search models.SearchModel
 	err = collection.FindOne(context.Background(), filter).Decode(&search)
@@ -146,3 +416,434 @@ func (r *SearchRepositoryImpl) GetSearchesByID(searchID string) (*models.SearchM
 
 	return &search, nil
 }
+
+// UpdateSearch - Updates the title, notes and tags of a search and returns the updated search
+func (r *SearchRepositoryImpl) UpdateSearch(searchID string, searchUpdate SearchUpdate) (*models.SearchModel, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(searchID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.D{{Key: "_id", Value: objectID}, {Key: "deletedAt", Value: nil}}
+
+	// Cleared titles are removed rather than stored empty, so searches without a title sort as one group
+	set := bson.D{}
+	unset := bson.D{}
+	if searchUpdate.Title != nil && *searchUpdate.Title == "" {
+		unset = append(unset, bson.E{Key: "title", Value: ""})
+	} else if searchUpdate.Title != nil {
+		set = append(set, bson.E{Key: "title", Value: *searchUpdate.Title})
+	}
+	if searchUpdate.Notes != nil {
+		set = append(set, bson.E{Key: "notes", Value: *searchUpdate.Notes})
+	}
+	if searchUpdate.Tags != nil {
+		set = append(set, bson.E{Key: "tags", Value: searchUpdate.Tags})
+	}
+
+	if len(set) == 0 && len(unset) == 0 {
+		return r.GetSearchesByID(searchID)
+	}
+
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var search models.SearchModel
+	err = collection.FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&search)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &search, nil
+}
+
+// AddExpiration - Puts the default expiration back on a saved search and unsaves it
+func (r *SearchRepositoryImpl) AddExpiration(searchID string) (bool, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(searchID)
+	if err != nil {
+		return false, err
+	}
+
+	// Only saved searches that are not deleted can be unsaved
+	filter := bson.D{
+		{Key: "_id", Value: objectID},
+		savedFilter,
+		{Key: "deletedAt", Value: nil},
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "expiresAt", Value: time.Now().Add(r.searchTTL)},
+		{Key: "saved", Value: false},
+	}}}
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// ClaimOwnerlessSearches - Gives the saved searches without an owner, i.e. saved before accounts existed, to owner
+// Returns the number of searches claimed
+func (r *SearchRepositoryImpl) ClaimOwnerlessSearches(owner string) (int64, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	// A nil value also matches documents without the field
+	filter := bson.D{
+		{Key: "owner", Value: bson.D{{Key: "$in", Value: bson.A{nil, ""}}}},
+		savedFilter,
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "owner", Value: owner}}}}
+
+	result, err := collection.UpdateMany(context.Background(), filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// RemoveEmptyTitles - Removes the titles stored as "" and returns the number of searches changed
+// Cleared titles used to be stored as "", which the cursors of GetSearchPage can't tell apart from a missing title.
+// UpdateSearch now removes them; this cleans up the titles cleared before that.
+func (r *SearchRepositoryImpl) RemoveEmptyTitles() (int64, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	filter := bson.D{{Key: "title", Value: ""}}
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "title", Value: ""}}}}
+
+	result, err := collection.UpdateMany(context.Background(), filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// DeleteSearch - Soft-deletes a search
+// The search is purged by the TTL index once the retention window has passed
+func (r *SearchRepositoryImpl) DeleteSearch(searchID string) (bool, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(searchID)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.D{{Key: "_id", Value: objectID}, {Key: "deletedAt", Value: nil}}
+
+	// Keep the current expiration so it can be put back on restore
+	now := time.Now()
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "restoreExpiresAt", Value: "$expiresAt"},
+			{Key: "deletedAt", Value: now},
+			{Key: "expiresAt", Value: now.Add(r.deleteRetention)},
+		}}},
+	}
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// RestoreSearch - Restores a soft-deleted search that is still within the retention window
+func (r *SearchRepositoryImpl) RestoreSearch(searchID string) (bool, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(searchID)
+	if err != nil {
+		return false, err
+	}
+
+	// The TTL monitor runs periodically, so also check the window here
+	now := time.Now()
+	filter := bson.D{
+		{Key: "_id", Value: objectID},
+		{Key: "deletedAt", Value: bson.D{{Key: "$gt", Value: now.Add(-r.deleteRetention)}}},
+	}
+
+	// Saved searches get no expiration back; unsaved ones get at least the default lifetime
+	// so they don't vanish right after being restored
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "expiresAt", Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$restoreExpiresAt", nil}}}, nil}}},
+				nil,
+				bson.D{{Key: "$max", Value: bson.A{"$restoreExpiresAt", now.Add(r.searchTTL)}}},
+			}}}},
+		}}},
+		{{Key: "$unset", Value: bson.A{"deletedAt", "restoreExpiresAt"}}},
+	}
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// GetDeletedSearches - Returns soft-deleted searches that can still be restored
+func (r *SearchRepositoryImpl) GetDeletedSearches(searchFilter SearchFilter) ([]models.SearchModel, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	filter := searchFilter.apply(bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$gt", Value: time.Now().Add(-r.deleteRetention)}}}})
+	cursor, err := collection.Find(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var searches []models.SearchModel
+	if err = cursor.All(context.Background(), &searches); err != nil {
+		return nil, err
+	}
+
+	return searches, nil
+}
+
+// GetSearch - Returns a search by ID, including soft-deleted searches
+func (r *SearchRepositoryImpl) GetSearch(searchID string) (*models.SearchModel, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(searchID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.SearchModel
+	err = collection.FindOne(context.Background(), bson.D{{Key: "_id", Value: objectID}}).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// UpsertSearch - Inserts the search, or replaces the search with the same ID
+// Unlike AddSearch, the expiration of the search is kept as it is
+func (r *SearchRepositoryImpl) UpsertSearch(search models.SearchModel) error {
+	collection := r.db.Collection(SearchCollection)
+
+	filter := bson.D{{Key: "_id", Value: search.ID}}
+	_, err := collection.ReplaceOne(context.Background(), filter, search, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetSearchesByIDs - Returns the searches with the given IDs that are not deleted
+// Invalid and unknown IDs are skipped
+func (r *SearchRepositoryImpl) GetSearchesByIDs(searchIDs []string) ([]models.SearchModel, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	var objectIDs []primitive.ObjectID
+	for _, searchID := range searchIDs {
+		objectID, err := primitive.ObjectIDFromHex(searchID)
+		if err != nil {
+			continue
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+
+	if len(objectIDs) == 0 {
+		return nil, nil
+	}
+
+	filter := bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$in", Value: objectIDs}}},
+		{Key: "deletedAt", Value: nil},
+	}
+	cursor, err := collection.Find(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var searches []models.SearchModel
+	if err = cursor.All(context.Background(), &searches); err != nil {
+		return nil, err
+	}
+
+	return searches, nil
+}
+
+// SetSnapshot - Stores the snapshot of a search unless it already has one
+func (r *SearchRepositoryImpl) SetSnapshot(searchID string, snapshot models.SearchSnapshot) (bool, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(searchID)
+	if err != nil {
+		return false, err
+	}
+
+	// Snapshots are immutable once taken
+	filter := bson.D{
+		{Key: "_id", Value: objectID},
+		{Key: "snapshot", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "snapshot", Value: snapshot}}}}
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// SetPendingRerun - Stores the result of a re-run so it can be accepted later
+// Replaces any earlier re-run that was not accepted
+func (r *SearchRepositoryImpl) SetPendingRerun(searchID string, rerun models.SearchSnapshot) (bool, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(searchID)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.D{{Key: "_id", Value: objectID}, {Key: "deletedAt", Value: nil}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "pendingRerun", Value: rerun}}}}
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// AcceptPendingRerun - Makes the pending re-run the saved version of the search
+// Both the snapshot and the list of characters are replaced
+func (r *SearchRepositoryImpl) AcceptPendingRerun(searchID string) (bool, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(searchID)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.D{
+		{Key: "_id", Value: objectID},
+		{Key: "deletedAt", Value: nil},
+		{Key: "pendingRerun", Value: bson.D{{Key: "$exists", Value: true}}},
+	}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "snapshot", Value: "$pendingRerun"},
+			{Key: "characters", Value: "$pendingRerun.characters.id"},
+		}}},
+		{{Key: "$unset", Value: "pendingRerun"}},
+	}
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// SetWatchedResult - Stores the result of the last re-run of the webhook watcher
+// The pending re-run of the user is not touched
+func (r *SearchRepositoryImpl) SetWatchedResult(searchID string, watched models.SearchSnapshot) (bool, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(searchID)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.D{{Key: "_id", Value: objectID}, {Key: "deletedAt", Value: nil}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "watchedResult", Value: watched}}}}
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// AddShareLink - Adds a read-only share link to a search
+func (r *SearchRepositoryImpl) AddShareLink(searchID string, link models.ShareLinkModel) (bool, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(searchID)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.D{{Key: "_id", Value: objectID}, {Key: "deletedAt", Value: nil}}
+	update := bson.D{{Key: "$push", Value: bson.D{{Key: "shareLinks", Value: link}}}}
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// GetShareLinks - Returns the share links of a search that haven't expired or been revoked
+func (r *SearchRepositoryImpl) GetShareLinks(searchID string) ([]models.ShareLinkModel, error) {
+	search, err := r.GetSearchesByID(searchID)
+	if err != nil || search == nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var links []models.ShareLinkModel
+	for _, link := range search.ShareLinks {
+		if link.IsActive(now) {
+			links = append(links, link)
+		}
+	}
+
+	return links, nil
+}
+
+// RevokeShareLink - Revokes a share link so it can no longer be used
+// The link is kept on the search so it's clear it existed
+func (r *SearchRepositoryImpl) RevokeShareLink(searchID string, linkID string) (bool, error) {
+	collection := r.db.Collection(SearchCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(searchID)
+	if err != nil {
+		return false, err
+	}
+
+	// The positional operator updates the link matched by $elemMatch
+	filter := bson.D{
+		{Key: "_id", Value: objectID},
+		{Key: "shareLinks", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+			{Key: "id", Value: linkID},
+			{Key: "revokedAt", Value: nil},
+		}}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "shareLinks.$.revokedAt", Value: time.Now()}}}}
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
@@ -0,0 +1,91 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	SessionCollection = "sessions"
+)
+
+type SessionRepositoryImpl struct {
+	db *mongo.Database
+}
+
+// NewSessionRepository - Creates a new SessionRepositoryImpl
+func NewSessionRepository(cfg Config) (*SessionRepositoryImpl, error) {
+
+	collection := cfg.DB.Collection(SessionCollection)
+
+	// Define the index models
+	// Sessions are looked up by token hash and removed once expiresAt passes
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	// CreateMany is a no-op for indexes that already exist
+	_, err := collection.Indexes().CreateMany(context.TODO(), indexModels)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionRepositoryImpl{
+		db: cfg.DB,
+	}, nil
+}
+
+// AddSession - Adds a new session to the database
+func (r *SessionRepositoryImpl) AddSession(session models.SessionModel) error {
+	collection := r.db.Collection(SessionCollection)
+
+	_, err := collection.InsertOne(context.TODO(), session)
+	return err
+}
+
+// GetSession - Returns the session with the given token hash if it hasn't expired
+// The TTL monitor only runs periodically, so expired sessions are filtered out here as well
+func (r *SessionRepositoryImpl) GetSession(tokenHash string) (*models.SessionModel, error) {
+	collection := r.db.Collection(SessionCollection)
+
+	filter := bson.D{
+		{Key: "tokenHash", Value: tokenHash},
+		{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+	}
+
+	var result models.SessionModel
+	err := collection.FindOne(context.TODO(), filter).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// DeleteSession - Deletes the session with the given token hash
+func (r *SessionRepositoryImpl) DeleteSession(tokenHash string) (bool, error) {
+	collection := r.db.Collection(SessionCollection)
+
+	result, err := collection.DeleteOne(context.TODO(), bson.D{{Key: "tokenHash", Value: tokenHash}})
+	if err != nil {
+		return false, err
+	}
+
+	return result.DeletedCount > 0, nil
+}
@@ -0,0 +1,102 @@
+package repositories
+
+import (
+	"context"
+
+	"alvinlucillo/swapi-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	UserCollection = "users"
+)
+
+type UserRepositoryImpl struct {
+	db *mongo.Database
+}
+
+// NewUserRepository - Creates a new UserRepositoryImpl
+func NewUserRepository(cfg Config) (*UserRepositoryImpl, error) {
+
+	collection := cfg.DB.Collection(UserCollection)
+
+	// Define the index model
+	// Usernames are unique
+	indexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	// CreateOne is a no-op if the index already exists
+	_, err := collection.Indexes().CreateOne(context.TODO(), indexModel)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserRepositoryImpl{
+		db: cfg.DB,
+	}, nil
+}
+
+// AddUser - Adds a new user to the database
+// Returns an error satisfying mongo.IsDuplicateKeyError if the username is taken
+func (r *UserRepositoryImpl) AddUser(user models.UserModel) (string, error) {
+	collection := r.db.Collection(UserCollection)
+
+	result, err := collection.InsertOne(context.TODO(), user)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// GetUser - Returns a user by ID
+func (r *UserRepositoryImpl) GetUser(userID string) (*models.UserModel, error) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.findOne(bson.D{{Key: "_id", Value: objectID}})
+}
+
+// GetUserByUsername - Returns a user by username
+func (r *UserRepositoryImpl) GetUserByUsername(username string) (*models.UserModel, error) {
+	return r.findOne(bson.D{{Key: "username", Value: username}})
+}
+
+// findOne - Returns the user matching the filter, or nil if there is none
+func (r *UserRepositoryImpl) findOne(filter bson.D) (*models.UserModel, error) {
+	collection := r.db.Collection(UserCollection)
+
+	var result models.UserModel
+	err := collection.FindOne(context.TODO(), filter).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// SetUserRole - Sets the role of a user
+func (r *UserRepositoryImpl) SetUserRole(username string, role string) (bool, error) {
+	collection := r.db.Collection(UserCollection)
+
+	filter := bson.D{{Key: "username", Value: username}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: role}}}}
+
+	result, err := collection.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
@@ -75,6 +75,15 @@ func NewVehicleRepository(cfg Config) (*VehicleRepositoryImpl, error) {
 		}
 	}
 
+	// Vehicles are looked up by ID, many at a time when GraphQL resolvers are batched
+	// CreateOne is a no-op if the index already exists
+	_, err = collection.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "id", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	return &VehicleRepositoryImpl{
 		db: cfg.DB,
 	}, nil
@@ -113,3 +122,20 @@ func (r *VehicleRepositoryImpl) GetVehicle(url string) (*models.VehicleModel, er
 
 	return &vehicle, nil
 }
+
+// GetVehicles - Gets the vehicles with the given IDs in one query; IDs that aren't cached are left out
+func (r *VehicleRepositoryImpl) GetVehicles(ids []string) ([]models.VehicleModel, error) {
+	collection := r.db.Collection(VehicleCollection)
+
+	cursor, err := collection.Find(context.TODO(), bson.M{"id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+
+	var vehicles []models.VehicleModel
+	if err = cursor.All(context.TODO(), &vehicles); err != nil {
+		return nil, err
+	}
+
+	return vehicles, nil
+}
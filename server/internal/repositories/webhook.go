@@ -0,0 +1,147 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	WebhookCollection         = "webhooks"
+	WebhookDeliveryCollection = "webhookDeliveries"
+	// How long webhook deliveries are kept if no retention is configured
+	DefaultWebhookDeliveryRetention = 30 * 24 * time.Hour
+)
+
+type WebhookRepositoryImpl struct {
+	db *mongo.Database
+}
+
+// NewWebhookRepository - Creates a new WebhookRepositoryImpl
+// Deliveries are removed by a TTL index once they are older than the retention
+func NewWebhookRepository(cfg Config) (*WebhookRepositoryImpl, error) {
+
+	webhooks := cfg.DB.Collection(WebhookCollection)
+	if _, err := webhooks.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "owner", Value: 1}},
+	}); err != nil {
+		return nil, err
+	}
+
+	retention := cfg.WebhookDeliveryRetention
+	if retention <= 0 {
+		retention = DefaultWebhookDeliveryRetention
+	}
+	expireAfterSeconds := int32(retention / time.Second)
+
+	deliveries := cfg.DB.Collection(WebhookDeliveryCollection)
+	if err := dropChangedTTLIndex(deliveries, "createdAt_1", expireAfterSeconds); err != nil {
+		return nil, err
+	}
+
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "createdAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(expireAfterSeconds),
+		},
+		{
+			Keys: bson.D{{Key: "webhookID", Value: 1}, {Key: "_id", Value: -1}},
+		},
+	}
+	if _, err := deliveries.Indexes().CreateMany(context.TODO(), indexModels); err != nil {
+		return nil, err
+	}
+
+	return &WebhookRepositoryImpl{
+		db: cfg.DB,
+	}, nil
+}
+
+// AddWebhook - Adds a webhook to the database
+func (r *WebhookRepositoryImpl) AddWebhook(webhook models.WebhookModel) (string, error) {
+	collection := r.db.Collection(WebhookCollection)
+
+	if _, err := collection.InsertOne(context.TODO(), webhook); err != nil {
+		return "", err
+	}
+
+	return webhook.ID.Hex(), nil
+}
+
+// GetWebhooks - Returns the webhooks of a user, oldest first
+func (r *WebhookRepositoryImpl) GetWebhooks(owner string) ([]models.WebhookModel, error) {
+	return r.findWebhooks(bson.D{{Key: "owner", Value: owner}})
+}
+
+// GetAllWebhooks - Returns the webhooks of every user, for the watcher
+func (r *WebhookRepositoryImpl) GetAllWebhooks() ([]models.WebhookModel, error) {
+	return r.findWebhooks(bson.D{})
+}
+
+// findWebhooks - Returns the webhooks matching the filter, oldest first
+func (r *WebhookRepositoryImpl) findWebhooks(filter bson.D) ([]models.WebhookModel, error) {
+	collection := r.db.Collection(WebhookCollection)
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := collection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var webhooks []models.WebhookModel
+	if err = cursor.All(context.TODO(), &webhooks); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// DeleteWebhook - Deletes a webhook of a user; its deliveries are kept until they expire
+func (r *WebhookRepositoryImpl) DeleteWebhook(webhookID string, owner string) (bool, error) {
+	collection := r.db.Collection(WebhookCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(webhookID)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.D{{Key: "_id", Value: objectID}, {Key: "owner", Value: owner}}
+	result, err := collection.DeleteOne(context.TODO(), filter)
+	if err != nil {
+		return false, err
+	}
+
+	return result.DeletedCount > 0, nil
+}
+
+// AddDelivery - Appends a delivery to the delivery log
+func (r *WebhookRepositoryImpl) AddDelivery(delivery models.WebhookDeliveryModel) error {
+	collection := r.db.Collection(WebhookDeliveryCollection)
+
+	_, err := collection.InsertOne(context.TODO(), delivery)
+	return err
+}
+
+// GetDeliveries - Returns up to limit deliveries of a webhook, newest first
+func (r *WebhookRepositoryImpl) GetDeliveries(webhookID string, limit int) ([]models.WebhookDeliveryModel, error) {
+	collection := r.db.Collection(WebhookDeliveryCollection)
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := collection.Find(context.TODO(), bson.D{{Key: "webhookID", Value: webhookID}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []models.WebhookDeliveryModel
+	if err = cursor.All(context.TODO(), &deliveries); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
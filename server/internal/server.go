@@ -1,7 +1,10 @@
 package internal
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/graphql-go/graphql"
 	"github.com/graphql-go/handler"
@@ -15,21 +18,70 @@ type Server struct {
 
 type ServerConfig struct {
 	Port string
+	// Origins allowed to call the GraphQL API from a browser
+	AllowedOrigins []string
+	// ReadyCheck reports whether the server's dependencies are reachable
+	ReadyCheck func(ctx context.Context) error
+	// Production leaves the reason out of failed ready checks, since it may name internal hosts
+	Production bool
+	// Middleware wraps the GraphQL handler, outermost first, e.g. to authenticate requests
+	Middleware []func(http.Handler) http.Handler
+	// Routes served next to the GraphQL handler, keyed by path pattern
+	Routes map[string]http.Handler
 }
 
 // NewServer returns a new HTTP server
 func NewServer(cfg ServerConfig, h *handler.Handler) *http.Server {
+	// Requests authenticate with headers rather than cookies, so credentials are never allowed
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"}, // Allow all origins
-		AllowCredentials: true,
+		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowCredentials: false,
 		AllowedMethods:   []string{"OPTIONS", "POST"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key"},
 	})
 
+	var graphqlHandler http.Handler = h
+	for i := len(cfg.Middleware) - 1; i >= 0; i-- {
+		graphqlHandler = cfg.Middleware[i](graphqlHandler)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", readyHandler(cfg.ReadyCheck, cfg.Production))
+	for pattern, route := range cfg.Routes {
+		mux.Handle(pattern, route)
+	}
+	mux.Handle("/", c.Handler(graphqlHandler))
+
 	// Create a new HTTP server
 	srv := &http.Server{
 		Addr:    ":" + cfg.Port,
-		Handler: c.Handler(h),
+		Handler: mux,
 	}
 
 	return srv
 }
+
+// readyHandler responds with 200 when the ready check passes and 503 otherwise
+func readyHandler(check func(ctx context.Context) error, production bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := map[string]string{"status": "ok"}
+		code := http.StatusOK
+
+		if check != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+			defer cancel()
+
+			if err := check(ctx); err != nil {
+				status = map[string]string{"status": "unavailable"}
+				if !production {
+					status["error"] = err.Error()
+				}
+				code = http.StatusServiceUnavailable
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(status)
+	}
+}
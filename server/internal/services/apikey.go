@@ -0,0 +1,221 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/models"
+	"alvinlucillo/swapi-app/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// API keys start with this prefix so they can be told apart from session tokens
+	apiKeyPrefix = "swapi_"
+	apiKeySize   = 32
+	// Number of characters of the key kept for display, including the prefix
+	apiKeyDisplayLength = 12
+)
+
+// CreateAPIKey - Creates an API key that acts as the user, limited to the given role
+// The key is returned only once; only its hash is stored
+func (c *CharacterServiceImpl) CreateAPIKey(username string, name string, role Role) (string, *APIKey, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if _, err := ParseRole(string(role)); err != nil {
+		return "", nil, err
+	}
+
+	user, err := repo.UserRepository.GetUserByUsername(normalizeUsername(username))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get user: %w", c.checkStorage(err))
+	}
+
+	if user == nil {
+		return "", nil, notFound("user %s not found", username)
+	}
+
+	keyBytes := make([]byte, apiKeySize)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return "", nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(keyBytes)
+
+	apiKey := models.APIKeyModel{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		Prefix:    key[:apiKeyDisplayLength],
+		KeyHash:   hashToken(key),
+		UserID:    user.ID.Hex(),
+		Role:      string(role),
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := repo.APIKeyRepository.AddAPIKey(apiKey); err != nil {
+		return "", nil, fmt.Errorf("failed to add API key: %w", c.checkStorage(err))
+	}
+
+	result := toAPIKey(apiKey)
+	return key, &result, nil
+}
+
+// GetAPIKeys - Gets all API keys, including revoked ones
+func (c *CharacterServiceImpl) GetAPIKeys() ([]APIKey, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := repo.APIKeyRepository.GetAPIKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API keys: %w", c.checkStorage(err))
+	}
+
+	var results []APIKey
+	for _, apiKey := range apiKeys {
+		results = append(results, toAPIKey(apiKey))
+	}
+
+	return results, nil
+}
+
+// RevokeAPIKey - Revokes an API key so requests using it are rejected
+func (c *CharacterServiceImpl) RevokeAPIKey(apiKeyID string) (bool, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return false, err
+	}
+
+	result, err := repo.APIKeyRepository.RevokeAPIKey(apiKeyID)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke API key: %w", c.checkStorage(err))
+	}
+
+	return result, nil
+}
+
+// SetUserRole - Sets the role of a user
+func (c *CharacterServiceImpl) SetUserRole(username string, role Role) (bool, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := ParseRole(string(role)); err != nil {
+		return false, err
+	}
+
+	result, err := repo.UserRepository.SetUserRole(normalizeUsername(username), string(role))
+	if err != nil {
+		return false, fmt.Errorf("failed to set user role: %w", c.checkStorage(err))
+	}
+
+	return result, nil
+}
+
+// GetUser - Gets a user by username, or nil if there is none
+func (c *CharacterServiceImpl) GetUser(username string) (*User, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := repo.UserRepository.GetUserByUsername(normalizeUsername(username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", c.checkStorage(err))
+	}
+
+	if user == nil {
+		return nil, nil
+	}
+
+	return &User{ID: user.ID.Hex(), Username: user.Username, Role: userRole(*user)}, nil
+}
+
+// ClaimOwnerlessSearches - Gives the saved searches without an owner to a user and returns how many were claimed
+// Searches saved before accounts existed have no owner, so no one can list or load them until they're claimed.
+func (c *CharacterServiceImpl) ClaimOwnerlessSearches(username string) (int64, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return 0, err
+	}
+
+	user, err := repo.UserRepository.GetUserByUsername(normalizeUsername(username))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get user: %w", c.checkStorage(err))
+	}
+
+	if user == nil {
+		return 0, notFound("user %s not found", username)
+	}
+
+	claimed, err := repo.SearchRepository.ClaimOwnerlessSearches(user.ID.Hex())
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim searches: %w", c.checkStorage(err))
+	}
+
+	return claimed, nil
+}
+
+// RemoveEmptySearchTitles - Removes the cleared search titles that older versions stored as ""
+// Returns the number of searches changed; it only needs to run once after upgrading.
+func (c *CharacterServiceImpl) RemoveEmptySearchTitles() (int64, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return 0, err
+	}
+
+	removed, err := repo.SearchRepository.RemoveEmptyTitles()
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove empty titles: %w", c.checkStorage(err))
+	}
+
+	return removed, nil
+}
+
+// authenticateAPIKey - Returns the user an API key acts as, or nil if the key is unknown or revoked
+// The key can never do more than its user, so the lower of the two roles is used
+func (c *CharacterServiceImpl) authenticateAPIKey(repo *repositories.Repository, key string) (*User, error) {
+	apiKey, err := repo.APIKeyRepository.GetAPIKeyByHash(hashToken(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", c.checkStorage(err))
+	}
+
+	if apiKey == nil {
+		return nil, nil
+	}
+
+	user, err := repo.UserRepository.GetUser(apiKey.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", c.checkStorage(err))
+	}
+
+	if user == nil {
+		return nil, nil
+	}
+
+	return &User{
+		ID:       user.ID.Hex(),
+		Username: user.Username,
+		Role:     lowerRole(Role(apiKey.Role), userRole(*user)),
+	}, nil
+}
+
+// toAPIKey - Converts an API key model to the API key result
+func toAPIKey(apiKey models.APIKeyModel) APIKey {
+	return APIKey{
+		ID:        apiKey.ID.Hex(),
+		Name:      apiKey.Name,
+		Prefix:    apiKey.Prefix,
+		UserID:    apiKey.UserID,
+		Role:      Role(apiKey.Role),
+		CreatedAt: apiKey.CreatedAt,
+		RevokedAt: apiKey.RevokedAt,
+	}
+}
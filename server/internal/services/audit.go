@@ -0,0 +1,285 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/models"
+	"alvinlucillo/swapi-app/internal/repositories"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Page size of the audit log if none is given, and the largest page allowed
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// Arguments that are never written to the audit log
+var redactedAuditArguments = map[string]bool{
+	"password": true,
+}
+
+// AuditTarget is the search or collection a mutation changes
+type AuditTarget struct {
+	// search or collection; empty if only the ID is known
+	Kind string
+	ID   string
+}
+
+// AuditRecord is what's known about a mutation once it ran
+type AuditRecord struct {
+	Actor     *User
+	Operation string
+	Target    AuditTarget
+	Arguments map[string]interface{}
+	Before    interface{}
+	After     interface{}
+	Err       error
+}
+
+// GetAuditState - Gets the current state of a mutation target, or nil if there is none
+// Deleted searches are included so deleting and restoring show up in the log
+func (c *CharacterServiceImpl) GetAuditState(target AuditTarget) (interface{}, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	switch target.Kind {
+	case "search":
+		search, err := repo.SearchRepository.GetSearch(target.ID)
+		if err != nil || search == nil {
+			return nil, c.checkStorage(err)
+		}
+		return search, nil
+	case "collection":
+		collection, err := repo.CollectionRepository.GetCollection(target.ID)
+		if err != nil || collection == nil {
+			return nil, c.checkStorage(err)
+		}
+		return collection, nil
+	}
+
+	return nil, nil
+}
+
+// RecordAudit - Appends a mutation to the audit log
+func (c *CharacterServiceImpl) RecordAudit(record AuditRecord) error {
+	repo, err := c.repo()
+	if err != nil {
+		return err
+	}
+
+	entry := models.AuditModel{
+		ID:        primitive.NewObjectID(),
+		Operation: record.Operation,
+		TargetID:  record.Target.ID,
+		Arguments: redactAuditArguments(record.Arguments),
+		Before:    record.Before,
+		After:     record.After,
+		CreatedAt: time.Now(),
+	}
+	if record.Actor != nil {
+		entry.ActorID = record.Actor.ID
+		entry.ActorName = record.Actor.Username
+	}
+	if record.Err != nil {
+		entry.Error = record.Err.Error()
+	}
+
+	if err := repo.AuditRepository.AddAuditEntry(entry); err != nil {
+		return fmt.Errorf("failed to add audit entry: %w", c.checkStorage(err))
+	}
+
+	return nil
+}
+
+// GetAuditLog - Gets a page of the audit log, newest first
+// after is the EndCursor of the previous page
+func (c *CharacterServiceImpl) GetAuditLog(filter AuditFilter, first int, after string) (*AuditLogPage, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if first <= 0 {
+		first = defaultAuditPageSize
+	}
+	if first > maxAuditPageSize {
+		return nil, invalidArgument("first can't be more than %d", maxAuditPageSize)
+	}
+	if after != "" && !primitive.IsValidObjectID(after) {
+		return nil, invalidArgument("invalid cursor %q", after)
+	}
+
+	// Fetch one more entry than asked to know if there's a next page
+	entries, err := repo.AuditRepository.GetAuditEntries(repositories.AuditFilter{
+		ActorID:   filter.ActorID,
+		Operation: filter.Operation,
+		TargetID:  filter.TargetID,
+		Since:     filter.Since,
+		Until:     filter.Until,
+		BeforeID:  after,
+	}, first+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit entries: %w", c.checkStorage(err))
+	}
+
+	page := &AuditLogPage{Entries: []AuditEntry{}}
+	if len(entries) > first {
+		page.HasNextPage = true
+		entries = entries[:first]
+	}
+
+	for _, entry := range entries {
+		page.Entries = append(page.Entries, AuditEntry{
+			ID:        entry.ID.Hex(),
+			ActorID:   entry.ActorID,
+			ActorName: entry.ActorName,
+			Operation: entry.Operation,
+			TargetID:  entry.TargetID,
+			Arguments: toAuditJSON(entry.Arguments),
+			Before:    toAuditJSON(entry.Before),
+			After:     toAuditJSON(entry.After),
+			Error:     entry.Error,
+			CreatedAt: entry.CreatedAt,
+		})
+	}
+
+	if len(page.Entries) > 0 {
+		page.EndCursor = page.Entries[len(page.Entries)-1].ID
+	}
+
+	return page, nil
+}
+
+// auditMutations - Wraps the resolver of every mutation so it's written to the audit log
+// with the state of its target before and after. Failed mutations are recorded too.
+// Writing the log never fails the mutation itself.
+// The state is only read for users who may change targets, and left out of rejected mutations, so
+// requests that are turned away don't cost reads nor put targets of other users in the log.
+func auditMutations(svc CharacterService, fields graphql.Fields) graphql.Fields {
+	for operation, field := range fields {
+		operation, resolve := operation, field.Resolve
+
+		field.Resolve = func(p graphql.ResolveParams) (interface{}, error) {
+			target := auditTargetFromArgs(p.Args)
+
+			// Every mutation of a search or collection needs at least an editor
+			var before interface{}
+			if _, err := authorize(p.Context, RoleEditor); err == nil {
+				before = auditState(svc, target)
+			}
+
+			result, err := resolve(p)
+
+			// Created collections are only known once the mutation ran
+			if target.ID == "" {
+				if collection, ok := result.(*Collection); ok && collection != nil {
+					target = AuditTarget{Kind: "collection", ID: collection.ID}
+				}
+			}
+
+			var after interface{}
+			if isRejected(err) {
+				before = nil
+			} else {
+				after = auditState(svc, target)
+			}
+
+			record := AuditRecord{
+				Actor:     UserFromContext(p.Context),
+				Operation: operation,
+				Target:    target,
+				Arguments: p.Args,
+				Before:    before,
+				After:     after,
+				Err:       err,
+			}
+			if auditErr := svc.RecordAudit(record); auditErr != nil {
+				fmt.Printf("failed to record %s in the audit log: %v\n", operation, auditErr)
+			}
+
+			return result, err
+		}
+	}
+
+	return fields
+}
+
+// isRejected - Checks if a mutation was turned away because of who made it or a target that isn't theirs
+func isRejected(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	code, _ := ErrorCodeOf(err)
+	return code == CodeUnauthorized || code == CodeForbidden || code == CodeNotFound
+}
+
+// auditTargetFromArgs - Finds the search or collection a mutation changes from its arguments
+// Collections come first, since adding a search to a collection changes the collection
+func auditTargetFromArgs(args map[string]interface{}) AuditTarget {
+	if id, ok := args["collectionID"].(string); ok {
+		return AuditTarget{Kind: "collection", ID: id}
+	}
+	if id, ok := args["searchID"].(string); ok {
+		return AuditTarget{Kind: "search", ID: id}
+	}
+	for _, name := range []string{"id", "webhookID"} {
+		if id, ok := args[name].(string); ok {
+			return AuditTarget{ID: id}
+		}
+	}
+	return AuditTarget{}
+}
+
+// auditState - Gets the state of a target for the audit log; errors only leave the state out
+func auditState(svc CharacterService, target AuditTarget) interface{} {
+	if target.Kind == "" {
+		return nil
+	}
+
+	state, err := svc.GetAuditState(target)
+	if err != nil && !errors.Is(err, ErrStorageUnavailable) {
+		fmt.Printf("failed to get %s %s for the audit log: %v\n", target.Kind, target.ID, err)
+	}
+
+	return state
+}
+
+// redactAuditArguments - Returns a copy of the arguments without secrets
+func redactAuditArguments(args map[string]interface{}) map[string]interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+
+	redacted := make(map[string]interface{}, len(args))
+	for name, value := range args {
+		if redactedAuditArguments[name] {
+			value = "[REDACTED]"
+		}
+		redacted[name] = value
+	}
+
+	return redacted
+}
+
+// toAuditJSON - Encodes a stored value as relaxed extended JSON, or an empty string if there is none
+func toAuditJSON(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	encoded, err := bson.MarshalExtJSON(value, false, false)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+
+	return string(encoded)
+}
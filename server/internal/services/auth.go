@@ -0,0 +1,360 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	// ErrUnauthenticated is returned by operations that need a logged in user
+	ErrUnauthenticated error = newError(CodeUnauthorized, "authentication required: log in to access saved searches")
+	// ErrInvalidCredentials is returned when the username or password is wrong
+	ErrInvalidCredentials error = newError(CodeUnauthorized, "invalid username or password")
+	// ErrForbidden is returned when the user's role doesn't allow the operation
+	ErrForbidden error = newError(CodeForbidden, "forbidden: your role doesn't allow this operation")
+)
+
+const (
+	// Number of PBKDF2 iterations for new passwords, as recommended by OWASP for PBKDF2-HMAC-SHA256
+	passwordIterations = 600000
+	passwordSaltSize   = 16
+	passwordKeySize    = 32
+	minPasswordLength  = 8
+	sessionTokenSize   = 32
+	// How long a session is valid if no TTL is configured
+	defaultSessionTTL = 24 * time.Hour
+)
+
+type userContextKey struct{}
+
+type sessionTokenContextKey struct{}
+
+// WithUser - Returns a copy of the context carrying the authenticated user
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// UserFromContext - Returns the authenticated user, or nil for anonymous requests
+func UserFromContext(ctx context.Context) *User {
+	if ctx == nil {
+		return nil
+	}
+
+	user, _ := ctx.Value(userContextKey{}).(*User)
+	return user
+}
+
+// withSessionToken - Returns a copy of the context carrying the session token of the request
+func withSessionToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, sessionTokenContextKey{}, token)
+}
+
+// sessionTokenFromContext - Returns the session token of the request, or an empty string
+func sessionTokenFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	token, _ := ctx.Value(sessionTokenContextKey{}).(string)
+	return token
+}
+
+// currentUserID - Returns the ID of the authenticated user, or an empty string for anonymous requests
+func currentUserID(ctx context.Context) string {
+	if user := UserFromContext(ctx); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// authorize - Returns the ID of the authenticated user if their role allows the required role
+// Returns ErrUnauthenticated for anonymous requests and ErrForbidden if the role is too low
+func authorize(ctx context.Context, required Role) (string, error) {
+	user := UserFromContext(ctx)
+	if user == nil {
+		return "", ErrUnauthenticated
+	}
+
+	if !user.Role.Allows(required) {
+		return "", ErrForbidden
+	}
+
+	return user.ID, nil
+}
+
+// roleRanks orders the roles from least to most privileged
+var roleRanks = map[Role]int{
+	RoleReader: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// ParseRole - Converts a string to a role, returning an error for unknown roles
+func ParseRole(role string) (Role, error) {
+	r := Role(strings.ToLower(strings.TrimSpace(role)))
+	if _, ok := roleRanks[r]; !ok {
+		return "", invalidArgument("unknown role %q, expected reader, editor or admin", role)
+	}
+	return r, nil
+}
+
+// Allows - Checks if the role includes the required role
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// lowerRole - Returns the less privileged of two roles
+func lowerRole(a Role, b Role) Role {
+	if roleRanks[a] < roleRanks[b] {
+		return a
+	}
+	return b
+}
+
+// userRole - Returns the role of a user model; users created before roles existed are editors
+func userRole(user models.UserModel) Role {
+	if user.Role == "" {
+		return RoleEditor
+	}
+	return Role(user.Role)
+}
+
+// Register - Creates a user with a salted password hash and logs them in
+func (c *CharacterServiceImpl) Register(username string, password string) (*AuthSession, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	username = normalizeUsername(username)
+	if len(username) < 3 || len(username) > 32 {
+		return nil, invalidArgument("username must be between 3 and 32 characters")
+	}
+	if len(password) < minPasswordLength {
+		return nil, invalidArgument("password must be at least %d characters", minPasswordLength)
+	}
+
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	user := models.UserModel{
+		ID:           primitive.NewObjectID(),
+		Username:     username,
+		PasswordHash: hashPassword(password, salt, passwordIterations),
+		Salt:         salt,
+		Iterations:   passwordIterations,
+		Role:         string(RoleReader),
+		CreatedAt:    time.Now(),
+	}
+
+	if _, err := repo.UserRepository.AddUser(user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, invalidArgument("username %s is already taken", username)
+		}
+		return nil, fmt.Errorf("failed to add user: %w", c.checkStorage(err))
+	}
+
+	return c.newSession(user)
+}
+
+// Login - Checks the password of a user and starts a new session
+func (c *CharacterServiceImpl) Login(username string, password string) (*AuthSession, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := repo.UserRepository.GetUserByUsername(normalizeUsername(username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", c.checkStorage(err))
+	}
+
+	if user == nil {
+		// Hash anyway so unknown usernames take as long as wrong passwords
+		hashPassword(password, make([]byte, passwordSaltSize), passwordIterations)
+		return nil, ErrInvalidCredentials
+	}
+
+	hash := hashPassword(password, user.Salt, user.Iterations)
+	if subtle.ConstantTimeCompare(hash, user.PasswordHash) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	return c.newSession(*user)
+}
+
+// Logout - Ends the session of the given token
+func (c *CharacterServiceImpl) Logout(token string) (bool, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return false, err
+	}
+
+	result, err := repo.SessionRepository.DeleteSession(hashToken(token))
+	if err != nil {
+		return false, fmt.Errorf("failed to delete session: %w", c.checkStorage(err))
+	}
+
+	return result, nil
+}
+
+// Authenticate - Returns the user of a session token or API key, or nil if it's unknown, expired or revoked
+func (c *CharacterServiceImpl) Authenticate(token string) (*User, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		return c.authenticateAPIKey(repo, token)
+	}
+
+	session, err := repo.SessionRepository.GetSession(hashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", c.checkStorage(err))
+	}
+
+	if session == nil {
+		return nil, nil
+	}
+
+	user, err := repo.UserRepository.GetUser(session.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", c.checkStorage(err))
+	}
+
+	if user == nil {
+		return nil, nil
+	}
+
+	return &User{ID: user.ID.Hex(), Username: user.Username, Role: userRole(*user)}, nil
+}
+
+// newSession - Starts a session for the user and returns its token
+// Only the hash of the token is stored, so a leaked database can't be used to log in
+func (c *CharacterServiceImpl) newSession(user models.UserModel) (*AuthSession, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	tokenBytes := make([]byte, sessionTokenSize)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate session token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	ttl := c.sessionTTL
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+
+	now := time.Now()
+	session := models.SessionModel{
+		TokenHash: hashToken(token),
+		UserID:    user.ID.Hex(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	if err := repo.SessionRepository.AddSession(session); err != nil {
+		return nil, fmt.Errorf("failed to add session: %w", c.checkStorage(err))
+	}
+
+	return &AuthSession{
+		Token:     token,
+		ExpiresAt: session.ExpiresAt,
+		User:      User{ID: user.ID.Hex(), Username: user.Username, Role: userRole(user)},
+	}, nil
+}
+
+// normalizeUsername - Trims and lowercases a username so logins are case-insensitive
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// hashPassword - Derives the password hash with PBKDF2-SHA256
+func hashPassword(password string, salt []byte, iterations int) []byte {
+	return pbkdf2.Key([]byte(password), salt, iterations, passwordKeySize, sha256.New)
+}
+
+// hashToken - Returns the hex encoded SHA-256 of a session token
+// Tokens are random, so a plain hash is enough; no salt is needed
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// BearerToken - Returns the token of an "Authorization: Bearer <token>" header, or an empty string
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// NewAuthMiddleware - Returns middleware that resolves the session token or API key to a user
+// and adds it to the request context. Both are accepted as "Authorization: Bearer <token>";
+// API keys can also be sent as "X-API-Key: <key>". Requests without either are anonymous.
+// Requests with an unknown or expired token are rejected with 401 so clients know to log in again.
+// If storage is down, sessions can't be checked and the request continues anonymously.
+func NewAuthMiddleware(cfg HandlerConfig, svc CharacterService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := BearerToken(r)
+			if token == "" {
+				token = strings.TrimSpace(r.Header.Get("X-API-Key"))
+			}
+			if token == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			user, err := svc.Authenticate(token)
+			if err != nil {
+				if errors.Is(err, ErrStorageUnavailable) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				writeAuthError(w, http.StatusInternalServerError, errorMessage(err, cfg.Production))
+				return
+			}
+
+			if user == nil {
+				writeAuthError(w, http.StatusUnauthorized, "invalid, expired or revoked token")
+				return
+			}
+
+			ctx := withSessionToken(WithUser(r.Context(), user), token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// writeAuthError - Writes a GraphQL style error response
+func writeAuthError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"message": message}},
+	})
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/graphql-go/handler"
+)
+
+// Code of the error of batches with more operations than allowed
+const ErrCodeBatchTooLarge = "BATCH_TOO_LARGE"
+
+// NewBatchMiddleware - Returns the middleware running batches of operations, sent as a JSON array in the body
+// of a POST, e.g. by Apollo's BatchHttpLink
+//
+// Every operation of a batch goes through the rest of the chain on its own, concurrently, with the context
+// of the batch, so they share its user and loaders. The response is the array of their results, in order;
+// operations rejected by a later middleware, e.g. for their cost, get their error as their result.
+// Batches of more than maxBatchSize operations are rejected; 0 or less turns batching off.
+func NewBatchMiddleware(maxBatchSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost || r.Body == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Single operations are passed on as they are, so the body is kept
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			trimmed := bytes.TrimSpace(body)
+			if len(trimmed) == 0 || trimmed[0] != '[' {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if maxBatchSize <= 0 {
+				writeBatchError(w, "batched operations are not supported", "")
+				return
+			}
+
+			var operations []json.RawMessage
+			if err := json.Unmarshal(trimmed, &operations); err != nil {
+				writeBatchError(w, "invalid batch: "+err.Error(), "")
+				return
+			}
+			if len(operations) == 0 {
+				writeBatchError(w, "invalid batch: there are no operations", "")
+				return
+			}
+			if len(operations) > maxBatchSize {
+				writeBatchError(w, fmt.Sprintf("batch has %d operations, more than the %d allowed", len(operations), maxBatchSize), ErrCodeBatchTooLarge)
+				return
+			}
+
+			results := make([][]byte, len(operations))
+			var wg sync.WaitGroup
+			for i, operation := range operations {
+				wg.Add(1)
+				go func(i int, operation json.RawMessage) {
+					defer wg.Done()
+
+					single := r.Clone(r.Context())
+					single.Body = io.NopCloser(bytes.NewReader(operation))
+					single.ContentLength = int64(len(operation))
+					single.Header.Set("Content-Type", handler.ContentTypeJSON)
+
+					response := &batchResponseWriter{header: http.Header{}}
+					next.ServeHTTP(response, single)
+					results[i] = bytes.TrimSpace(response.body.Bytes())
+				}(i, operation)
+			}
+			wg.Wait()
+
+			var batch bytes.Buffer
+			batch.WriteString("[")
+			for i, result := range results {
+				if i > 0 {
+					batch.WriteString(",")
+				}
+				if !json.Valid(result) {
+					result, _ = json.Marshal(map[string]interface{}{
+						"errors": []map[string]string{{"message": internalErrorMessage}},
+					})
+				}
+				batch.Write(result)
+			}
+			batch.WriteString("]")
+
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(batch.Bytes())
+		})
+	}
+}
+
+// batchResponseWriter keeps the response of an operation of a batch to add it to the response of the batch
+// The status is dropped; the batch always succeeds and the errors are in the results.
+type batchResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *batchResponseWriter) WriteHeader(statusCode int) {}
+
+// writeBatchError - Writes the GraphQL response of a rejected batch, with the code of the error if there's one
+func writeBatchError(w http.ResponseWriter, message string, code string) {
+	formatted := map[string]interface{}{"message": message}
+	if code != "" {
+		formatted["extensions"] = map[string]interface{}{"code": code}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]interface{}{formatted},
+	})
+}
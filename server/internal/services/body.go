@@ -0,0 +1,47 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// NewBodyLimitMiddleware - Returns the middleware limiting the body of requests to maxBytes
+// It goes first in the chain so no later middleware reads more than maxBytes, e.g. before the user
+// is authenticated or the operation is checked against the query limits. Bodies that say they're larger
+// are rejected with 413 right away; the others fail with *http.MaxBytesError once maxBytes are read.
+// 0 or less turns the limit off.
+func NewBodyLimitMiddleware(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxBytes <= 0 || r.Body == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.ContentLength > maxBytes {
+				writeBodyTooLarge(w, maxBytes)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeBodyError - Writes the error of a request body that couldn't be read
+// Bodies over the limit of NewBodyLimitMiddleware get 413, other failures 400
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeBodyTooLarge(w, tooLarge.Limit)
+		return
+	}
+	writeAuthError(w, http.StatusBadRequest, "failed to read request body")
+}
+
+// writeBodyTooLarge - Writes the 413 of a request body over the limit
+func writeBodyTooLarge(w http.ResponseWriter, maxBytes int64) {
+	writeAuthError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than the maximum of %d bytes", maxBytes))
+}
@@ -0,0 +1,440 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/models"
+	"alvinlucillo/swapi-app/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BundleVersion is the version of the bundle format written by ExportBundle
+// Bundles of a newer version are rejected so fields aren't silently dropped
+const BundleVersion = 1
+
+// Bundle is a portable file with saved searches, their characters and the films and vehicles they reference
+type Bundle struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exportedAt"`
+	Searches   []BundleSearch    `json:"searches"`
+	Characters []BundleCharacter `json:"characters"`
+	Films      []BundleFilm      `json:"films"`
+	Vehicles   []BundleVehicle   `json:"vehicles"`
+}
+
+// BundleSearch is a saved search in a bundle; imported searches are saved without an expiration
+type BundleSearch struct {
+	ID           string   `json:"id"`
+	SearchKey    string   `json:"searchKey"`
+	Title        string   `json:"title,omitempty"`
+	Notes        string   `json:"notes,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	CharacterIDs []string `json:"characterIDs"`
+	// The characters as they were when the search was saved, if it has a snapshot
+	Snapshot *BundleSnapshot `json:"snapshot,omitempty"`
+}
+
+// BundleSnapshot is the snapshot of a saved search in a bundle
+type BundleSnapshot struct {
+	TakenAt    time.Time                 `json:"takenAt"`
+	Characters []BundleSnapshotCharacter `json:"characters"`
+}
+
+// BundleSnapshotCharacter is a character of a snapshot, with film titles and vehicle models
+type BundleSnapshotCharacter struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Films         []string `json:"films"`
+	VehicleModels []string `json:"vehicleModels"`
+	// Left out by bundles exported before films and vehicles had IDs in snapshots
+	FilmIDs    []string `json:"filmIDs,omitempty"`
+	VehicleIDs []string `json:"vehicleIDs,omitempty"`
+}
+
+// BundleCharacter is a character in a bundle, referencing films and vehicles by ID
+type BundleCharacter struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	FilmIDs    []string `json:"filmIDs"`
+	VehicleIDs []string `json:"vehicleIDs"`
+}
+
+// BundleFilm is a film in a bundle
+type BundleFilm struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// BundleVehicle is a vehicle in a bundle
+type BundleVehicle struct {
+	ID    string `json:"id"`
+	Model string `json:"model"`
+}
+
+// ReadBundle - Decodes a bundle and checks its version
+func ReadBundle(r io.Reader) (*Bundle, error) {
+	var bundle Bundle
+	if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+		return nil, invalidArgument("invalid bundle: %w", err)
+	}
+
+	if bundle.Version < 1 || bundle.Version > BundleVersion {
+		return nil, invalidArgument("unsupported bundle version %d, expected %d", bundle.Version, BundleVersion)
+	}
+
+	return &bundle, nil
+}
+
+// WriteBundle - Encodes a bundle as indented JSON
+func WriteBundle(w io.Writer, bundle *Bundle) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(bundle)
+}
+
+// ParseImportConflict - Converts a string to a conflict strategy, returning an error for unknown ones
+func ParseImportConflict(conflict string) (ImportConflict, error) {
+	c := ImportConflict(strings.ToUpper(strings.TrimSpace(conflict)))
+	switch c {
+	case ImportConflictSkip, ImportConflictOverwrite, ImportConflictDuplicate:
+		return c, nil
+	}
+	return "", invalidArgument("unknown conflict strategy %q, expected skip, overwrite or duplicate", conflict)
+}
+
+// ExportBundle - Builds a bundle with a saved search of the user, or all their saved searches if searchID is empty
+// Characters, films and vehicles that are no longer cached are fetched from the SWAPI
+func (c *CharacterServiceImpl) ExportBundle(userID string, searchID string) (*Bundle, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	var searches []models.SearchModel
+	if searchID == "" {
+		searches, err = repo.SearchRepository.GetSearches(repositories.SearchFilter{Owner: userID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get searches: %w", c.checkStorage(err))
+		}
+	} else {
+		search, err := c.getOwnedSearch(repo, userID, searchID)
+		if err != nil {
+			return nil, err
+		}
+		if search == nil {
+			return nil, fmt.Errorf("%w: %s", ErrSearchNotFound, searchID)
+		}
+		searches = append(searches, *search)
+	}
+
+	bundle := &Bundle{
+		Version:    BundleVersion,
+		ExportedAt: time.Now().UTC(),
+		Searches:   []BundleSearch{},
+		Characters: []BundleCharacter{},
+		Films:      []BundleFilm{},
+		Vehicles:   []BundleVehicle{},
+	}
+
+	// Searches often share characters, and characters share films and vehicles
+	seen := map[string]bool{}
+	for _, search := range searches {
+		bundleSearch := BundleSearch{
+			ID:           search.ID.Hex(),
+			SearchKey:    search.SearchKey,
+			Title:        search.Title,
+			Notes:        search.Notes,
+			Tags:         search.Tags,
+			CharacterIDs: nonNilStrings(search.Characters),
+		}
+		if search.Snapshot != nil {
+			bundleSearch.Snapshot = &BundleSnapshot{
+				TakenAt:    search.Snapshot.TakenAt,
+				Characters: []BundleSnapshotCharacter{},
+			}
+			for _, character := range search.Snapshot.Characters {
+				bundleSearch.Snapshot.Characters = append(bundleSearch.Snapshot.Characters, BundleSnapshotCharacter{
+					ID:            character.ID,
+					Name:          character.Name,
+					Films:         nonNilStrings(character.Films),
+					VehicleModels: nonNilStrings(character.VehicleModels),
+					FilmIDs:       character.FilmIDs,
+					VehicleIDs:    character.VehicleIDs,
+				})
+			}
+		}
+		bundle.Searches = append(bundle.Searches, bundleSearch)
+
+		for _, characterID := range search.Characters {
+			if seen["character:"+characterID] {
+				continue
+			}
+			seen["character:"+characterID] = true
+
+			character, err := c.getCharacter(repo, characterID)
+			if err != nil {
+				return nil, err
+			}
+			bundle.Characters = append(bundle.Characters, BundleCharacter{
+				ID:         character.ID,
+				Name:       character.Name,
+				FilmIDs:    nonNilStrings(character.Films),
+				VehicleIDs: nonNilStrings(character.Vehicles),
+			})
+
+			for _, filmID := range character.Films {
+				if seen["film:"+filmID] {
+					continue
+				}
+				seen["film:"+filmID] = true
+
+				film, err := c.getFilm(repo, filmID)
+				if err != nil {
+					return nil, err
+				}
+				bundle.Films = append(bundle.Films, BundleFilm{ID: film.ID, Title: film.Title})
+			}
+
+			for _, vehicleID := range character.Vehicles {
+				if seen["vehicle:"+vehicleID] {
+					continue
+				}
+				seen["vehicle:"+vehicleID] = true
+
+				vehicle, err := c.getVehicle(repo, vehicleID)
+				if err != nil {
+					return nil, err
+				}
+				bundle.Vehicles = append(bundle.Vehicles, BundleVehicle{ID: vehicle.ID, Model: vehicle.Model})
+			}
+		}
+	}
+
+	return bundle, nil
+}
+
+// ImportSearches - Adds the searches of a bundle to the saved searches of the user
+// A search conflicts if the user already has a search with the same ID; it is then skipped,
+// overwritten or imported as a new search, depending on the conflict strategy.
+// Searches of other users with the same ID are never touched; the search is imported as a new one.
+// Every character, film and vehicle ID must be a Star Wars API resource; the bundle is refused otherwise.
+// Characters, films and vehicles of the bundle are never written to the cache, hydration fetches them from the Star Wars API.
+// With dryRun, nothing is written and the report says what would have happened.
+func (c *CharacterServiceImpl) ImportSearches(userID string, bundle Bundle, conflict ImportConflict, dryRun bool) (*ImportReport, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if userID == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	if bundle.Version < 1 || bundle.Version > BundleVersion {
+		return nil, invalidArgument("unsupported bundle version %d, expected %d", bundle.Version, BundleVersion)
+	}
+
+	if conflict == "" {
+		conflict = ImportConflictSkip
+	}
+	if _, err := ParseImportConflict(string(conflict)); err != nil {
+		return nil, err
+	}
+
+	bundle, err = c.normalizeBundleIDs(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	characterIDs := map[string]bool{}
+	for _, character := range bundle.Characters {
+		characterIDs[character.ID] = true
+	}
+
+	// Check the whole bundle first so a bad search doesn't leave a partial import
+	for i, search := range bundle.Searches {
+		if strings.TrimSpace(search.SearchKey) == "" {
+			return nil, invalidArgument("search %d of the bundle has no searchKey", i+1)
+		}
+		for _, characterID := range search.CharacterIDs {
+			if !characterIDs[characterID] {
+				return nil, invalidArgument("search %q references character %s, which isn't in the bundle", search.SearchKey, characterID)
+			}
+		}
+	}
+
+	report := &ImportReport{DryRun: dryRun, Searches: []ImportedSearch{}}
+
+	for _, search := range bundle.Searches {
+		imported, err := c.importSearch(repo, userID, search, conflict, dryRun)
+		if err != nil {
+			return nil, err
+		}
+
+		switch imported.Action {
+		case ImportActionCreated:
+			report.Created++
+		case ImportActionOverwritten:
+			report.Overwritten++
+		case ImportActionSkipped:
+			report.Skipped++
+		}
+		report.Searches = append(report.Searches, *imported)
+	}
+
+	return report, nil
+}
+
+// normalizeBundleIDs - Returns a copy of the bundle with every ID turned into its Star Wars API URL
+// IDs are fetched later on, so anything that isn't a Star Wars API resource is rejected.
+func (c *CharacterServiceImpl) normalizeBundleIDs(bundle Bundle) (Bundle, error) {
+	normalize := func(resource string, ids []string) ([]string, error) {
+		if ids == nil {
+			return nil, nil
+		}
+		normalized := make([]string, len(ids))
+		for i, id := range ids {
+			url, err := c.swapiClient.ResourceURL(resource, id)
+			if err != nil {
+				return nil, err
+			}
+			normalized[i] = url
+		}
+		return normalized, nil
+	}
+
+	var err error
+	searches := make([]BundleSearch, len(bundle.Searches))
+	for i, search := range bundle.Searches {
+		if search.CharacterIDs, err = normalize("people", search.CharacterIDs); err != nil {
+			return Bundle{}, err
+		}
+		if search.Snapshot != nil {
+			snapshot := *search.Snapshot
+			snapshot.Characters = make([]BundleSnapshotCharacter, len(search.Snapshot.Characters))
+			for j, character := range search.Snapshot.Characters {
+				if character.ID, err = c.swapiClient.ResourceURL("people", character.ID); err != nil {
+					return Bundle{}, err
+				}
+				if character.FilmIDs, err = normalize("films", character.FilmIDs); err != nil {
+					return Bundle{}, err
+				}
+				if character.VehicleIDs, err = normalize("vehicles", character.VehicleIDs); err != nil {
+					return Bundle{}, err
+				}
+				snapshot.Characters[j] = character
+			}
+			search.Snapshot = &snapshot
+		}
+		searches[i] = search
+	}
+	bundle.Searches = searches
+
+	characters := make([]BundleCharacter, len(bundle.Characters))
+	for i, character := range bundle.Characters {
+		if character.ID, err = c.swapiClient.ResourceURL("people", character.ID); err != nil {
+			return Bundle{}, err
+		}
+		if character.FilmIDs, err = normalize("films", character.FilmIDs); err != nil {
+			return Bundle{}, err
+		}
+		if character.VehicleIDs, err = normalize("vehicles", character.VehicleIDs); err != nil {
+			return Bundle{}, err
+		}
+		characters[i] = character
+	}
+	bundle.Characters = characters
+
+	films := make([]BundleFilm, len(bundle.Films))
+	for i, film := range bundle.Films {
+		if film.ID, err = c.swapiClient.ResourceURL("films", film.ID); err != nil {
+			return Bundle{}, err
+		}
+		films[i] = film
+	}
+	bundle.Films = films
+
+	vehicles := make([]BundleVehicle, len(bundle.Vehicles))
+	for i, vehicle := range bundle.Vehicles {
+		if vehicle.ID, err = c.swapiClient.ResourceURL("vehicles", vehicle.ID); err != nil {
+			return Bundle{}, err
+		}
+		vehicles[i] = vehicle
+	}
+	bundle.Vehicles = vehicles
+
+	return bundle, nil
+}
+
+// importSearch - Imports a search of a bundle according to the conflict strategy
+func (c *CharacterServiceImpl) importSearch(repo *repositories.Repository, userID string, search BundleSearch, conflict ImportConflict, dryRun bool) (*ImportedSearch, error) {
+	var existing *models.SearchModel
+	if primitive.IsValidObjectID(search.ID) {
+		var err error
+		existing, err = repo.SearchRepository.GetSearch(search.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get search: %w", c.checkStorage(err))
+		}
+	}
+
+	model := models.SearchModel{
+		Owner:      userID,
+		SearchKey:  search.SearchKey,
+		Characters: nonNilStrings(search.CharacterIDs),
+		Title:      search.Title,
+		Notes:      search.Notes,
+		Tags:       search.Tags,
+		Saved:      true,
+	}
+	if search.Snapshot != nil {
+		var characters []Character
+		for _, character := range search.Snapshot.Characters {
+			characters = append(characters, Character{
+				ID:            character.ID,
+				Name:          character.Name,
+				Films:         character.Films,
+				VehicleModels: character.VehicleModels,
+				FilmIDs:       character.FilmIDs,
+				VehicleIDs:    character.VehicleIDs,
+			})
+		}
+		model.Snapshot = toSnapshot(characters, search.Snapshot.TakenAt)
+	}
+
+	imported := &ImportedSearch{SourceID: search.ID, SearchKey: search.SearchKey}
+
+	switch {
+	case existing == nil && primitive.IsValidObjectID(search.ID):
+		// Keep the ID so importing the same bundle again is detected as a conflict
+		model.ID, _ = primitive.ObjectIDFromHex(search.ID)
+		imported.Action = ImportActionCreated
+	case existing == nil || existing.Owner != userID || conflict == ImportConflictDuplicate:
+		model.ID = primitive.NewObjectID()
+		imported.Action = ImportActionCreated
+	case conflict == ImportConflictOverwrite:
+		// Share links stay valid, since they're tied to the search rather than its content
+		model.ID = existing.ID
+		model.ShareLinks = existing.ShareLinks
+		imported.Action = ImportActionOverwritten
+	default:
+		imported.SearchID = existing.ID.Hex()
+		imported.Action = ImportActionSkipped
+		return imported, nil
+	}
+
+	imported.SearchID = model.ID.Hex()
+	if dryRun {
+		return imported, nil
+	}
+
+	if err := repo.SearchRepository.UpsertSearch(model); err != nil {
+		return nil, fmt.Errorf("failed to import search: %w", c.checkStorage(err))
+	}
+
+	return imported, nil
+}
@@ -0,0 +1,417 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"alvinlucillo/swapi-app/internal/models"
+	"alvinlucillo/swapi-app/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetCollections - Gets the collections of a user in order along with their saved searches
+func (c *CharacterServiceImpl) GetCollections(userID string) ([]Collection, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if userID == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	collections, err := repo.CollectionRepository.GetCollections(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collections: %w", c.checkStorage(err))
+	}
+
+	return c.toCollections(repo, collections)
+}
+
+// GetCollection - Gets a collection along with its saved searches
+func (c *CharacterServiceImpl) GetCollection(userID string, collectionID string) (*Collection, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := c.getOwnedCollection(repo, userID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.toCollection(repo, collection)
+}
+
+// GetCollectionCharacters - Gets the characters of every search in the collection
+// Characters appearing in more than one search are only returned once, in the order they first appear
+func (c *CharacterServiceImpl) GetCollectionCharacters(userID string, collectionID string) ([]Character, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := c.getOwnedCollection(repo, userID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if collection == nil {
+		return nil, nil
+	}
+
+	searches, err := c.getOrderedSearches(repo, collection.SearchIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var characterIDs []string
+	for _, search := range searches {
+		for _, characterID := range search.Characters {
+			if seen[characterID] {
+				continue
+			}
+			seen[characterID] = true
+			characterIDs = append(characterIDs, characterID)
+		}
+	}
+
+	return c.hydrateCharacters(repo, characterIDs)
+}
+
+// CreateCollection - Creates a collection at the end of the user's list of collections
+func (c *CharacterServiceImpl) CreateCollection(userID string, input CollectionInput) (*Collection, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if userID == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	if input.Name == nil || strings.TrimSpace(*input.Name) == "" {
+		return nil, invalidArgument("collection name is required")
+	}
+
+	searchIDs, err := c.validateCollectionSearches(repo, userID, input.SearchIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	newCollection := models.CollectionModel{
+		ID:        primitive.NewObjectID(),
+		Owner:     userID,
+		Name:      strings.TrimSpace(*input.Name),
+		SearchIDs: searchIDs,
+	}
+	if input.Description != nil {
+		newCollection.Description = *input.Description
+	}
+
+	collectionID, err := repo.CollectionRepository.AddCollection(newCollection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add collection: %w", c.checkStorage(err))
+	}
+
+	return c.GetCollection(userID, collectionID)
+}
+
+// UpdateCollection - Updates the name, description and searches of a collection
+// When search IDs are given, they replace the searches in the collection in the given order
+func (c *CharacterServiceImpl) UpdateCollection(userID string, collectionID string, input CollectionInput) (*Collection, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if collection, err := c.getOwnedCollection(repo, userID, collectionID); err != nil || collection == nil {
+		return nil, err
+	}
+
+	collectionUpdate := repositories.CollectionUpdate{
+		Description: input.Description,
+	}
+
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" {
+			return nil, invalidArgument("collection name is required")
+		}
+		collectionUpdate.Name = &name
+	}
+
+	if input.SearchIDs != nil {
+		collectionUpdate.SearchIDs, err = c.validateCollectionSearches(repo, userID, input.SearchIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	collection, err := repo.CollectionRepository.UpdateCollection(collectionID, collectionUpdate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update collection: %w", c.checkStorage(err))
+	}
+
+	return c.toCollection(repo, collection)
+}
+
+// DeleteCollection - Deletes a collection; the searches in it are kept
+func (c *CharacterServiceImpl) DeleteCollection(userID string, collectionID string) (bool, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return false, err
+	}
+
+	if collection, err := c.getOwnedCollection(repo, userID, collectionID); err != nil || collection == nil {
+		return false, err
+	}
+
+	result, err := repo.CollectionRepository.DeleteCollection(collectionID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete collection: %w", c.checkStorage(err))
+	}
+
+	return result, nil
+}
+
+// AddSearchToCollection - Adds a saved search to the end of a collection
+func (c *CharacterServiceImpl) AddSearchToCollection(userID string, collectionID string, searchID string) (*Collection, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if collection, err := c.getOwnedCollection(repo, userID, collectionID); err != nil || collection == nil {
+		return nil, err
+	}
+
+	if _, err := c.validateCollectionSearches(repo, userID, []string{searchID}); err != nil {
+		return nil, err
+	}
+
+	collection, err := repo.CollectionRepository.AddSearchToCollection(collectionID, searchID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add search to collection: %w", c.checkStorage(err))
+	}
+
+	return c.toCollection(repo, collection)
+}
+
+// RemoveSearchFromCollection - Removes a search from a collection
+func (c *CharacterServiceImpl) RemoveSearchFromCollection(userID string, collectionID string, searchID string) (*Collection, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if collection, err := c.getOwnedCollection(repo, userID, collectionID); err != nil || collection == nil {
+		return nil, err
+	}
+
+	collection, err := repo.CollectionRepository.RemoveSearchFromCollection(collectionID, searchID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove search from collection: %w", c.checkStorage(err))
+	}
+
+	return c.toCollection(repo, collection)
+}
+
+// MoveCollection - Moves a collection to the given zero-based position among the user's collections
+// and returns the reordered collections
+func (c *CharacterServiceImpl) MoveCollection(userID string, collectionID string, position int) ([]Collection, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if userID == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	collections, err := repo.CollectionRepository.GetCollections(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collections: %w", c.checkStorage(err))
+	}
+
+	// Take the collection out of the list and put it back at the new position
+	var moved *models.CollectionModel
+	var rest []models.CollectionModel
+	for i := range collections {
+		if collections[i].ID.Hex() == collectionID {
+			moved = &collections[i]
+			continue
+		}
+		rest = append(rest, collections[i])
+	}
+
+	if moved == nil {
+		return nil, notFound("collection %s not found", collectionID)
+	}
+
+	if position < 0 {
+		position = 0
+	}
+	if position > len(rest) {
+		position = len(rest)
+	}
+
+	ordered := make([]models.CollectionModel, 0, len(collections))
+	ordered = append(ordered, rest[:position]...)
+	ordered = append(ordered, *moved)
+	ordered = append(ordered, rest[position:]...)
+
+	var collectionIDs []string
+	for i := range ordered {
+		ordered[i].Position = i
+		collectionIDs = append(collectionIDs, ordered[i].ID.Hex())
+	}
+
+	if err := repo.CollectionRepository.SetCollectionPositions(collectionIDs); err != nil {
+		return nil, fmt.Errorf("failed to set collection positions: %w", c.checkStorage(err))
+	}
+
+	return c.toCollections(repo, ordered)
+}
+
+// validateCollectionSearches - Checks that every search exists, is saved and belongs to the user
+// Returns the search IDs with duplicates removed
+func (c *CharacterServiceImpl) validateCollectionSearches(repo *repositories.Repository, userID string, searchIDs []string) ([]string, error) {
+	var unique []string
+	seen := map[string]bool{}
+	for _, searchID := range searchIDs {
+		if seen[searchID] {
+			continue
+		}
+		seen[searchID] = true
+		unique = append(unique, searchID)
+	}
+
+	if len(unique) == 0 {
+		return []string{}, nil
+	}
+
+	searches, err := repo.SearchRepository.GetSearchesByIDs(unique)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get searches: %w", c.checkStorage(err))
+	}
+
+	saved := map[string]bool{}
+	for _, search := range searches {
+		if search.IsSaved() && search.Owner == userID {
+			saved[search.ID.Hex()] = true
+		}
+	}
+
+	for _, searchID := range unique {
+		if !saved[searchID] {
+			return nil, invalidArgument("search %s is not a saved search", searchID)
+		}
+	}
+
+	return unique, nil
+}
+
+// getOwnedCollection - Gets a collection that belongs to the user
+// Returns nil if the collection doesn't exist or belongs to someone else
+func (c *CharacterServiceImpl) getOwnedCollection(repo *repositories.Repository, userID string, collectionID string) (*models.CollectionModel, error) {
+	if userID == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	collection, err := repo.CollectionRepository.GetCollection(collectionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collection: %w", c.checkStorage(err))
+	}
+
+	if collection == nil || collection.Owner != userID {
+		return nil, nil
+	}
+
+	return collection, nil
+}
+
+// getOrderedSearches - Gets the searches with the given IDs in the same order
+// Searches that no longer exist are skipped
+func (c *CharacterServiceImpl) getOrderedSearches(repo *repositories.Repository, searchIDs []string) ([]models.SearchModel, error) {
+	searches, err := repo.SearchRepository.GetSearchesByIDs(searchIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get searches: %w", c.checkStorage(err))
+	}
+
+	searchMap := map[string]models.SearchModel{}
+	for _, search := range searches {
+		searchMap[search.ID.Hex()] = search
+	}
+
+	var ordered []models.SearchModel
+	for _, searchID := range searchIDs {
+		if search, ok := searchMap[searchID]; ok {
+			ordered = append(ordered, search)
+		}
+	}
+
+	return ordered, nil
+}
+
+// toCollection - Converts a collection model to the collection result
+func (c *CharacterServiceImpl) toCollection(repo *repositories.Repository, collection *models.CollectionModel) (*Collection, error) {
+	if collection == nil {
+		return nil, nil
+	}
+
+	collections, err := c.toCollections(repo, []models.CollectionModel{*collection})
+	if err != nil {
+		return nil, err
+	}
+
+	return &collections[0], nil
+}
+
+// toCollections - Converts collection models to collection results
+// The searches of all the collections are fetched at once
+func (c *CharacterServiceImpl) toCollections(repo *repositories.Repository, collections []models.CollectionModel) ([]Collection, error) {
+	var searchIDs []string
+	for _, collection := range collections {
+		searchIDs = append(searchIDs, collection.SearchIDs...)
+	}
+
+	var searches []models.SearchModel
+	if len(searchIDs) > 0 {
+		var err error
+		searches, err = repo.SearchRepository.GetSearchesByIDs(searchIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get searches: %w", c.checkStorage(err))
+		}
+	}
+
+	searchMap := map[string]models.SearchModel{}
+	for _, search := range searches {
+		searchMap[search.ID.Hex()] = search
+	}
+
+	var results []Collection
+	for _, collection := range collections {
+		result := Collection{
+			ID:          collection.ID.Hex(),
+			Name:        collection.Name,
+			Description: collection.Description,
+			Position:    collection.Position,
+			SearchIDs:   collection.SearchIDs,
+			CreatedAt:   collection.CreatedAt,
+			UpdatedAt:   collection.UpdatedAt,
+		}
+
+		for _, searchID := range collection.SearchIDs {
+			if search, ok := searchMap[searchID]; ok {
+				result.Searches = append(result.Searches, toSearch(search))
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrorCode tells clients what kind of error happened; GraphQL errors carry it as extensions.code
+type ErrorCode string
+
+const (
+	// CodeNotFound is for searches, collections, users, etc. that don't exist
+	CodeNotFound ErrorCode = "NOT_FOUND"
+	// CodeInvalidArgument is for arguments the client should fix, e.g. a malformed ID
+	CodeInvalidArgument ErrorCode = "INVALID_ARGUMENT"
+	// CodeUpstreamUnavailable is for failures of the Star Wars API; retrying later may work
+	CodeUpstreamUnavailable ErrorCode = "UPSTREAM_UNAVAILABLE"
+	// CodeStorageUnavailable is for database outages, see ErrStorageUnavailable; retrying later may work
+	CodeStorageUnavailable ErrorCode = "STORAGE_UNAVAILABLE"
+	// CodeUnauthorized is for anonymous users or invalid credentials
+	CodeUnauthorized ErrorCode = "UNAUTHORIZED"
+	// CodeForbidden is for users whose role doesn't allow the operation
+	CodeForbidden ErrorCode = "FORBIDDEN"
+	// CodeGraphQLValidationFailed is for documents that can't be parsed or don't match the schema
+	CodeGraphQLValidationFailed ErrorCode = "GRAPHQL_VALIDATION_FAILED"
+	// CodeInternal is for every other error; its details are never shown in production
+	CodeInternal ErrorCode = "INTERNAL_SERVER_ERROR"
+)
+
+// Messages shown in production instead of the details of errors that aren't the client's fault
+const (
+	upstreamUnavailableMessage = "the Star Wars API is unavailable, please try again later"
+	internalErrorMessage       = "internal server error"
+)
+
+// Error is an error of the domain with the code clients see
+// It's usually wrapped with the context of where it happened, which errors.As sees through
+type Error struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Extensions - Returns the extensions of the GraphQL error, see gqlerrors.ExtendedError
+func (e *Error) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.Code}
+}
+
+// newError - Returns a domain error with a fixed message, for sentinel errors
+func newError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Err: errors.New(message)}
+}
+
+// notFound - Returns a NOT_FOUND error
+func notFound(format string, args ...interface{}) error {
+	return &Error{Code: CodeNotFound, Err: fmt.Errorf(format, args...)}
+}
+
+// invalidArgument - Returns an INVALID_ARGUMENT error
+func invalidArgument(format string, args ...interface{}) error {
+	return &Error{Code: CodeInvalidArgument, Err: fmt.Errorf(format, args...)}
+}
+
+// upstreamUnavailable - Marks a failure to reach or understand the Star Wars API
+func upstreamUnavailable(err error) error {
+	return &Error{Code: CodeUpstreamUnavailable, Err: err}
+}
+
+// ErrorCodeOf - Returns the code of an error and the message clients may see in production
+// Only the message of the domain error is kept, without the context it was wrapped in
+func ErrorCodeOf(err error) (ErrorCode, string) {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		switch domainErr.Code {
+		case CodeUpstreamUnavailable:
+			return domainErr.Code, upstreamUnavailableMessage
+		case CodeStorageUnavailable:
+			return domainErr.Code, ErrStorageUnavailable.Error()
+		case CodeInternal:
+			return domainErr.Code, internalErrorMessage
+		}
+		return domainErr.Code, domainErr.Error()
+	}
+
+	// Repositories return the error of the driver for IDs that aren't ObjectIDs
+	if errors.Is(err, primitive.ErrInvalidHex) {
+		return CodeInvalidArgument, "invalid ID: " + primitive.ErrInvalidHex.Error()
+	}
+
+	return CodeInternal, internalErrorMessage
+}
+
+// errorMessage - Returns the message of an error for clients; in production, the one of ErrorCodeOf
+func errorMessage(err error, production bool) string {
+	if !production {
+		return err.Error()
+	}
+	_, message := ErrorCodeOf(err)
+	return message
+}
+
+// formatError - Returns the function formatting the errors of GraphQL results, which adds
+// extensions.code to every error and, in production, replaces messages with ones safe to show
+func formatError(production bool) func(err error) gqlerrors.FormattedError {
+	return func(err error) gqlerrors.FormattedError {
+		if err == nil {
+			err = errors.New(internalErrorMessage)
+		}
+		formatted := gqlerrors.FormatError(err)
+
+		// Errors of resolvers are located errors wrapping what the resolver returned. Errors of the request,
+		// e.g. syntax errors, unknown fields or a missing operation, are located errors without an original
+		// error or plain errors of the executor, and never have internal details.
+		var domainErr *Error
+		original := err
+		if located, ok := err.(*gqlerrors.Error); ok {
+			original = located.OriginalError
+		} else if !errors.As(err, &domainErr) {
+			original = nil
+		}
+		if original == nil {
+			formatted.Extensions = map[string]interface{}{"code": CodeGraphQLValidationFailed}
+			return formatted
+		}
+
+		code, message := ErrorCodeOf(original)
+		formatted.Extensions = map[string]interface{}{"code": code}
+		if production {
+			formatted.Message = message
+		}
+		return formatted
+	}
+}
+
+// formatErrors - Formats the errors of a GraphQL result, see formatError
+func formatErrors(errs []gqlerrors.FormattedError, production bool) []gqlerrors.FormattedError {
+	format := formatError(production)
+	formatted := make([]gqlerrors.FormattedError, len(errs))
+	for i, err := range errs {
+		formatted[i] = format(err.OriginalError())
+	}
+	return formatted
+}
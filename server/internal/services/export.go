@@ -0,0 +1,289 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrSearchNotFound is returned when a search doesn't exist or belongs to someone else
+var ErrSearchNotFound error = newError(CodeNotFound, "search not found")
+
+// ExportFormat is the file format saved searches are exported to
+type ExportFormat string
+
+const (
+	// ExportFormatCSV has one row per character, film and vehicle
+	ExportFormatCSV ExportFormat = "csv"
+	// ExportFormatJSON has the searches and their characters as nested objects
+	ExportFormatJSON ExportFormat = "json"
+	// ExportFormatMarkdown has a heading per search and a table per character, for printing
+	ExportFormatMarkdown ExportFormat = "markdown"
+)
+
+// exportContentTypes are the content types and file extensions of the export formats
+var exportContentTypes = map[ExportFormat][2]string{
+	ExportFormatCSV:      {"text/csv; charset=utf-8", "csv"},
+	ExportFormatJSON:     {"application/json; charset=utf-8", "json"},
+	ExportFormatMarkdown: {"text/markdown; charset=utf-8", "md"},
+}
+
+// ParseExportFormat - Converts a string to an export format, returning an error for unknown formats
+func ParseExportFormat(format string) (ExportFormat, error) {
+	f := ExportFormat(strings.ToLower(strings.TrimSpace(format)))
+	if f == "md" {
+		f = ExportFormatMarkdown
+	}
+	if _, ok := exportContentTypes[f]; !ok {
+		return "", invalidArgument("unknown export format %q, expected csv, json or markdown", format)
+	}
+	return f, nil
+}
+
+// ExportSearches - Gets a saved search of the user with its characters, or all their saved searches
+// if searchID is empty. Characters come from the snapshot, like GetSavedSearchesByID.
+func (c *CharacterServiceImpl) ExportSearches(userID string, searchID string) ([]SearchExport, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	var searches []Search
+	if searchID == "" {
+		searches, err = c.GetSavedSearches(userID, "")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		search, err := c.getOwnedSearch(repo, userID, searchID)
+		if err != nil {
+			return nil, err
+		}
+		if search == nil {
+			return nil, fmt.Errorf("%w: %s", ErrSearchNotFound, searchID)
+		}
+		searches = append(searches, toSearch(*search))
+	}
+
+	exports := []SearchExport{}
+	for _, search := range searches {
+		characters, err := c.GetSavedSearchesByID(userID, search.ID, SearchViewSnapshot)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, SearchExport{Search: search, Characters: characters})
+	}
+
+	return exports, nil
+}
+
+// WriteExport - Renders exported searches in the given format
+func WriteExport(w io.Writer, format ExportFormat, exports []SearchExport) error {
+	switch format {
+	case ExportFormatCSV:
+		return writeExportCSV(w, exports)
+	case ExportFormatJSON:
+		return writeExportJSON(w, exports)
+	case ExportFormatMarkdown:
+		return writeExportMarkdown(w, exports)
+	}
+	return invalidArgument("unknown export format %q", format)
+}
+
+// writeExportCSV - Writes a row per character, and a row per film and vehicle of the character
+func writeExportCSV(w io.Writer, exports []SearchExport) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"search_id", "search", "title", "character_id", "character", "type", "name"}); err != nil {
+		return err
+	}
+
+	for _, export := range exports {
+		search := export.Search
+		for _, character := range export.Characters {
+			row := func(kind string, name string) []string {
+				return []string{search.ID, search.SearchKey, search.Title, character.ID, character.Name, kind, name}
+			}
+
+			if err := writer.Write(row("character", character.Name)); err != nil {
+				return err
+			}
+			for _, film := range character.Films {
+				if err := writer.Write(row("film", film)); err != nil {
+					return err
+				}
+			}
+			for _, vehicle := range character.VehicleModels {
+				if err := writer.Write(row("vehicle", vehicle)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeExportJSON - Writes the searches with their characters nested in them
+func writeExportJSON(w io.Writer, exports []SearchExport) error {
+	type exportCharacter struct {
+		ID            string   `json:"id"`
+		Name          string   `json:"name"`
+		Films         []string `json:"films"`
+		VehicleModels []string `json:"vehicleModels"`
+	}
+
+	type exportSearch struct {
+		ID         string            `json:"id"`
+		SearchKey  string            `json:"searchKey"`
+		Title      string            `json:"title,omitempty"`
+		Notes      string            `json:"notes,omitempty"`
+		Tags       []string          `json:"tags"`
+		SnapshotAt *time.Time        `json:"snapshotAt,omitempty"`
+		Characters []exportCharacter `json:"characters"`
+	}
+
+	searches := []exportSearch{}
+	for _, export := range exports {
+		search := exportSearch{
+			ID:         export.Search.ID,
+			SearchKey:  export.Search.SearchKey,
+			Title:      export.Search.Title,
+			Notes:      export.Search.Notes,
+			Tags:       nonNilStrings(export.Search.Tags),
+			SnapshotAt: export.Search.SnapshotAt,
+			Characters: []exportCharacter{},
+		}
+		for _, character := range export.Characters {
+			search.Characters = append(search.Characters, exportCharacter{
+				ID:            character.ID,
+				Name:          character.Name,
+				Films:         nonNilStrings(character.Films),
+				VehicleModels: nonNilStrings(character.VehicleModels),
+			})
+		}
+		searches = append(searches, search)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{
+		"exportedAt": time.Now().UTC(),
+		"searches":   searches,
+	})
+}
+
+// writeExportMarkdown - Writes a heading per search and a table of films and vehicles per character
+func writeExportMarkdown(w io.Writer, exports []SearchExport) error {
+	var b strings.Builder
+
+	for i, export := range exports {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		search := export.Search
+		title := search.SearchKey
+		if search.Title != "" {
+			title = fmt.Sprintf("%s (%s)", search.Title, search.SearchKey)
+		}
+		fmt.Fprintf(&b, "# %s\n\n", title)
+		if search.Notes != "" {
+			fmt.Fprintf(&b, "%s\n\n", search.Notes)
+		}
+		if len(export.Characters) == 0 {
+			b.WriteString("No characters.\n")
+		}
+
+		for _, character := range export.Characters {
+			fmt.Fprintf(&b, "## %s\n\n", character.Name)
+			b.WriteString("| Film | Vehicle |\n| --- | --- |\n")
+
+			rows := len(character.Films)
+			if len(character.VehicleModels) > rows {
+				rows = len(character.VehicleModels)
+			}
+			for row := 0; row < rows; row++ {
+				fmt.Fprintf(&b, "| %s | %s |\n", markdownCell(character.Films, row), markdownCell(character.VehicleModels, row))
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownCell - Returns the value at the index escaped for a table cell, or an empty string if there is none
+func markdownCell(values []string, index int) string {
+	if index >= len(values) {
+		return ""
+	}
+	return strings.ReplaceAll(values[index], "|", "\\|")
+}
+
+// nonNilStrings - Returns an empty slice instead of nil so JSON has [] instead of null
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// NewExportHandler - Returns the handler of GET /export?format=csv|json|markdown&searchID=<id>
+// which downloads a saved search of the logged in user, or all of them if searchID is left out.
+// It must be wrapped in the auth middleware.
+func NewExportHandler(cfg HandlerConfig, svc CharacterService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		userID, err := authorize(r.Context(), RoleReader)
+		switch {
+		case errors.Is(err, ErrUnauthenticated):
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": errorMessage(err, cfg.Production)})
+			return
+		case err != nil:
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": errorMessage(err, cfg.Production)})
+			return
+		}
+
+		query := r.URL.Query()
+		format := ExportFormatCSV
+		if query.Get("format") != "" {
+			format, err = ParseExportFormat(query.Get("format"))
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": errorMessage(err, cfg.Production)})
+				return
+			}
+		}
+
+		exports, err := svc.ExportSearches(userID, query.Get("searchID"))
+		switch {
+		case errors.Is(err, ErrStorageUnavailable):
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": errorMessage(err, cfg.Production)})
+			return
+		case errors.Is(err, ErrSearchNotFound):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": errorMessage(err, cfg.Production)})
+			return
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": errorMessage(err, cfg.Production)})
+			return
+		}
+
+		contentType := exportContentTypes[format]
+		w.Header().Set("Content-Type", contentType[0])
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"searches.%s\"", contentType[1]))
+		if err := WriteExport(w, format, exports); err != nil {
+			fmt.Printf("failed to write export: %v\n", err)
+		}
+	})
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// entityKeys are the key fields of the types other subgraphs of a federation can reference and extend
+var entityKeys = map[string]string{
+	"Character": "id",
+	"Film":      "id",
+	"Vehicle":   "id",
+}
+
+// entityResources are the Star Wars API resources of the entities, whose IDs are URLs of the resource
+var entityResources = map[string]string{
+	"Character": "people",
+	"Film":      "films",
+	"Vehicle":   "vehicles",
+}
+
+// federationLink opts the SDL of the subgraph into Federation v2 and imports the directives it uses
+const federationLink = `extend schema @link(url: "https://specs.apollo.dev/federation/v2.0", import: ["@key"])`
+
+// NewFederatedSchema - Returns the schema with the fields of an Apollo Federation v2 subgraph:
+// _service, whose sdl is the schema with the @key of every entity, and _entities, which resolves
+// references to characters, films and vehicles by their ID
+func NewFederatedSchema(svc CharacterService) (graphql.Schema, error) {
+	schema, err := NewSchema(svc)
+	if err != nil {
+		return graphql.Schema{}, err
+	}
+
+	return newSchema(svc, PrintSubgraphSchema(&schema))
+}
+
+// PrintSubgraphSchema - Returns the SDL a federation router composes into the supergraph
+// It's the schema with the @key of every entity, without the _service and _entities fields.
+func PrintSubgraphSchema(schema *graphql.Schema) string {
+	return federationLink + "\n\n" + printSchema(schema, entityKeys)
+}
+
+// newHandlerSchema - Returns the schema the handlers run operations against, a subgraph schema if federation is on
+func newHandlerSchema(cfg HandlerConfig, svc CharacterService) (graphql.Schema, error) {
+	if cfg.Federation {
+		return NewFederatedSchema(svc)
+	}
+	return NewSchema(svc)
+}
+
+// addFederationFields - Adds _service and _entities to the query type and returns the types they need
+func addFederationFields(svc CharacterService, sdl string, queryType *graphql.Object, entities []*graphql.Object) []graphql.Type {
+	// Representations are objects with the __typename and the key fields of an entity
+	anyType := graphql.NewScalar(graphql.ScalarConfig{
+		Name:        "_Any",
+		Description: "A reference to an entity: its __typename and its key fields.",
+		Serialize: func(value interface{}) interface{} {
+			return value
+		},
+		ParseValue: func(value interface{}) interface{} {
+			return value
+		},
+		ParseLiteral: func(valueAST ast.Value) interface{} {
+			return literalValue(valueAST)
+		},
+	})
+
+	entityType := graphql.NewUnion(graphql.UnionConfig{
+		Name:  "_Entity",
+		Types: entities,
+		ResolveType: func(p graphql.ResolveTypeParams) *graphql.Object {
+			var name string
+			switch p.Value.(type) {
+			case Character:
+				name = "Character"
+			case Film, *Film:
+				name = "Film"
+			case Vehicle, *Vehicle:
+				name = "Vehicle"
+			}
+			for _, entity := range entities {
+				if entity.Name() == name {
+					return entity
+				}
+			}
+			return nil
+		},
+	})
+
+	serviceType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "_Service",
+		Fields: graphql.Fields{
+			"sdl": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The SDL of the subgraph, with the @key of every entity.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return sdl, nil
+				},
+			},
+		},
+	})
+
+	queryType.AddFieldConfig("_service", &graphql.Field{
+		Type: graphql.NewNonNull(serviceType),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return struct{}{}, nil
+		},
+	})
+
+	queryType.AddFieldConfig("_entities", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(entityType)),
+		Description: "Returns the entities of the representations, in order; null for those that don't exist",
+		Args: graphql.FieldConfigArgument{
+			"representations": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(anyType))),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			representations, _ := p.Args["representations"].([]interface{})
+			return resolveEntities(svc, loadersFromContext(p.Context, svc), representations)
+		},
+	})
+
+	return []graphql.Type{anyType, entityType, serviceType}
+}
+
+// entityRef is the type and ID of an entity a representation refers to
+type entityRef struct {
+	typename string
+	id       string
+}
+
+// resolveEntities - Returns the thunk of the entities of representations
+// The entities of each type are looked up in one batch through the loaders of the request.
+// IDs that aren't of the Star Wars API resolve to null without being looked up.
+func resolveEntities(svc CharacterService, loaders *Loaders, representations []interface{}) (interface{}, error) {
+	refs := make([]entityRef, len(representations))
+	ids := map[string][]string{}
+	for i, representation := range representations {
+		fields, _ := representation.(map[string]interface{})
+		typename, _ := fields["__typename"].(string)
+		if _, ok := entityKeys[typename]; !ok {
+			return nil, invalidArgument("representation %d is not a reference to a Character, Film or Vehicle", i)
+		}
+		id, _ := fields["id"].(string)
+		if id == "" {
+			return nil, invalidArgument("representation %d of a %s has no id", i, typename)
+		}
+
+		resourceURL, err := svc.ResourceURL(entityResources[typename], id)
+		if err != nil {
+			continue
+		}
+
+		refs[i] = entityRef{typename: typename, id: resourceURL}
+		ids[typename] = append(ids[typename], resourceURL)
+	}
+
+	// Loads are started before any of them is needed, so every type is looked up in a single batch
+	loads := map[string]func() (interface{}, error){}
+	if len(ids["Character"]) > 0 {
+		loads["Character"] = loaders.Characters(ids["Character"])
+	}
+	if len(ids["Film"]) > 0 {
+		loads["Film"] = loaders.Films(ids["Film"])
+	}
+	if len(ids["Vehicle"]) > 0 {
+		loads["Vehicle"] = loaders.Vehicles(ids["Vehicle"])
+	}
+
+	return func() (interface{}, error) {
+		found := map[entityRef]interface{}{}
+		for _, load := range loads {
+			values, err := load()
+			if err != nil {
+				return nil, err
+			}
+			switch values := values.(type) {
+			case []Character:
+				for _, character := range values {
+					found[entityRef{typename: "Character", id: character.ID}] = character
+				}
+			case []Film:
+				for _, film := range values {
+					found[entityRef{typename: "Film", id: film.ID}] = film
+				}
+			case []Vehicle:
+				for _, vehicle := range values {
+					found[entityRef{typename: "Vehicle", id: vehicle.ID}] = vehicle
+				}
+			}
+		}
+
+		entities := make([]interface{}, len(refs))
+		for i, ref := range refs {
+			entities[i] = found[ref]
+		}
+		return entities, nil
+	}, nil
+}
+
+// literalValue - Returns the Go value of a literal, e.g. a map for an object, for scalars that take any value
+func literalValue(valueAST ast.Value) interface{} {
+	switch value := valueAST.(type) {
+	case *ast.ObjectValue:
+		fields := map[string]interface{}{}
+		for _, field := range value.Fields {
+			fields[field.Name.Value] = literalValue(field.Value)
+		}
+		return fields
+	case *ast.ListValue:
+		values := []interface{}{}
+		for _, item := range value.Values {
+			values = append(values, literalValue(item))
+		}
+		return values
+	case *ast.IntValue:
+		if i, err := strconv.Atoi(value.Value); err == nil {
+			return i
+		}
+		return nil
+	case *ast.FloatValue:
+		if f, err := strconv.ParseFloat(value.Value, 64); err == nil {
+			return f
+		}
+		return nil
+	case *ast.StringValue:
+		return value.Value
+	case *ast.BooleanValue:
+		return value.Value
+	case *ast.EnumValue:
+		return value.Value
+	}
+	return nil
+}
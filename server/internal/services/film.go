@@ -0,0 +1,218 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"alvinlucillo/swapi-app/internal/models"
+)
+
+// GetFilm - Gets a film by its number or its URL in the Star Wars API, or nil if there is no such film
+func (c *CharacterServiceImpl) GetFilm(id string) (*Film, error) {
+	filmID, err := c.swapiClient.ResourceURL("films", id)
+	if err != nil {
+		return nil, err
+	}
+
+	films, err := c.GetFilms([]string{filmID})
+	if err != nil || len(films) == 0 {
+		return nil, err
+	}
+
+	return &films[0], nil
+}
+
+// GetVehicle - Gets a vehicle by its number or its URL in the Star Wars API, or nil if there is no such vehicle
+func (c *CharacterServiceImpl) GetVehicle(id string) (*Vehicle, error) {
+	vehicleID, err := c.swapiClient.ResourceURL("vehicles", id)
+	if err != nil {
+		return nil, err
+	}
+
+	vehicles, err := c.GetVehicles([]string{vehicleID})
+	if err != nil || len(vehicles) == 0 {
+		return nil, err
+	}
+
+	return &vehicles[0], nil
+}
+
+// ResourceURL - Returns the URL of a resource of the Star Wars API from its number or its URL
+// IDs that come from clients go through it before they're looked up, so only the Star Wars API is ever fetched.
+func (c *CharacterServiceImpl) ResourceURL(resource string, id string) (string, error) {
+	return c.swapiClient.ResourceURL(resource, id)
+}
+
+// GetFilms - Gets the films with the given IDs in the same order, leaving out the ones the SWAPI doesn't have
+// Films come from the database like the characters of a search, or straight from the SWAPI
+// while the database is unavailable
+func (c *CharacterServiceImpl) GetFilms(filmIDs []string) ([]Film, error) {
+	repo, err := c.repo()
+	var found map[string]models.FilmModel
+	if err == nil {
+		found, err = c.getFilms(repo, filmIDs)
+	}
+	if errors.Is(err, ErrStorageUnavailable) {
+		found, err = c.getLiveFilms(filmIDs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	films := []Film{}
+	for _, filmID := range filmIDs {
+		if film, ok := found[filmID]; ok {
+			films = append(films, toFilm(film))
+		}
+	}
+
+	return films, nil
+}
+
+// GetVehicles - Gets the vehicles with the given IDs in the same order, leaving out the ones the SWAPI doesn't have
+func (c *CharacterServiceImpl) GetVehicles(vehicleIDs []string) ([]Vehicle, error) {
+	repo, err := c.repo()
+	var found map[string]models.VehicleModel
+	if err == nil {
+		found, err = c.getVehicles(repo, vehicleIDs)
+	}
+	if errors.Is(err, ErrStorageUnavailable) {
+		found, err = c.getLiveVehicles(vehicleIDs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	vehicles := []Vehicle{}
+	for _, vehicleID := range vehicleIDs {
+		if vehicle, ok := found[vehicleID]; ok {
+			vehicles = append(vehicles, toVehicle(vehicle))
+		}
+	}
+
+	return vehicles, nil
+}
+
+// GetCharactersByIDs - Gets the characters with the given IDs, in the same order
+func (c *CharacterServiceImpl) GetCharactersByIDs(characterIDs []string) ([]Character, error) {
+	repo, err := c.repo()
+	if err == nil {
+		characters, err := c.hydrateCharacters(repo, characterIDs)
+		if err == nil || !errors.Is(err, ErrStorageUnavailable) {
+			return characters, err
+		}
+	}
+
+	var people []PeopleResult
+	for _, characterID := range characterIDs {
+		person, err := c.swapiClient.QueryPerson(characterID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query person: %w", err)
+		}
+		people = append(people, person)
+	}
+
+	return c.getLiveCharacters(people)
+}
+
+// GetCharacterFilms - Gets the films a character has been in
+func (c *CharacterServiceImpl) GetCharacterFilms(character Character) ([]Film, error) {
+	character, err := c.withReferences(character)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetFilms(character.FilmIDs)
+}
+
+// GetCharacterVehicles - Gets the vehicles a character drives
+func (c *CharacterServiceImpl) GetCharacterVehicles(character Character) ([]Vehicle, error) {
+	character, err := c.withReferences(character)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetVehicles(character.VehicleIDs)
+}
+
+// withReferences - Fills in the film and vehicle IDs of characters from snapshots taken before they were stored
+func (c *CharacterServiceImpl) withReferences(character Character) (Character, error) {
+	if len(character.FilmIDs) > 0 || len(character.VehicleIDs) > 0 {
+		return character, nil
+	}
+	if len(character.Films) == 0 && len(character.VehicleModels) == 0 {
+		return character, nil
+	}
+
+	characters, err := c.GetCharactersByIDs([]string{character.ID})
+	if err != nil || len(characters) == 0 {
+		return character, err
+	}
+	character.FilmIDs = characters[0].FilmIDs
+	character.VehicleIDs = characters[0].VehicleIDs
+
+	return character, nil
+}
+
+// getLiveFilms - Gets films straight from the SWAPI while the database is unavailable
+func (c *CharacterServiceImpl) getLiveFilms(filmIDs []string) (map[string]models.FilmModel, error) {
+	films := map[string]models.FilmModel{}
+	var mu sync.Mutex
+	err := fetchAll(uniqueStrings(filmIDs), func(filmID string) error {
+		result, err := c.swapiClient.QueryFilm(filmID)
+		if err != nil {
+			return fmt.Errorf("failed to query film: %w", err)
+		}
+		mu.Lock()
+		films[filmID] = toFilmModel(filmID, result)
+		mu.Unlock()
+		return nil
+	})
+
+	return films, err
+}
+
+// getLiveVehicles - Gets vehicles straight from the SWAPI while the database is unavailable
+func (c *CharacterServiceImpl) getLiveVehicles(vehicleIDs []string) (map[string]models.VehicleModel, error) {
+	vehicles := map[string]models.VehicleModel{}
+	var mu sync.Mutex
+	err := fetchAll(uniqueStrings(vehicleIDs), func(vehicleID string) error {
+		result, err := c.swapiClient.QueryVehicle(vehicleID)
+		if err != nil {
+			return fmt.Errorf("failed to query vehicle: %w", err)
+		}
+		mu.Lock()
+		vehicles[vehicleID] = toVehicleModel(vehicleID, result)
+		mu.Unlock()
+		return nil
+	})
+
+	return vehicles, err
+}
+
+// toFilm - Converts a film model to the film result
+func toFilm(film models.FilmModel) Film {
+	return Film{
+		ID:           film.ID,
+		Title:        film.Title,
+		EpisodeID:    film.EpisodeID,
+		Director:     film.Director,
+		ReleaseDate:  film.ReleaseDate,
+		CharacterIDs: film.Characters,
+		VehicleIDs:   film.Vehicles,
+	}
+}
+
+// toVehicle - Converts a vehicle model to the vehicle result
+func toVehicle(vehicle models.VehicleModel) Vehicle {
+	return Vehicle{
+		ID:           vehicle.ID,
+		Name:         vehicle.Name,
+		Model:        vehicle.Model,
+		Manufacturer: vehicle.Manufacturer,
+		VehicleClass: vehicle.VehicleClass,
+		PilotIDs:     vehicle.Pilots,
+		FilmIDs:      vehicle.Films,
+	}
+}
@@ -1,6 +1,9 @@
 package services
 
 import (
+	"strings"
+	"time"
+
 	"github.com/graphql-go/graphql"
 	"github.com/graphql-go/handler"
 )
@@ -8,6 +11,10 @@ import (
 type HandlerConfig struct {
 	Pretty   bool
 	GraphiQL bool
+	// Production replaces the messages of internal, storage and upstream errors with ones safe to show
+	Production bool
+	// Federation adds the fields of an Apollo Federation v2 subgraph, _service and _entities
+	Federation bool
 }
 
 type CharactersResult struct {
@@ -15,8 +22,85 @@ type CharactersResult struct {
 	SearchID   string
 }
 
+// searchExpiryFromArgs builds the search expiry from the expiresAt and ttl arguments
+func searchExpiryFromArgs(args map[string]interface{}) SearchExpiry {
+	var expiry SearchExpiry
+	if expiresAt, ok := args["expiresAt"].(time.Time); ok {
+		expiry.ExpiresAt = &expiresAt
+	}
+	if ttl, ok := args["ttl"].(int); ok {
+		expiry.TTL = time.Duration(ttl) * time.Second
+	}
+	return expiry
+}
+
+// collectionInputFromArgs builds the collection input from the mutation arguments
+// Arguments that are not given are left nil so they stay unchanged
+func collectionInputFromArgs(args map[string]interface{}) CollectionInput {
+	var input CollectionInput
+	if name, ok := args["name"].(string); ok {
+		input.Name = &name
+	}
+	if description, ok := args["description"].(string); ok {
+		input.Description = &description
+	}
+	if searchIDs, ok := args["searchIDs"].([]interface{}); ok {
+		input.SearchIDs = []string{}
+		for _, searchID := range searchIDs {
+			input.SearchIDs = append(input.SearchIDs, searchID.(string))
+		}
+	}
+	return input
+}
+
+// filmFromSource - Gets the film a field is resolved on; root queries return pointers and lists return values
+func filmFromSource(source interface{}) (Film, bool) {
+	switch film := source.(type) {
+	case Film:
+		return film, true
+	case *Film:
+		if film != nil {
+			return *film, true
+		}
+	}
+	return Film{}, false
+}
+
+// vehicleFromSource - Gets the vehicle a field is resolved on; root queries return pointers and lists return values
+func vehicleFromSource(source interface{}) (Vehicle, bool) {
+	switch vehicle := source.(type) {
+	case Vehicle:
+		return vehicle, true
+	case *Vehicle:
+		if vehicle != nil {
+			return *vehicle, true
+		}
+	}
+	return Vehicle{}, false
+}
+
 // NewHandler returns a new graphql handler
 func NewHandler(cfg HandlerConfig, svc CharacterService) *handler.Handler {
+	schema, _ := newHandlerSchema(cfg, svc)
+
+	h := handler.New(&handler.Config{
+		Schema:   &schema,
+		Pretty:   cfg.Pretty,
+		GraphiQL: cfg.GraphiQL,
+		// Every error gets extensions.code so clients can tell, e.g., a bad ID from an outage
+		FormatErrorFn: formatError(cfg.Production),
+	})
+
+	return h
+}
+
+// NewSchema returns the graphql schema, shared by the HTTP handler and the subscription handler
+func NewSchema(svc CharacterService) (graphql.Schema, error) {
+	return newSchema(svc, "")
+}
+
+// newSchema returns the graphql schema; with the SDL of the subgraph, it also has the fields of a federation subgraph
+func newSchema(svc CharacterService, federationSDL string) (graphql.Schema, error) {
 	// Defines the properties of a character
 	// For example, R2-D2 has the following properties:
 	// {
@@ -43,8 +127,9 @@ func NewHandler(cfg HandlerConfig, svc CharacterService) *handler.Handler {
 				},
 			},
 			"films": &graphql.Field{
-				Type:        graphql.NewList(graphql.String),
-				Description: "The films the character has been in.",
+				Type:              graphql.NewList(graphql.String),
+				Description:       "The films the character has been in.",
+				DeprecationReason: "Use appearsIn, which returns the films themselves.",
 				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 					if character, ok := p.Source.(Character); ok {
 						return character.Films, nil
@@ -53,8 +138,9 @@ func NewHandler(cfg HandlerConfig, svc CharacterService) *handler.Handler {
 				},
 			},
 			"vehicleModels": &graphql.Field{
-				Type:        graphql.NewList(graphql.String),
-				Description: "The vehicle models the character drives.",
+				Type:              graphql.NewList(graphql.String),
+				Description:       "The vehicle models the character drives.",
+				DeprecationReason: "Use vehicles, which returns the vehicles themselves.",
 				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 					if character, ok := p.Source.(Character); ok {
 						return character.VehicleModels, nil
@@ -65,6 +151,182 @@ func NewHandler(cfg HandlerConfig, svc CharacterService) *handler.Handler {
 		},
 	})
 
+	// Defines a film, e.g. A New Hope; its ID is its URL in the Star Wars API
+	filmType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Film",
+		Description: "A StarWars film",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.ID),
+				Description: "The URL of the film in the Star Wars API.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					film, _ := filmFromSource(p.Source)
+					return film.ID, nil
+				},
+			},
+			"title": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					film, _ := filmFromSource(p.Source)
+					return film.Title, nil
+				},
+			},
+			"episodeID": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "The episode number, e.g. 4 for A New Hope.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					film, _ := filmFromSource(p.Source)
+					return film.EpisodeID, nil
+				},
+			},
+			"director": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					film, _ := filmFromSource(p.Source)
+					return film.Director, nil
+				},
+			},
+			"releaseDate": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The release date as YYYY-MM-DD.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					film, _ := filmFromSource(p.Source)
+					return film.ReleaseDate, nil
+				},
+			},
+			"url": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					film, _ := filmFromSource(p.Source)
+					return film.ID, nil
+				},
+			},
+			"characters": &graphql.Field{
+				Type:        graphql.NewList(characterType),
+				Description: "The characters that are in the film.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					film, _ := filmFromSource(p.Source)
+					return loadersFromContext(p.Context, svc).Characters(film.CharacterIDs), nil
+				},
+			},
+		},
+	})
+
+	// Defines a vehicle, e.g. a snowspeeder; its ID is its URL in the Star Wars API
+	vehicleType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Vehicle",
+		Description: "A StarWars vehicle",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.ID),
+				Description: "The URL of the vehicle in the Star Wars API.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					vehicle, _ := vehicleFromSource(p.Source)
+					return vehicle.ID, nil
+				},
+			},
+			"name": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					vehicle, _ := vehicleFromSource(p.Source)
+					return vehicle.Name, nil
+				},
+			},
+			"model": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					vehicle, _ := vehicleFromSource(p.Source)
+					return vehicle.Model, nil
+				},
+			},
+			"manufacturer": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					vehicle, _ := vehicleFromSource(p.Source)
+					return vehicle.Manufacturer, nil
+				},
+			},
+			"vehicleClass": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The class of the vehicle, e.g. wheeled or repulsorcraft.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					vehicle, _ := vehicleFromSource(p.Source)
+					return vehicle.VehicleClass, nil
+				},
+			},
+			"url": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					vehicle, _ := vehicleFromSource(p.Source)
+					return vehicle.ID, nil
+				},
+			},
+			"pilots": &graphql.Field{
+				Type:        graphql.NewList(characterType),
+				Description: "The characters that have piloted the vehicle.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					vehicle, _ := vehicleFromSource(p.Source)
+					return loadersFromContext(p.Context, svc).Characters(vehicle.PilotIDs), nil
+				},
+			},
+			"films": &graphql.Field{
+				Type:        graphql.NewList(filmType),
+				Description: "The films the vehicle is in.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					vehicle, _ := vehicleFromSource(p.Source)
+					return loadersFromContext(p.Context, svc).Films(vehicle.FilmIDs), nil
+				},
+			},
+		},
+	})
+
+	// Films, vehicles and characters reference each other, so these fields are added once all of them exist
+	filmType.AddFieldConfig("vehicles", &graphql.Field{
+		Type:        graphql.NewList(vehicleType),
+		Description: "The vehicles that are in the film.",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			film, _ := filmFromSource(p.Source)
+			return loadersFromContext(p.Context, svc).Vehicles(film.VehicleIDs), nil
+		},
+	})
+	characterType.AddFieldConfig("id", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.ID),
+		Description: "The URL of the character in the Star Wars API.",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			character, _ := p.Source.(Character)
+			return character.ID, nil
+		},
+	})
+	characterType.AddFieldConfig("appearsIn", &graphql.Field{
+		Type:        graphql.NewList(filmType),
+		Description: "The films the character has been in.",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			character, ok := p.Source.(Character)
+			if !ok {
+				return []interface{}{}, nil
+			}
+			// Snapshots taken before film IDs were stored don't have them
+			if len(character.FilmIDs) == 0 && len(character.Films) > 0 {
+				return svc.GetCharacterFilms(character)
+			}
+			return loadersFromContext(p.Context, svc).Films(character.FilmIDs), nil
+		},
+	})
+	characterType.AddFieldConfig("vehicles", &graphql.Field{
+		Type:        graphql.NewList(vehicleType),
+		Description: "The vehicles the character drives.",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			character, ok := p.Source.(Character)
+			if !ok {
+				return []interface{}{}, nil
+			}
+			if len(character.VehicleIDs) == 0 && len(character.VehicleModels) > 0 {
+				return svc.GetCharacterVehicles(character)
+			}
+			return loadersFromContext(p.Context, svc).Vehicles(character.VehicleIDs), nil
+		},
+	})
+
 	// Defines the list of characters that are returned from a search with its search ID
 	charactersResultType := graphql.NewObject(
 		graphql.ObjectConfig{
@@ -80,6 +342,21 @@ func NewHandler(cfg HandlerConfig, svc CharacterService) *handler.Handler {
 		},
 	)
 
+	// Defines how the characters of a saved search are returned
+	searchViewType := graphql.NewEnum(graphql.EnumConfig{
+		Name: "SearchView",
+		Values: graphql.EnumValueConfigMap{
+			"SNAPSHOT": &graphql.EnumValueConfig{
+				Value:       SearchViewSnapshot,
+				Description: "The characters exactly as they were when the search was saved; searches without a snapshot fall back to LIVE.",
+			},
+			"LIVE": &graphql.EnumValueConfig{
+				Value:       SearchViewLive,
+				Description: "The characters rebuilt from the current data.",
+			},
+		},
+	})
+
 	// Defines the properties of a saved search, used when querying for saved searches
 	searchQueryType := graphql.NewObject(graphql.ObjectConfig{
 		Name: "Search",
@@ -90,99 +367,1757 @@ func NewHandler(cfg HandlerConfig, svc CharacterService) *handler.Handler {
 			"SearchKey": &graphql.Field{
 				Type: graphql.String,
 			},
+			"Title": &graphql.Field{
+				Type:        graphql.String,
+				Description: "User-editable title of the search.",
+			},
+			"Notes": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Free-text notes about the search.",
+			},
+			"Saved": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Whether the search was saved, either indefinitely or until ExpiresAt.",
+			},
+			"Tags": &graphql.Field{
+				Type:        graphql.NewList(graphql.String),
+				Description: "Tags used to organise searches, e.g. \"Prequel villains\".",
+			},
+			"ExpiresAt": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "When the search will be removed; empty for saved searches.",
+			},
+			"DeletedAt": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "When the search was deleted; empty unless the search is deleted.",
+			},
+			"SnapshotAt": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "When the snapshot of the search was taken; empty if it has none.",
+			},
+			"CreatedAt": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "When the search was first made.",
+			},
 		},
 	})
 
-	// Defines the Queries that can be made
-	characterQueryType := graphql.NewObject(graphql.ObjectConfig{
-		Name: "CharacterQuery",
-		Fields: graphql.Fields{
-			"getCharacters": &graphql.Field{
-				Type: charactersResultType,
-				Args: graphql.FieldConfigArgument{
-					"name": &graphql.ArgumentConfig{
-						Description: "name of the character",
-						Type:        graphql.NewNonNull(graphql.String),
-					},
-				},
-				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-					name := p.Args["name"].(string)
-					characters, searchID, err := svc.GetCharacters(name)
-					if err != nil {
-						return nil, err
-					}
+	// Defines the orders saved searches can be listed in
+	searchSortType := graphql.NewEnum(graphql.EnumConfig{
+		Name: "SearchSort",
+		Values: graphql.EnumValueConfigMap{
+			"CREATED": &graphql.EnumValueConfig{
+				Value:       SearchSortCreated,
+				Description: "By when the search was first made.",
+			},
+			"TITLE": &graphql.EnumValueConfig{
+				Value:       SearchSortTitle,
+				Description: "By title, ignoring case; searches without a title come first.",
+			},
+			"SEARCH_KEY": &graphql.EnumValueConfig{
+				Value:       SearchSortSearchKey,
+				Description: "By search key, ignoring case.",
+			},
+		},
+	})
 
-					return CharactersResult{Characters: characters, SearchID: searchID}, nil
-				},
+	// Defines the direction of a sort
+	sortDirectionType := graphql.NewEnum(graphql.EnumConfig{
+		Name: "SortDirection",
+		Values: graphql.EnumValueConfigMap{
+			"ASC": &graphql.EnumValueConfig{
+				Value: false,
 			},
-			"getSavedSearches": &graphql.Field{
-				Type: graphql.NewList(searchQueryType),
-				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-					searches, err := svc.GetSavedSearches()
-					if err != nil {
-						return nil, err
-					}
-					return searches, nil
-				},
+			"DESC": &graphql.EnumValueConfig{
+				Value: true,
 			},
-			"getSavedSearchesByID": &graphql.Field{
-				Type: graphql.NewList(characterType),
-				Args: graphql.FieldConfigArgument{
-					"searchID": &graphql.ArgumentConfig{
-						Description: "the search ID",
-						Type:        graphql.NewNonNull(graphql.String),
-					},
-				},
-				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-					searchID := p.Args["searchID"].(string)
+		},
+	})
 
-					characters, err := svc.GetSavedSearchesByID(searchID)
-					if err != nil {
-						return nil, err
-					}
-					return characters, nil
-				},
+	// Defines the Relay page info of a connection
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+			},
+			"hasPreviousPage": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "Whether the page was asked for with an after cursor; pages only go forward.",
+			},
+			"startCursor": &graphql.Field{
+				Type: graphql.String,
+			},
+			"endCursor": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Pass as after to get the next page.",
 			},
 		},
 	})
 
-	// Defines the Mutations that can be made
-	characterMutationType := graphql.NewObject(graphql.ObjectConfig{
-		Name: "CharacterMutation",
+	// Defines a saved search in a connection with its cursor
+	searchEdgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchEdge",
 		Fields: graphql.Fields{
-			"saveSearch": &graphql.Field{
-				Type: graphql.Boolean,
-				Args: graphql.FieldConfigArgument{
-					"searchID": &graphql.ArgumentConfig{
-						Description: "the search ID",
-						Type:        graphql.NewNonNull(graphql.String),
-					},
-				},
-				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-					searchID := p.Args["searchID"].(string)
+			"cursor": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"node": &graphql.Field{
+				Type: searchQueryType,
+			},
+		},
+	})
 
-					result, err := svc.SaveSearch(searchID)
-					if err != nil {
-						return nil, err
-					}
+	// Defines a page of saved searches as a Relay connection
+	searchConnectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchConnection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{
+				Type: graphql.NewList(searchEdgeType),
+			},
+			"pageInfo": &graphql.Field{
+				Type: graphql.NewNonNull(pageInfoType),
+			},
+			"totalCount": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "Number of searches that match the filters, over all pages.",
+			},
+		},
+	})
 
-					return result, nil
-				},
+	// Defines a collection that groups saved searches around a topic, e.g. all the Skywalkers
+	collectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Collection",
+		Fields: graphql.Fields{
+			"ID": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Description": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Position": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Position of the collection in the list of collections.",
+			},
+			"SearchIDs": &graphql.Field{
+				Type:        graphql.NewList(graphql.String),
+				Description: "IDs of the saved searches in the collection, in order.",
+			},
+			"Searches": &graphql.Field{
+				Type:        graphql.NewList(searchQueryType),
+				Description: "The saved searches in the collection, in order.",
+			},
+			"CreatedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"UpdatedAt": &graphql.Field{
+				Type: graphql.DateTime,
 			},
 		},
 	})
 
-	// Combines the Queries and Mutations into a Schema
-	schema, _ := graphql.NewSchema(graphql.SchemaConfig{
-		Query:    characterQueryType,
-		Mutation: characterMutationType,
+	// Defines the films and vehicles a character gained or lost when a search was run again
+	characterDiffType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "CharacterDiff",
+		Fields: graphql.Fields{
+			"ID": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"FilmsAdded": &graphql.Field{
+				Type: graphql.NewList(graphql.String),
+			},
+			"FilmsRemoved": &graphql.Field{
+				Type: graphql.NewList(graphql.String),
+			},
+			"VehiclesAdded": &graphql.Field{
+				Type: graphql.NewList(graphql.String),
+			},
+			"VehiclesRemoved": &graphql.Field{
+				Type: graphql.NewList(graphql.String),
+			},
+		},
 	})
 
-	h := handler.New(&handler.Config{
-		Schema:   &schema,
-		Pretty:   cfg.Pretty,
-		GraphiQL: cfg.GraphiQL,
+	// Defines the result of running a search again, compared with the stored result
+	searchDiffType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchDiff",
+		Fields: graphql.Fields{
+			"SearchID": &graphql.Field{
+				Type: graphql.String,
+			},
+			"SearchKey": &graphql.Field{
+				Type: graphql.String,
+			},
+			"ComparedTo": &graphql.Field{
+				Type:        searchViewType,
+				Description: "The stored result the new one was compared to; LIVE if the search has no snapshot.",
+			},
+			"RanAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"Added": &graphql.Field{
+				Type:        graphql.NewList(characterType),
+				Description: "Characters that are only in the new result.",
+			},
+			"Removed": &graphql.Field{
+				Type:        graphql.NewList(characterType),
+				Description: "Characters that are no longer in the new result.",
+			},
+			"Changed": &graphql.Field{
+				Type:        graphql.NewList(characterDiffType),
+				Description: "Characters in both results whose films or vehicles changed.",
+			},
+			"HasChanges": &graphql.Field{
+				Type: graphql.Boolean,
+			},
+		},
 	})
 
-	return h
+	// Defines the properties of a user account
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"ID": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Username": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Role": &graphql.Field{
+				Type:        graphql.String,
+				Description: "One of reader, editor or admin.",
+			},
+		},
+	})
+
+	// Defines the properties of an API key; the key itself is only shown by the admin CLI when it's created
+	apiKeyType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "APIKey",
+		Fields: graphql.Fields{
+			"ID": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Prefix": &graphql.Field{
+				Type:        graphql.String,
+				Description: "First characters of the key, to tell keys apart.",
+			},
+			"UserID": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Role": &graphql.Field{
+				Type: graphql.String,
+			},
+			"CreatedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"RevokedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+
+	// Defines the session returned by register and login
+	authSessionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AuthSession",
+		Fields: graphql.Fields{
+			"Token": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Send as \"Authorization: Bearer <Token>\" to act as the user.",
+			},
+			"ExpiresAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"User": &graphql.Field{
+				Type: userType,
+			},
+		},
+	})
+
+	// Defines the properties of a read-only share link to a saved search
+	shareLinkType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ShareLink",
+		Fields: graphql.Fields{
+			"ID": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Token": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Pass to sharedSearch, or open /shared/<Token>.",
+			},
+			"ExpiresAt": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "When the link stops working; empty if it works until it's revoked.",
+			},
+			"CreatedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+
+	// Defines what a share link shows
+	sharedSearchType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SharedSearch",
+		Fields: graphql.Fields{
+			"SearchKey": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Title": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Notes": &graphql.Field{
+				Type: graphql.String,
+			},
+			"SnapshotAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"Characters": &graphql.Field{
+				Type: graphql.NewList(characterType),
+			},
+		},
+	})
+
+	// Defines an entry of the audit log
+	auditEntryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AuditEntry",
+		Fields: graphql.Fields{
+			"ID": &graphql.Field{
+				Type: graphql.String,
+			},
+			"ActorID": &graphql.Field{
+				Type:        graphql.String,
+				Description: "ID of the user who made the mutation; empty for anonymous requests.",
+			},
+			"ActorName": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Operation": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Name of the mutation.",
+			},
+			"TargetID": &graphql.Field{
+				Type:        graphql.String,
+				Description: "ID of the search, collection or API key that was changed.",
+			},
+			"Arguments": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Arguments as JSON; passwords are redacted.",
+			},
+			"Before": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Target before the mutation as JSON; empty if it didn't exist.",
+			},
+			"After": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Target after the mutation as JSON; empty if it doesn't exist.",
+			},
+			"Error": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Why the mutation failed; empty if it succeeded.",
+			},
+			"CreatedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+
+	// Defines a page of the audit log
+	auditLogPageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AuditLogPage",
+		Fields: graphql.Fields{
+			"Entries": &graphql.Field{
+				Type: graphql.NewList(auditEntryType),
+			},
+			"EndCursor": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Pass as after to get the next page.",
+			},
+			"HasNextPage": &graphql.Field{
+				Type: graphql.Boolean,
+			},
+		},
+	})
+
+	// Defines what happens to an imported search the user already has
+	importConflictType := graphql.NewEnum(graphql.EnumConfig{
+		Name: "ImportConflict",
+		Values: graphql.EnumValueConfigMap{
+			"SKIP": &graphql.EnumValueConfig{
+				Value:       ImportConflictSkip,
+				Description: "Keep the existing search.",
+			},
+			"OVERWRITE": &graphql.EnumValueConfig{
+				Value:       ImportConflictOverwrite,
+				Description: "Replace the existing search with the imported one.",
+			},
+			"DUPLICATE": &graphql.EnumValueConfig{
+				Value:       ImportConflictDuplicate,
+				Description: "Import the search as a new search next to the existing one.",
+			},
+		},
+	})
+
+	// Defines what happened to a search of an imported bundle
+	importedSearchType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ImportedSearch",
+		Fields: graphql.Fields{
+			"SourceID": &graphql.Field{
+				Type:        graphql.String,
+				Description: "ID of the search in the bundle.",
+			},
+			"SearchID": &graphql.Field{
+				Type:        graphql.String,
+				Description: "ID of the search after the import.",
+			},
+			"SearchKey": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Action": &graphql.Field{
+				Type:        graphql.String,
+				Description: "CREATED, OVERWRITTEN or SKIPPED.",
+			},
+		},
+	})
+
+	// Defines the report of an import
+	importReportType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ImportReport",
+		Fields: graphql.Fields{
+			"DryRun": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "True if nothing was written and the report shows what would have happened.",
+			},
+			"Created": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"Overwritten": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"Skipped": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"Searches": &graphql.Field{
+				Type: graphql.NewList(importedSearchType),
+			},
+		},
+	})
+
+	// Defines the properties of a webhook
+	webhookType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Webhook",
+		Fields: graphql.Fields{
+			"ID": &graphql.Field{
+				Type: graphql.String,
+			},
+			"URL": &graphql.Field{
+				Type: graphql.String,
+			},
+			"SearchIDs": &graphql.Field{
+				Type:        graphql.NewList(graphql.String),
+				Description: "Watched searches; all saved searches if empty.",
+			},
+			"Secret": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Key the payloads are signed with; only returned by createWebhook.",
+			},
+			"CreatedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+
+	// Defines an attempt to deliver a payload to a webhook
+	webhookAttemptType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "WebhookAttempt",
+		Fields: graphql.Fields{
+			"At": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"StatusCode": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Status code of the response; 0 if there was none.",
+			},
+			"Error": &graphql.Field{
+				Type: graphql.String,
+			},
+		},
+	})
+
+	// Defines a payload sent to a webhook
+	webhookDeliveryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "WebhookDelivery",
+		Fields: graphql.Fields{
+			"ID": &graphql.Field{
+				Type: graphql.String,
+			},
+			"WebhookID": &graphql.Field{
+				Type: graphql.String,
+			},
+			"SearchID": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Event": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Payload": &graphql.Field{
+				Type: graphql.String,
+			},
+			"Succeeded": &graphql.Field{
+				Type: graphql.Boolean,
+			},
+			"Attempts": &graphql.Field{
+				Type: graphql.NewList(webhookAttemptType),
+			},
+			"CreatedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+
+	// Defines the Queries that can be made
+	characterQueryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "CharacterQuery",
+		Fields: graphql.Fields{
+			"me": &graphql.Field{
+				Type:        userType,
+				Description: "Returns the logged in user, or null for anonymous requests",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return UserFromContext(p.Context), nil
+				},
+			},
+			"apiKeys": &graphql.Field{
+				Type:        graphql.NewList(apiKeyType),
+				Description: "Returns all API keys, including revoked ones; admin only",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if _, err := authorize(p.Context, RoleAdmin); err != nil {
+						return nil, err
+					}
+
+					apiKeys, err := svc.GetAPIKeys()
+					if err != nil {
+						return nil, err
+					}
+
+					return apiKeys, nil
+				},
+			},
+			"auditLog": &graphql.Field{
+				Type:        auditLogPageType,
+				Description: "Returns the audit log of mutations, newest first; admin only",
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{
+						Description:  "number of entries to return",
+						Type:         graphql.Int,
+						DefaultValue: defaultAuditPageSize,
+					},
+					"after": &graphql.ArgumentConfig{
+						Description: "EndCursor of the previous page",
+						Type:        graphql.String,
+					},
+					"actorID": &graphql.ArgumentConfig{
+						Description: "only entries of this user",
+						Type:        graphql.String,
+					},
+					"operation": &graphql.ArgumentConfig{
+						Description: "only entries of this mutation",
+						Type:        graphql.String,
+					},
+					"targetID": &graphql.ArgumentConfig{
+						Description: "only entries that changed this search, collection or API key",
+						Type:        graphql.String,
+					},
+					"since": &graphql.ArgumentConfig{
+						Description: "only entries made at or after this time",
+						Type:        graphql.DateTime,
+					},
+					"until": &graphql.ArgumentConfig{
+						Description: "only entries made before this time",
+						Type:        graphql.DateTime,
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if _, err := authorize(p.Context, RoleAdmin); err != nil {
+						return nil, err
+					}
+
+					filter := AuditFilter{}
+					filter.ActorID, _ = p.Args["actorID"].(string)
+					filter.Operation, _ = p.Args["operation"].(string)
+					filter.TargetID, _ = p.Args["targetID"].(string)
+					if since, ok := p.Args["since"].(time.Time); ok {
+						filter.Since = &since
+					}
+					if until, ok := p.Args["until"].(time.Time); ok {
+						filter.Until = &until
+					}
+
+					first, _ := p.Args["first"].(int)
+					after, _ := p.Args["after"].(string)
+
+					result, err := svc.GetAuditLog(filter, first, after)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"webhooks": &graphql.Field{
+				Type:        graphql.NewList(webhookType),
+				Description: "Returns the webhooks of the user",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleReader)
+					if err != nil {
+						return nil, err
+					}
+
+					webhooks, err := svc.GetWebhooks(userID)
+					if err != nil {
+						return nil, err
+					}
+
+					return webhooks, nil
+				},
+			},
+			"webhookDeliveries": &graphql.Field{
+				Type:        graphql.NewList(webhookDeliveryType),
+				Description: "Returns the latest deliveries of a webhook, newest first",
+				Args: graphql.FieldConfigArgument{
+					"webhookID": &graphql.ArgumentConfig{
+						Description: "the webhook ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"limit": &graphql.ArgumentConfig{
+						Description:  "number of deliveries to return",
+						Type:         graphql.Int,
+						DefaultValue: defaultWebhookDeliveries,
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleReader)
+					if err != nil {
+						return nil, err
+					}
+
+					webhookID := p.Args["webhookID"].(string)
+					limit, _ := p.Args["limit"].(int)
+
+					deliveries, err := svc.GetWebhookDeliveries(userID, webhookID, limit)
+					if err != nil {
+						return nil, err
+					}
+
+					return deliveries, nil
+				},
+			},
+			"sharedSearch": &graphql.Field{
+				Type:        sharedSearchType,
+				Description: "Returns the saved search of a share link; no login is needed",
+				Args: graphql.FieldConfigArgument{
+					"token": &graphql.ArgumentConfig{
+						Description: "the share link token",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					token := p.Args["token"].(string)
+
+					result, err := svc.GetSharedSearch(token)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"shareLinks": &graphql.Field{
+				Type:        graphql.NewList(shareLinkType),
+				Description: "Returns the share links of a search that can still be used",
+				Args: graphql.FieldConfigArgument{
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleReader)
+					if err != nil {
+						return nil, err
+					}
+
+					searchID := p.Args["searchID"].(string)
+
+					links, err := svc.GetShareLinks(userID, searchID)
+					if err != nil {
+						return nil, err
+					}
+
+					return links, nil
+				},
+			},
+			"getCharacters": &graphql.Field{
+				Type: charactersResultType,
+				Args: graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{
+						Description: "name of the character",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					name := p.Args["name"].(string)
+					characters, searchID, err := svc.GetCharacters(currentUserID(p.Context), name)
+					if err != nil {
+						return nil, err
+					}
+
+					return CharactersResult{Characters: characters, SearchID: searchID}, nil
+				},
+			},
+			"film": &graphql.Field{
+				Type:        filmType,
+				Description: "Returns a film by its number, e.g. 1, or its URL in the Star Wars API",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.ID),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					film, err := svc.GetFilm(p.Args["id"].(string))
+					if err != nil || film == nil {
+						return nil, err
+					}
+					return film, nil
+				},
+			},
+			"vehicle": &graphql.Field{
+				Type:        vehicleType,
+				Description: "Returns a vehicle by its number, e.g. 14, or its URL in the Star Wars API",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.ID),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					vehicle, err := svc.GetVehicle(p.Args["id"].(string))
+					if err != nil || vehicle == nil {
+						return nil, err
+					}
+					return vehicle, nil
+				},
+			},
+			"getSavedSearches": &graphql.Field{
+				Type:              graphql.NewList(searchQueryType),
+				DeprecationReason: "Use savedSearches, which is paginated.",
+				Args: graphql.FieldConfigArgument{
+					"tag": &graphql.ArgumentConfig{
+						Description: "only return searches with this tag",
+						Type:        graphql.String,
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleReader)
+					if err != nil {
+						return nil, err
+					}
+
+					tag, _ := p.Args["tag"].(string)
+
+					searches, err := svc.GetSavedSearches(userID, tag)
+					if err != nil {
+						return nil, err
+					}
+					return searches, nil
+				},
+			},
+			"savedSearches": &graphql.Field{
+				Type:        searchConnectionType,
+				Description: "Returns a page of the saved searches of the user",
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{
+						Description:  "number of searches to return",
+						Type:         graphql.Int,
+						DefaultValue: defaultSearchPageSize,
+					},
+					"after": &graphql.ArgumentConfig{
+						Description: "endCursor of the previous page",
+						Type:        graphql.String,
+					},
+					"sortBy": &graphql.ArgumentConfig{
+						Type:         searchSortType,
+						DefaultValue: SearchSortCreated,
+					},
+					"sortDirection": &graphql.ArgumentConfig{
+						Description: "newest first when sorting by CREATED and ascending otherwise if left out",
+						Type:        sortDirectionType,
+					},
+					"searchKey": &graphql.ArgumentConfig{
+						Description: "only searches whose search key contains this, ignoring case",
+						Type:        graphql.String,
+					},
+					"tag": &graphql.ArgumentConfig{
+						Description: "only searches with this tag",
+						Type:        graphql.String,
+					},
+					"createdAfter": &graphql.ArgumentConfig{
+						Description: "only searches made at or after this time",
+						Type:        graphql.DateTime,
+					},
+					"createdBefore": &graphql.ArgumentConfig{
+						Description: "only searches made before this time",
+						Type:        graphql.DateTime,
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleReader)
+					if err != nil {
+						return nil, err
+					}
+
+					args := SearchConnectionArgs{}
+					args.First, _ = p.Args["first"].(int)
+					args.After, _ = p.Args["after"].(string)
+					args.Sort, _ = p.Args["sortBy"].(SearchSort)
+					args.SearchKey, _ = p.Args["searchKey"].(string)
+					args.Tag, _ = p.Args["tag"].(string)
+					if descending, ok := p.Args["sortDirection"].(bool); ok {
+						args.Descending = &descending
+					}
+					if createdAfter, ok := p.Args["createdAfter"].(time.Time); ok {
+						args.CreatedAfter = &createdAfter
+					}
+					if createdBefore, ok := p.Args["createdBefore"].(time.Time); ok {
+						args.CreatedBefore = &createdBefore
+					}
+
+					connection, err := svc.GetSavedSearchConnection(userID, args)
+					if err != nil {
+						return nil, err
+					}
+					return connection, nil
+				},
+			},
+			"getSavedSearchesByID": &graphql.Field{
+				Type: graphql.NewList(characterType),
+				Args: graphql.FieldConfigArgument{
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"view": &graphql.ArgumentConfig{
+						Description:  "whether to return the snapshot taken when the search was saved or a fresh view",
+						Type:         searchViewType,
+						DefaultValue: SearchViewSnapshot,
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleReader)
+					if err != nil {
+						return nil, err
+					}
+
+					searchID := p.Args["searchID"].(string)
+					view, _ := p.Args["view"].(SearchView)
+
+					characters, err := svc.GetSavedSearchesByID(userID, searchID, view)
+					if err != nil {
+						return nil, err
+					}
+					return characters, nil
+				},
+			},
+			"searchesExpiringSoon": &graphql.Field{
+				Type:        graphql.NewList(searchQueryType),
+				Description: "Searches that will expire soon, soonest first",
+				Args: graphql.FieldConfigArgument{
+					"within": &graphql.ArgumentConfig{
+						Description:  "number of seconds from now",
+						Type:         graphql.Int,
+						DefaultValue: 3600,
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleReader)
+					if err != nil {
+						return nil, err
+					}
+
+					within := p.Args["within"].(int)
+
+					searches, err := svc.GetSearchesExpiringSoon(userID, time.Duration(within)*time.Second)
+					if err != nil {
+						return nil, err
+					}
+					return searches, nil
+				},
+			},
+			"getDeletedSearches": &graphql.Field{
+				Type:        graphql.NewList(searchQueryType),
+				Description: "Deleted searches that can still be restored",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleReader)
+					if err != nil {
+						return nil, err
+					}
+
+					searches, err := svc.GetDeletedSearches(userID)
+					if err != nil {
+						return nil, err
+					}
+					return searches, nil
+				},
+			},
+			"getCollections": &graphql.Field{
+				Type:        graphql.NewList(collectionType),
+				Description: "All collections in order",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleReader)
+					if err != nil {
+						return nil, err
+					}
+
+					collections, err := svc.GetCollections(userID)
+					if err != nil {
+						return nil, err
+					}
+					return collections, nil
+				},
+			},
+			"getCollection": &graphql.Field{
+				Type: collectionType,
+				Args: graphql.FieldConfigArgument{
+					"collectionID": &graphql.ArgumentConfig{
+						Description: "the collection ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleReader)
+					if err != nil {
+						return nil, err
+					}
+
+					collectionID := p.Args["collectionID"].(string)
+
+					collection, err := svc.GetCollection(userID, collectionID)
+					if err != nil {
+						return nil, err
+					}
+					return collection, nil
+				},
+			},
+			"getCollectionCharacters": &graphql.Field{
+				Type:        graphql.NewList(characterType),
+				Description: "The characters of every search in the collection, without duplicates",
+				Args: graphql.FieldConfigArgument{
+					"collectionID": &graphql.ArgumentConfig{
+						Description: "the collection ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleReader)
+					if err != nil {
+						return nil, err
+					}
+
+					collectionID := p.Args["collectionID"].(string)
+
+					characters, err := svc.GetCollectionCharacters(userID, collectionID)
+					if err != nil {
+						return nil, err
+					}
+					return characters, nil
+				},
+			},
+		},
+	})
+
+	// Defines the Mutations that can be made; every one is written to the audit log
+	characterMutationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "CharacterMutation",
+		Fields: auditMutations(svc, graphql.Fields{
+			"register": &graphql.Field{
+				Type:        authSessionType,
+				Description: "Creates a user account and logs in",
+				Args: graphql.FieldConfigArgument{
+					"username": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"password": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					username := p.Args["username"].(string)
+					password := p.Args["password"].(string)
+
+					result, err := svc.Register(username, password)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"login": &graphql.Field{
+				Type:        authSessionType,
+				Description: "Logs in and returns a session token",
+				Args: graphql.FieldConfigArgument{
+					"username": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"password": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					username := p.Args["username"].(string)
+					password := p.Args["password"].(string)
+
+					result, err := svc.Login(username, password)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"logout": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Ends the session of the token the request was made with",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					token := sessionTokenFromContext(p.Context)
+					if token == "" {
+						return nil, ErrUnauthenticated
+					}
+
+					result, err := svc.Logout(token)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"revokeAPIKey": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Revokes an API key; admin only",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Description: "the API key ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if _, err := authorize(p.Context, RoleAdmin); err != nil {
+						return nil, err
+					}
+
+					apiKeyID := p.Args["id"].(string)
+
+					result, err := svc.RevokeAPIKey(apiKeyID)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"createShareLink": &graphql.Field{
+				Type:        shareLinkType,
+				Description: "Creates a read-only link to a saved search that works without logging in",
+				Args: graphql.FieldConfigArgument{
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"expiresAt": &graphql.ArgumentConfig{
+						Description: "when the link stops working; it works until revoked if omitted",
+						Type:        graphql.DateTime,
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					searchID := p.Args["searchID"].(string)
+
+					var expiresAt *time.Time
+					if value, ok := p.Args["expiresAt"].(time.Time); ok {
+						expiresAt = &value
+					}
+
+					result, err := svc.CreateShareLink(userID, searchID, expiresAt)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"revokeShareLink": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Revokes a share link so its token stops working",
+				Args: graphql.FieldConfigArgument{
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"linkID": &graphql.ArgumentConfig{
+						Description: "the share link ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					searchID := p.Args["searchID"].(string)
+					linkID := p.Args["linkID"].(string)
+
+					result, err := svc.RevokeShareLink(userID, searchID, linkID)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"createWebhook": &graphql.Field{
+				Type:        webhookType,
+				Description: "Registers a URL that is sent a signed POST when the result of a saved search changes",
+				Args: graphql.FieldConfigArgument{
+					"url": &graphql.ArgumentConfig{
+						Description: "the http or https URL to notify",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"searchIDs": &graphql.ArgumentConfig{
+						Description: "only watch these saved searches instead of all of them",
+						Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					url := p.Args["url"].(string)
+
+					var searchIDs []string
+					if ids, ok := p.Args["searchIDs"].([]interface{}); ok {
+						for _, id := range ids {
+							searchIDs = append(searchIDs, id.(string))
+						}
+					}
+
+					webhook, err := svc.CreateWebhook(userID, url, searchIDs)
+					if err != nil {
+						return nil, err
+					}
+
+					return webhook, nil
+				},
+			},
+			"deleteWebhook": &graphql.Field{
+				Type: graphql.Boolean,
+				Args: graphql.FieldConfigArgument{
+					"webhookID": &graphql.ArgumentConfig{
+						Description: "the webhook ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					webhookID := p.Args["webhookID"].(string)
+
+					result, err := svc.DeleteWebhook(userID, webhookID)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"importSearches": &graphql.Field{
+				Type:        importReportType,
+				Description: "Imports the saved searches of a bundle written by `bundle export`",
+				Args: graphql.FieldConfigArgument{
+					"bundle": &graphql.ArgumentConfig{
+						Description: "the bundle as JSON",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"conflict": &graphql.ArgumentConfig{
+						Description:  "what to do with searches the user already has",
+						Type:         importConflictType,
+						DefaultValue: ImportConflictSkip,
+					},
+					"dryRun": &graphql.ArgumentConfig{
+						Description:  "only report what would be imported",
+						Type:         graphql.Boolean,
+						DefaultValue: false,
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					bundle, err := ReadBundle(strings.NewReader(p.Args["bundle"].(string)))
+					if err != nil {
+						return nil, err
+					}
+
+					conflict, _ := p.Args["conflict"].(ImportConflict)
+					dryRun, _ := p.Args["dryRun"].(bool)
+
+					report, err := svc.ImportSearches(userID, *bundle, conflict, dryRun)
+					if err != nil {
+						return nil, err
+					}
+
+					return report, nil
+				},
+			},
+			"saveSearch": &graphql.Field{
+				Type: graphql.Boolean,
+				Args: graphql.FieldConfigArgument{
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"expiresAt": &graphql.ArgumentConfig{
+						Description: "keep the search until this time instead of indefinitely",
+						Type:        graphql.DateTime,
+					},
+					"ttl": &graphql.ArgumentConfig{
+						Description: "keep the search for this many seconds instead of indefinitely",
+						Type:        graphql.Int,
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					searchID := p.Args["searchID"].(string)
+
+					result, err := svc.SaveSearch(userID, searchID, searchExpiryFromArgs(p.Args))
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"extendSearch": &graphql.Field{
+				Type:        searchQueryType,
+				Description: "Pushes back the expiration of a search; ttl is added to the current expiration while expiresAt replaces it",
+				Args: graphql.FieldConfigArgument{
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"expiresAt": &graphql.ArgumentConfig{
+						Description: "the new expiration",
+						Type:        graphql.DateTime,
+					},
+					"ttl": &graphql.ArgumentConfig{
+						Description: "number of seconds to add to the expiration",
+						Type:        graphql.Int,
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					searchID := p.Args["searchID"].(string)
+
+					search, err := svc.ExtendSearch(userID, searchID, searchExpiryFromArgs(p.Args))
+					if err != nil {
+						return nil, err
+					}
+
+					return search, nil
+				},
+			},
+			"updateSearch": &graphql.Field{
+				Type:        searchQueryType,
+				Description: "Updates the title, notes and tags of a search; omitted fields are left unchanged",
+				Args: graphql.FieldConfigArgument{
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"title": &graphql.ArgumentConfig{
+						Description: "the title of the search",
+						Type:        graphql.String,
+					},
+					"notes": &graphql.ArgumentConfig{
+						Description: "notes about the search",
+						Type:        graphql.String,
+					},
+					"tags": &graphql.ArgumentConfig{
+						Description: "replaces the tags of the search",
+						Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					searchID := p.Args["searchID"].(string)
+
+					var input SearchUpdateInput
+					if title, ok := p.Args["title"].(string); ok {
+						input.Title = &title
+					}
+					if notes, ok := p.Args["notes"].(string); ok {
+						input.Notes = &notes
+					}
+					if tags, ok := p.Args["tags"].([]interface{}); ok {
+						input.Tags = []string{}
+						for _, tag := range tags {
+							input.Tags = append(input.Tags, tag.(string))
+						}
+					}
+
+					search, err := svc.UpdateSearch(userID, searchID, input)
+					if err != nil {
+						return nil, err
+					}
+
+					return search, nil
+				},
+			},
+			"createCollection": &graphql.Field{
+				Type:        collectionType,
+				Description: "Creates a collection at the end of the list of collections",
+				Args: graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{
+						Description: "the name of the collection",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"description": &graphql.ArgumentConfig{
+						Description: "the description of the collection",
+						Type:        graphql.String,
+					},
+					"searchIDs": &graphql.ArgumentConfig{
+						Description: "the saved searches in the collection, in order",
+						Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					collection, err := svc.CreateCollection(userID, collectionInputFromArgs(p.Args))
+					if err != nil {
+						return nil, err
+					}
+
+					return collection, nil
+				},
+			},
+			"updateCollection": &graphql.Field{
+				Type:        collectionType,
+				Description: "Updates a collection; searchIDs replaces the searches in the given order, omitted fields are left unchanged",
+				Args: graphql.FieldConfigArgument{
+					"collectionID": &graphql.ArgumentConfig{
+						Description: "the collection ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"name": &graphql.ArgumentConfig{
+						Description: "the name of the collection",
+						Type:        graphql.String,
+					},
+					"description": &graphql.ArgumentConfig{
+						Description: "the description of the collection",
+						Type:        graphql.String,
+					},
+					"searchIDs": &graphql.ArgumentConfig{
+						Description: "the saved searches in the collection, in order",
+						Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					collectionID := p.Args["collectionID"].(string)
+
+					collection, err := svc.UpdateCollection(userID, collectionID, collectionInputFromArgs(p.Args))
+					if err != nil {
+						return nil, err
+					}
+
+					return collection, nil
+				},
+			},
+			"deleteCollection": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Deletes a collection; the searches in it are kept",
+				Args: graphql.FieldConfigArgument{
+					"collectionID": &graphql.ArgumentConfig{
+						Description: "the collection ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					collectionID := p.Args["collectionID"].(string)
+
+					result, err := svc.DeleteCollection(userID, collectionID)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"addSearchToCollection": &graphql.Field{
+				Type:        collectionType,
+				Description: "Adds a saved search to the end of a collection",
+				Args: graphql.FieldConfigArgument{
+					"collectionID": &graphql.ArgumentConfig{
+						Description: "the collection ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					collectionID := p.Args["collectionID"].(string)
+					searchID := p.Args["searchID"].(string)
+
+					collection, err := svc.AddSearchToCollection(userID, collectionID, searchID)
+					if err != nil {
+						return nil, err
+					}
+
+					return collection, nil
+				},
+			},
+			"removeSearchFromCollection": &graphql.Field{
+				Type:        collectionType,
+				Description: "Removes a search from a collection",
+				Args: graphql.FieldConfigArgument{
+					"collectionID": &graphql.ArgumentConfig{
+						Description: "the collection ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					collectionID := p.Args["collectionID"].(string)
+					searchID := p.Args["searchID"].(string)
+
+					collection, err := svc.RemoveSearchFromCollection(userID, collectionID, searchID)
+					if err != nil {
+						return nil, err
+					}
+
+					return collection, nil
+				},
+			},
+			"moveCollection": &graphql.Field{
+				Type:        graphql.NewList(collectionType),
+				Description: "Moves a collection to a zero-based position and returns the reordered collections",
+				Args: graphql.FieldConfigArgument{
+					"collectionID": &graphql.ArgumentConfig{
+						Description: "the collection ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+					"position": &graphql.ArgumentConfig{
+						Description: "the new position of the collection",
+						Type:        graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					collectionID := p.Args["collectionID"].(string)
+					position := p.Args["position"].(int)
+
+					collections, err := svc.MoveCollection(userID, collectionID, position)
+					if err != nil {
+						return nil, err
+					}
+
+					return collections, nil
+				},
+			},
+			"unsaveSearch": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Puts the default expiration back on a saved search",
+				Args: graphql.FieldConfigArgument{
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					searchID := p.Args["searchID"].(string)
+
+					result, err := svc.UnsaveSearch(userID, searchID)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"deleteSearch": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Deletes a search; it can be restored until the retention window passes",
+				Args: graphql.FieldConfigArgument{
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					searchID := p.Args["searchID"].(string)
+
+					result, err := svc.DeleteSearch(userID, searchID)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"rerunSearch": &graphql.Field{
+				Type:        searchDiffType,
+				Description: "Runs a search against the SWAPI again and returns the differences with the stored result; the new result is kept until it's accepted",
+				Args: graphql.FieldConfigArgument{
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					searchID := p.Args["searchID"].(string)
+
+					result, err := svc.RerunSearch(userID, searchID)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"acceptSearchRerun": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Makes the result of the last rerunSearch the saved version of the search",
+				Args: graphql.FieldConfigArgument{
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					searchID := p.Args["searchID"].(string)
+
+					result, err := svc.AcceptSearchRerun(userID, searchID)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+			"restoreSearch": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Restores a deleted search",
+				Args: graphql.FieldConfigArgument{
+					"searchID": &graphql.ArgumentConfig{
+						Description: "the search ID",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					userID, err := authorize(p.Context, RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					searchID := p.Args["searchID"].(string)
+
+					result, err := svc.RestoreSearch(userID, searchID)
+					if err != nil {
+						return nil, err
+					}
+
+					return result, nil
+				},
+			},
+		}),
+	})
+
+	// Defines an event of a search streamed by the searchProgress subscription
+	searchProgressType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchProgress",
+		Fields: graphql.Fields{
+			"Character": &graphql.Field{
+				Type:        characterType,
+				Description: "The character that was just hydrated; null on the last event.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if progress, ok := p.Source.(SearchProgress); ok && progress.Character != nil {
+						return *progress.Character, nil
+					}
+					return nil, nil
+				},
+			},
+			"Count": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Number of characters hydrated so far.",
+			},
+			"Total": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Number of characters the search found.",
+			},
+			"Done": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Whether this is the last event of the search.",
+			},
+			"SearchID": &graphql.Field{
+				Type:        graphql.String,
+				Description: "ID of the recorded search on the last event; empty for anonymous users.",
+			},
+		},
+	})
+
+	// Defines the Subscriptions, served over WebSocket by NewSubscriptionHandler
+	characterSubscriptionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"searchProgress": &graphql.Field{
+				Type:        searchProgressType,
+				Description: "Searches characters like getCharacters, streaming each character as it's hydrated and then the search ID",
+				Args: graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{
+						Description: "name of the character",
+						Type:        graphql.NewNonNull(graphql.String),
+					},
+				},
+				Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+					name := p.Args["name"].(string)
+					userID := currentUserID(p.Context)
+
+					events := make(chan interface{})
+					go func() {
+						defer close(events)
+
+						send := func(event interface{}) error {
+							select {
+							case events <- event:
+								return nil
+							case <-p.Context.Done():
+								return p.Context.Err()
+							}
+						}
+
+						err := svc.SearchCharactersWithProgress(p.Context, userID, name, func(progress SearchProgress) error {
+							return send(progress)
+						})
+						if err != nil && p.Context.Err() == nil {
+							send(err)
+						}
+					}()
+
+					return events, nil
+				},
+				// Every event is resolved with the event as the source; errors end the subscription
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if err, ok := p.Source.(error); ok {
+						return nil, err
+					}
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	// Lets a federation router look characters, films and vehicles up by their key
+	var types []graphql.Type
+	if federationSDL != "" {
+		types = addFederationFields(svc, federationSDL, characterQueryType, []*graphql.Object{characterType, filmType, vehicleType})
+	}
+
+	// Combines the Queries, Mutations and Subscriptions into a Schema
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:        characterQueryType,
+		Mutation:     characterMutationType,
+		Subscription: characterSubscriptionType,
+		Types:        types,
+	})
 }
@@ -0,0 +1,497 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"alvinlucillo/swapi-app/internal/models"
+	"alvinlucillo/swapi-app/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// getCachedCharacters - Builds the characters using the database as a cache for films and vehicles
+// and records the search for the user
+func (c *CharacterServiceImpl) getCachedCharacters(repo *repositories.Repository, userID string, name string, peopleResult []PeopleResult) ([]Character, string, error) {
+	characters, err := c.cacheCharacters(repo, peopleResult)
+	if err != nil {
+		return nil, "", err
+	}
+
+	searchID, err := addSearch(repo, userID, name, characters)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return characters, searchID, nil
+}
+
+// addSearch - Records a search of the user with the characters it found
+func addSearch(repo *repositories.Repository, userID string, name string, characters []Character) (string, error) {
+	var characterIDs []string
+	for _, character := range characters {
+		characterIDs = append(characterIDs, character.ID)
+	}
+
+	search := models.SearchModel{
+		ID:         primitive.NewObjectID(),
+		Owner:      userID,
+		SearchKey:  name,
+		Characters: characterIDs,
+	}
+
+	searchID, err := repo.SearchRepository.AddSearch(search)
+	if err != nil {
+		return "", fmt.Errorf("failed to add search: %w", err)
+	}
+
+	return searchID, nil
+}
+
+// cacheCharacters - Builds the characters from the SWAPI people results
+//  1. Adds the films and vehicles to the database if they don't already exist
+//  2. Adds the characters to the database if they don't already exist
+func (c *CharacterServiceImpl) cacheCharacters(repo *repositories.Repository, peopleResult []PeopleResult) ([]Character, error) {
+	var characterIDs, filmIDs, vehicleIDs []string
+	for _, person := range peopleResult {
+		characterIDs = append(characterIDs, person.URL)
+		filmIDs = append(filmIDs, person.Films...)
+		vehicleIDs = append(vehicleIDs, person.Vehicles...)
+	}
+
+	films, err := c.getFilms(repo, filmIDs)
+	if err != nil {
+		return nil, err
+	}
+	vehicles, err := c.getVehicles(repo, vehicleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	existingCharacters, err := repo.CharacterRepository.GetCharacters(uniqueStrings(characterIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get characters: %w", err)
+	}
+	cached := map[string]bool{}
+	for _, character := range existingCharacters {
+		cached[character.ID] = true
+	}
+
+	var characters []Character
+	for _, person := range peopleResult {
+		characters = append(characters, toCharacter(models.CharacterModel{
+			ID:       person.URL,
+			Name:     person.Name,
+			Films:    person.Films,
+			Vehicles: person.Vehicles,
+		}, films, vehicles))
+
+		if !cached[person.URL] {
+			_, err := repo.CharacterRepository.AddCharacter(models.CharacterModel{
+				Name:     person.Name,
+				ID:       person.URL,
+				Films:    person.Films,
+				Vehicles: person.Vehicles,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to add character: %w", err)
+			}
+			cached[person.URL] = true
+		}
+	}
+
+	return characters, nil
+}
+
+// getLiveCharacters - Builds the characters straight from the SWAPI without touching the database
+func (c *CharacterServiceImpl) getLiveCharacters(peopleResult []PeopleResult) ([]Character, error) {
+	var characters []Character
+	for _, person := range peopleResult {
+		character := Character{
+			ID:         person.URL,
+			Name:       person.Name,
+			FilmIDs:    person.Films,
+			VehicleIDs: person.Vehicles,
+		}
+
+		for _, film := range person.Films {
+			filmResult, err := c.swapiClient.QueryFilm(film)
+			if err != nil {
+				return nil, fmt.Errorf("failed to query film: %w", err)
+			}
+			character.Films = append(character.Films, filmResult.Title)
+		}
+
+		for _, vehicle := range person.Vehicles {
+			vehicleResult, err := c.swapiClient.QueryVehicle(vehicle)
+			if err != nil {
+				return nil, fmt.Errorf("failed to query vehicle: %w", err)
+			}
+			character.VehicleModels = append(character.VehicleModels, vehicleResult.Model)
+		}
+
+		characters = append(characters, character)
+	}
+
+	return characters, nil
+}
+
+// hydrateCharacters - Builds the character results from the cached characters, films and vehicles
+// Entries that are no longer in the cache are fetched from the SWAPI and cached again.
+// Characters, films and vehicles are each looked up with one query however many there are.
+func (c *CharacterServiceImpl) hydrateCharacters(repo *repositories.Repository, characterIDs []string) ([]Character, error) {
+	cachedCharacters, err := c.getCharacters(repo, characterIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var filmIDs, vehicleIDs []string
+	for _, character := range cachedCharacters {
+		filmIDs = append(filmIDs, character.Films...)
+		vehicleIDs = append(vehicleIDs, character.Vehicles...)
+	}
+
+	films, err := c.getFilms(repo, filmIDs)
+	if err != nil {
+		return nil, err
+	}
+	vehicles, err := c.getVehicles(repo, vehicleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var characters []Character
+	for _, characterID := range characterIDs {
+		if character, ok := cachedCharacters[characterID]; ok {
+			characters = append(characters, toCharacter(character, films, vehicles))
+		}
+	}
+
+	return characters, nil
+}
+
+// toCharacter - Builds the character result with the titles of its films and the models of its vehicles
+func toCharacter(character models.CharacterModel, films map[string]models.FilmModel, vehicles map[string]models.VehicleModel) Character {
+	result := Character{
+		ID:         character.ID,
+		Name:       character.Name,
+		FilmIDs:    character.Films,
+		VehicleIDs: character.Vehicles,
+	}
+	for _, filmID := range character.Films {
+		if film, ok := films[filmID]; ok {
+			result.Films = append(result.Films, film.Title)
+		}
+	}
+	for _, vehicleID := range character.Vehicles {
+		if vehicle, ok := vehicles[vehicleID]; ok {
+			result.VehicleModels = append(result.VehicleModels, vehicle.Model)
+		}
+	}
+	return result
+}
+
+// getCharacters - Gets characters from the database in one query; the ones that aren't cached
+// are fetched from the SWAPI together and cached. Characters the SWAPI doesn't have are left out.
+func (c *CharacterServiceImpl) getCharacters(repo *repositories.Repository, characterIDs []string) (map[string]models.CharacterModel, error) {
+	characters := map[string]models.CharacterModel{}
+	characterIDs = uniqueStrings(characterIDs)
+	if len(characterIDs) == 0 {
+		return characters, nil
+	}
+
+	cached, err := repo.CharacterRepository.GetCharacters(characterIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get characters: %w", c.checkStorage(err))
+	}
+	for _, character := range cached {
+		characters[character.ID] = character
+	}
+
+	var missing []string
+	for _, id := range characterIDs {
+		if _, ok := characters[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	var fetched []models.CharacterModel
+	var mu sync.Mutex
+	err = fetchAll(missing, func(characterID string) error {
+		person, err := c.swapiClient.QueryPerson(characterID)
+		if err != nil {
+			return fmt.Errorf("failed to query person: %w", err)
+		}
+		mu.Lock()
+		fetched = append(fetched, models.CharacterModel{
+			ID:       characterID,
+			Name:     person.Name,
+			Films:    person.Films,
+			Vehicles: person.Vehicles,
+		})
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, character := range fetched {
+		if _, err := repo.CharacterRepository.AddCharacter(character); err != nil {
+			return nil, fmt.Errorf("failed to add character: %w", c.checkStorage(err))
+		}
+		characters[character.ID] = character
+	}
+
+	return characters, nil
+}
+
+// getFilms - Gets films from the database in one query, fetching and caching the missing ones like getCharacters
+func (c *CharacterServiceImpl) getFilms(repo *repositories.Repository, filmIDs []string) (map[string]models.FilmModel, error) {
+	films := map[string]models.FilmModel{}
+	filmIDs = uniqueStrings(filmIDs)
+	if len(filmIDs) == 0 {
+		return films, nil
+	}
+
+	cached, err := repo.FilmRepository.GetFilms(filmIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get films: %w", c.checkStorage(err))
+	}
+	for _, film := range cached {
+		films[film.ID] = film
+	}
+
+	var missing []string
+	for _, id := range filmIDs {
+		if _, ok := films[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	var fetched []models.FilmModel
+	var mu sync.Mutex
+	err = fetchAll(missing, func(filmID string) error {
+		filmResult, err := c.swapiClient.QueryFilm(filmID)
+		if err != nil {
+			return fmt.Errorf("failed to query film: %w", err)
+		}
+		mu.Lock()
+		fetched = append(fetched, toFilmModel(filmID, filmResult))
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, film := range fetched {
+		if _, err := repo.FilmRepository.AddFilm(film); err != nil {
+			return nil, fmt.Errorf("failed to add film: %w", c.checkStorage(err))
+		}
+		films[film.ID] = film
+	}
+
+	return films, nil
+}
+
+// getVehicles - Gets vehicles from the database in one query, fetching and caching the missing ones like getCharacters
+func (c *CharacterServiceImpl) getVehicles(repo *repositories.Repository, vehicleIDs []string) (map[string]models.VehicleModel, error) {
+	vehicles := map[string]models.VehicleModel{}
+	vehicleIDs = uniqueStrings(vehicleIDs)
+	if len(vehicleIDs) == 0 {
+		return vehicles, nil
+	}
+
+	cached, err := repo.VehicleRepository.GetVehicles(vehicleIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vehicles: %w", c.checkStorage(err))
+	}
+	for _, vehicle := range cached {
+		vehicles[vehicle.ID] = vehicle
+	}
+
+	var missing []string
+	for _, id := range vehicleIDs {
+		if _, ok := vehicles[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	var fetched []models.VehicleModel
+	var mu sync.Mutex
+	err = fetchAll(missing, func(vehicleID string) error {
+		vehicleResult, err := c.swapiClient.QueryVehicle(vehicleID)
+		if err != nil {
+			return fmt.Errorf("failed to query vehicle: %w", err)
+		}
+		mu.Lock()
+		fetched = append(fetched, toVehicleModel(vehicleID, vehicleResult))
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vehicle := range fetched {
+		if _, err := repo.VehicleRepository.AddVehicle(vehicle); err != nil {
+			return nil, fmt.Errorf("failed to add vehicle: %w", c.checkStorage(err))
+		}
+		vehicles[vehicle.ID] = vehicle
+	}
+
+	return vehicles, nil
+}
+
+// maxConcurrentFetches is how many requests fetchAll sends to the SWAPI at the same time
+const maxConcurrentFetches = 8
+
+// fetchAll - Calls fetch for the IDs concurrently, since the SWAPI can't return many at once
+// At most maxConcurrentFetches calls run at the same time.
+// IDs the SWAPI doesn't have are skipped; any other error is returned
+func fetchAll(ids []string, fetch func(id string) error) error {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentFetches)
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		i, id := i, id
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fetch(id); err != nil && !errors.Is(err, ErrSWAPINotFound) {
+				errs[i] = err
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uniqueStrings - Returns the strings without duplicates, in the order they first appear
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// getCharacter - Gets a character from the database, or from the SWAPI if it's not cached
+func (c *CharacterServiceImpl) getCharacter(repo *repositories.Repository, characterID string) (models.CharacterModel, error) {
+	existingCharacter, err := repo.CharacterRepository.GetCharacter(characterID)
+	if err != nil {
+		return models.CharacterModel{}, fmt.Errorf("failed to get character: %w", c.checkStorage(err))
+	}
+
+	if existingCharacter != nil {
+		return *existingCharacter, nil
+	}
+
+	person, err := c.swapiClient.QueryPerson(characterID)
+	if err != nil {
+		return models.CharacterModel{}, fmt.Errorf("failed to query person: %w", err)
+	}
+
+	character := models.CharacterModel{
+		ID:       characterID,
+		Name:     person.Name,
+		Films:    person.Films,
+		Vehicles: person.Vehicles,
+	}
+	_, err = repo.CharacterRepository.AddCharacter(character)
+	if err != nil {
+		return models.CharacterModel{}, fmt.Errorf("failed to add character: %w", c.checkStorage(err))
+	}
+
+	return character, nil
+}
+
+// getFilm - Gets a film from the database, or from the SWAPI if it's not cached
+func (c *CharacterServiceImpl) getFilm(repo *repositories.Repository, filmID string) (models.FilmModel, error) {
+	existingFilm, err := repo.FilmRepository.GetFilm(filmID)
+	if err != nil {
+		return models.FilmModel{}, fmt.Errorf("failed to get film: %w", c.checkStorage(err))
+	}
+
+	if existingFilm != nil {
+		return *existingFilm, nil
+	}
+
+	filmResult, err := c.swapiClient.QueryFilm(filmID)
+	if err != nil {
+		return models.FilmModel{}, fmt.Errorf("failed to query film: %w", err)
+	}
+
+	film := toFilmModel(filmID, filmResult)
+	_, err = repo.FilmRepository.AddFilm(film)
+	if err != nil {
+		return models.FilmModel{}, fmt.Errorf("failed to add film: %w", c.checkStorage(err))
+	}
+
+	return film, nil
+}
+
+// getVehicle - Gets a vehicle from the database, or from the SWAPI if it's not cached
+func (c *CharacterServiceImpl) getVehicle(repo *repositories.Repository, vehicleID string) (models.VehicleModel, error) {
+	existingVehicle, err := repo.VehicleRepository.GetVehicle(vehicleID)
+	if err != nil {
+		return models.VehicleModel{}, fmt.Errorf("failed to get vehicle: %w", c.checkStorage(err))
+	}
+
+	if existingVehicle != nil {
+		return *existingVehicle, nil
+	}
+
+	vehicleResult, err := c.swapiClient.QueryVehicle(vehicleID)
+	if err != nil {
+		return models.VehicleModel{}, fmt.Errorf("failed to query vehicle: %w", err)
+	}
+
+	vehicle := toVehicleModel(vehicleID, vehicleResult)
+	_, err = repo.VehicleRepository.AddVehicle(vehicle)
+	if err != nil {
+		return models.VehicleModel{}, fmt.Errorf("failed to add vehicle: %w", c.checkStorage(err))
+	}
+
+	return vehicle, nil
+}
+
+// toFilmModel - Converts a SWAPI film to the cached film
+func toFilmModel(filmID string, result FilmResult) models.FilmModel {
+	return models.FilmModel{
+		ID:          filmID,
+		Title:       result.Title,
+		EpisodeID:   result.EpisodeID,
+		Director:    result.Director,
+		ReleaseDate: result.ReleaseDate,
+		Characters:  result.Characters,
+		Vehicles:    result.Vehicles,
+	}
+}
+
+// toVehicleModel - Converts a SWAPI vehicle to the cached vehicle
+func toVehicleModel(vehicleID string, result VehicleResult) models.VehicleModel {
+	return models.VehicleModel{
+		ID:           vehicleID,
+		Name:         result.Name,
+		Model:        result.Model,
+		Manufacturer: result.Manufacturer,
+		VehicleClass: result.VehicleClass,
+		Pilots:       result.Pilots,
+		Films:        result.Films,
+	}
+}
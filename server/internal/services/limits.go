@@ -0,0 +1,455 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/location"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/graphql-go/handler"
+)
+
+// Codes of the errors of operations rejected before they run
+const (
+	ErrCodeQueryTooDeep    = "QUERY_TOO_DEEP"
+	ErrCodeQueryTooComplex = "QUERY_TOO_COMPLEX"
+)
+
+// DefaultFieldCosts are the costs of fields that call the SWAPI, keyed by "Type.field"; other fields cost 1
+// Root fields are keyed by the operation type, e.g. Query.film
+// Nested fields are batched per request, so they cost less than the root fields that start a search
+var DefaultFieldCosts = map[string]int{
+	"Query.getCharacters":           10,
+	"Query.getCollectionCharacters": 10,
+	"Query.film":                    5,
+	"Query.vehicle":                 5,
+	"Mutation.rerunSearch":          10,
+	"Subscription.searchProgress":   10,
+	"Character.appearsIn":           5,
+	"Character.vehicles":            5,
+	"Film.characters":               5,
+	"Film.vehicles":                 5,
+	"Vehicle.pilots":                5,
+	"Vehicle.films":                 5,
+}
+
+// QueryLimits bound the depth and the cost of GraphQL operations, which are checked before they run
+//
+// The cost of a field is its weight plus the cost of its selections, multiplied by the size of the list
+// for list fields. The size is the first or limit argument of the field, or of the closest field above it
+// like savedSearches(first: 50) { edges }, or DefaultListSize when there is none.
+// Introspection fields are neither counted nor limited, so GraphiQL keeps working.
+type QueryLimits struct {
+	// Deepest nesting of fields allowed; 0 disables the limit
+	MaxDepth int
+	// Highest cost allowed; 0 disables the limit
+	MaxCost int
+	// Size assumed for lists without a first or limit argument
+	DefaultListSize int
+	// Costs of fields keyed by "Type.field", on top of DefaultFieldCosts
+	FieldCosts map[string]int
+}
+
+// QueryCost is the result of analyzing an operation
+type QueryCost struct {
+	Depth int
+	Cost  int
+}
+
+// QueryLimitError is the error of an operation that exceeds the limits
+type QueryLimitError struct {
+	Code   string
+	Cost   QueryCost
+	Limits QueryLimits
+}
+
+func (e *QueryLimitError) Error() string {
+	if e.Code == ErrCodeQueryTooDeep {
+		return fmt.Sprintf("query depth %d exceeds the maximum of %d", e.Cost.Depth, e.Limits.MaxDepth)
+	}
+	// The analysis stops once the cost is over the maximum, so the cost is a lower bound
+	return fmt.Sprintf("query cost of at least %d exceeds the maximum of %d", e.Cost.Cost, e.Limits.MaxCost)
+}
+
+// FormattedError - Returns the GraphQL error, explaining the computed cost in its extensions
+func (e *QueryLimitError) FormattedError() gqlerrors.FormattedError {
+	return gqlerrors.FormattedError{
+		Message:   e.Error(),
+		Locations: []location.SourceLocation{},
+		Extensions: map[string]interface{}{
+			"code":     e.Code,
+			"depth":    e.Cost.Depth,
+			"maxDepth": e.Limits.MaxDepth,
+			"cost":     e.Cost.Cost,
+			"maxCost":  e.Limits.MaxCost,
+		},
+	}
+}
+
+// DefaultQueryLimits - Returns the limits used when none are configured
+func DefaultQueryLimits() QueryLimits {
+	return QueryLimits{
+		MaxDepth:        10,
+		MaxCost:         1000,
+		DefaultListSize: 10,
+	}
+}
+
+// Check - Analyzes an operation and returns a *QueryLimitError if it exceeds the limits
+// Operations that can't be parsed or selected pass, so the executor reports the error as usual
+// The analysis stops once the cost is over MaxCost, so the cost of rejected operations is where it stopped.
+func (l QueryLimits) Check(schema *graphql.Schema, query string, operationName string, variables map[string]interface{}) (QueryCost, *QueryLimitError) {
+	cost, ok := l.analyze(schema, query, operationName, variables, l.MaxCost)
+	if !ok {
+		return cost, nil
+	}
+
+	if l.MaxDepth > 0 && cost.Depth > l.MaxDepth {
+		return cost, &QueryLimitError{Code: ErrCodeQueryTooDeep, Cost: cost, Limits: l}
+	}
+	if l.MaxCost > 0 && cost.Cost > l.MaxCost {
+		return cost, &QueryLimitError{Code: ErrCodeQueryTooComplex, Cost: cost, Limits: l}
+	}
+
+	return cost, nil
+}
+
+// Analyze - Computes the depth and the cost of an operation without running it
+// Returns false if the query can't be parsed or has no such operation
+func (l QueryLimits) Analyze(schema *graphql.Schema, query string, operationName string, variables map[string]interface{}) (QueryCost, bool) {
+	return l.analyze(schema, query, operationName, variables, 0)
+}
+
+// analyze - Computes the depth and the cost of an operation, stopping once the cost is over maxCost unless it's 0
+func (l QueryLimits) analyze(schema *graphql.Schema, query string, operationName string, variables map[string]interface{}, maxCost int) (QueryCost, bool) {
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(query)})})
+	if err != nil {
+		return QueryCost{}, false
+	}
+
+	analyzer := queryAnalyzer{
+		limits:    l,
+		maxCost:   maxCost,
+		schema:    schema,
+		variables: variables,
+		fragments: map[string]*ast.FragmentDefinition{},
+		spreads:   map[fragmentKey]fragmentCost{},
+	}
+	var operations []*ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.OperationDefinition:
+			operations = append(operations, definition)
+		case *ast.FragmentDefinition:
+			analyzer.fragments[definition.Name.Value] = definition
+		}
+	}
+
+	var operation *ast.OperationDefinition
+	for _, candidate := range operations {
+		if (operationName == "" && len(operations) == 1) || (candidate.Name != nil && candidate.Name.Value == operationName) {
+			operation = candidate
+		}
+	}
+	if operation == nil {
+		return QueryCost{}, false
+	}
+
+	var root *graphql.Object
+	switch operation.Operation {
+	case ast.OperationTypeQuery:
+		root = schema.QueryType()
+	case ast.OperationTypeMutation:
+		root = schema.MutationType()
+	case ast.OperationTypeSubscription:
+		root = schema.SubscriptionType()
+	}
+	if root == nil {
+		return QueryCost{}, false
+	}
+
+	depth, cost := analyzer.selections(root, operation.SelectionSet, 1, 0, map[string]bool{})
+	return QueryCost{Depth: depth, Cost: cost}, true
+}
+
+// queryAnalyzer walks the selections of an operation along the schema
+type queryAnalyzer struct {
+	limits QueryLimits
+	// Cost past which the walk stops; 0 walks the whole operation
+	maxCost   int
+	schema    *graphql.Schema
+	variables map[string]interface{}
+	fragments map[string]*ast.FragmentDefinition
+	// Costs of the fragments already walked, so fragments spread many times are only walked once
+	spreads map[fragmentKey]fragmentCost
+}
+
+// fragmentKey is what the cost of a fragment spread depends on: the fragment, its type and the list size above it
+type fragmentKey struct {
+	name     string
+	typeName string
+	size     int
+}
+
+// fragmentCost is the cost of a fragment spread, with its depth relative to the spread; -1 if it has no fields
+type fragmentCost struct {
+	depth int
+	cost  int
+}
+
+// overCost - Checks if a cost is past the point where the walk stops
+func (a *queryAnalyzer) overCost(cost int) bool {
+	return a.maxCost > 0 && cost > a.maxCost
+}
+
+// selections - Returns the depth and the cost of a selection set of the given type
+// depth is the depth of its fields, size the list size set by a field above and not used by a list yet,
+// and spread the fragments being expanded, so cyclic fragments stop instead of recursing forever
+// Once the cost is over maxCost the rest of the selections are skipped; the operation is rejected anyway.
+func (a *queryAnalyzer) selections(parent graphql.Type, set *ast.SelectionSet, depth int, size int, spread map[string]bool) (int, int) {
+	if set == nil {
+		return 0, 0
+	}
+
+	maxDepth, total := 0, 0
+	add := func(fieldDepth int, cost int) {
+		if fieldDepth > maxDepth {
+			maxDepth = fieldDepth
+		}
+		total += cost
+	}
+
+	for _, selection := range set.Selections {
+		if a.overCost(total) {
+			break
+		}
+
+		switch selection := selection.(type) {
+		case *ast.Field:
+			add(a.field(parent, selection, depth, size, spread))
+		case *ast.InlineFragment:
+			fragmentType := parent
+			if selection.TypeCondition != nil {
+				if conditionType := a.schema.Type(selection.TypeCondition.Name.Value); conditionType != nil {
+					fragmentType = conditionType
+				}
+			}
+			add(a.selections(fragmentType, selection.SelectionSet, depth, size, spread))
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			fragment, ok := a.fragments[name]
+			if !ok || spread[name] {
+				continue
+			}
+			fragmentType := parent
+			if conditionType := a.schema.Type(fragment.TypeCondition.Name.Value); conditionType != nil {
+				fragmentType = conditionType
+			}
+			key := fragmentKey{name: name, typeName: fragmentType.Name(), size: size}
+			known, ok := a.spreads[key]
+			if !ok {
+				spread[name] = true
+				fragmentDepth, fragmentTotal := a.selections(fragmentType, fragment.SelectionSet, depth, size, spread)
+				delete(spread, name)
+
+				known = fragmentCost{depth: -1, cost: fragmentTotal}
+				if fragmentDepth > 0 {
+					known.depth = fragmentDepth - depth
+				}
+				a.spreads[key] = known
+			}
+			if known.depth >= 0 {
+				add(depth+known.depth, known.cost)
+			} else {
+				add(0, known.cost)
+			}
+		}
+	}
+
+	return maxDepth, total
+}
+
+// field - Returns the depth and the cost of a field and its selections
+func (a *queryAnalyzer) field(parent graphql.Type, field *ast.Field, depth int, size int, spread map[string]bool) (int, int) {
+	name := field.Name.Value
+	if strings.HasPrefix(name, "__") {
+		return 0, 0
+	}
+
+	cost := 1
+	var fieldType graphql.Type
+	if fields := typeFields(parent); fields != nil {
+		if definition, ok := fields[name]; ok {
+			fieldType = definition.Type
+		}
+	}
+	key := a.typeName(parent) + "." + name
+	if weight, ok := DefaultFieldCosts[key]; ok {
+		cost = weight
+	}
+	if weight, ok := a.limits.FieldCosts[key]; ok {
+		cost = weight
+	}
+
+	if argumentSize := a.pageSize(field); argumentSize > 0 {
+		size = argumentSize
+	}
+
+	multiplier := 1
+	named, isList := unwrapType(fieldType)
+	if isList {
+		multiplier = size
+		if multiplier <= 0 {
+			multiplier = a.limits.DefaultListSize
+		}
+		if multiplier <= 0 {
+			multiplier = 1
+		}
+		size = 0
+	}
+
+	if named == nil || field.SelectionSet == nil {
+		return depth, cost
+	}
+	childDepth, childCost := a.selections(named, field.SelectionSet, depth+1, size, spread)
+	if childDepth < depth {
+		childDepth = depth
+	}
+
+	// Huge list sizes would overflow the cost, which is already over the maximum
+	if a.overCost(childCost) || (childCost > 0 && a.overCost(multiplier)) {
+		return childDepth, a.maxCost + 1
+	}
+
+	return childDepth, cost + multiplier*childCost
+}
+
+// typeName - Returns the name costs are keyed by: Query, Mutation or Subscription for the root types,
+// whatever the schema names them, or else the name of the type
+func (a *queryAnalyzer) typeName(t graphql.Type) string {
+	switch t {
+	case graphql.Type(a.schema.QueryType()):
+		return "Query"
+	case graphql.Type(a.schema.MutationType()):
+		return "Mutation"
+	case graphql.Type(a.schema.SubscriptionType()):
+		return "Subscription"
+	}
+	return t.Name()
+}
+
+// pageSize - Returns the first or limit argument of a field, or the number of representations of _entities,
+// or 0 if it has none
+func (a *queryAnalyzer) pageSize(field *ast.Field) int {
+	for _, argument := range field.Arguments {
+		// _entities of federation subgraphs returns an entity per representation
+		if argument.Name.Value == "representations" {
+			switch value := argument.Value.(type) {
+			case *ast.ListValue:
+				return len(value.Values)
+			case *ast.Variable:
+				if representations, ok := a.variables[value.Name.Value].([]interface{}); ok {
+					return len(representations)
+				}
+			}
+			continue
+		}
+		if argument.Name.Value != "first" && argument.Name.Value != "limit" {
+			continue
+		}
+
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			size, _ := strconv.Atoi(value.Value)
+			return size
+		case *ast.Variable:
+			switch size := a.variables[value.Name.Value].(type) {
+			case int:
+				return size
+			case float64:
+				return int(size)
+			}
+		}
+	}
+	return 0
+}
+
+// typeFields - Returns the fields of an object or interface type
+func typeFields(t graphql.Type) graphql.FieldDefinitionMap {
+	switch t := t.(type) {
+	case *graphql.Object:
+		return t.Fields()
+	case *graphql.Interface:
+		return t.Fields()
+	}
+	return nil
+}
+
+// unwrapType - Returns the named type under non-null and list wrappers, and whether it's a list
+func unwrapType(t graphql.Type) (graphql.Type, bool) {
+	isList := false
+	for {
+		switch wrapped := t.(type) {
+		case *graphql.NonNull:
+			t = wrapped.OfType
+		case *graphql.List:
+			isList = true
+			t = wrapped.OfType
+		default:
+			return t, isList
+		}
+	}
+}
+
+// NewQueryLimitMiddleware - Returns a middleware that rejects GraphQL operations exceeding the limits
+// before the handler runs them, with a 400 response whose error explains the computed cost
+func NewQueryLimitMiddleware(cfg HandlerConfig, svc CharacterService, limits QueryLimits) func(http.Handler) http.Handler {
+	schema, _ := newHandlerSchema(cfg, svc)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The handler reads the body again, so it's kept
+			var body []byte
+			if r.Body != nil {
+				var err error
+				body, err = io.ReadAll(r.Body)
+				r.Body.Close()
+				if err != nil {
+					writeBodyError(w, err)
+					return
+				}
+			}
+			parsed := r.Clone(r.Context())
+			parsed.Body = io.NopCloser(bytes.NewReader(body))
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			options := handler.NewRequestOptions(parsed)
+			if options.Query != "" {
+				if _, err := limits.Check(&schema, options.Query, options.OperationName, options.Variables); err != nil {
+					writeQueryLimitError(w, err)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeQueryLimitError - Writes the GraphQL response of a rejected operation
+func writeQueryLimitError(w http.ResponseWriter, err *QueryLimitError) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []gqlerrors.FormattedError{err.FormattedError()},
+	})
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+type loadersContextKey struct{}
+
+// Loaders batch and memoize the characters, films and vehicles looked up while resolving one request
+//
+// Resolvers return the thunk of a load instead of its result. graphql-go only calls thunks once every
+// field of a level has been resolved, so the keys of sibling resolvers, e.g. the films of every character
+// in a list, are collected first and the first thunk called loads all of them in one batch.
+type Loaders struct {
+	characters *loader
+	films      *loader
+	vehicles   *loader
+}
+
+// NewLoaders - Returns empty loaders that look characters, films and vehicles up through the service
+func NewLoaders(svc CharacterService) *Loaders {
+	clock := &loaderClock{}
+	return &Loaders{
+		characters: newLoader(clock, func(ids []string) (map[string]interface{}, error) {
+			characters, err := svc.GetCharactersByIDs(ids)
+			if err != nil {
+				return nil, err
+			}
+			values := map[string]interface{}{}
+			for _, character := range characters {
+				values[character.ID] = character
+			}
+			return values, nil
+		}),
+		films: newLoader(clock, func(ids []string) (map[string]interface{}, error) {
+			films, err := svc.GetFilms(ids)
+			if err != nil {
+				return nil, err
+			}
+			values := map[string]interface{}{}
+			for _, film := range films {
+				values[film.ID] = film
+			}
+			return values, nil
+		}),
+		vehicles: newLoader(clock, func(ids []string) (map[string]interface{}, error) {
+			vehicles, err := svc.GetVehicles(ids)
+			if err != nil {
+				return nil, err
+			}
+			values := map[string]interface{}{}
+			for _, vehicle := range vehicles {
+				values[vehicle.ID] = vehicle
+			}
+			return values, nil
+		}),
+	}
+}
+
+// WithLoaders - Returns a copy of the context carrying the loaders of the request
+func WithLoaders(ctx context.Context, loaders *Loaders) context.Context {
+	return context.WithValue(ctx, loadersContextKey{}, loaders)
+}
+
+// loadersFromContext - Returns the loaders of the request
+// Requests that didn't go through the loader middleware get loaders of their own for every call,
+// so they still work but aren't batched
+func loadersFromContext(ctx context.Context, svc CharacterService) *Loaders {
+	if ctx != nil {
+		if loaders, ok := ctx.Value(loadersContextKey{}).(*Loaders); ok {
+			return loaders
+		}
+	}
+	return NewLoaders(svc)
+}
+
+// NewLoaderMiddleware - Returns a middleware that gives every request its own loaders
+// Loaders are never shared between requests, so cached values never outlive a request
+func NewLoaderMiddleware(svc CharacterService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithLoaders(r.Context(), NewLoaders(svc))))
+		})
+	}
+}
+
+// Characters - Returns the thunk of the characters with the given IDs, resolving to []Character
+func (l *Loaders) Characters(ids []string) func() (interface{}, error) {
+	load := l.characters.load(ids)
+	return func() (interface{}, error) {
+		values, err := load()
+		if err != nil {
+			return nil, err
+		}
+		characters := []Character{}
+		for _, value := range values {
+			characters = append(characters, value.(Character))
+		}
+		return characters, nil
+	}
+}
+
+// Films - Returns the thunk of the films with the given IDs, resolving to []Film
+func (l *Loaders) Films(ids []string) func() (interface{}, error) {
+	load := l.films.load(ids)
+	return func() (interface{}, error) {
+		values, err := load()
+		if err != nil {
+			return nil, err
+		}
+		films := []Film{}
+		for _, value := range values {
+			films = append(films, value.(Film))
+		}
+		return films, nil
+	}
+}
+
+// Vehicles - Returns the thunk of the vehicles with the given IDs, resolving to []Vehicle
+func (l *Loaders) Vehicles(ids []string) func() (interface{}, error) {
+	load := l.vehicles.load(ids)
+	return func() (interface{}, error) {
+		values, err := load()
+		if err != nil {
+			return nil, err
+		}
+		vehicles := []Vehicle{}
+		for _, value := range values {
+			vehicles = append(vehicles, value.(Vehicle))
+		}
+		return vehicles, nil
+	}
+}
+
+// loader collects the keys of loads until one of them is needed, then looks all of them up in one batch
+type loader struct {
+	// batch looks up many keys at once; keys that don't exist are left out of the map
+	batch func(keys []string) (map[string]interface{}, error)
+	// clock is shared by the loaders of a request, see loaderClock
+	clock *loaderClock
+
+	mu      sync.Mutex
+	pending []pendingKey
+	results map[string]*loaderResult
+}
+
+// loaderClock is the tick keys are queued in: the number of thunks above the field loading them
+//
+// graphql-go calls the thunks of a level one by one and completes the value of each, which resolves the
+// fields below it, before calling the next one. A thunk only looks up the keys queued up to its own tick,
+// so the keys of its siblings are in its batch and the keys of the fields below them never are, whatever
+// order the siblings are resolved in.
+type loaderClock struct {
+	tick atomic.Int64
+}
+
+// pendingKey is a key queued for the next batch with the tick it was queued in
+type pendingKey struct {
+	key  string
+	tick int64
+}
+
+// loaderResult is the memoized result of a key; value and err are set before done is closed
+type loaderResult struct {
+	value interface{}
+	err   error
+	done  chan struct{}
+}
+
+func newLoader(clock *loaderClock, batch func(keys []string) (map[string]interface{}, error)) *loader {
+	return &loader{
+		batch:   batch,
+		clock:   clock,
+		results: map[string]*loaderResult{},
+	}
+}
+
+// load - Queues the keys that weren't loaded before and returns the thunk of their values
+// The thunk leaves out keys that don't exist and keeps the order of the rest
+func (l *loader) load(keys []string) func() ([]interface{}, error) {
+	tick := l.clock.tick.Load()
+
+	l.mu.Lock()
+	for _, key := range keys {
+		if _, ok := l.results[key]; !ok {
+			l.results[key] = &loaderResult{done: make(chan struct{})}
+			l.pending = append(l.pending, pendingKey{key: key, tick: tick})
+		}
+	}
+	l.mu.Unlock()
+
+	return func() ([]interface{}, error) {
+		// Fields resolved while the value of this thunk is completed are a level below it
+		l.clock.tick.Store(tick + 1)
+
+		l.dispatch(tick, keys)
+
+		// Keys of other batches may still be looked up, e.g. by another operation sharing the loaders
+		results := make([]*loaderResult, len(keys))
+		l.mu.Lock()
+		for i, key := range keys {
+			results[i] = l.results[key]
+		}
+		l.mu.Unlock()
+
+		var values []interface{}
+		for _, result := range results {
+			<-result.done
+			if result.err != nil {
+				return nil, result.err
+			}
+			if result.value != nil {
+				values = append(values, result.value)
+			}
+		}
+		return values, nil
+	}
+}
+
+// dispatch - Looks up the keys queued up to the tick in one batch, with the given keys if they're still queued
+// The lock is only held to take the keys and to store their results, so other loads don't wait for the lookup.
+func (l *loader) dispatch(tick int64, needed []string) {
+	neededKeys := map[string]bool{}
+	for _, key := range needed {
+		neededKeys[key] = true
+	}
+
+	l.mu.Lock()
+	var keys []string
+	var later []pendingKey
+	for _, pending := range l.pending {
+		if pending.tick <= tick || neededKeys[pending.key] {
+			keys = append(keys, pending.key)
+		} else {
+			later = append(later, pending)
+		}
+	}
+	l.pending = later
+	l.mu.Unlock()
+
+	if len(keys) == 0 {
+		return
+	}
+
+	// Loads waiting for the keys must be released even if the lookup panics
+	var values map[string]interface{}
+	err := fmt.Errorf("failed to look up %d keys", len(keys))
+	defer func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, key := range keys {
+			result := l.results[key]
+			result.err = err
+			result.value = values[key]
+			close(result.done)
+		}
+	}()
+
+	values, err = l.batch(keys)
+}
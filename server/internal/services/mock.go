@@ -1,24 +1,38 @@
 package services
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
 	"alvinlucillo/swapi-app/internal/models"
 	"alvinlucillo/swapi-app/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func NewMockRepository(searches []models.SearchModel, characters []models.CharacterModel, vehicles []models.VehicleModel, films []models.FilmModel) repositories.Repository {
 	repository := repositories.Repository{
-		SearchRepository: mockSearchRepository{
+		SearchRepository: &mockSearchRepository{
 			searches: searches,
 		},
-		CharacterRepository: mockCharacterRepository{
+		CharacterRepository: &mockCharacterRepository{
 			characters: characters,
 		},
-		VehicleRepository: mockVehicleRepository{
+		VehicleRepository: &mockVehicleRepository{
 			vehicles: vehicles,
 		},
-		FilmRepository: mockFilmRepository{
+		FilmRepository: &mockFilmRepository{
 			films: films,
 		},
+		CollectionRepository: &mockCollectionRepository{},
+		UserRepository:       &mockUserRepository{},
+		SessionRepository:    &mockSessionRepository{},
+		APIKeyRepository:     &mockAPIKeyRepository{},
+		AuditRepository:      &mockAuditRepository{},
+		WebhookRepository:    &mockWebhookRepository{},
 	}
 
 	return repository
@@ -54,16 +68,51 @@ func (s MockSWAPIClient) QueryPeople(name string) ([]PeopleResult, error) {
 
 	return nil, nil
 }
+func (s MockSWAPIClient) QueryPerson(id string) (PeopleResult, error) {
+	for _, character := range s.characters {
+		if character.ID == id {
+			return PeopleResult{
+				Name:     character.Name,
+				URL:      character.ID,
+				Films:    character.Films,
+				Vehicles: character.Vehicles,
+			}, nil
+		}
+	}
+
+	return PeopleResult{}, fmt.Errorf("%w: person %s", ErrSWAPINotFound, id)
+}
+
+// QueryFilm and QueryVehicle always return the same film and vehicle so tests see them change upstream
 func (s MockSWAPIClient) QueryFilm(id string) (FilmResult, error) {
-	return FilmResult{
-		Title: "A New Hope",
-	}, nil
+	for _, film := range s.films {
+		if film.ID == id {
+			return FilmResult{
+				Title: "A New Hope",
+			}, nil
+		}
+	}
+
+	return FilmResult{}, fmt.Errorf("%w: film %s", ErrSWAPINotFound, id)
 }
 
 func (s MockSWAPIClient) QueryVehicle(id string) (VehicleResult, error) {
-	return VehicleResult{
-		Model: "T-16 skyhopper",
-	}, nil
+	for _, vehicle := range s.vehicles {
+		if vehicle.ID == id {
+			return VehicleResult{
+				Model: "T-16 skyhopper",
+			}, nil
+		}
+	}
+
+	return VehicleResult{}, fmt.Errorf("%w: vehicle %s", ErrSWAPINotFound, id)
+}
+
+func (s MockSWAPIClient) ResourceURL(resource string, id string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("invalid %s ID %q", resource, id)
+	}
+	return id, nil
 }
 
 type mockSearchRepository struct {
@@ -72,14 +121,129 @@ type mockSearchRepository struct {
 
 func (m mockSearchRepository) AddSearch(newSearch models.SearchModel) (string, error) {
 	m.searches = append(m.searches, newSearch)
-	return "", nil
+	return newSearch.ID.Hex(), nil
 }
 
-func (m mockSearchRepository) GetSearches() ([]models.SearchModel, error) {
-	return m.searches, nil
+func (m mockSearchRepository) GetSearches(filter repositories.SearchFilter) ([]models.SearchModel, error) {
+	var searches []models.SearchModel
+	for _, search := range m.searches {
+		if search.Owner != filter.Owner {
+			continue
+		}
+		if filter.Tag == "" {
+			searches = append(searches, search)
+			continue
+		}
+		for _, tag := range search.Tags {
+			if tag == filter.Tag {
+				searches = append(searches, search)
+				break
+			}
+		}
+	}
+	return searches, nil
+}
+
+func (m mockSearchRepository) GetSearchPage(query repositories.SearchPageQuery) ([]models.SearchModel, int64, error) {
+	searches, _ := m.GetSearches(repositories.SearchFilter{Owner: query.Filter.Owner, Tag: query.Filter.Tag})
+
+	sortValue := func(search models.SearchModel) string {
+		switch query.Sort {
+		case repositories.SearchSortTitle:
+			return strings.ToLower(search.Title)
+		case repositories.SearchSortSearchKey:
+			return strings.ToLower(search.SearchKey)
+		}
+		return ""
+	}
+	less := func(a models.SearchModel, value string, id primitive.ObjectID) bool {
+		if sortValue(a) != value {
+			return sortValue(a) < value != query.Descending
+		}
+		return (a.ID.Hex() < id.Hex()) != query.Descending
+	}
+
+	var matched []models.SearchModel
+	for _, search := range searches {
+		if query.Filter.SearchKeyContains != "" && !strings.Contains(strings.ToLower(search.SearchKey), strings.ToLower(query.Filter.SearchKeyContains)) {
+			continue
+		}
+		created := search.ID.Timestamp()
+		if query.Filter.CreatedAfter != nil && created.Before(*query.Filter.CreatedAfter) {
+			continue
+		}
+		if query.Filter.CreatedBefore != nil && !created.Before(*query.Filter.CreatedBefore) {
+			continue
+		}
+		matched = append(matched, search)
+	}
+	total := int64(len(matched))
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return less(matched[i], sortValue(matched[j]), matched[j].ID)
+	})
+
+	var page []models.SearchModel
+	for _, search := range matched {
+		if query.After != nil && (search.ID == query.After.ID || less(search, strings.ToLower(query.After.Value), query.After.ID)) {
+			continue
+		}
+		if query.Limit > 0 && len(page) == query.Limit {
+			break
+		}
+		page = append(page, search)
+	}
+	return page, total, nil
+}
+
+func (m mockSearchRepository) UpdateSearch(searchID string, update repositories.SearchUpdate) (*models.SearchModel, error) {
+	for i, search := range m.searches {
+		if search.ID.Hex() == searchID && search.DeletedAt == nil {
+			if update.Title != nil {
+				m.searches[i].Title = *update.Title
+			}
+			if update.Notes != nil {
+				m.searches[i].Notes = *update.Notes
+			}
+			if update.Tags != nil {
+				m.searches[i].Tags = update.Tags
+			}
+			return &m.searches[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (m mockSearchRepository) ClaimOwnerlessSearches(owner string) (int64, error) {
+	var claimed int64
+	for i, search := range m.searches {
+		if search.Owner == "" && search.IsSaved() {
+			m.searches[i].Owner = owner
+			claimed++
+		}
+	}
+	return claimed, nil
+}
+
+func (m mockSearchRepository) RemoveEmptyTitles() (int64, error) {
+	// Titles of the mock are never stored as ""; an empty title is a missing one
+	return 0, nil
 }
 
 func (m mockSearchRepository) GetSearchesByID(searchID string) (*models.SearchModel, error) {
+	// Like the repository, IDs must be ObjectIDs
+	if _, err := primitive.ObjectIDFromHex(searchID); err != nil {
+		return nil, err
+	}
+	for _, search := range m.searches {
+		if search.ID.Hex() == searchID {
+			return &search, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m mockSearchRepository) GetSearch(searchID string) (*models.SearchModel, error) {
 	for _, search := range m.searches {
 		if search.ID.Hex() == searchID {
 			return &search, nil
@@ -88,26 +252,344 @@ func (m mockSearchRepository) GetSearchesByID(searchID string) (*models.SearchMo
 	return nil, nil
 }
 
+func (m *mockSearchRepository) UpsertSearch(search models.SearchModel) error {
+	for i := range m.searches {
+		if m.searches[i].ID == search.ID {
+			m.searches[i] = search
+			return nil
+		}
+	}
+	m.searches = append(m.searches, search)
+	return nil
+}
+
+func (m mockSearchRepository) GetSearchesByIDs(searchIDs []string) ([]models.SearchModel, error) {
+	var searches []models.SearchModel
+	for _, search := range m.searches {
+		for _, searchID := range searchIDs {
+			if search.ID.Hex() == searchID && search.DeletedAt == nil {
+				searches = append(searches, search)
+				break
+			}
+		}
+	}
+	return searches, nil
+}
+
 func (m mockSearchRepository) RemoveExpiration(searchID string) (bool, error) {
 	for i, search := range m.searches {
 		if search.ID.Hex() == searchID {
 			m.searches[i].ExpiresAt = nil
+			m.searches[i].Saved = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m mockSearchRepository) PinSearch(searchID string, expiresAt time.Time) (bool, error) {
+	for i, search := range m.searches {
+		if search.ID.Hex() == searchID && search.DeletedAt == nil {
+			m.searches[i].ExpiresAt = &expiresAt
+			m.searches[i].Saved = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m mockSearchRepository) ExtendExpiration(searchID string, expiresAt time.Time) (*models.SearchModel, error) {
+	for i, search := range m.searches {
+		if search.ID.Hex() == searchID && search.ExpiresAt != nil && search.DeletedAt == nil {
+			m.searches[i].ExpiresAt = &expiresAt
+			return &m.searches[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (m mockSearchRepository) ExtendExpirationBy(searchID string, ttl time.Duration) (*models.SearchModel, error) {
+	for i, search := range m.searches {
+		if search.ID.Hex() == searchID && search.ExpiresAt != nil && search.DeletedAt == nil {
+			from := *search.ExpiresAt
+			if from.Before(time.Now()) {
+				from = time.Now()
+			}
+			expiresAt := from.Add(ttl)
+			m.searches[i].ExpiresAt = &expiresAt
+			return &m.searches[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (m mockSearchRepository) GetExpiringSearches(filter repositories.SearchFilter, before time.Time) ([]models.SearchModel, error) {
+	var searches []models.SearchModel
+	for _, search := range m.searches {
+		if search.Owner == filter.Owner && search.ExpiresAt != nil && search.DeletedAt == nil && search.ExpiresAt.After(time.Now()) && !search.ExpiresAt.After(before) {
+			searches = append(searches, search)
+		}
+	}
+	sort.SliceStable(searches, func(i, j int) bool {
+		return searches[i].ExpiresAt.Before(*searches[j].ExpiresAt)
+	})
+	return searches, nil
+}
+
+func (m mockSearchRepository) SetSnapshot(searchID string, snapshot models.SearchSnapshot) (bool, error) {
+	for i, search := range m.searches {
+		if search.ID.Hex() == searchID && search.Snapshot == nil {
+			m.searches[i].Snapshot = &snapshot
 			return true, nil
 		}
 	}
 	return false, nil
 }
 
+func (m mockSearchRepository) SetPendingRerun(searchID string, rerun models.SearchSnapshot) (bool, error) {
+	for i, search := range m.searches {
+		if search.ID.Hex() == searchID && search.DeletedAt == nil {
+			m.searches[i].PendingRerun = &rerun
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m mockSearchRepository) AcceptPendingRerun(searchID string) (bool, error) {
+	for i, search := range m.searches {
+		if search.ID.Hex() == searchID && search.DeletedAt == nil && search.PendingRerun != nil {
+			var characterIDs []string
+			for _, character := range search.PendingRerun.Characters {
+				characterIDs = append(characterIDs, character.ID)
+			}
+			m.searches[i].Snapshot = search.PendingRerun
+			m.searches[i].Characters = characterIDs
+			m.searches[i].PendingRerun = nil
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m mockSearchRepository) SetWatchedResult(searchID string, result models.SearchSnapshot) (bool, error) {
+	for i, search := range m.searches {
+		if search.ID.Hex() == searchID && search.DeletedAt == nil {
+			m.searches[i].WatchedResult = &result
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m mockSearchRepository) AddShareLink(searchID string, link models.ShareLinkModel) (bool, error) {
+	for i, search := range m.searches {
+		if search.ID.Hex() == searchID && search.DeletedAt == nil {
+			m.searches[i].ShareLinks = append(m.searches[i].ShareLinks, link)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m mockSearchRepository) GetShareLinks(searchID string) ([]models.ShareLinkModel, error) {
+	var links []models.ShareLinkModel
+	for _, search := range m.searches {
+		if search.ID.Hex() == searchID && search.DeletedAt == nil {
+			for _, link := range search.ShareLinks {
+				if link.IsActive(time.Now()) {
+					links = append(links, link)
+				}
+			}
+		}
+	}
+	return links, nil
+}
+
+func (m mockSearchRepository) RevokeShareLink(searchID string, linkID string) (bool, error) {
+	for i, search := range m.searches {
+		if search.ID.Hex() != searchID {
+			continue
+		}
+		for j, link := range search.ShareLinks {
+			if link.ID == linkID && link.RevokedAt == nil {
+				now := time.Now()
+				m.searches[i].ShareLinks[j].RevokedAt = &now
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (m mockSearchRepository) AddExpiration(searchID string) (bool, error) {
+	for i, search := range m.searches {
+		if search.ID.Hex() == searchID && search.IsSaved() && search.DeletedAt == nil {
+			t := time.Now().Add(repositories.DefaultSearchTTL)
+			m.searches[i].ExpiresAt = &t
+			m.searches[i].Saved = false
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m mockSearchRepository) DeleteSearch(searchID string) (bool, error) {
+	for i, search := range m.searches {
+		if search.ID.Hex() == searchID && search.DeletedAt == nil {
+			now := time.Now()
+			expiresAt := now.Add(repositories.DefaultSearchDeleteRetention)
+			m.searches[i].RestoreExpiresAt = search.ExpiresAt
+			m.searches[i].DeletedAt = &now
+			m.searches[i].ExpiresAt = &expiresAt
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m mockSearchRepository) RestoreSearch(searchID string) (bool, error) {
+	for i, search := range m.searches {
+		if search.ID.Hex() == searchID && search.DeletedAt != nil {
+			m.searches[i].ExpiresAt = search.RestoreExpiresAt
+			m.searches[i].DeletedAt = nil
+			m.searches[i].RestoreExpiresAt = nil
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m mockSearchRepository) GetDeletedSearches(filter repositories.SearchFilter) ([]models.SearchModel, error) {
+	var searches []models.SearchModel
+	for _, search := range m.searches {
+		if search.Owner == filter.Owner && search.DeletedAt != nil {
+			searches = append(searches, search)
+		}
+	}
+	return searches, nil
+}
+
+type mockCollectionRepository struct {
+	collections []models.CollectionModel
+}
+
+func (m *mockCollectionRepository) AddCollection(newCollection models.CollectionModel) (string, error) {
+	newCollection.Position = len(m.collections)
+	m.collections = append(m.collections, newCollection)
+	return newCollection.ID.Hex(), nil
+}
+
+func (m *mockCollectionRepository) GetCollections(owner string) ([]models.CollectionModel, error) {
+	var collections []models.CollectionModel
+	for _, collection := range m.collections {
+		if collection.Owner == owner {
+			collections = append(collections, collection)
+		}
+	}
+	sort.SliceStable(collections, func(i, j int) bool {
+		return collections[i].Position < collections[j].Position
+	})
+	return collections, nil
+}
+
+func (m *mockCollectionRepository) GetCollection(id string) (*models.CollectionModel, error) {
+	for i := range m.collections {
+		if m.collections[i].ID.Hex() == id {
+			collection := m.collections[i]
+			return &collection, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockCollectionRepository) UpdateCollection(id string, update repositories.CollectionUpdate) (*models.CollectionModel, error) {
+	for i := range m.collections {
+		if m.collections[i].ID.Hex() == id {
+			if update.Name != nil {
+				m.collections[i].Name = *update.Name
+			}
+			if update.Description != nil {
+				m.collections[i].Description = *update.Description
+			}
+			if update.SearchIDs != nil {
+				m.collections[i].SearchIDs = update.SearchIDs
+			}
+			collection := m.collections[i]
+			return &collection, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockCollectionRepository) DeleteCollection(id string) (bool, error) {
+	for i := range m.collections {
+		if m.collections[i].ID.Hex() == id {
+			m.collections = append(m.collections[:i], m.collections[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockCollectionRepository) AddSearchToCollection(id string, searchID string) (*models.CollectionModel, error) {
+	for i := range m.collections {
+		if m.collections[i].ID.Hex() == id {
+			for _, existing := range m.collections[i].SearchIDs {
+				if existing == searchID {
+					collection := m.collections[i]
+					return &collection, nil
+				}
+			}
+			m.collections[i].SearchIDs = append(m.collections[i].SearchIDs, searchID)
+			collection := m.collections[i]
+			return &collection, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockCollectionRepository) RemoveSearchFromCollection(id string, searchID string) (*models.CollectionModel, error) {
+	for i := range m.collections {
+		if m.collections[i].ID.Hex() == id {
+			var searchIDs []string
+			for _, existing := range m.collections[i].SearchIDs {
+				if existing != searchID {
+					searchIDs = append(searchIDs, existing)
+				}
+			}
+			m.collections[i].SearchIDs = searchIDs
+			collection := m.collections[i]
+			return &collection, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockCollectionRepository) SetCollectionPositions(ids []string) error {
+	for position, id := range ids {
+		for i := range m.collections {
+			if m.collections[i].ID.Hex() == id {
+				m.collections[i].Position = position
+			}
+		}
+	}
+	return nil
+}
+
+// The character, film and vehicle mocks count their lookups so tests can check resolvers are batched
 type mockCharacterRepository struct {
 	characters []models.CharacterModel
+	queries    int
 }
 
-func (m mockCharacterRepository) AddCharacter(newCharacter models.CharacterModel) (string, error) {
+func (m *mockCharacterRepository) AddCharacter(newCharacter models.CharacterModel) (string, error) {
 	m.characters = append(m.characters, newCharacter)
 	return "", nil
 }
 
-func (m mockCharacterRepository) GetCharacter(id string) (*models.CharacterModel, error) {
+func (m *mockCharacterRepository) GetCharacter(id string) (*models.CharacterModel, error) {
+	m.queries++
 	for _, character := range m.characters {
 		if character.ID == id {
 			return &character, nil
@@ -116,7 +598,7 @@ func (m mockCharacterRepository) GetCharacter(id string) (*models.CharacterModel
 	return nil, nil
 }
 
-func (m mockCharacterRepository) GetCharacterByID(id string) (*models.CharacterModel, error) {
+func (m *mockCharacterRepository) GetCharacterByID(id string) (*models.CharacterModel, error) {
 	for _, character := range m.characters {
 		if character.ID == id {
 			return &character, nil
@@ -125,11 +607,8 @@ func (m mockCharacterRepository) GetCharacterByID(id string) (*models.CharacterM
 	return nil, nil
 }
 
-func (m mockCharacterRepository) GetCharacters() ([]models.CharacterModel, error) {
-	return m.characters, nil
-}
-
-func (m mockCharacterRepository) GetCharactersByID(ids []string) ([]models.CharacterModel, error) {
+func (m *mockCharacterRepository) GetCharacters(ids []string) ([]models.CharacterModel, error) {
+	m.queries++
 	var characters []models.CharacterModel
 	for _, id := range ids {
 		for _, character := range m.characters {
@@ -142,15 +621,17 @@ func (m mockCharacterRepository) GetCharactersByID(ids []string) ([]models.Chara
 }
 
 type mockFilmRepository struct {
-	films []models.FilmModel
+	films   []models.FilmModel
+	queries int
 }
 
-func (m mockFilmRepository) AddFilm(newFilm models.FilmModel) (string, error) {
+func (m *mockFilmRepository) AddFilm(newFilm models.FilmModel) (string, error) {
 	m.films = append(m.films, newFilm)
 	return "", nil
 }
 
-func (m mockFilmRepository) GetFilm(id string) (*models.FilmModel, error) {
+func (m *mockFilmRepository) GetFilm(id string) (*models.FilmModel, error) {
+	m.queries++
 	for _, film := range m.films {
 		if film.ID == id {
 			return &film, nil
@@ -159,16 +640,31 @@ func (m mockFilmRepository) GetFilm(id string) (*models.FilmModel, error) {
 	return nil, nil
 }
 
+func (m *mockFilmRepository) GetFilms(ids []string) ([]models.FilmModel, error) {
+	m.queries++
+	var films []models.FilmModel
+	for _, id := range ids {
+		for _, film := range m.films {
+			if film.ID == id {
+				films = append(films, film)
+			}
+		}
+	}
+	return films, nil
+}
+
 type mockVehicleRepository struct {
 	vehicles []models.VehicleModel
+	queries  int
 }
 
-func (m mockVehicleRepository) AddVehicle(newVehicle models.VehicleModel) (string, error) {
+func (m *mockVehicleRepository) AddVehicle(newVehicle models.VehicleModel) (string, error) {
 	m.vehicles = append(m.vehicles, newVehicle)
 	return "", nil
 }
 
-func (m mockVehicleRepository) GetVehicle(id string) (*models.VehicleModel, error) {
+func (m *mockVehicleRepository) GetVehicle(id string) (*models.VehicleModel, error) {
+	m.queries++
 	for _, vehicle := range m.vehicles {
 		if vehicle.ID == id {
 			return &vehicle, nil
@@ -176,3 +672,212 @@ func (m mockVehicleRepository) GetVehicle(id string) (*models.VehicleModel, erro
 	}
 	return nil, nil
 }
+
+func (m *mockVehicleRepository) GetVehicles(ids []string) ([]models.VehicleModel, error) {
+	m.queries++
+	var vehicles []models.VehicleModel
+	for _, id := range ids {
+		for _, vehicle := range m.vehicles {
+			if vehicle.ID == id {
+				vehicles = append(vehicles, vehicle)
+			}
+		}
+	}
+	return vehicles, nil
+}
+
+type mockUserRepository struct {
+	users []models.UserModel
+}
+
+func (m *mockUserRepository) AddUser(newUser models.UserModel) (string, error) {
+	for _, user := range m.users {
+		if user.Username == newUser.Username {
+			return "", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "duplicate key"}}}
+		}
+	}
+	m.users = append(m.users, newUser)
+	return newUser.ID.Hex(), nil
+}
+
+func (m *mockUserRepository) GetUser(id string) (*models.UserModel, error) {
+	for i := range m.users {
+		if m.users[i].ID.Hex() == id {
+			user := m.users[i]
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockUserRepository) GetUserByUsername(username string) (*models.UserModel, error) {
+	for i := range m.users {
+		if m.users[i].Username == username {
+			user := m.users[i]
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockUserRepository) SetUserRole(username string, role string) (bool, error) {
+	for i := range m.users {
+		if m.users[i].Username == username {
+			m.users[i].Role = role
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type mockSessionRepository struct {
+	sessions []models.SessionModel
+}
+
+func (m *mockSessionRepository) AddSession(newSession models.SessionModel) error {
+	m.sessions = append(m.sessions, newSession)
+	return nil
+}
+
+func (m *mockSessionRepository) GetSession(tokenHash string) (*models.SessionModel, error) {
+	for i := range m.sessions {
+		if m.sessions[i].TokenHash == tokenHash && m.sessions[i].ExpiresAt.After(time.Now()) {
+			session := m.sessions[i]
+			return &session, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockSessionRepository) DeleteSession(tokenHash string) (bool, error) {
+	for i := range m.sessions {
+		if m.sessions[i].TokenHash == tokenHash {
+			m.sessions = append(m.sessions[:i], m.sessions[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type mockAPIKeyRepository struct {
+	apiKeys []models.APIKeyModel
+}
+
+func (m *mockAPIKeyRepository) AddAPIKey(newAPIKey models.APIKeyModel) (string, error) {
+	m.apiKeys = append(m.apiKeys, newAPIKey)
+	return newAPIKey.ID.Hex(), nil
+}
+
+func (m *mockAPIKeyRepository) GetAPIKeyByHash(keyHash string) (*models.APIKeyModel, error) {
+	for i := range m.apiKeys {
+		if m.apiKeys[i].KeyHash == keyHash && m.apiKeys[i].RevokedAt == nil {
+			apiKey := m.apiKeys[i]
+			return &apiKey, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockAPIKeyRepository) GetAPIKeys() ([]models.APIKeyModel, error) {
+	return m.apiKeys, nil
+}
+
+func (m *mockAPIKeyRepository) RevokeAPIKey(id string) (bool, error) {
+	for i := range m.apiKeys {
+		if m.apiKeys[i].ID.Hex() == id && m.apiKeys[i].RevokedAt == nil {
+			now := time.Now()
+			m.apiKeys[i].RevokedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type mockAuditRepository struct {
+	entries []models.AuditModel
+}
+
+func (m *mockAuditRepository) AddAuditEntry(newEntry models.AuditModel) error {
+	m.entries = append(m.entries, newEntry)
+	return nil
+}
+
+func (m *mockAuditRepository) GetAuditEntries(filter repositories.AuditFilter, limit int) ([]models.AuditModel, error) {
+	var entries []models.AuditModel
+	// Newest first, like the sort on _id
+	for i := len(m.entries) - 1; i >= 0; i-- {
+		entry := m.entries[i]
+		if filter.ActorID != "" && entry.ActorID != filter.ActorID {
+			continue
+		}
+		if filter.Operation != "" && entry.Operation != filter.Operation {
+			continue
+		}
+		if filter.TargetID != "" && entry.TargetID != filter.TargetID {
+			continue
+		}
+		if filter.Since != nil && entry.CreatedAt.Before(*filter.Since) {
+			continue
+		}
+		if filter.Until != nil && !entry.CreatedAt.Before(*filter.Until) {
+			continue
+		}
+		if filter.BeforeID != "" && entry.ID.Hex() >= filter.BeforeID {
+			continue
+		}
+		entries = append(entries, entry)
+		if len(entries) == limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+type mockWebhookRepository struct {
+	webhooks   []models.WebhookModel
+	deliveries []models.WebhookDeliveryModel
+}
+
+func (m *mockWebhookRepository) AddWebhook(newWebhook models.WebhookModel) (string, error) {
+	m.webhooks = append(m.webhooks, newWebhook)
+	return newWebhook.ID.Hex(), nil
+}
+
+func (m *mockWebhookRepository) GetWebhooks(owner string) ([]models.WebhookModel, error) {
+	var webhooks []models.WebhookModel
+	for _, webhook := range m.webhooks {
+		if webhook.Owner == owner {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (m *mockWebhookRepository) GetAllWebhooks() ([]models.WebhookModel, error) {
+	return m.webhooks, nil
+}
+
+func (m *mockWebhookRepository) DeleteWebhook(id string, owner string) (bool, error) {
+	for i, webhook := range m.webhooks {
+		if webhook.ID.Hex() == id && webhook.Owner == owner {
+			m.webhooks = append(m.webhooks[:i], m.webhooks[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockWebhookRepository) AddDelivery(newDelivery models.WebhookDeliveryModel) error {
+	m.deliveries = append(m.deliveries, newDelivery)
+	return nil
+}
+
+func (m *mockWebhookRepository) GetDeliveries(webhookID string, limit int) ([]models.WebhookDeliveryModel, error) {
+	var deliveries []models.WebhookDeliveryModel
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, m.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
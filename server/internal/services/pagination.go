@@ -0,0 +1,159 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"alvinlucillo/swapi-app/internal/models"
+	"alvinlucillo/swapi-app/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Page size of saved searches if none is given, and the largest page allowed
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+)
+
+// searchCursor is what a cursor of a saved search encodes
+// The sort is kept so a cursor can't be used with another sort order
+type searchCursor struct {
+	Sort       SearchSort `json:"s"`
+	Descending bool       `json:"d,omitempty"`
+	Value      string     `json:"v,omitempty"`
+	ID         string     `json:"id"`
+}
+
+// GetSavedSearchConnection - Gets a page of the saved searches of a user, filtered and sorted
+// Searches are sorted by creation time, newest first, unless another sort is given;
+// the other sorts are ascending unless Descending is set.
+func (c *CharacterServiceImpl) GetSavedSearchConnection(userID string, args SearchConnectionArgs) (*SearchConnection, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if userID == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	first := args.First
+	if first <= 0 {
+		first = defaultSearchPageSize
+	}
+	if first > maxSearchPageSize {
+		return nil, invalidArgument("first can't be more than %d", maxSearchPageSize)
+	}
+
+	sort := args.Sort
+	if sort == "" {
+		sort = SearchSortCreated
+	}
+	repoSort, ok := searchSorts[sort]
+	if !ok {
+		return nil, invalidArgument("unknown sort %q", sort)
+	}
+
+	descending := sort == SearchSortCreated
+	if args.Descending != nil {
+		descending = *args.Descending
+	}
+
+	query := repositories.SearchPageQuery{
+		Filter: repositories.SearchFilter{
+			Owner:             userID,
+			Tag:               strings.TrimSpace(args.Tag),
+			SearchKeyContains: strings.TrimSpace(args.SearchKey),
+			CreatedAfter:      args.CreatedAfter,
+			CreatedBefore:     args.CreatedBefore,
+		},
+		Sort:       repoSort,
+		Descending: descending,
+		// Fetch one more search than asked to know if there's a next page
+		Limit: first + 1,
+	}
+
+	if args.After != "" {
+		cursor, err := decodeSearchCursor(args.After)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Sort != sort || cursor.Descending != descending {
+			return nil, invalidArgument("cursor %q is for a different sort order", args.After)
+		}
+		objectID, err := primitive.ObjectIDFromHex(cursor.ID)
+		if err != nil {
+			return nil, invalidArgument("invalid cursor %q", args.After)
+		}
+		query.After = &repositories.SearchCursor{Value: cursor.Value, ID: objectID}
+	}
+
+	searches, total, err := repo.SearchRepository.GetSearchPage(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get searches: %w", c.checkStorage(err))
+	}
+
+	connection := &SearchConnection{
+		Edges:      []SearchEdge{},
+		TotalCount: int(total),
+		// Only forward pagination is supported, so there's a previous page whenever the page starts after a cursor
+		PageInfo: PageInfo{HasPreviousPage: args.After != ""},
+	}
+	if len(searches) > first {
+		connection.PageInfo.HasNextPage = true
+		searches = searches[:first]
+	}
+
+	for _, search := range searches {
+		connection.Edges = append(connection.Edges, SearchEdge{
+			Cursor: encodeSearchCursor(search, sort, descending),
+			Node:   toSearch(search),
+		})
+	}
+
+	if len(connection.Edges) > 0 {
+		connection.PageInfo.StartCursor = connection.Edges[0].Cursor
+		connection.PageInfo.EndCursor = connection.Edges[len(connection.Edges)-1].Cursor
+	}
+
+	return connection, nil
+}
+
+// searchSorts maps the sorts of the API to the sorts of the repository
+var searchSorts = map[SearchSort]repositories.SearchSort{
+	SearchSortCreated:   repositories.SearchSortCreated,
+	SearchSortTitle:     repositories.SearchSortTitle,
+	SearchSortSearchKey: repositories.SearchSortSearchKey,
+}
+
+// encodeSearchCursor - Returns the opaque cursor of a search in the sort order
+func encodeSearchCursor(search models.SearchModel, sort SearchSort, descending bool) string {
+	cursor := searchCursor{Sort: sort, Descending: descending, ID: search.ID.Hex()}
+	switch sort {
+	case SearchSortTitle:
+		cursor.Value = search.Title
+	case SearchSortSearchKey:
+		cursor.Value = search.SearchKey
+	}
+
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// decodeSearchCursor - Decodes a cursor returned by encodeSearchCursor
+func decodeSearchCursor(cursor string) (*searchCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, invalidArgument("invalid cursor %q", cursor)
+	}
+
+	var result searchCursor
+	if err := json.Unmarshal(decoded, &result); err != nil {
+		return nil, invalidArgument("invalid cursor %q", cursor)
+	}
+
+	return &result, nil
+}
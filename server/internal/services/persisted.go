@@ -0,0 +1,405 @@
+package services
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/location"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/printer"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/graphql-go/handler"
+)
+
+// Codes of the errors of persisted queries; the first two are the ones Apollo clients react to
+const (
+	ErrCodePersistedQueryNotFound    = "PERSISTED_QUERY_NOT_FOUND"
+	ErrCodePersistedQueryUnsupported = "PERSISTED_QUERY_NOT_SUPPORTED"
+	ErrCodeBadPersistedQuery         = "BAD_PERSISTED_QUERY"
+	ErrCodeOperationNotAllowed       = "OPERATION_NOT_ALLOWED"
+)
+
+// Number of registered queries kept when no cache size is configured
+const defaultPersistedQueryCacheSize = 1000
+
+// PersistedQueryConfig configures persisted queries
+type PersistedQueryConfig struct {
+	// Path of the manifest of allowed operations; optional unless AllowlistOnly is set
+	ManifestPath string
+	// Only runs operations of the manifest, e.g. in production
+	AllowlistOnly bool
+	// Number of queries registered by clients kept in memory, least recently used first out
+	CacheSize int
+}
+
+// PersistedQueries implements automatic persisted queries: clients send the SHA-256 hash of a query
+// instead of the query, and when the server doesn't know the hash yet, send both so it's registered.
+// In allowlist mode only the operations of the manifest run, whether they're sent by hash or in full.
+//
+// Operations are matched against the manifest by their normalized document, printed without
+// __typename fields, so the whitespace of the client and the __typename fields Apollo adds don't matter.
+type PersistedQueries struct {
+	allowlistOnly bool
+	// manifest holds the queries of the manifest by their hash
+	manifest map[string]string
+	// allowed holds the normalized documents of the manifest operations
+	allowed map[string]bool
+
+	mu       sync.Mutex
+	size     int
+	registry *list.List
+	entries  map[string]*list.Element
+}
+
+// persistedQuery is a query registered by a client
+type persistedQuery struct {
+	hash  string
+	query string
+}
+
+// persistedQueryManifest is the format written by @apollo/generate-persisted-query-manifest
+type persistedQueryManifest struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	Operations []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Type string `json:"type"`
+		Body string `json:"body"`
+	} `json:"operations"`
+}
+
+// PersistedQueryError is the error of a request whose persisted query can't be run
+type PersistedQueryError struct {
+	Code    string
+	Message string
+	// Status is the HTTP status of the response
+	Status int
+}
+
+func (e *PersistedQueryError) Error() string {
+	return e.Message
+}
+
+// FormattedError - Returns the GraphQL error with its code in the extensions
+func (e *PersistedQueryError) FormattedError() gqlerrors.FormattedError {
+	return gqlerrors.FormattedError{
+		Message:    e.Message,
+		Locations:  []location.SourceLocation{},
+		Extensions: map[string]interface{}{"code": e.Code},
+	}
+}
+
+// NewPersistedQueries - Returns persisted queries, loading the manifest if there is one
+// The manifest is either an Apollo persisted query manifest or an object of queries keyed by their hash
+func NewPersistedQueries(cfg PersistedQueryConfig) (*PersistedQueries, error) {
+	p := &PersistedQueries{
+		allowlistOnly: cfg.AllowlistOnly,
+		manifest:      map[string]string{},
+		allowed:       map[string]bool{},
+		size:          cfg.CacheSize,
+		registry:      list.New(),
+		entries:       map[string]*list.Element{},
+	}
+	if p.size <= 0 {
+		p.size = defaultPersistedQueryCacheSize
+	}
+
+	if cfg.ManifestPath == "" {
+		if cfg.AllowlistOnly {
+			return nil, errors.New("allowlist mode needs a persisted query manifest")
+		}
+		return p, nil
+	}
+
+	data, err := os.ReadFile(cfg.ManifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read persisted query manifest: %w", err)
+	}
+	queries, err := parsePersistedQueryManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse persisted query manifest: %w", err)
+	}
+
+	for hash, query := range queries {
+		if queryHash(query) != hash {
+			return nil, fmt.Errorf("persisted query manifest: %s is not the hash of its query", hash)
+		}
+		document, err := normalizeQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("persisted query manifest: query %s is invalid: %w", hash, err)
+		}
+		p.manifest[hash] = query
+		p.allowed[document] = true
+	}
+
+	return p, nil
+}
+
+// parsePersistedQueryManifest - Returns the queries of a manifest keyed by their hash
+func parsePersistedQueryManifest(data []byte) (map[string]string, error) {
+	var manifest persistedQueryManifest
+	if err := json.Unmarshal(data, &manifest); err == nil && manifest.Operations != nil {
+		queries := map[string]string{}
+		for _, operation := range manifest.Operations {
+			queries[strings.ToLower(operation.ID)] = operation.Body
+		}
+		return queries, nil
+	}
+
+	var queries map[string]string
+	if err := json.Unmarshal(data, &queries); err != nil {
+		return nil, err
+	}
+	lowered := map[string]string{}
+	for hash, query := range queries {
+		lowered[strings.ToLower(hash)] = query
+	}
+	return lowered, nil
+}
+
+// Resolve - Returns the query a request runs, given its query and extensions
+// Requests without a persisted query extension run their query, which must be allowed in allowlist mode.
+// Requests with one run the query of the hash, registering the query they send if they send one.
+func (p *PersistedQueries) Resolve(query string, extensions map[string]interface{}) (string, *PersistedQueryError) {
+	persisted, ok := extensions["persistedQuery"].(map[string]interface{})
+	if !ok {
+		if query != "" && !p.isAllowed(query) {
+			return "", errOperationNotAllowed()
+		}
+		return query, nil
+	}
+
+	if version, _ := persisted["version"].(float64); version != 1 {
+		return "", &PersistedQueryError{
+			Code:    ErrCodePersistedQueryUnsupported,
+			Message: "Unsupported persisted query version",
+			Status:  http.StatusBadRequest,
+		}
+	}
+	hash, _ := persisted["sha256Hash"].(string)
+	hash = strings.ToLower(hash)
+	if hash == "" {
+		return "", &PersistedQueryError{
+			Code:    ErrCodeBadPersistedQuery,
+			Message: "persisted query is missing its sha256Hash",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	if query == "" {
+		if known, ok := p.lookup(hash); ok {
+			return known, nil
+		}
+		// Apollo clients send the query after this error, so it's a 200 like any GraphQL error
+		return "", &PersistedQueryError{
+			Code:    ErrCodePersistedQueryNotFound,
+			Message: "PersistedQueryNotFound",
+			Status:  http.StatusOK,
+		}
+	}
+
+	if queryHash(query) != hash {
+		return "", &PersistedQueryError{
+			Code:    ErrCodeBadPersistedQuery,
+			Message: "provided sha256Hash does not match query",
+			Status:  http.StatusBadRequest,
+		}
+	}
+	if !p.isAllowed(query) {
+		return "", errOperationNotAllowed()
+	}
+	p.register(hash, query)
+
+	return query, nil
+}
+
+// errOperationNotAllowed - Returns the error of operations that aren't in the manifest in allowlist mode
+func errOperationNotAllowed() *PersistedQueryError {
+	return &PersistedQueryError{
+		Code:    ErrCodeOperationNotAllowed,
+		Message: "operation is not in the allowlist",
+		Status:  http.StatusForbidden,
+	}
+}
+
+// isAllowed - Checks if a query may run; every query may unless in allowlist mode
+func (p *PersistedQueries) isAllowed(query string) bool {
+	if !p.allowlistOnly {
+		return true
+	}
+	document, err := normalizeQuery(query)
+	return err == nil && p.allowed[document]
+}
+
+// lookup - Returns the query of a hash from the manifest or the queries registered by clients
+func (p *PersistedQueries) lookup(hash string) (string, bool) {
+	if query, ok := p.manifest[hash]; ok {
+		return query, true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	element, ok := p.entries[hash]
+	if !ok {
+		return "", false
+	}
+	p.registry.MoveToFront(element)
+	return element.Value.(*persistedQuery).query, true
+}
+
+// register - Keeps a query sent by a client, dropping the least recently used one when full
+func (p *PersistedQueries) register(hash string, query string) {
+	if _, ok := p.manifest[hash]; ok {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if element, ok := p.entries[hash]; ok {
+		p.registry.MoveToFront(element)
+		return
+	}
+	p.entries[hash] = p.registry.PushFront(&persistedQuery{hash: hash, query: query})
+	for p.registry.Len() > p.size {
+		oldest := p.registry.Back()
+		p.registry.Remove(oldest)
+		delete(p.entries, oldest.Value.(*persistedQuery).hash)
+	}
+}
+
+// queryHash - Returns the hex SHA-256 hash of a query, as persisted query clients compute it
+func queryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeQuery - Returns the document printed without __typename fields
+func normalizeQuery(query string) (string, error) {
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(query)})})
+	if err != nil {
+		return "", err
+	}
+
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.OperationDefinition:
+			withoutTypename(definition.SelectionSet)
+		case *ast.FragmentDefinition:
+			withoutTypename(definition.SelectionSet)
+		}
+	}
+
+	printed, _ := printer.Print(document).(string)
+	return printed, nil
+}
+
+// withoutTypename - Removes the __typename fields of a selection set and the ones under it
+func withoutTypename(set *ast.SelectionSet) {
+	if set == nil {
+		return
+	}
+
+	selections := set.Selections[:0]
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.Name.Value == "__typename" {
+				continue
+			}
+			withoutTypename(selection.SelectionSet)
+		case *ast.InlineFragment:
+			withoutTypename(selection.SelectionSet)
+		}
+		selections = append(selections, selection)
+	}
+	set.Selections = selections
+}
+
+// NewPersistedQueryMiddleware - Returns a middleware that resolves persisted queries before the handler
+// runs them, rewriting requests sent by hash into requests with the query
+func NewPersistedQueryMiddleware(p *PersistedQueries) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body []byte
+			if r.Body != nil {
+				var err error
+				body, err = io.ReadAll(r.Body)
+				r.Body.Close()
+				if err != nil {
+					writeBodyError(w, err)
+					return
+				}
+			}
+			parsed := r.Clone(r.Context())
+			parsed.Body = io.NopCloser(bytes.NewReader(body))
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			options := handler.NewRequestOptions(parsed)
+			extensions := requestExtensions(r, body)
+
+			query, err := p.Resolve(options.Query, extensions)
+			if err != nil {
+				writePersistedQueryError(w, err)
+				return
+			}
+
+			if query != options.Query {
+				if r.Method == http.MethodGet {
+					values := r.URL.Query()
+					values.Set("query", query)
+					r.URL.RawQuery = values.Encode()
+				} else {
+					rewritten, _ := json.Marshal(handler.RequestOptions{
+						Query:         query,
+						Variables:     options.Variables,
+						OperationName: options.OperationName,
+					})
+					r.Body = io.NopCloser(bytes.NewReader(rewritten))
+					r.ContentLength = int64(len(rewritten))
+					r.Header.Set("Content-Type", handler.ContentTypeJSON)
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestExtensions - Returns the extensions of a GraphQL request, sent in the URL or the JSON body
+func requestExtensions(r *http.Request, body []byte) map[string]interface{} {
+	var extensions map[string]interface{}
+	if value := r.URL.Query().Get("extensions"); value != "" {
+		_ = json.Unmarshal([]byte(value), &extensions)
+		return extensions
+	}
+
+	var request struct {
+		Extensions map[string]interface{} `json:"extensions"`
+	}
+	if json.Unmarshal(body, &request) == nil {
+		return request.Extensions
+	}
+	return nil
+}
+
+// writePersistedQueryError - Writes the GraphQL response of a request whose persisted query can't run
+func writePersistedQueryError(w http.ResponseWriter, err *PersistedQueryError) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(err.Status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []gqlerrors.FormattedError{err.FormattedError()},
+	})
+}
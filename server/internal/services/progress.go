@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// SearchCharactersWithProgress - Searches characters like GetCharacters but hydrates them one at a time,
+// calling progress with each character and lastly with an event carrying the search ID.
+// Stops early, without recording the search, when the context is done or progress returns an error.
+func (c *CharacterServiceImpl) SearchCharactersWithProgress(ctx context.Context, userID string, name string, progress func(SearchProgress) error) error {
+	peopleResult, err := c.swapiClient.QueryPeople(name)
+	if err != nil {
+		return fmt.Errorf("failed to query people: %w", err)
+	}
+
+	total := len(peopleResult)
+	characters := []Character{}
+	for _, person := range peopleResult {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		character, err := c.hydratePerson(person)
+		if err != nil {
+			return err
+		}
+		characters = append(characters, character)
+
+		if err := progress(SearchProgress{Character: &character, Count: len(characters), Total: total}); err != nil {
+			return err
+		}
+	}
+
+	done := SearchProgress{Count: len(characters), Total: total, Done: true}
+	if userID != "" && total > 0 {
+		repo, err := c.repo()
+		if err == nil {
+			done.SearchID, err = addSearch(repo, userID, name, characters)
+		}
+		if err := c.checkStorage(err); err != nil && !errors.Is(err, ErrStorageUnavailable) {
+			return err
+		}
+	}
+
+	return progress(done)
+}
+
+// hydratePerson - Builds a character using the database as a cache, or straight from the SWAPI while it's unavailable
+func (c *CharacterServiceImpl) hydratePerson(person PeopleResult) (Character, error) {
+	repo, err := c.repo()
+	if err == nil {
+		var characters []Character
+		characters, err = c.cacheCharacters(repo, []PeopleResult{person})
+		if err == nil {
+			return characters[0], nil
+		}
+	}
+	if err := c.checkStorage(err); !errors.Is(err, ErrStorageUnavailable) {
+		return Character{}, err
+	}
+
+	characters, err := c.getLiveCharacters([]PeopleResult{person})
+	if err != nil {
+		return Character{}, err
+	}
+	return characters[0], nil
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/models"
+	"alvinlucillo/swapi-app/internal/repositories"
+)
+
+// RerunSearch - Runs the search key of a search against the SWAPI again and compares the result
+// with the stored one. The new result is kept as a pending re-run until it's accepted
+// with AcceptSearchRerun; the stored result is not changed.
+func (c *CharacterServiceImpl) RerunSearch(userID string, searchID string) (*SearchDiff, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	search, err := c.getOwnedSearch(repo, userID, searchID)
+	if err != nil {
+		return nil, err
+	}
+
+	if search == nil {
+		return nil, notFound("search %s not found", searchID)
+	}
+
+	diff, result, err := c.rerunSearch(repo, *search)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := repo.SearchRepository.SetPendingRerun(searchID, *result); err != nil {
+		return nil, fmt.Errorf("failed to store re-run: %w", c.checkStorage(err))
+	}
+
+	return diff, nil
+}
+
+// rerunSearch - Re-runs a search and compares the result with the stored result
+// Nothing is stored; the caller decides where the new result is kept
+func (c *CharacterServiceImpl) rerunSearch(repo *repositories.Repository, search models.SearchModel) (*SearchDiff, *models.SearchSnapshot, error) {
+	searchID := search.ID.Hex()
+
+	comparedTo := SearchViewSnapshot
+	var previous []Character
+	var err error
+	if search.Snapshot != nil {
+		previous = fromSnapshot(*search.Snapshot)
+	} else {
+		comparedTo = SearchViewLive
+		previous, err = c.hydrateCharacters(repo, search.Characters)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The SWAPI is queried directly so changes upstream aren't hidden by the cache
+	peopleResult, err := c.swapiClient.QueryPeople(search.SearchKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query people: %w", err)
+	}
+
+	current, err := c.getLiveCharacters(peopleResult)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ranAt := time.Now()
+	diff := diffCharacters(previous, current)
+	diff.SearchID = searchID
+	diff.SearchKey = search.SearchKey
+	diff.ComparedTo = comparedTo
+	diff.RanAt = ranAt
+
+	return &diff, toSnapshot(current, ranAt), nil
+}
+
+// AcceptSearchRerun - Makes the result of the last re-run the saved version of the search
+// Returns false if the search has no pending re-run
+func (c *CharacterServiceImpl) AcceptSearchRerun(userID string, searchID string) (bool, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return false, err
+	}
+
+	if search, err := c.getOwnedSearch(repo, userID, searchID); err != nil || search == nil {
+		return false, err
+	}
+
+	result, err := repo.SearchRepository.AcceptPendingRerun(searchID)
+	if err != nil {
+		return false, fmt.Errorf("failed to accept re-run: %w", c.checkStorage(err))
+	}
+
+	return result, nil
+}
+
+// diffCharacters - Compares two results by character ID
+// Added and changed characters follow the order of the current result, removed ones the previous result
+func diffCharacters(previous []Character, current []Character) SearchDiff {
+	previousMap := map[string]Character{}
+	for _, character := range previous {
+		previousMap[character.ID] = character
+	}
+
+	currentMap := map[string]Character{}
+	for _, character := range current {
+		currentMap[character.ID] = character
+	}
+
+	var diff SearchDiff
+	for _, character := range current {
+		old, ok := previousMap[character.ID]
+		if !ok {
+			diff.Added = append(diff.Added, character)
+			continue
+		}
+
+		characterDiff := CharacterDiff{
+			ID:              character.ID,
+			Name:            character.Name,
+			FilmsAdded:      difference(character.Films, old.Films),
+			FilmsRemoved:    difference(old.Films, character.Films),
+			VehiclesAdded:   difference(character.VehicleModels, old.VehicleModels),
+			VehiclesRemoved: difference(old.VehicleModels, character.VehicleModels),
+		}
+		if len(characterDiff.FilmsAdded) > 0 || len(characterDiff.FilmsRemoved) > 0 ||
+			len(characterDiff.VehiclesAdded) > 0 || len(characterDiff.VehiclesRemoved) > 0 {
+			diff.Changed = append(diff.Changed, characterDiff)
+		}
+	}
+
+	for _, character := range previous {
+		if _, ok := currentMap[character.ID]; !ok {
+			diff.Removed = append(diff.Removed, character)
+		}
+	}
+
+	diff.HasChanges = len(diff.Added) > 0 || len(diff.Removed) > 0 || len(diff.Changed) > 0
+
+	return diff
+}
+
+// difference - Returns the values of a that are not in b, without duplicates
+func difference(a []string, b []string) []string {
+	exclude := map[string]bool{}
+	for _, value := range b {
+		exclude[value] = true
+	}
+
+	var result []string
+	for _, value := range a {
+		if exclude[value] {
+			continue
+		}
+		exclude[value] = true
+		result = append(result, value)
+	}
+
+	return result
+}
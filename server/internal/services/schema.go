@@ -0,0 +1,504 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// Scalars every GraphQL server has, which the SDL leaves out
+var specifiedScalars = map[string]bool{
+	"String":  true,
+	"Int":     true,
+	"Float":   true,
+	"Boolean": true,
+	"ID":      true,
+}
+
+// PrintSchema - Returns the schema in the GraphQL schema definition language (SDL)
+// Types, fields, arguments and enum values are sorted by name so the output only changes with the schema.
+func PrintSchema(schema *graphql.Schema) string {
+	return printSchema(schema, nil)
+}
+
+// printSchema - Returns the schema in SDL, with the @key directives of the given types, e.g. Character: "id"
+func printSchema(schema *graphql.Schema, keys map[string]string) string {
+	var sdl strings.Builder
+
+	sdl.WriteString("schema {\n")
+	sdl.WriteString("  query: " + schema.QueryType().Name() + "\n")
+	if mutation := schema.MutationType(); mutation != nil {
+		sdl.WriteString("  mutation: " + mutation.Name() + "\n")
+	}
+	if subscription := schema.SubscriptionType(); subscription != nil {
+		sdl.WriteString("  subscription: " + subscription.Name() + "\n")
+	}
+	sdl.WriteString("}\n")
+
+	names := make([]string, 0, len(schema.TypeMap()))
+	for name := range schema.TypeMap() {
+		if strings.HasPrefix(name, "__") || specifiedScalars[name] {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		sdl.WriteString("\n")
+		switch t := schema.Type(name).(type) {
+		case *graphql.Scalar:
+			printDescription(&sdl, "", t.Description())
+			sdl.WriteString("scalar " + t.Name() + "\n")
+		case *graphql.Object:
+			printDescription(&sdl, "", t.Description())
+			sdl.WriteString("type " + t.Name())
+			if len(t.Interfaces()) > 0 {
+				interfaces := make([]string, len(t.Interfaces()))
+				for i, iface := range t.Interfaces() {
+					interfaces[i] = iface.Name()
+				}
+				sdl.WriteString(" implements " + strings.Join(interfaces, " & "))
+			}
+			if key, ok := keys[t.Name()]; ok {
+				sdl.WriteString(" @key(fields: " + printString(key) + ")")
+			}
+			printFields(&sdl, t.Fields())
+		case *graphql.Interface:
+			printDescription(&sdl, "", t.Description())
+			sdl.WriteString("interface " + t.Name())
+			printFields(&sdl, t.Fields())
+		case *graphql.Union:
+			printDescription(&sdl, "", t.Description())
+			members := make([]string, len(t.Types()))
+			for i, member := range t.Types() {
+				members[i] = member.Name()
+			}
+			sdl.WriteString("union " + t.Name() + " = " + strings.Join(members, " | ") + "\n")
+		case *graphql.Enum:
+			printDescription(&sdl, "", t.Description())
+			sdl.WriteString("enum " + t.Name() + " {\n")
+			values := append([]*graphql.EnumValueDefinition{}, t.Values()...)
+			sort.Slice(values, func(i, j int) bool { return values[i].Name < values[j].Name })
+			for _, value := range values {
+				printDescription(&sdl, "  ", value.Description)
+				sdl.WriteString("  " + value.Name + printDeprecation(value.DeprecationReason) + "\n")
+			}
+			sdl.WriteString("}\n")
+		case *graphql.InputObject:
+			printDescription(&sdl, "", t.Description())
+			sdl.WriteString("input " + t.Name() + " {\n")
+			fieldNames := make([]string, 0, len(t.Fields()))
+			for fieldName := range t.Fields() {
+				fieldNames = append(fieldNames, fieldName)
+			}
+			sort.Strings(fieldNames)
+			for _, fieldName := range fieldNames {
+				field := t.Fields()[fieldName]
+				printDescription(&sdl, "  ", field.Description())
+				sdl.WriteString("  " + printInputValue(field.Name(), field.Type, field.DefaultValue) + "\n")
+			}
+			sdl.WriteString("}\n")
+		}
+	}
+
+	return sdl.String()
+}
+
+// printFields - Writes the fields of an object or interface, starting with the opening brace
+func printFields(sdl *strings.Builder, fields graphql.FieldDefinitionMap) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sdl.WriteString(" {\n")
+	for _, name := range names {
+		field := fields[name]
+		printDescription(sdl, "  ", field.Description)
+		sdl.WriteString("  " + field.Name)
+
+		args := append([]*graphql.Argument{}, field.Args...)
+		sort.Slice(args, func(i, j int) bool { return args[i].Name() < args[j].Name() })
+		// Arguments go on their own lines when they have descriptions, like graphql-js prints them
+		described := false
+		for _, arg := range args {
+			described = described || arg.Description() != ""
+		}
+		if len(args) > 0 {
+			sdl.WriteString("(")
+			for i, arg := range args {
+				if described {
+					sdl.WriteString("\n")
+					printDescription(sdl, "    ", arg.Description())
+					sdl.WriteString("    ")
+				} else if i > 0 {
+					sdl.WriteString(", ")
+				}
+				sdl.WriteString(printInputValue(arg.Name(), arg.Type, arg.DefaultValue))
+			}
+			if described {
+				sdl.WriteString("\n  ")
+			}
+			sdl.WriteString(")")
+		}
+
+		sdl.WriteString(": " + field.Type.String() + printDeprecation(field.DeprecationReason) + "\n")
+	}
+	sdl.WriteString("}\n")
+}
+
+// printInputValue - Returns an argument or input field with its type and default value
+func printInputValue(name string, ttype graphql.Input, defaultValue interface{}) string {
+	if defaultValue == nil {
+		return name + ": " + ttype.String()
+	}
+	return name + ": " + ttype.String() + " = " + printValue(defaultValue, ttype)
+}
+
+// printValue - Returns a default value as a GraphQL literal
+func printValue(value interface{}, ttype graphql.Type) string {
+	if nonNull, ok := ttype.(*graphql.NonNull); ok {
+		ttype = nonNull.OfType
+	}
+	if enum, ok := ttype.(*graphql.Enum); ok {
+		if name, ok := enum.Serialize(value).(string); ok {
+			return name
+		}
+	}
+	if s, ok := value.(string); ok {
+		return printString(s)
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// printString - Returns a string literal; JSON escapes are valid GraphQL escapes
+func printString(s string) string {
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(s)
+	return strings.TrimSuffix(encoded.String(), "\n")
+}
+
+// printDescription - Writes a description on the line above what it describes, as a block string if it has several lines
+func printDescription(sdl *strings.Builder, indent string, description string) {
+	if description == "" {
+		return
+	}
+	if !strings.Contains(description, "\n") {
+		sdl.WriteString(indent + printString(description) + "\n")
+		return
+	}
+
+	sdl.WriteString(indent + `"""` + "\n")
+	for _, line := range strings.Split(description, "\n") {
+		if line == "" {
+			sdl.WriteString("\n")
+			continue
+		}
+		sdl.WriteString(indent + strings.ReplaceAll(line, `"""`, `\"""`) + "\n")
+	}
+	sdl.WriteString(indent + `"""` + "\n")
+}
+
+// printDeprecation - Returns the @deprecated directive of a deprecated field or enum value
+func printDeprecation(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return " @deprecated(reason: " + printString(reason) + ")"
+}
+
+// NewSchemaHandler - Returns the handler serving the schema in SDL, e.g. for code generators
+func NewSchemaHandler(svc CharacterService) http.Handler {
+	schema, _ := NewSchema(svc)
+	sdl := PrintSchema(&schema)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(sdl))
+	})
+}
+
+// BreakingChange is a change of the schema that can break clients written against the previous one
+type BreakingChange struct {
+	// Coordinate of what changed, e.g. Character.name or CharacterQuery.film(id:)
+	Path string
+	// What changed, e.g. "field was removed"
+	Reason string
+}
+
+func (c BreakingChange) String() string {
+	return c.Path + ": " + c.Reason
+}
+
+// sdlType is a type of an SDL document, reduced to what clients depend on
+type sdlType struct {
+	kind string
+	// Fields of objects, interfaces and input objects
+	fields map[string]sdlField
+	// Interfaces of objects, members of unions and values of enums
+	members map[string]bool
+}
+
+// sdlField is a field, argument or input field
+type sdlField struct {
+	ttype      ast.Type
+	hasDefault bool
+	args       map[string]sdlField
+}
+
+// FindBreakingChanges - Compares two schemas in SDL and returns the changes of the new one that can break clients:
+// removed types, fields, arguments, enum values and union members, changed types of fields and arguments,
+// and new required arguments and input fields. Additions that are safe, e.g. new fields, aren't returned.
+func FindBreakingChanges(oldSDL string, newSDL string) ([]BreakingChange, error) {
+	oldRoots, oldTypes, err := parseSDL(oldSDL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the previous schema: %w", err)
+	}
+	newRoots, newTypes, err := parseSDL(newSDL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the new schema: %w", err)
+	}
+
+	changes := []BreakingChange{}
+	add := func(path string, format string, args ...interface{}) {
+		changes = append(changes, BreakingChange{Path: path, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	for _, operation := range sortedKeys(oldRoots) {
+		switch newRoot, ok := newRoots[operation]; {
+		case !ok:
+			add("schema."+operation, "root type was removed")
+		case newRoot != oldRoots[operation]:
+			add("schema."+operation, "root type changed from %s to %s", oldRoots[operation], newRoot)
+		}
+	}
+
+	for _, name := range sortedKeys(oldTypes) {
+		oldType := oldTypes[name]
+		newType, ok := newTypes[name]
+		if !ok {
+			add(name, "%s was removed", oldType.kind)
+			continue
+		}
+		if newType.kind != oldType.kind {
+			add(name, "changed from %s to %s", oldType.kind, newType.kind)
+			continue
+		}
+
+		for _, member := range sortedKeys(oldType.members) {
+			if !newType.members[member] {
+				switch oldType.kind {
+				case "enum":
+					add(name+"."+member, "enum value was removed")
+				case "union":
+					add(name, "member %s was removed", member)
+				default:
+					add(name, "no longer implements %s", member)
+				}
+			}
+		}
+
+		input := oldType.kind == "input object"
+		for _, fieldName := range sortedKeys(oldType.fields) {
+			oldField := oldType.fields[fieldName]
+			path := name + "." + fieldName
+			newField, ok := newType.fields[fieldName]
+			if !ok {
+				add(path, "field was removed")
+				continue
+			}
+			if input && !isSafeInputChange(oldField.ttype, newField.ttype) || !input && !isSafeOutputChange(oldField.ttype, newField.ttype) {
+				add(path, "type changed from %s to %s", printType(oldField.ttype), printType(newField.ttype))
+			}
+
+			for _, argName := range sortedKeys(oldField.args) {
+				argPath := path + "(" + argName + ":)"
+				newArg, ok := newField.args[argName]
+				if !ok {
+					add(argPath, "argument was removed")
+					continue
+				}
+				if !isSafeInputChange(oldField.args[argName].ttype, newArg.ttype) {
+					add(argPath, "type changed from %s to %s", printType(oldField.args[argName].ttype), printType(newArg.ttype))
+				}
+			}
+			for _, argName := range sortedKeys(newField.args) {
+				if _, ok := oldField.args[argName]; !ok && isRequired(newField.args[argName]) {
+					add(path+"("+argName+":)", "required argument was added")
+				}
+			}
+		}
+
+		if input {
+			for _, fieldName := range sortedKeys(newType.fields) {
+				if _, ok := oldType.fields[fieldName]; !ok && isRequired(newType.fields[fieldName]) {
+					add(name+"."+fieldName, "required input field was added")
+				}
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// parseSDL - Parses an SDL document into its root types, keyed by operation, and its types
+func parseSDL(sdl string) (map[string]string, map[string]sdlType, error) {
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(sdl), Name: "GraphQL schema"})})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Root types are named after the operation unless a schema definition says otherwise
+	roots := map[string]string{"query": "Query", "mutation": "Mutation", "subscription": "Subscription"}
+	types := map[string]sdlType{}
+	for _, definition := range document.Definitions {
+		switch d := definition.(type) {
+		case *ast.SchemaDefinition:
+			roots = map[string]string{}
+			for _, operationType := range d.OperationTypes {
+				roots[operationType.Operation] = operationType.Type.Name.Value
+			}
+		case *ast.ScalarDefinition:
+			types[d.Name.Value] = sdlType{kind: "scalar"}
+		case *ast.ObjectDefinition:
+			t := sdlType{kind: "object", fields: sdlFields(d.Fields), members: map[string]bool{}}
+			for _, iface := range d.Interfaces {
+				t.members[iface.Name.Value] = true
+			}
+			types[d.Name.Value] = t
+		case *ast.InterfaceDefinition:
+			types[d.Name.Value] = sdlType{kind: "interface", fields: sdlFields(d.Fields)}
+		case *ast.UnionDefinition:
+			t := sdlType{kind: "union", members: map[string]bool{}}
+			for _, member := range d.Types {
+				t.members[member.Name.Value] = true
+			}
+			types[d.Name.Value] = t
+		case *ast.EnumDefinition:
+			t := sdlType{kind: "enum", members: map[string]bool{}}
+			for _, value := range d.Values {
+				t.members[value.Name.Value] = true
+			}
+			types[d.Name.Value] = t
+		case *ast.InputObjectDefinition:
+			types[d.Name.Value] = sdlType{kind: "input object", fields: sdlInputValues(d.Fields)}
+		}
+	}
+
+	// Only keep the roots of the types the document has, e.g. no subscription if there's no Subscription type
+	for operation, name := range roots {
+		if _, ok := types[name]; !ok {
+			delete(roots, operation)
+		}
+	}
+
+	return roots, types, nil
+}
+
+// sdlFields - Returns the fields of an object or interface with their arguments
+func sdlFields(definitions []*ast.FieldDefinition) map[string]sdlField {
+	fields := map[string]sdlField{}
+	for _, definition := range definitions {
+		fields[definition.Name.Value] = sdlField{ttype: definition.Type, args: sdlInputValues(definition.Arguments)}
+	}
+	return fields
+}
+
+// sdlInputValues - Returns arguments or the fields of an input object
+func sdlInputValues(definitions []*ast.InputValueDefinition) map[string]sdlField {
+	values := map[string]sdlField{}
+	for _, definition := range definitions {
+		values[definition.Name.Value] = sdlField{ttype: definition.Type, hasDefault: definition.DefaultValue != nil}
+	}
+	return values
+}
+
+// isRequired - Tells if clients must give an argument or input field
+func isRequired(field sdlField) bool {
+	_, nonNull := field.ttype.(*ast.NonNull)
+	return nonNull && !field.hasDefault
+}
+
+// isSafeOutputChange - Tells if clients reading a field of the old type can read the new one,
+// which only allows the type to become non-null
+func isSafeOutputChange(oldType ast.Type, newType ast.Type) bool {
+	if newNonNull, ok := newType.(*ast.NonNull); ok {
+		if oldNonNull, ok := oldType.(*ast.NonNull); ok {
+			return isSafeOutputChange(oldNonNull.Type, newNonNull.Type)
+		}
+		return isSafeOutputChange(oldType, newNonNull.Type)
+	}
+
+	switch oldType := oldType.(type) {
+	case *ast.List:
+		newList, ok := newType.(*ast.List)
+		return ok && isSafeOutputChange(oldType.Type, newList.Type)
+	case *ast.Named:
+		newNamed, ok := newType.(*ast.Named)
+		return ok && oldType.Name.Value == newNamed.Name.Value
+	}
+	return false
+}
+
+// isSafeInputChange - Tells if clients sending values of the old type can still send them,
+// which only allows the type to become nullable
+func isSafeInputChange(oldType ast.Type, newType ast.Type) bool {
+	return isSafeOutputChange(newType, oldType)
+}
+
+// printType - Returns a type as written in SDL, e.g. [String!]
+func printType(ttype ast.Type) string {
+	switch t := ttype.(type) {
+	case *ast.NonNull:
+		return printType(t.Type) + "!"
+	case *ast.List:
+		return "[" + printType(t.Type) + "]"
+	case *ast.Named:
+		return t.Name.Value
+	}
+	return ""
+}
+
+// sortedKeys - Returns the keys of a map in order, so changes are reported in the same order every time
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]string:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]sdlType:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]sdlField:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]bool:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,20 +1,51 @@
 package services
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"alvinlucillo/swapi-app/internal/db"
 	"alvinlucillo/swapi-app/internal/models"
 	"alvinlucillo/swapi-app/internal/repositories"
 
 	"github.com/caarlos0/env/v10"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Config struct {
-	DBName             string `env:"DB_NAME" envDefault:"swapiapp"`
-	DBConnectionString string `env:"DB_CONNECTION_STRING" envDefault:"mongodb://localhost:27017/"`
-	DBDocumentTTL      int32  `env:"DB_DOCUMENT_TTL" envDefault:"43200"`
+	DBName                   string        `env:"DB_NAME" envDefault:"swapiapp"`
+	DBConnectionString       string        `env:"DB_CONNECTION_STRING" envDefault:"mongodb://localhost:27017/"`
+	DBDocumentTTL            int32         `env:"DB_DOCUMENT_TTL" envDefault:"43200"`
+	DBMaxPoolSize            uint64        `env:"DB_MAX_POOL_SIZE" envDefault:"100"`
+	DBMinPoolSize            uint64        `env:"DB_MIN_POOL_SIZE" envDefault:"0"`
+	DBMaxConnIdleTime        time.Duration `env:"DB_MAX_CONN_IDLE_TIME" envDefault:"5m"`
+	DBConnectTimeout         time.Duration `env:"DB_CONNECT_TIMEOUT" envDefault:"10s"`
+	DBServerSelectionTimeout time.Duration `env:"DB_SERVER_SELECTION_TIMEOUT" envDefault:"5s"`
+	DBOperationTimeout       time.Duration `env:"DB_OPERATION_TIMEOUT" envDefault:"10s"`
+	DBReadConcern            string        `env:"DB_READ_CONCERN" envDefault:""`
+	DBWriteConcern           string        `env:"DB_WRITE_CONCERN" envDefault:""`
+	DBConnectRetries         int           `env:"DB_CONNECT_RETRIES" envDefault:"10"`
+	DBConnectBackoff         time.Duration `env:"DB_CONNECT_BACKOFF" envDefault:"500ms"`
+	DBConnectMaxBackoff      time.Duration `env:"DB_CONNECT_MAX_BACKOFF" envDefault:"10s"`
+	DBHealthCheckInterval    time.Duration `env:"DB_HEALTH_CHECK_INTERVAL" envDefault:"10s"`
+	SearchTTL                time.Duration `env:"SEARCH_TTL" envDefault:"1h"`
+	SearchDeleteRetention    time.Duration `env:"SEARCH_DELETE_RETENTION" envDefault:"168h"`
+	SessionTTL               time.Duration `env:"SESSION_TTL" envDefault:"24h"`
+	AuditRetention           time.Duration `env:"AUDIT_RETENTION" envDefault:"2160h"`
+	WebhookWatchInterval     time.Duration `env:"WEBHOOK_WATCH_INTERVAL" envDefault:"1h"`
+	WebhookMaxAttempts       int           `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"3"`
+	WebhookRetryDelay        time.Duration `env:"WEBHOOK_RETRY_DELAY" envDefault:"5s"`
+	WebhookTimeout           time.Duration `env:"WEBHOOK_TIMEOUT" envDefault:"10s"`
+	WebhookDeliveryRetention time.Duration `env:"WEBHOOK_DELIVERY_RETENTION" envDefault:"720h"`
+	// Lets webhooks point to loopback, link-local and private addresses, e.g. to test them locally
+	WebhookAllowPrivateNetworks bool `env:"WEBHOOK_ALLOW_PRIVATE_NETWORKS" envDefault:"false"`
+	// Key used to sign share links; a random key is used if empty, which invalidates links on restart
+	ShareLinkSecret string `env:"SHARE_LINK_SECRET" envDefault:""`
 }
 
 func NewConfig() (*Config, error) {
@@ -26,15 +57,85 @@ func NewConfig() (*Config, error) {
 }
 
 type CharacterService interface {
-	GetCharacters(name string) ([]Character, string, error)
-	GetSavedSearches() ([]Search, error)
-	GetSavedSearchesByID(searchID string) ([]Character, error)
-	SaveSearch(searchID string) (bool, error)
+	GetCharacters(userID string, name string) ([]Character, string, error)
+	SearchCharactersWithProgress(ctx context.Context, userID string, name string, progress func(SearchProgress) error) error
+	GetSavedSearches(userID string, tag string) ([]Search, error)
+	GetSavedSearchConnection(userID string, args SearchConnectionArgs) (*SearchConnection, error)
+	GetFilm(id string) (*Film, error)
+	GetVehicle(id string) (*Vehicle, error)
+	ResourceURL(resource string, id string) (string, error)
+	GetFilms(filmIDs []string) ([]Film, error)
+	GetVehicles(vehicleIDs []string) ([]Vehicle, error)
+	GetCharactersByIDs(characterIDs []string) ([]Character, error)
+	GetCharacterFilms(character Character) ([]Film, error)
+	GetCharacterVehicles(character Character) ([]Vehicle, error)
+	GetSavedSearchesByID(userID string, searchID string, view SearchView) ([]Character, error)
+	SaveSearch(userID string, searchID string, expiry SearchExpiry) (bool, error)
+	ExtendSearch(userID string, searchID string, expiry SearchExpiry) (*Search, error)
+	GetSearchesExpiringSoon(userID string, within time.Duration) ([]Search, error)
+	UnsaveSearch(userID string, searchID string) (bool, error)
+	DeleteSearch(userID string, searchID string) (bool, error)
+	RestoreSearch(userID string, searchID string) (bool, error)
+	GetDeletedSearches(userID string) ([]Search, error)
+	UpdateSearch(userID string, searchID string, input SearchUpdateInput) (*Search, error)
+	RerunSearch(userID string, searchID string) (*SearchDiff, error)
+	AcceptSearchRerun(userID string, searchID string) (bool, error)
+	GetCollections(userID string) ([]Collection, error)
+	GetCollection(userID string, collectionID string) (*Collection, error)
+	GetCollectionCharacters(userID string, collectionID string) ([]Character, error)
+	CreateCollection(userID string, input CollectionInput) (*Collection, error)
+	UpdateCollection(userID string, collectionID string, input CollectionInput) (*Collection, error)
+	DeleteCollection(userID string, collectionID string) (bool, error)
+	AddSearchToCollection(userID string, collectionID string, searchID string) (*Collection, error)
+	RemoveSearchFromCollection(userID string, collectionID string, searchID string) (*Collection, error)
+	MoveCollection(userID string, collectionID string, position int) ([]Collection, error)
+	Register(username string, password string) (*AuthSession, error)
+	Login(username string, password string) (*AuthSession, error)
+	Logout(token string) (bool, error)
+	Authenticate(token string) (*User, error)
+	CreateAPIKey(username string, name string, role Role) (string, *APIKey, error)
+	GetAPIKeys() ([]APIKey, error)
+	RevokeAPIKey(apiKeyID string) (bool, error)
+	SetUserRole(username string, role Role) (bool, error)
+	CreateShareLink(userID string, searchID string, expiresAt *time.Time) (*ShareLink, error)
+	GetShareLinks(userID string, searchID string) ([]ShareLink, error)
+	RevokeShareLink(userID string, searchID string, linkID string) (bool, error)
+	GetSharedSearch(token string) (*SharedSearch, error)
+	GetAuditState(target AuditTarget) (interface{}, error)
+	RecordAudit(record AuditRecord) error
+	GetAuditLog(filter AuditFilter, first int, after string) (*AuditLogPage, error)
+	ExportSearches(userID string, searchID string) ([]SearchExport, error)
+	GetUser(username string) (*User, error)
+	ClaimOwnerlessSearches(username string) (int64, error)
+	RemoveEmptySearchTitles() (int64, error)
+	ExportBundle(userID string, searchID string) (*Bundle, error)
+	ImportSearches(userID string, bundle Bundle, conflict ImportConflict, dryRun bool) (*ImportReport, error)
+	CreateWebhook(userID string, url string, searchIDs []string) (*Webhook, error)
+	GetWebhooks(userID string) ([]Webhook, error)
+	DeleteWebhook(userID string, webhookID string) (bool, error)
+	GetWebhookDeliveries(userID string, webhookID string, limit int) ([]WebhookDelivery, error)
 }
 
 type CharacterServiceImpl struct {
 	swapiClient SWAPIQueryer
 	repository  *repositories.Repository
+	mongoDB     *db.MongoDB
+	repoConfig  repositories.Config
+
+	healthCheckInterval time.Duration
+	sessionTTL          time.Duration
+	shareLinkSecret     []byte
+
+	// Client and retry policy of webhook deliveries, and how often watched searches are re-run
+	webhookClient        *http.Client
+	webhookMaxAttempts   int
+	webhookRetryDelay    time.Duration
+	webhookWatchInterval time.Duration
+	webhookAllowPrivate  bool
+
+	// Guards repository and storageDown, which change when the database goes down or comes back
+	mu          sync.RWMutex
+	storageDown bool
 }
 
 func NewService(swapiClient SWAPIQueryer) (*CharacterServiceImpl, error) {
@@ -45,21 +146,91 @@ func NewService(swapiClient SWAPIQueryer) (*CharacterServiceImpl, error) {
 		return nil, err
 	}
 
-	db, err := db.NewMongoDB(cfg.DBConnectionString, cfg.DBName)
-	if err != nil {
+	mongoDB, err := db.NewMongoDB(db.Config{
+		ConnectionString:       cfg.DBConnectionString,
+		DBName:                 cfg.DBName,
+		MaxPoolSize:            cfg.DBMaxPoolSize,
+		MinPoolSize:            cfg.DBMinPoolSize,
+		MaxConnIdleTime:        cfg.DBMaxConnIdleTime,
+		ConnectTimeout:         cfg.DBConnectTimeout,
+		ServerSelectionTimeout: cfg.DBServerSelectionTimeout,
+		OperationTimeout:       cfg.DBOperationTimeout,
+		ReadConcern:            cfg.DBReadConcern,
+		WriteConcern:           cfg.DBWriteConcern,
+		ConnectRetries:         cfg.DBConnectRetries,
+		ConnectBackoff:         cfg.DBConnectBackoff,
+		ConnectMaxBackoff:      cfg.DBConnectMaxBackoff,
+	})
+	if err != nil && !errors.Is(err, db.ErrUnreachable) {
 		fmt.Printf("%+v\n", err)
 		return nil, err
 	}
 
-	repo, err := repositories.NewRepository(repositories.Config{DocumentTTL: cfg.DBDocumentTTL, DB: db.Database})
+	svc := &CharacterServiceImpl{
+		swapiClient: swapiClient,
+		mongoDB:     mongoDB,
+		repoConfig: repositories.Config{
+			DocumentTTL:              cfg.DBDocumentTTL,
+			SearchTTL:                cfg.SearchTTL,
+			SearchDeleteRetention:    cfg.SearchDeleteRetention,
+			AuditRetention:           cfg.AuditRetention,
+			WebhookDeliveryRetention: cfg.WebhookDeliveryRetention,
+			DB:                       mongoDB.Database,
+		},
+
+		healthCheckInterval: cfg.DBHealthCheckInterval,
+		sessionTTL:          cfg.SessionTTL,
+		shareLinkSecret:     []byte(cfg.ShareLinkSecret),
+
+		webhookClient:        newWebhookClient(cfg.WebhookTimeout, cfg.WebhookAllowPrivateNetworks),
+		webhookMaxAttempts:   cfg.WebhookMaxAttempts,
+		webhookRetryDelay:    cfg.WebhookRetryDelay,
+		webhookWatchInterval: cfg.WebhookWatchInterval,
+		webhookAllowPrivate:  cfg.WebhookAllowPrivateNetworks,
+	}
+
+	if len(svc.shareLinkSecret) == 0 {
+		fmt.Println("SHARE_LINK_SECRET is not set, share links will stop working when the server restarts")
+		svc.shareLinkSecret = make([]byte, 32)
+		if _, err := rand.Read(svc.shareLinkSecret); err != nil {
+			return nil, fmt.Errorf("failed to generate share link secret: %w", err)
+		}
+	}
+
+	// Start in degraded mode if the database is down; MonitorStorage brings it back
+	if err != nil {
+		fmt.Printf("starting without storage: %v\n", err)
+		svc.storageDown = true
+		return svc, nil
+	}
+
+	repo, err := repositories.NewRepository(svc.repoConfig)
 	if err != nil {
 		fmt.Printf("%+v\n", err)
+		_ = mongoDB.Disconnect(context.Background())
 		return nil, err
 	}
-	return &CharacterServiceImpl{
-		swapiClient: swapiClient,
-		repository:  repo,
-	}, nil
+	svc.repository = repo
+
+	return svc, nil
+}
+
+// Ready - Checks that the database can be reached
+func (c *CharacterServiceImpl) Ready(ctx context.Context) error {
+	if c.mongoDB == nil {
+		return fmt.Errorf("database is not configured")
+	}
+
+	return c.mongoDB.Ping(ctx)
+}
+
+// Close - Disconnects from the database
+func (c *CharacterServiceImpl) Close(ctx context.Context) error {
+	if c.mongoDB == nil {
+		return nil
+	}
+
+	return c.mongoDB.Disconnect(ctx)
 }
 
 // GetCharacters -
@@ -67,138 +238,338 @@ func NewService(swapiClient SWAPIQueryer) (*CharacterServiceImpl, error) {
 //  2. Adds the films and vehicles to the database if they don't already exist
 //  3. Adds the character to the database if it doesn't already exist
 //  4. Adds the search to the database for retrieval later
-func (c CharacterServiceImpl) GetCharacters(name string) ([]Character, string, error) {
+//
+// Anonymous users can browse, but the search is only recorded for logged in users, who own it.
+// If storage is unavailable, the characters are built straight from the SWAPI
+// without caching and no search ID is returned.
+func (c *CharacterServiceImpl) GetCharacters(userID string, name string) ([]Character, string, error) {
 	peopleResult, err := c.swapiClient.QueryPeople(name)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to query people: %w", err)
 	}
 
+	if len(peopleResult) == 0 {
+		return nil, "", nil
+	}
+
+	repo, err := c.repo()
+	if err != nil {
+		characters, err := c.getLiveCharacters(peopleResult)
+		return characters, "", err
+	}
+
 	var characters []Character
-	var characterIDs, filmIDs, vehicleIDs []string
-	for _, person := range peopleResult {
-		character := Character{
-			ID:   person.URL,
-			Name: person.Name,
+	var searchID string
+	if userID == "" {
+		characters, err = c.cacheCharacters(repo, peopleResult)
+	} else {
+		characters, searchID, err = c.getCachedCharacters(repo, userID, name, peopleResult)
+	}
+	if err != nil {
+		if err := c.checkStorage(err); errors.Is(err, ErrStorageUnavailable) {
+			characters, err := c.getLiveCharacters(peopleResult)
+			return characters, "", err
 		}
+		return nil, "", err
+	}
 
-		for _, film := range person.Films {
-			existingFilm, err := c.repository.FilmRepository.GetFilm(film)
-			if err != nil {
-				return nil, "", fmt.Errorf("failed to get film: %w", err)
-			}
-
-			var f models.FilmModel
-			if existingFilm != nil {
-				f = *existingFilm
-			} else {
-				filmResult, err := c.swapiClient.QueryFilm(film)
-				if err != nil {
-					return nil, "", fmt.Errorf("failed to query film: %w", err)
-				}
-
-				f = models.FilmModel{
-					Title: filmResult.Title,
-					ID:    filmResult.URL,
-				}
-				_, err = c.repository.FilmRepository.AddFilm(f)
-				if err != nil {
-					return nil, "", fmt.Errorf("failed to add film: %w", err)
-				}
-			}
-			filmIDs = append(filmIDs, film)
-			character.Films = append(character.Films, f.Title)
-		}
+	return characters, searchID, nil
+}
+
+// GetSavedSearches - Gets the saved searches of a user, optionally only those with the given tag
+func (c *CharacterServiceImpl) GetSavedSearches(userID string, tag string) ([]Search, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	searches, err := repo.SearchRepository.GetSearches(repositories.SearchFilter{Owner: userID, Tag: strings.TrimSpace(tag)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get searches: %w", c.checkStorage(err))
+	}
+
+	var searchResults []Search
+	for _, search := range searches {
+		searchResults = append(searchResults, toSearch(search))
+	}
+
+	return searchResults, nil
+}
 
-		for _, vehicle := range person.Vehicles {
-			existingVehicle, err := c.repository.VehicleRepository.GetVehicle(vehicle)
-			if err != nil {
-				return nil, "", fmt.Errorf("failed to get vehicle: %w", err)
-			}
-
-			var v models.VehicleModel
-			if existingVehicle != nil {
-				v = *existingVehicle
-			} else {
-				vehicleResult, err := c.swapiClient.QueryVehicle(vehicle)
-				if err != nil {
-					return nil, "", fmt.Errorf("failed to query vehicle: %w", err)
-				}
-
-				v = models.VehicleModel{
-					Model: vehicleResult.Model,
-					ID:    vehicleResult.URL,
-				}
-				_, err = c.repository.VehicleRepository.AddVehicle(v)
-				if err != nil {
-					return nil, "", fmt.Errorf("failed to add vehicle: %w", err)
-				}
-			}
-			vehicleIDs = append(vehicleIDs, vehicle)
-			character.VehicleModels = append(character.VehicleModels, v.Model)
+// GetDeletedSearches - Gets the deleted searches of a user that can still be restored
+func (c *CharacterServiceImpl) GetDeletedSearches(userID string) ([]Search, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	searches, err := repo.SearchRepository.GetDeletedSearches(repositories.SearchFilter{Owner: userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deleted searches: %w", c.checkStorage(err))
+	}
+
+	var searchResults []Search
+	for _, search := range searches {
+		searchResults = append(searchResults, toSearch(search))
+	}
+
+	return searchResults, nil
+}
+
+// toSearch - Converts a search model to the search result
+func toSearch(search models.SearchModel) Search {
+	result := Search{
+		ID:        search.ID.Hex(),
+		SearchKey: search.SearchKey,
+		Title:     search.Title,
+		Notes:     search.Notes,
+		Tags:      search.Tags,
+		Saved:     search.IsSaved(),
+		ExpiresAt: search.ExpiresAt,
+		DeletedAt: search.DeletedAt,
+		CreatedAt: search.ID.Timestamp(),
+	}
+	if search.Snapshot != nil {
+		result.SnapshotAt = &search.Snapshot.TakenAt
+	}
+	return result
+}
+
+// SaveSearch - Removes the expiration from a search so it's not marked for deletion
+// If an expiration or TTL is given, the search is saved until then instead.
+// The first time a search is saved, a snapshot of its hydrated result is stored with it.
+func (c *CharacterServiceImpl) SaveSearch(userID string, searchID string, expiry SearchExpiry) (bool, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return false, err
+	}
+
+	search, err := c.getOwnedSearch(repo, userID, searchID)
+	if err != nil {
+		return false, err
+	}
+
+	if search == nil {
+		return false, nil
+	}
+
+	// Take the snapshot before saving so a failed snapshot doesn't leave a saved search without one
+	var snapshot *models.SearchSnapshot
+	if search.Snapshot == nil {
+		snapshot, err = c.takeSnapshot(repo, search)
+		if err != nil {
+			return false, fmt.Errorf("failed to take snapshot: %w", err)
 		}
+	}
 
-		characterIDs = append(characterIDs, character.ID)
-		characters = append(characters, character)
+	var result bool
+	if expiry.IsZero() {
+		result, err = repo.SearchRepository.RemoveExpiration(searchID)
+		if err != nil {
+			return false, fmt.Errorf("failed to remove expiration: %w", c.checkStorage(err))
+		}
+	} else {
+		expiresAt, err := expiry.resolve(time.Now())
+		if err != nil {
+			return false, err
+		}
 
-		existingCharacter, error := c.repository.CharacterRepository.GetCharacter(person.URL)
-		if error != nil {
-			return nil, "", fmt.Errorf("failed to get character: %w", err)
+		result, err = repo.SearchRepository.PinSearch(searchID, expiresAt)
+		if err != nil {
+			return false, fmt.Errorf("failed to pin search: %w", c.checkStorage(err))
 		}
+	}
 
-		if existingCharacter == nil {
-			_, error := c.repository.CharacterRepository.AddCharacter(models.CharacterModel{
-				Name:     person.Name,
-				ID:       person.URL,
-				Films:    filmIDs,
-				Vehicles: vehicleIDs,
-			})
-			if error != nil {
-				return nil, "", fmt.Errorf("failed to add character: %w", err)
-			}
+	if snapshot != nil {
+		if _, err := repo.SearchRepository.SetSnapshot(searchID, *snapshot); err != nil {
+			return false, fmt.Errorf("failed to set snapshot: %w", c.checkStorage(err))
 		}
 	}
 
-	if len(peopleResult) == 0 {
-		return nil, "", nil
+	return result, nil
+}
+
+// ExtendSearch - Pushes back the expiration of a search
+// A TTL is added to the current expiration, while an expiration replaces it
+func (c *CharacterServiceImpl) ExtendSearch(userID string, searchID string, expiry SearchExpiry) (*Search, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
 	}
 
-	search := models.SearchModel{
-		ID:         primitive.NewObjectID(),
-		SearchKey:  name,
-		Characters: characterIDs,
+	if expiry.IsZero() {
+		return nil, invalidArgument("either expiresAt or ttl is required")
 	}
 
-	searchID, err := c.repository.SearchRepository.AddSearch(search)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to add search: %w", err)
+	search, err := c.getOwnedSearch(repo, userID, searchID)
+	if err != nil || search == nil {
+		return nil, err
 	}
 
-	return characters, searchID, nil
+	if expiry.ExpiresAt != nil {
+		expiresAt, err := expiry.resolve(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		search, err = repo.SearchRepository.ExtendExpiration(searchID, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extend expiration: %w", c.checkStorage(err))
+		}
+	} else {
+		if _, err := expiry.resolve(time.Now()); err != nil {
+			return nil, err
+		}
+		search, err = repo.SearchRepository.ExtendExpirationBy(searchID, expiry.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extend expiration: %w", c.checkStorage(err))
+		}
+	}
+
+	if search == nil {
+		return nil, nil
+	}
+
+	result := toSearch(*search)
+	return &result, nil
 }
 
-// GetSavedSearches - Gets saved searches from the database
-func (c CharacterServiceImpl) GetSavedSearches() ([]Search, error) {
-	searches, err := c.repository.SearchRepository.GetSearches()
+// GetSearchesExpiringSoon - Gets the searches that will expire within the given duration, soonest first
+func (c *CharacterServiceImpl) GetSearchesExpiringSoon(userID string, within time.Duration) ([]Search, error) {
+	repo, err := c.repo()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get searches: %w", err)
+		return nil, err
+	}
+
+	if within <= 0 {
+		return nil, invalidArgument("within must be positive")
+	}
+
+	searches, err := repo.SearchRepository.GetExpiringSearches(repositories.SearchFilter{Owner: userID}, time.Now().Add(within))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expiring searches: %w", c.checkStorage(err))
 	}
 
 	var searchResults []Search
 	for _, search := range searches {
-		searchResults = append(searchResults, Search{
-			ID:        search.ID.Hex(),
-			SearchKey: search.SearchKey,
-		})
+		searchResults = append(searchResults, toSearch(search))
 	}
 
 	return searchResults, nil
 }
 
-// SaveSearch - Removes the expiration from a search so it's not marked for deletion
-func (c CharacterServiceImpl) SaveSearch(searchID string) (bool, error) {
-	result, err := c.repository.SearchRepository.RemoveExpiration(searchID)
+// UpdateSearch - Updates the title, notes and tags of a search
+// Tags are trimmed and duplicates are dropped
+func (c *CharacterServiceImpl) UpdateSearch(userID string, searchID string, input SearchUpdateInput) (*Search, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if search, err := c.getOwnedSearch(repo, userID, searchID); err != nil || search == nil {
+		return nil, err
+	}
+
+	searchUpdate := repositories.SearchUpdate{
+		Title: input.Title,
+		Notes: input.Notes,
+	}
+	if input.Tags != nil {
+		searchUpdate.Tags = normalizeTags(input.Tags)
+	}
+
+	search, err := repo.SearchRepository.UpdateSearch(searchID, searchUpdate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update search: %w", c.checkStorage(err))
+	}
+
+	if search == nil {
+		return nil, nil
+	}
+
+	result := toSearch(*search)
+	return &result, nil
+}
+
+// normalizeTags - Trims the tags and removes empty and duplicate ones
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// UnsaveSearch - Puts the default expiration back on a saved search so it's marked for deletion again
+func (c *CharacterServiceImpl) UnsaveSearch(userID string, searchID string) (bool, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return false, err
+	}
+
+	if search, err := c.getOwnedSearch(repo, userID, searchID); err != nil || search == nil {
+		return false, err
+	}
+
+	result, err := repo.SearchRepository.AddExpiration(searchID)
 	if err != nil {
-		return false, fmt.Errorf("failed to remove expiration: %w", err)
+		return false, fmt.Errorf("failed to add expiration: %w", c.checkStorage(err))
+	}
+
+	return result, nil
+}
+
+// DeleteSearch - Soft-deletes a search; it can be restored until the retention window passes
+func (c *CharacterServiceImpl) DeleteSearch(userID string, searchID string) (bool, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return false, err
+	}
+
+	if search, err := c.getOwnedSearch(repo, userID, searchID); err != nil || search == nil {
+		return false, err
+	}
+
+	result, err := repo.SearchRepository.DeleteSearch(searchID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete search: %w", c.checkStorage(err))
+	}
+
+	return result, nil
+}
+
+// RestoreSearch - Restores a deleted search along with its previous expiration
+func (c *CharacterServiceImpl) RestoreSearch(userID string, searchID string) (bool, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return false, err
+	}
+
+	// Only searches the user deleted themselves can be restored
+	deleted, err := repo.SearchRepository.GetDeletedSearches(repositories.SearchFilter{Owner: userID})
+	if err != nil {
+		return false, fmt.Errorf("failed to get deleted searches: %w", c.checkStorage(err))
+	}
+
+	owned := false
+	for _, search := range deleted {
+		if search.ID.Hex() == searchID {
+			owned = true
+			break
+		}
+	}
+	if !owned {
+		return false, nil
+	}
+
+	result, err := repo.SearchRepository.RestoreSearch(searchID)
+	if err != nil {
+		return false, fmt.Errorf("failed to restore search: %w", c.checkStorage(err))
 	}
 
 	return result, nil
@@ -207,48 +578,46 @@ func (c CharacterServiceImpl) SaveSearch(searchID string) (bool, error) {
 // GetSavedSearchesByID - Gets saved searches from the database by ID
 // 1. Gets the characters from the search
 // 2. Builds the character result with the film and vehicle data
-func (c CharacterServiceImpl) GetSavedSearchesByID(searchID string) ([]Character, error) {
-	search, err := c.repository.SearchRepository.GetSearchesByID(searchID)
+//
+// With the snapshot view, the characters are returned exactly as they were when the search was saved.
+// Searches without a snapshot always use the live view.
+func (c *CharacterServiceImpl) GetSavedSearchesByID(userID string, searchID string, view SearchView) ([]Character, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	search, err := c.getOwnedSearch(repo, userID, searchID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get search by ID: %w", err)
+		return nil, err
 	}
 
 	if search == nil {
 		return nil, nil
 	}
 
-	var characters []Character
-	for _, characterID := range search.Characters {
-		character, err := c.repository.CharacterRepository.GetCharacter(characterID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get character: %w", err)
-		}
-
-		characterResult := Character{
-			ID:   character.ID,
-			Name: character.Name,
-		}
-
-		for _, film := range character.Films {
-			existingFilm, err := c.repository.FilmRepository.GetFilm(film)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get film: %w", err)
-			}
+	if view != SearchViewLive && search.Snapshot != nil {
+		return fromSnapshot(*search.Snapshot), nil
+	}
 
-			characterResult.Films = append(characterResult.Films, existingFilm.Title)
-		}
+	return c.hydrateCharacters(repo, search.Characters)
+}
 
-		for _, vehicle := range character.Vehicles {
-			existingVehicle, err := c.repository.VehicleRepository.GetVehicle(vehicle)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get vehicle: %w", err)
-			}
+// getOwnedSearch - Gets a search that belongs to the user
+// Returns nil if the search doesn't exist or belongs to someone else, so other users' searches can't be probed
+func (c *CharacterServiceImpl) getOwnedSearch(repo *repositories.Repository, userID string, searchID string) (*models.SearchModel, error) {
+	if userID == "" {
+		return nil, ErrUnauthenticated
+	}
 
-			characterResult.VehicleModels = append(characterResult.VehicleModels, existingVehicle.Model)
-		}
+	search, err := repo.SearchRepository.GetSearchesByID(searchID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get search by ID: %w", c.checkStorage(err))
+	}
 
-		characters = append(characters, characterResult)
+	if search == nil || search.Owner != userID {
+		return nil, nil
 	}
 
-	return characters, nil
+	return search, nil
 }
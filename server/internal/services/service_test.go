@@ -2,6 +2,19 @@ package services
 
 import (
 	"alvinlucillo/swapi-app/internal/models"
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -9,9 +22,12 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const testUserID = "user-1"
+
 func generateMockData() ([]models.SearchModel, []models.CharacterModel, []models.VehicleModel, []models.FilmModel) {
 	characters := []models.CharacterModel{
 		{ID: "1", Name: "Luke Skywalker", Films: []string{"1", "2", "3"}, Vehicles: []string{"1", "2", "3"}},
+		{ID: "2", Name: "Darth Vader", Films: []string{"1"}, Vehicles: []string{"3"}},
 	}
 
 	vehicles := []models.VehicleModel{
@@ -28,8 +44,8 @@ func generateMockData() ([]models.SearchModel, []models.CharacterModel, []models
 
 	timeNow := time.Now()
 	searches := []models.SearchModel{
-		{ID: primitive.NewObjectID(), SearchKey: "Luke Skywalker", Characters: []string{"1"}, ExpiresAt: nil},
-		{ID: primitive.NewObjectID(), SearchKey: "Darth Vader", Characters: []string{"2"}, ExpiresAt: &timeNow},
+		{ID: primitive.NewObjectID(), Owner: testUserID, SearchKey: "Luke Skywalker", Characters: []string{"1"}, ExpiresAt: nil},
+		{ID: primitive.NewObjectID(), Owner: testUserID, SearchKey: "Darth Vader", Characters: []string{"2"}, ExpiresAt: &timeNow},
 	}
 
 	return searches, characters, vehicles, films
@@ -43,7 +59,7 @@ func TestGetSavedSearches(t *testing.T) {
 		repository: &repository,
 	}
 
-	searchResult, err := svc.GetSavedSearches()
+	searchResult, err := svc.GetSavedSearches(testUserID, "")
 	require.NoError(t, err, "error should be nil")
 
 	require.Equal(t, len(searches), len(searchResult), "searches should be equal")
@@ -59,7 +75,7 @@ func TestSaveSearch(t *testing.T) {
 		repository: &repository,
 	}
 
-	searchResult, err := svc.SaveSearch(searches[1].ID.Hex())
+	searchResult, err := svc.SaveSearch(testUserID, searches[1].ID.Hex(), SearchExpiry{})
 	require.NoError(t, err, "error should be nil")
 
 	require.Equal(t, true, searchResult, "result should be equal")
@@ -73,7 +89,7 @@ func TestGetSavedSearchesByID(t *testing.T) {
 		repository: &repository,
 	}
 
-	searchResult, err := svc.GetSavedSearchesByID(searches[0].ID.Hex())
+	searchResult, err := svc.GetSavedSearchesByID(testUserID, searches[0].ID.Hex(), SearchViewLive)
 	require.NoError(t, err, "error should be nil")
 
 	require.Equal(t, len(searchResult), 1, "character length should be equal")
@@ -93,7 +109,7 @@ func TestGetCharacter(t *testing.T) {
 		swapiClient: swapiClient,
 	}
 
-	searchResult, _, err := svc.GetCharacters(characters[0].Name)
+	searchResult, _, err := svc.GetCharacters(testUserID, characters[0].Name)
 	require.NoError(t, err, "error should be nil")
 
 	require.Equal(t, len(searchResult), 1, "character length should be equal")
@@ -103,3 +119,1845 @@ func TestGetCharacter(t *testing.T) {
 	require.Equal(t, len(searchResult[0].VehicleModels), 3, "vehicle length should be equal")
 
 }
+
+func TestGetCharacterStorageUnavailable(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	swapiClient := NewMockSWAPIClient(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		swapiClient: swapiClient,
+		storageDown: true,
+	}
+
+	searchResult, searchID, err := svc.GetCharacters(testUserID, characters[0].Name)
+	require.NoError(t, err, "error should be nil")
+
+	require.Equal(t, "", searchID, "search ID should be empty")
+	require.Equal(t, len(searchResult), 1, "character length should be equal")
+	require.Equal(t, len(searchResult[0].Films), 3, "film length should be equal")
+	require.Equal(t, len(searchResult[0].VehicleModels), 3, "vehicle length should be equal")
+
+	_, err = svc.SaveSearch(testUserID, searches[1].ID.Hex(), SearchExpiry{})
+	require.ErrorIs(t, err, ErrStorageUnavailable, "error should be storage unavailable")
+
+	_, err = svc.GetSavedSearches(testUserID, "")
+	require.ErrorIs(t, err, ErrStorageUnavailable, "error should be storage unavailable")
+}
+
+func TestDeleteAndRestoreSearch(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository: &repository,
+	}
+
+	result, err := svc.DeleteSearch(testUserID, searches[0].ID.Hex())
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, true, result, "result should be equal")
+
+	deleted, err := svc.GetDeletedSearches(testUserID)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, 1, len(deleted), "deleted searches length should be equal")
+	require.Equal(t, searches[0].ID.Hex(), deleted[0].ID, "deleted search should be equal")
+	require.NotNil(t, deleted[0].ExpiresAt, "deleted search should expire")
+
+	result, err = svc.RestoreSearch(testUserID, searches[0].ID.Hex())
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, true, result, "result should be equal")
+	require.Nil(t, searches[0].ExpiresAt, "restored saved search should not expire")
+	require.Nil(t, searches[0].DeletedAt, "restored search should not be deleted")
+
+	result, err = svc.UnsaveSearch(testUserID, searches[0].ID.Hex())
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, true, result, "result should be equal")
+	require.NotNil(t, searches[0].ExpiresAt, "unsaved search should expire")
+}
+
+func TestUpdateSearch(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository: &repository,
+	}
+
+	title := "The chosen one"
+	searchResult, err := svc.UpdateSearch(testUserID, searches[0].ID.Hex(), SearchUpdateInput{
+		Title: &title,
+		Tags:  []string{" Skywalkers ", "Jedi", "Skywalkers", ""},
+	})
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, title, searchResult.Title, "title should be equal")
+	require.Equal(t, "", searchResult.Notes, "notes should be unchanged")
+	require.Equal(t, []string{"Skywalkers", "Jedi"}, searchResult.Tags, "tags should be normalized")
+
+	tagged, err := svc.GetSavedSearches(testUserID, "Jedi")
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, 1, len(tagged), "tagged searches length should be equal")
+	require.Equal(t, searches[0].ID.Hex(), tagged[0].ID, "tagged search should be equal")
+}
+
+func TestCollectionCharacters(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	searches = append(searches, models.SearchModel{ID: primitive.NewObjectID(), Owner: testUserID, SearchKey: "Skywalker", Characters: []string{"1", "2"}, ExpiresAt: nil})
+	repository := NewMockRepository(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository: &repository,
+	}
+
+	name := "Skywalkers"
+	collection, err := svc.CreateCollection(testUserID, CollectionInput{Name: &name, SearchIDs: []string{searches[0].ID.Hex(), searches[0].ID.Hex()}})
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, []string{searches[0].ID.Hex()}, collection.SearchIDs, "search IDs should be de-duplicated")
+
+	_, err = svc.AddSearchToCollection(testUserID, collection.ID, searches[1].ID.Hex())
+	require.Error(t, err, "unsaved searches should not be added")
+
+	collection, err = svc.AddSearchToCollection(testUserID, collection.ID, searches[2].ID.Hex())
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, 2, len(collection.Searches), "searches length should be equal")
+
+	collectionCharacters, err := svc.GetCollectionCharacters(testUserID, collection.ID)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, 2, len(collectionCharacters), "characters should be de-duplicated")
+	require.Equal(t, "1", collectionCharacters[0].ID, "character order should be kept")
+	require.Equal(t, "2", collectionCharacters[1].ID, "character order should be kept")
+
+	other := "Sith"
+	_, err = svc.CreateCollection(testUserID, CollectionInput{Name: &other})
+	require.NoError(t, err, "error should be nil")
+
+	collections, err := svc.MoveCollection(testUserID, collection.ID, 1)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, other, collections[0].Name, "collections should be reordered")
+	require.Equal(t, name, collections[1].Name, "collections should be reordered")
+}
+
+func TestPinAndExtendSearch(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository: &repository,
+	}
+
+	_, err := svc.SaveSearch(testUserID, searches[1].ID.Hex(), SearchExpiry{TTL: -time.Hour})
+	require.Error(t, err, "negative ttl should be rejected")
+
+	result, err := svc.SaveSearch(testUserID, searches[1].ID.Hex(), SearchExpiry{TTL: 30 * time.Minute})
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, true, result, "result should be equal")
+	require.Equal(t, true, searches[1].Saved, "search should be saved")
+	require.NotNil(t, searches[1].ExpiresAt, "search should be pinned until a time")
+
+	expiring, err := svc.GetSearchesExpiringSoon(testUserID, time.Hour)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, 1, len(expiring), "expiring searches length should be equal")
+	require.Equal(t, searches[1].ID.Hex(), expiring[0].ID, "expiring search should be equal")
+
+	previous := *searches[1].ExpiresAt
+	search, err := svc.ExtendSearch(testUserID, searches[1].ID.Hex(), SearchExpiry{TTL: 2 * time.Hour})
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, previous.Add(2*time.Hour), *search.ExpiresAt, "expiration should be extended")
+
+	expiring, err = svc.GetSearchesExpiringSoon(testUserID, time.Hour)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, 0, len(expiring), "extended search should no longer expire soon")
+}
+
+func TestSaveSearchSnapshot(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository: &repository,
+	}
+
+	_, err := svc.SaveSearch(testUserID, searches[1].ID.Hex(), SearchExpiry{})
+	require.NoError(t, err, "error should be nil")
+	require.NotNil(t, searches[1].Snapshot, "snapshot should be taken")
+
+	// Change the cached data after the search was saved
+	films[0].Title = "Star Wars"
+
+	snapshot, err := svc.GetSavedSearchesByID(testUserID, searches[1].ID.Hex(), SearchViewSnapshot)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, []string{"A New Hope"}, snapshot[0].Films, "snapshot should not change")
+	require.Equal(t, []string{"TIE/LN starfighter"}, snapshot[0].VehicleModels, "snapshot should not change")
+
+	live, err := svc.GetSavedSearchesByID(testUserID, searches[1].ID.Hex(), SearchViewLive)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, []string{"Star Wars"}, live[0].Films, "live view should reflect the cache")
+}
+
+func TestRerunSearch(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	swapiClient := NewMockSWAPIClient(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository:  &repository,
+		swapiClient: swapiClient,
+	}
+
+	_, err := svc.SaveSearch(testUserID, searches[1].ID.Hex(), SearchExpiry{})
+	require.NoError(t, err, "error should be nil")
+
+	// The mock SWAPI returns a different vehicle model than the one that was saved
+	diff, err := svc.RerunSearch(testUserID, searches[1].ID.Hex())
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, SearchViewSnapshot, diff.ComparedTo, "diff should be against the snapshot")
+	require.True(t, diff.HasChanges, "diff should have changes")
+	require.Empty(t, diff.Added, "no characters should be added")
+	require.Empty(t, diff.Removed, "no characters should be removed")
+	require.Len(t, diff.Changed, 1, "one character should change")
+	require.Equal(t, []string{"T-16 skyhopper"}, diff.Changed[0].VehiclesAdded, "vehicle should be gained")
+	require.Equal(t, []string{"TIE/LN starfighter"}, diff.Changed[0].VehiclesRemoved, "vehicle should be lost")
+	require.Empty(t, diff.Changed[0].FilmsAdded, "films should not change")
+
+	snapshot, err := svc.GetSavedSearchesByID(testUserID, searches[1].ID.Hex(), SearchViewSnapshot)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, []string{"TIE/LN starfighter"}, snapshot[0].VehicleModels, "snapshot should not change before accepting")
+
+	result, err := svc.AcceptSearchRerun(testUserID, searches[1].ID.Hex())
+	require.NoError(t, err, "error should be nil")
+	require.True(t, result, "re-run should be accepted")
+
+	snapshot, err = svc.GetSavedSearchesByID(testUserID, searches[1].ID.Hex(), SearchViewSnapshot)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, []string{"T-16 skyhopper"}, snapshot[0].VehicleModels, "snapshot should be the re-run")
+
+	result, err = svc.AcceptSearchRerun(testUserID, searches[1].ID.Hex())
+	require.NoError(t, err, "error should be nil")
+	require.False(t, result, "there should be no pending re-run left")
+}
+
+func TestDiffCharacters(t *testing.T) {
+	previous := []Character{
+		{ID: "1", Name: "Luke Skywalker", Films: []string{"A New Hope"}},
+		{ID: "2", Name: "Darth Vader"},
+	}
+	current := []Character{
+		{ID: "1", Name: "Luke Skywalker", Films: []string{"A New Hope", "Return of the Jedi"}},
+		{ID: "3", Name: "Leia Organa"},
+	}
+
+	diff := diffCharacters(previous, current)
+	require.True(t, diff.HasChanges, "diff should have changes")
+	require.Equal(t, "3", diff.Added[0].ID, "Leia should be added")
+	require.Equal(t, "2", diff.Removed[0].ID, "Vader should be removed")
+	require.Equal(t, []string{"Return of the Jedi"}, diff.Changed[0].FilmsAdded, "film should be gained")
+
+	require.False(t, diffCharacters(current, current).HasChanges, "identical results should have no changes")
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository: &repository,
+	}
+
+	session, err := svc.Register(" Obi-Wan ", "hello there")
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, "obi-wan", session.User.Username, "username should be normalized")
+	require.NotEmpty(t, session.Token, "token should be returned")
+	require.Equal(t, RoleReader, session.User.Role, "new users should be readers until an admin promotes them")
+
+	_, err = authorize(WithUser(context.Background(), &session.User), RoleEditor)
+	require.ErrorIs(t, err, ErrForbidden, "new users should not be able to make changes")
+
+	_, err = svc.Register("obi-wan", "general kenobi")
+	require.Error(t, err, "username should be unique")
+
+	_, err = svc.Register("ben", "short")
+	require.Error(t, err, "short passwords should be rejected")
+
+	_, err = svc.Login("obi-wan", "general kenobi")
+	require.ErrorIs(t, err, ErrInvalidCredentials, "wrong password should be rejected")
+
+	session, err = svc.Login("OBI-WAN", "hello there")
+	require.NoError(t, err, "error should be nil")
+
+	user, err := svc.Authenticate(session.Token)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, session.User.ID, user.ID, "token should resolve to the user")
+
+	result, err := svc.Logout(session.Token)
+	require.NoError(t, err, "error should be nil")
+	require.True(t, result, "session should be deleted")
+
+	user, err = svc.Authenticate(session.Token)
+	require.NoError(t, err, "error should be nil")
+	require.Nil(t, user, "token should no longer be valid")
+}
+
+func TestSearchesScopedToOwner(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	swapiClient := NewMockSWAPIClient(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository:  &repository,
+		swapiClient: swapiClient,
+	}
+
+	otherSearches, err := svc.GetSavedSearches("user-2", "")
+	require.NoError(t, err, "error should be nil")
+	require.Empty(t, otherSearches, "other users should not see the searches")
+
+	result, err := svc.SaveSearch("user-2", searches[1].ID.Hex(), SearchExpiry{})
+	require.NoError(t, err, "error should be nil")
+	require.False(t, result, "other users should not be able to save the search")
+
+	_, err = svc.GetSavedSearchesByID("", searches[0].ID.Hex(), SearchViewLive)
+	require.ErrorIs(t, err, ErrUnauthenticated, "anonymous users should not see saved searches")
+
+	// Anonymous users can still browse, but no search is recorded for them
+	characterResult, searchID, err := svc.GetCharacters("", characters[0].Name)
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, characterResult, 1, "characters should be returned")
+	require.Empty(t, searchID, "no search should be recorded")
+}
+
+func TestClaimOwnerlessSearches(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	// Saved before accounts existed, so it has no owner; the unsaved one expires on its own
+	timeNow := time.Now()
+	searches = append(searches,
+		models.SearchModel{ID: primitive.NewObjectID(), SearchKey: "Han Solo", Characters: []string{"1"}},
+		models.SearchModel{ID: primitive.NewObjectID(), SearchKey: "Chewbacca", Characters: []string{"1"}, ExpiresAt: &timeNow},
+	)
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	swapiClient := NewMockSWAPIClient(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository:  &repository,
+		swapiClient: swapiClient,
+	}
+
+	_, err := svc.ClaimOwnerlessSearches("nobody")
+	code, _ := ErrorCodeOf(err)
+	require.Equal(t, CodeNotFound, code, "unknown users should be rejected")
+
+	session, err := svc.Register("han", "never tell me the odds")
+	require.NoError(t, err, "error should be nil")
+
+	claimed, err := svc.ClaimOwnerlessSearches("han")
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, int64(1), claimed, "only the saved search without an owner should be claimed")
+
+	savedSearches, err := svc.GetSavedSearches(session.User.ID, "")
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, savedSearches, 1, "the claimed search should be listed")
+	require.Equal(t, "Han Solo", savedSearches[0].SearchKey, "the claimed search should be listed")
+
+	claimed, err = svc.ClaimOwnerlessSearches("han")
+	require.NoError(t, err, "error should be nil")
+	require.Zero(t, claimed, "claimed searches should have an owner")
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository: &repository,
+	}
+
+	session, err := svc.Register("leia", "help me obi-wan")
+	require.NoError(t, err, "error should be nil")
+
+	result, err := svc.SetUserRole("leia", RoleEditor)
+	require.NoError(t, err, "error should be nil")
+	require.True(t, result, "role should be set")
+
+	_, _, err = svc.CreateAPIKey("leia", "trivia bot", Role("owner"))
+	require.Error(t, err, "unknown roles should be rejected")
+
+	key, apiKey, err := svc.CreateAPIKey("leia", "trivia bot", RoleAdmin)
+	require.NoError(t, err, "error should be nil")
+	require.True(t, len(key) > len(apiKey.Prefix), "prefix should only be part of the key")
+
+	// The key can't do more than its user
+	user, err := svc.Authenticate(key)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, session.User.ID, user.ID, "key should act as the user")
+	require.Equal(t, RoleEditor, user.Role, "key should be limited to the user's role")
+
+	_, err = authorize(WithUser(context.Background(), user), RoleAdmin)
+	require.ErrorIs(t, err, ErrForbidden, "editors should not be admins")
+
+	result, err = svc.SetUserRole("leia", RoleReader)
+	require.NoError(t, err, "error should be nil")
+	require.True(t, result, "role should be set")
+
+	user, err = svc.Authenticate(session.Token)
+	require.NoError(t, err, "error should be nil")
+	_, err = authorize(WithUser(context.Background(), user), RoleEditor)
+	require.ErrorIs(t, err, ErrForbidden, "readers should not be able to make changes")
+
+	result, err = svc.RevokeAPIKey(apiKey.ID)
+	require.NoError(t, err, "error should be nil")
+	require.True(t, result, "key should be revoked")
+
+	user, err = svc.Authenticate(key)
+	require.NoError(t, err, "error should be nil")
+	require.Nil(t, user, "revoked keys should be rejected")
+}
+
+func TestShareLink(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository:      &repository,
+		shareLinkSecret: []byte("secret"),
+	}
+
+	_, err := svc.CreateShareLink(testUserID, searches[1].ID.Hex(), nil)
+	require.Error(t, err, "unsaved searches should not be shared")
+
+	_, err = svc.CreateShareLink("user-2", searches[0].ID.Hex(), nil)
+	require.Error(t, err, "other users' searches should not be shared")
+
+	link, err := svc.CreateShareLink(testUserID, searches[0].ID.Hex(), nil)
+	require.NoError(t, err, "error should be nil")
+
+	shared, err := svc.GetSharedSearch(link.Token)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, "Luke Skywalker", shared.SearchKey, "shared search should be returned")
+	require.Len(t, shared.Characters, 1, "characters should be hydrated")
+
+	// Tampering with the token breaks the signature
+	_, err = svc.GetSharedSearch("x" + link.Token)
+	require.ErrorIs(t, err, ErrInvalidShareLink, "tampered tokens should be rejected")
+
+	recorder := httptest.NewRecorder()
+	NewSharedSearchHandler(HandlerConfig{}, &svc).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/shared/"+link.Token, nil))
+	require.Equal(t, http.StatusOK, recorder.Code, "REST endpoint should return the search")
+	require.Contains(t, recorder.Body.String(), "Luke Skywalker", "REST endpoint should return the characters")
+
+	links, err := svc.GetShareLinks(testUserID, searches[0].ID.Hex())
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, link.Token, links[0].Token, "listed links should have the same token")
+
+	result, err := svc.RevokeShareLink(testUserID, searches[0].ID.Hex(), link.ID)
+	require.NoError(t, err, "error should be nil")
+	require.True(t, result, "link should be revoked")
+
+	_, err = svc.GetSharedSearch(link.Token)
+	require.ErrorIs(t, err, ErrInvalidShareLink, "revoked links should be rejected")
+
+	recorder = httptest.NewRecorder()
+	NewSharedSearchHandler(HandlerConfig{}, &svc).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/shared/"+link.Token, nil))
+	require.Equal(t, http.StatusNotFound, recorder.Code, "revoked links should not be found")
+
+	// Expired tokens are rejected by their signed expiry
+	expiredAt := time.Now().Add(-time.Minute)
+	token := svc.signShareToken(searches[0].ID.Hex(), models.ShareLinkModel{ID: "expired", ExpiresAt: &expiredAt})
+	_, _, err = svc.verifyShareToken(token, time.Now())
+	require.ErrorIs(t, err, ErrInvalidShareLink, "expired links should be rejected")
+}
+
+func TestAuditLog(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository: &repository,
+	}
+	h := NewHandler(HandlerConfig{}, &svc)
+
+	mutate := func(user *User, query string) string {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(query))
+		request.Header.Set("Content-Type", "application/graphql")
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request.WithContext(WithUser(request.Context(), user)))
+		return recorder.Body.String()
+	}
+
+	editor := &User{ID: testUserID, Username: "luke", Role: RoleEditor}
+	searchID := searches[0].ID.Hex()
+
+	mutate(editor, `mutation { updateSearch(searchID: "`+searchID+`", title: "Farm boy") { Title } }`)
+	mutate(nil, `mutation { login(username: "luke", password: "hunter2hunter2") { Token } }`)
+
+	page, err := svc.GetAuditLog(AuditFilter{}, 1, "")
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, page.Entries, 1, "only the first page should be returned")
+	require.True(t, page.HasNextPage, "there should be another page")
+
+	login := page.Entries[0]
+	require.Equal(t, "login", login.Operation, "newest entry should come first")
+	require.Empty(t, login.ActorID, "anonymous mutations should have no actor")
+	require.NotEmpty(t, login.Error, "failed mutations should be recorded")
+	require.Contains(t, login.Arguments, "[REDACTED]", "passwords should be redacted")
+	require.NotContains(t, login.Arguments, "hunter2hunter2", "passwords should not be stored")
+
+	page, err = svc.GetAuditLog(AuditFilter{}, 1, page.EndCursor)
+	require.NoError(t, err, "error should be nil")
+	require.False(t, page.HasNextPage, "there should be no more pages")
+
+	update := page.Entries[0]
+	require.Equal(t, "updateSearch", update.Operation, "operation should be recorded")
+	require.Equal(t, testUserID, update.ActorID, "actor should be recorded")
+	require.Equal(t, searchID, update.TargetID, "target should be recorded")
+	require.NotContains(t, update.Before, "Farm boy", "before should have the old title")
+	require.Contains(t, update.After, "Farm boy", "after should have the new title")
+
+	page, err = svc.GetAuditLog(AuditFilter{ActorID: testUserID}, 10, "")
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, page.Entries, 1, "entries should be filtered by actor")
+
+	_, err = svc.GetAuditLog(AuditFilter{}, 10, "not-a-cursor")
+	require.Error(t, err, "invalid cursors should be rejected")
+}
+
+// countingService counts the reads of the state of audit targets
+type countingService struct {
+	CharacterService
+	reads *int
+}
+
+func (s countingService) GetAuditState(target AuditTarget) (interface{}, error) {
+	*s.reads++
+	return s.CharacterService.GetAuditState(target)
+}
+
+func TestAuditLogRejectedMutations(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository: &repository,
+	}
+	var reads int
+	h := NewHandler(HandlerConfig{}, countingService{CharacterService: &svc, reads: &reads})
+
+	mutate := func(user *User, query string) {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(query))
+		request.Header.Set("Content-Type", "application/graphql")
+		if user != nil {
+			request = request.WithContext(WithUser(request.Context(), user))
+		}
+		h.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	searchID := searches[0].ID.Hex()
+	update := `mutation { updateSearch(searchID: "` + searchID + `", title: "Farm boy") { Title } }`
+
+	mutate(nil, update)
+	require.Zero(t, reads, "the target should not be read for anonymous users")
+
+	mutate(&User{ID: testUserID, Username: "luke", Role: RoleReader}, update)
+	require.Zero(t, reads, "the target should not be read for users whose role can't change it")
+
+	// The search isn't theirs, so it's read before the mutation but not after it's turned away
+	mutate(&User{ID: "user-2", Username: "han", Role: RoleEditor}, `mutation { rerunSearch(searchID: "`+searchID+`") { HasChanges } }`)
+	require.Equal(t, 1, reads, "the target should not be read again after the mutation is rejected")
+
+	page, err := svc.GetAuditLog(AuditFilter{}, 10, "")
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, page.Entries, 3, "rejected mutations should be recorded")
+	for _, entry := range page.Entries {
+		require.NotEmpty(t, entry.Error, "the reason should be recorded")
+		require.Empty(t, entry.Before, "the state of targets of rejected mutations should be left out")
+		require.Empty(t, entry.After, "the state of targets of rejected mutations should be left out")
+	}
+}
+
+func TestExportSearches(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository: &repository,
+	}
+
+	exports, err := svc.ExportSearches(testUserID, searches[0].ID.Hex())
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, exports, 1, "only the given search should be exported")
+
+	var csv strings.Builder
+	require.NoError(t, WriteExport(&csv, ExportFormatCSV, exports), "error should be nil")
+	lines := strings.Split(strings.TrimSpace(csv.String()), "\n")
+	require.Len(t, lines, 8, "there should be a header and a row per character, film and vehicle")
+	require.Contains(t, lines[2], "film,A New Hope", "films should have their own rows")
+
+	var markdown strings.Builder
+	require.NoError(t, WriteExport(&markdown, ExportFormatMarkdown, exports), "error should be nil")
+	require.Contains(t, markdown.String(), "## Luke Skywalker", "characters should have a heading")
+	require.Contains(t, markdown.String(), "| A New Hope | T-16 skyhopper |", "films and vehicles should be in a table")
+
+	_, err = svc.ExportSearches("user-2", searches[0].ID.Hex())
+	require.ErrorIs(t, err, ErrSearchNotFound, "other users' searches should not be exported")
+
+	h := NewExportHandler(HandlerConfig{}, &svc)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/export?format=json", nil))
+	require.Equal(t, http.StatusUnauthorized, recorder.Code, "anonymous users should not export")
+
+	request := httptest.NewRequest(http.MethodGet, "/export?format=json", nil)
+	user := &User{ID: testUserID, Username: "luke", Role: RoleReader}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, request.WithContext(WithUser(request.Context(), user)))
+	require.Equal(t, http.StatusOK, recorder.Code, "saved searches should be exported")
+	require.Contains(t, recorder.Header().Get("Content-Disposition"), "searches.json", "export should be a download")
+	require.Contains(t, recorder.Body.String(), `"name": "Darth Vader"`, "all searches should be exported")
+}
+
+func TestImportSearches(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	swapiClient := NewMockSWAPIClient(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository:  &repository,
+		swapiClient: swapiClient,
+	}
+
+	bundle, err := svc.ExportBundle(testUserID, "")
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, bundle.Searches, 2, "all saved searches should be exported")
+	require.Len(t, bundle.Characters, 2, "characters should be exported")
+	require.Len(t, bundle.Films, 3, "films should be exported once")
+	require.Len(t, bundle.Vehicles, 3, "vehicles should be exported once")
+
+	var file strings.Builder
+	require.NoError(t, WriteBundle(&file, bundle), "error should be nil")
+	read, err := ReadBundle(strings.NewReader(file.String()))
+	require.NoError(t, err, "error should be nil")
+
+	report, err := svc.ImportSearches("user-2", *read, ImportConflictSkip, true)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, 2, report.Created, "searches of other users should be imported as new searches")
+	require.NotEqual(t, searches[0].ID.Hex(), report.Searches[0].SearchID, "searches of other users should not be overwritten")
+	saved, err := svc.GetSavedSearches("user-2", "")
+	require.NoError(t, err, "error should be nil")
+	require.Empty(t, saved, "dry runs should not import anything")
+
+	_, err = svc.ImportSearches("user-2", *read, ImportConflictSkip, false)
+	require.NoError(t, err, "error should be nil")
+	saved, err = svc.GetSavedSearches("user-2", "")
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, saved, 2, "searches should be imported")
+
+	report, err = svc.ImportSearches(testUserID, *read, ImportConflictSkip, false)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, 2, report.Skipped, "existing searches should be skipped")
+
+	read.Searches[0].Title = "Imported"
+	report, err = svc.ImportSearches(testUserID, *read, ImportConflictOverwrite, false)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, 2, report.Overwritten, "existing searches should be overwritten")
+	saved, err = svc.GetSavedSearches(testUserID, "")
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, saved, 2, "overwriting should not add searches")
+	require.Equal(t, "Imported", saved[0].Title, "overwritten searches should have the imported title")
+
+	report, err = svc.ImportSearches(testUserID, *read, ImportConflictDuplicate, false)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, 2, report.Created, "existing searches should be duplicated")
+	saved, err = svc.GetSavedSearches(testUserID, "")
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, saved, 4, "duplicates should be added next to the existing searches")
+
+	_, err = ReadBundle(strings.NewReader(`{"version": 99}`))
+	require.Error(t, err, "newer bundle versions should be rejected")
+
+	// IDs of a bundle are fetched later on, so they must be Star Wars API resources
+	svc.swapiClient = NewSWAPIClient(http.DefaultClient, "https://swapi.dev/api")
+	evil := Bundle{
+		Version: BundleVersion,
+		Searches: []BundleSearch{
+			{SearchKey: "Luke", CharacterIDs: []string{"https://swapi.dev/api/people/1/"}},
+		},
+		Characters: []BundleCharacter{
+			{ID: "https://swapi.dev/api/people/1/", Name: "Luke Skywalker", FilmIDs: []string{"http://169.254.169.254/latest/meta-data/"}},
+		},
+	}
+	_, err = svc.ImportSearches("user-3", evil, ImportConflictSkip, false)
+	code, _ := ErrorCodeOf(err)
+	require.Equal(t, CodeInvalidArgument, code, "IDs that aren't Star Wars API resources should be rejected")
+	saved, err = svc.GetSavedSearches("user-3", "")
+	require.NoError(t, err, "error should be nil")
+	require.Empty(t, saved, "refused bundles should not import anything")
+
+	// Characters of a bundle are not written to the shared cache
+	evil.Characters[0].FilmIDs = []string{"1"}
+	report, err = svc.ImportSearches("user-3", evil, ImportConflictSkip, false)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, 1, report.Created, "the search should be imported")
+	cached, err := repository.CharacterRepository.GetCharacter("https://swapi.dev/api/people/1/")
+	require.NoError(t, err, "error should be nil")
+	require.Nil(t, cached, "bundle characters should not be cached")
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	swapiClient := NewMockSWAPIClient(searches, characters, vehicles, films)
+
+	// The test server listens on loopback
+	svc := CharacterServiceImpl{
+		repository:          &repository,
+		swapiClient:         swapiClient,
+		webhookRetryDelay:   time.Millisecond,
+		webhookAllowPrivate: true,
+	}
+
+	var requests int
+	var signature, timestamp string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// Fail the first attempt so it's retried
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		signature = r.Header.Get("X-Webhook-Signature")
+		timestamp = r.Header.Get("X-Webhook-Timestamp")
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	_, err := svc.SaveSearch(testUserID, searches[1].ID.Hex(), SearchExpiry{})
+	require.NoError(t, err, "error should be nil")
+
+	_, err = svc.CreateWebhook(testUserID, "ftp://example.com", nil)
+	require.Error(t, err, "only http and https URLs should be accepted")
+
+	webhook, err := svc.CreateWebhook(testUserID, server.URL, []string{searches[1].ID.Hex()})
+	require.NoError(t, err, "error should be nil")
+	require.NotEmpty(t, webhook.Secret, "secret should be returned when the webhook is created")
+
+	// A manual re-run of the user doesn't hide the change from the watcher
+	_, err = svc.RerunSearch(testUserID, searches[1].ID.Hex())
+	require.NoError(t, err, "error should be nil")
+	rerun, err := repository.SearchRepository.GetSearch(searches[1].ID.Hex())
+	require.NoError(t, err, "error should be nil")
+	pendingRerun := rerun.PendingRerun
+	require.NotNil(t, pendingRerun, "re-run should be pending")
+
+	// The mock SWAPI returns a different vehicle model than the one that was saved
+	require.NoError(t, svc.CheckWatchedSearches(context.Background()), "error should be nil")
+	require.Equal(t, 2, requests, "failed attempts should be retried")
+	watched, err := repository.SearchRepository.GetSearch(searches[1].ID.Hex())
+	require.NoError(t, err, "error should be nil")
+	require.Same(t, pendingRerun, watched.PendingRerun, "the watcher should not replace the pending re-run")
+	require.NotNil(t, watched.WatchedResult, "the watcher should keep its own result")
+	require.Equal(t, "sha256="+SignWebhookPayload(webhook.Secret, timestamp, body), signature, "payload should be signed")
+	require.Contains(t, string(body), `"vehiclesAdded":["T-16 skyhopper"]`, "payload should have the change")
+
+	deliveries, err := svc.GetWebhookDeliveries(testUserID, webhook.ID, 0)
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, deliveries, 1, "delivery should be logged")
+	require.True(t, deliveries[0].Succeeded, "delivery should succeed")
+	require.Len(t, deliveries[0].Attempts, 2, "every attempt should be logged")
+	require.Equal(t, http.StatusInternalServerError, deliveries[0].Attempts[0].StatusCode, "failed attempt should be logged")
+
+	// The same change is only reported once
+	require.NoError(t, svc.CheckWatchedSearches(context.Background()), "error should be nil")
+	require.Equal(t, 2, requests, "unchanged results should not be sent again")
+
+	_, err = svc.GetWebhookDeliveries("user-2", webhook.ID, 0)
+	require.Error(t, err, "other users' deliveries should not be returned")
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	swapiClient := NewMockSWAPIClient(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository:        &repository,
+		swapiClient:       swapiClient,
+		webhookRetryDelay: time.Millisecond,
+	}
+
+	for _, url := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://[::1]/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.1/hook",
+		"http://192.168.1.1/hook",
+		"http://0.0.0.0/hook",
+	} {
+		_, err := svc.CreateWebhook(testUserID, url, nil)
+		require.ErrorIs(t, err, ErrWebhookAddressNotAllowed, "%s should be rejected", url)
+	}
+
+	// IP literals are checked without DNS; the webhook is deleted so nothing is sent to it
+	public, err := svc.CreateWebhook(testUserID, "https://93.184.216.34/hook", nil)
+	require.NoError(t, err, "public addresses should be accepted")
+	_, err = svc.DeleteWebhook(testUserID, public.ID)
+	require.NoError(t, err, "error should be nil")
+
+	// A host that resolved to a public address when the webhook was created may resolve to a private one later,
+	// so the address is checked again when delivering
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	_, err = svc.SaveSearch(testUserID, searches[1].ID.Hex(), SearchExpiry{})
+	require.NoError(t, err, "error should be nil")
+	webhookID := primitive.NewObjectID()
+	_, err = repository.WebhookRepository.AddWebhook(models.WebhookModel{
+		ID:        webhookID,
+		Owner:     testUserID,
+		URL:       server.URL,
+		Secret:    "secret",
+		SearchIDs: []string{searches[1].ID.Hex()},
+		CreatedAt: time.Now(),
+	})
+	require.NoError(t, err, "error should be nil")
+
+	require.NoError(t, svc.CheckWatchedSearches(context.Background()), "error should be nil")
+	require.Zero(t, requests, "private addresses should not be connected to")
+
+	deliveries, err := svc.GetWebhookDeliveries(testUserID, webhookID.Hex(), 0)
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, deliveries, 1, "delivery should be logged")
+	require.False(t, deliveries[0].Succeeded, "delivery should fail")
+	require.Len(t, deliveries[0].Attempts, 1, "refused addresses should not be retried")
+	require.Contains(t, deliveries[0].Attempts[0].Error, ErrWebhookAddressNotAllowed.Error(), "the reason should be logged")
+}
+
+func TestSavedSearchConnection(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	searches = append(searches,
+		models.SearchModel{ID: primitive.NewObjectID(), Owner: testUserID, SearchKey: "leia organa", Tags: []string{"rebels"}},
+		models.SearchModel{ID: primitive.NewObjectID(), Owner: "someone-else", SearchKey: "Luke"},
+	)
+	repository := NewMockRepository(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository: &repository,
+	}
+
+	page, err := svc.GetSavedSearchConnection(testUserID, SearchConnectionArgs{First: 2})
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, 3, page.TotalCount, "only searches of the user should be counted")
+	require.Len(t, page.Edges, 2, "only the first page should be returned")
+	require.True(t, page.PageInfo.HasNextPage, "there should be another page")
+	require.False(t, page.PageInfo.HasPreviousPage, "the first page has no previous page")
+	require.Equal(t, "leia organa", page.Edges[0].Node.SearchKey, "newest search should come first")
+
+	page, err = svc.GetSavedSearchConnection(testUserID, SearchConnectionArgs{First: 2, After: page.PageInfo.EndCursor})
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, page.Edges, 1, "the rest should be on the second page")
+	require.False(t, page.PageInfo.HasNextPage, "there should be no more pages")
+	require.Equal(t, "Luke Skywalker", page.Edges[0].Node.SearchKey, "oldest search should come last")
+
+	_, err = svc.GetSavedSearchConnection(testUserID, SearchConnectionArgs{Sort: SearchSortTitle, After: page.PageInfo.EndCursor})
+	require.Error(t, err, "cursors of another sort should be rejected")
+	_, err = svc.GetSavedSearchConnection(testUserID, SearchConnectionArgs{After: "not-a-cursor"})
+	require.Error(t, err, "invalid cursors should be rejected")
+	_, err = svc.GetSavedSearchConnection(testUserID, SearchConnectionArgs{First: maxSearchPageSize + 1})
+	require.Error(t, err, "pages larger than the maximum should be rejected")
+
+	h := NewHandler(HandlerConfig{}, &svc)
+	query := func(query string) string {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(query))
+		request.Header.Set("Content-Type", "application/graphql")
+		recorder := httptest.NewRecorder()
+		user := &User{ID: testUserID, Username: "luke", Role: RoleReader}
+		h.ServeHTTP(recorder, request.WithContext(WithUser(request.Context(), user)))
+		return recorder.Body.String()
+	}
+
+	body := query(`{ savedSearches(sortBy: SEARCH_KEY, sortDirection: ASC) { totalCount edges { node { SearchKey } } } }`)
+	require.Regexp(t, `Darth Vader.*leia organa.*Luke Skywalker`, body, "searches should be sorted by search key ignoring case")
+
+	body = query(`{ savedSearches(searchKey: "SKY") { totalCount edges { node { SearchKey } } } }`)
+	require.Contains(t, body, `"totalCount":1`, "searches should be filtered by search key")
+	require.Contains(t, body, "Luke Skywalker", "matching searches should be returned")
+
+	body = query(`{ savedSearches(tag: "rebels") { totalCount pageInfo { hasNextPage } } }`)
+	require.Contains(t, body, `"totalCount":1`, "searches should be filtered by tag")
+}
+
+func TestSavedSearchConnectionByTitle(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	searches[0].Title = "Farm boy"
+	searches[1].Title = "Dark lord"
+	searches = append(searches, models.SearchModel{ID: primitive.NewObjectID(), Owner: testUserID, SearchKey: "leia organa", Title: "Princess"})
+	repository := NewMockRepository(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository: &repository,
+	}
+
+	// A cleared title sorts with the searches that never had one
+	cleared := ""
+	_, err := svc.UpdateSearch(testUserID, searches[1].ID.Hex(), SearchUpdateInput{Title: &cleared})
+	require.NoError(t, err, "error should be nil")
+
+	for _, descending := range []bool{false, true} {
+		var titles []string
+		seen := map[string]bool{}
+		args := SearchConnectionArgs{First: 1, Sort: SearchSortTitle, Descending: &descending}
+		for {
+			page, err := svc.GetSavedSearchConnection(testUserID, args)
+			require.NoError(t, err, "error should be nil")
+			require.Equal(t, args.After != "", page.PageInfo.HasPreviousPage, "pages after a cursor should have a previous page")
+			for _, edge := range page.Edges {
+				require.False(t, seen[edge.Node.ID], "searches should only be on one page")
+				seen[edge.Node.ID] = true
+				titles = append(titles, edge.Node.Title)
+			}
+			if !page.PageInfo.HasNextPage {
+				break
+			}
+			args.After = page.PageInfo.EndCursor
+		}
+
+		if descending {
+			require.Equal(t, []string{"Princess", "Farm boy", ""}, titles, "searches without a title should come last")
+		} else {
+			require.Equal(t, []string{"", "Farm boy", "Princess"}, titles, "searches without a title should come first")
+		}
+	}
+}
+
+func TestFilmsAndVehicles(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	films[0].EpisodeID = 4
+	films[0].Characters = []string{"1", "2"}
+	films[0].Vehicles = []string{"3"}
+	vehicles[2].Pilots = []string{"2"}
+	vehicles[2].Films = []string{"1"}
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	swapiClient := NewMockSWAPIClient(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository:  &repository,
+		swapiClient: swapiClient,
+	}
+	h := NewHandler(HandlerConfig{}, &svc)
+
+	query := func(query string) string {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(query))
+		request.Header.Set("Content-Type", "application/graphql")
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		return recorder.Body.String()
+	}
+
+	body := query(`{ film(id: "1") { id title episodeID characters { name } vehicles { model } } }`)
+	require.Contains(t, body, `"title":"A New Hope"`, "film should be returned")
+	require.Contains(t, body, `"episodeID":4`, "episode number should be returned")
+	require.Regexp(t, `Luke Skywalker.*Darth Vader`, body, "characters of the film should be returned")
+	require.Contains(t, body, "TIE/LN starfighter", "vehicles of the film should be returned")
+
+	body = query(`{ vehicle(id: "3") { model pilots { name } films { title } } }`)
+	require.Contains(t, body, `"pilots":[{"name":"Darth Vader"}]`, "pilots of the vehicle should be returned")
+	require.Contains(t, body, `"films":[{"title":"A New Hope"}]`, "films of the vehicle should be returned")
+
+	// Snapshots taken before film IDs were stored find them through the character
+	snapshot := models.SearchSnapshot{Characters: []models.SnapshotCharacter{
+		{ID: "2", Name: "Darth Vader", Films: []string{"A New Hope"}, VehicleModels: []string{"TIE/LN starfighter"}},
+	}}
+	snapshotFilms, err := svc.GetCharacterFilms(fromSnapshot(snapshot)[0])
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, snapshotFilms, 1, "films should be found for old snapshots")
+	require.Equal(t, "1", snapshotFilms[0].ID, "film IDs should come from the character")
+
+	_, err = svc.GetFilm("")
+	require.Error(t, err, "invalid film IDs should be rejected")
+
+	swapi := NewSWAPIClient(http.DefaultClient, "https://swapi.dev/api")
+	filmURL, err := swapi.ResourceURL("films", "1")
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, "https://swapi.dev/api/films/1/", filmURL, "numbers should be turned into URLs")
+	filmURL, err = swapi.ResourceURL("films", "https://swapi.dev/api/films/2/")
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, "https://swapi.dev/api/films/2/", filmURL, "URLs should be kept")
+	_, err = swapi.ResourceURL("films", "http://169.254.169.254/api/films/1/")
+	require.Error(t, err, "URLs outside the Star Wars API should be rejected")
+}
+
+func TestLoaders(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	for i := range films {
+		films[i].Characters = []string{"1", "2"}
+	}
+	films[0].Vehicles = []string{"1", "3"}
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	swapiClient := NewMockSWAPIClient(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository:  &repository,
+		swapiClient: swapiClient,
+	}
+	h := NewLoaderMiddleware(&svc)(NewHandler(HandlerConfig{}, &svc))
+
+	characterRepository := repository.CharacterRepository.(*mockCharacterRepository)
+	filmRepository := repository.FilmRepository.(*mockFilmRepository)
+	vehicleRepository := repository.VehicleRepository.(*mockVehicleRepository)
+
+	// Siblings are resolved in no fixed order, so the query is run a few times to check batches don't depend on it
+	for i := 0; i < 10; i++ {
+		characterRepository.queries, filmRepository.queries, vehicleRepository.queries = 0, 0, 0
+
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(
+			`{ film(id: "1") { characters { name appearsIn { title characters { name } } vehicles { model } } vehicles { pilots { name } } } }`,
+		))
+		request.Header.Set("Content-Type", "application/graphql")
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+
+		body := recorder.Body.String()
+		require.NotContains(t, body, "errors", "query should succeed")
+		require.Contains(t, body, "Return of the Jedi", "nested films should be returned")
+		require.Contains(t, body, "X-34 landspeeder", "nested vehicles should be returned")
+
+		// Without batching every character, film and vehicle of every level would be looked up on its own.
+		// Characters are looked up once; later levels ask for the same ones. Films are looked up for
+		// film(id), to build the characters and for appearsIn. Vehicles are looked up to build the characters,
+		// for film.vehicles, and once for the vehicles of every character, which are a level below film.vehicles.
+		require.Equal(t, 1, characterRepository.queries, "characters should be looked up once and memoized")
+		require.Equal(t, 3, filmRepository.queries, "films should be looked up once per batch")
+		require.Equal(t, 3, vehicleRepository.queries, "vehicles should be looked up once per level")
+	}
+
+	loaders := NewLoaders(&svc)
+	first := loaders.Films([]string{"1", "2"})
+	second := loaders.Films([]string{"2", "3", "missing"})
+	queries := filmRepository.queries
+
+	result, err := first()
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, result, 2, "films should be returned in order")
+	result, err = second()
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, result, 2, "films that don't exist should be left out")
+	require.Equal(t, queries+1, filmRepository.queries, "queued loads should be batched")
+
+	_, err = loaders.Films([]string{"1"})()
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, queries+1, filmRepository.queries, "loaded films should be memoized")
+
+	// Loads don't wait for the lookups of other batches, e.g. of other operations of a batch request
+	started, release := make(chan struct{}), make(chan struct{})
+	blocking := newLoader(&loaderClock{}, func(keys []string) (map[string]interface{}, error) {
+		values := map[string]interface{}{}
+		for _, key := range keys {
+			if key == "slow" {
+				close(started)
+				<-release
+			}
+			values[key] = key
+		}
+		return values, nil
+	})
+	slow := make(chan []interface{})
+	go func() {
+		values, _ := blocking.load([]string{"slow"})()
+		slow <- values
+	}()
+	<-started
+	values, err := blocking.load([]string{"fast"})()
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, []interface{}{"fast"}, values, "loads should be looked up while another batch is")
+	close(release)
+	require.Equal(t, []interface{}{"slow"}, <-slow, "the blocked batch should still finish")
+}
+
+func TestSearchProgressSubscription(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	swapiClient := NewMockSWAPIClient(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository:  &repository,
+		swapiClient: swapiClient,
+	}
+
+	var events []SearchProgress
+	err := svc.SearchCharactersWithProgress(context.Background(), testUserID, "Luke Skywalker", func(progress SearchProgress) error {
+		events = append(events, progress)
+		return nil
+	})
+	require.NoError(t, err, "error should be nil")
+	require.Len(t, events, 2, "the character and the completion should be sent")
+	require.Equal(t, "Luke Skywalker", events[0].Character.Name, "the hydrated character should be sent")
+	require.Equal(t, 1, events[0].Total, "the total should be sent with every event")
+	require.True(t, events[1].Done, "the last event should be the completion")
+	require.NotEmpty(t, events[1].SearchID, "the search should be recorded for logged in users")
+
+	user := &User{ID: testUserID, Username: "luke", Role: RoleReader}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		NewSubscriptionHandler(HandlerConfig{}, &svc, DefaultQueryLimits(), nil).ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err, "error should be nil")
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: graphql-transport-ws\r\n\r\n")
+	require.NoError(t, err, "error should be nil")
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, http.StatusSwitchingProtocols, response.StatusCode, "the connection should be upgraded")
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", response.Header.Get("Sec-WebSocket-Accept"), "the key should be accepted")
+
+	// Client frames are masked; messages here are shorter than 64KB
+	write := func(message string) {
+		mask := []byte{1, 2, 3, 4}
+		frame := []byte{0x81, 0x80 | byte(len(message))}
+		if len(message) > 125 {
+			frame = []byte{0x81, 0x80 | 126, byte(len(message) >> 8), byte(len(message))}
+		}
+		frame = append(frame, mask...)
+		for i := 0; i < len(message); i++ {
+			frame = append(frame, message[i]^mask[i%4])
+		}
+		_, err := conn.Write(frame)
+		require.NoError(t, err, "error should be nil")
+	}
+	read := func() subscriptionMessage {
+		header := make([]byte, 2)
+		_, err := io.ReadFull(reader, header)
+		require.NoError(t, err, "error should be nil")
+		length := int(header[1] & 0x7F)
+		if length == 126 {
+			extended := make([]byte, 2)
+			_, err = io.ReadFull(reader, extended)
+			require.NoError(t, err, "error should be nil")
+			length = int(binary.BigEndian.Uint16(extended))
+		}
+		payload := make([]byte, length)
+		_, err = io.ReadFull(reader, payload)
+		require.NoError(t, err, "error should be nil")
+		require.Equal(t, byte(0x81), header[0], "messages should be single text frames: %q", payload)
+
+		var message subscriptionMessage
+		require.NoError(t, json.Unmarshal(payload, &message), "messages should be JSON")
+		return message
+	}
+
+	write(`{"type":"connection_init"}`)
+	require.Equal(t, "connection_ack", read().Type, "the connection should be acknowledged")
+
+	write(`{"type":"ping"}`)
+	require.Equal(t, "pong", read().Type, "pings should be answered")
+
+	write(`{"id":"1","type":"subscribe","payload":{"query":"subscription { searchProgress(name: \"Luke Skywalker\") { Character { name } Count Total Done SearchID } }"}}`)
+
+	message := read()
+	require.Equal(t, "next", message.Type, "the character should be sent")
+	require.Equal(t, "1", message.ID, "messages should carry the subscription ID")
+	require.Contains(t, string(message.Payload), "Luke Skywalker", "the hydrated character should be sent")
+
+	message = read()
+	require.Equal(t, "next", message.Type, "the completion should be sent")
+	var result struct {
+		Data struct {
+			SearchProgress SearchProgress `json:"searchProgress"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(message.Payload, &result), "error should be nil")
+	require.True(t, result.Data.SearchProgress.Done, "the last event should be the completion")
+	require.Equal(t, 1, result.Data.SearchProgress.Count, "every character should be counted")
+	require.NotEmpty(t, result.Data.SearchProgress.SearchID, "the search should be recorded")
+
+	require.Equal(t, "complete", read().Type, "the subscription should complete")
+
+	write(`{"id":"2","type":"subscribe","payload":{"query":"subscription { searchProgress { Done } }"}}`)
+	message = read()
+	require.Equal(t, "error", message.Type, "invalid operations should be rejected")
+	require.Equal(t, "2", message.ID, "errors should carry the subscription ID")
+}
+
+func TestQueryLimits(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	swapiClient := NewMockSWAPIClient(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository:  &repository,
+		swapiClient: swapiClient,
+	}
+	schema, err := NewSchema(&svc)
+	require.NoError(t, err, "error should be nil")
+
+	limits := QueryLimits{MaxDepth: 5, MaxCost: 100, DefaultListSize: 10}
+
+	// film 5 + title 1 + characters 5 + 10 characters * (name 1 + appearsIn 5 + 10 films * title 1)
+	cost, ok := limits.Analyze(&schema, `{ film(id: "1") { title characters { name appearsIn { title } } } }`, "", nil)
+	require.True(t, ok, "the query should be analyzed")
+	require.Equal(t, QueryCost{Depth: 4, Cost: 171}, cost, "list fields should multiply the cost of their selections")
+
+	// The page size of the connection sizes its edges: savedSearches 1 + edges 1 + 2 edges * (node 1 + ID 1)
+	cost, _ = limits.Analyze(&schema, `query($first: Int) { savedSearches(first: $first) { edges { node { ...search } } } }
+		fragment search on Search { ID }`, "", map[string]interface{}{"first": float64(2)})
+	require.Equal(t, QueryCost{Depth: 4, Cost: 6}, cost, "first should size the list and fragments should be counted")
+
+	cost, _ = limits.Analyze(&schema, `{ __schema { types { fields { type { ofType { ofType { name } } } } } } me { Username } }`, "", nil)
+	require.Equal(t, QueryCost{Depth: 2, Cost: 2}, cost, "introspection should be ignored")
+
+	h := NewQueryLimitMiddleware(HandlerConfig{}, &svc, limits)(NewHandler(HandlerConfig{}, &svc))
+	query := func(body string) (int, string) {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		return recorder.Code, recorder.Body.String()
+	}
+
+	code, body := query(`{"query": "{ film(id: \"1\") { title characters { name } } }"}`)
+	require.Equal(t, http.StatusOK, code, "cheap queries should run")
+	require.Contains(t, body, "A New Hope", "cheap queries should run")
+
+	code, body = query(`{"query": "{ film(id: \"1\") { title characters { name appearsIn { title } } } }"}`)
+	require.Equal(t, http.StatusBadRequest, code, "expensive queries should be rejected")
+	var response struct {
+		Errors []struct {
+			Message    string                 `json:"message"`
+			Extensions map[string]interface{} `json:"extensions"`
+		} `json:"errors"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &response), "error should be nil")
+	require.Len(t, response.Errors, 1, "the rejection should be explained")
+	require.Equal(t, "query cost of at least 101 exceeds the maximum of 100", response.Errors[0].Message, "the cost should be explained")
+	require.Equal(t, ErrCodeQueryTooComplex, response.Errors[0].Extensions["code"], "the error should have a code")
+	require.Equal(t, float64(101), response.Errors[0].Extensions["cost"], "the cost where the analysis stopped should be returned")
+	require.Equal(t, float64(100), response.Errors[0].Extensions["maxCost"], "the limit should be returned")
+
+	code, body = query(`{"query": "{ film(id: \"1\") { characters { appearsIn { characters { appearsIn { characters { name } } } } } } }"}`)
+	require.Equal(t, http.StatusBadRequest, code, "deep queries should be rejected")
+	require.Contains(t, body, ErrCodeQueryTooDeep, "deep queries should be rejected")
+	require.Contains(t, body, "query depth 7 exceeds the maximum of 5", "the depth should be explained")
+
+	// Every fragment spreads the next one twice, which doubles the work of walking it for each fragment
+	var fragments strings.Builder
+	const chain = 60
+	fragments.WriteString(`{ me { ...F0 } }`)
+	for i := 0; i < chain; i++ {
+		fmt.Fprintf(&fragments, " fragment F%d on User { ...F%d ...F%d }", i, i+1, i+1)
+	}
+	fmt.Fprintf(&fragments, " fragment F%d on User { Username }", chain)
+
+	started := time.Now()
+	_, limitErr := limits.Check(&schema, fragments.String(), "", nil)
+	require.NotNil(t, limitErr, "fragments spread many times should be rejected")
+	require.Equal(t, ErrCodeQueryTooComplex, limitErr.Code, "fragments spread many times should cost every spread")
+	cost, ok = QueryLimits{}.Analyze(&schema, fragments.String(), "", nil)
+	require.True(t, ok, "the query should be analyzed")
+	require.Equal(t, 2, cost.Depth, "fragments should not add depth")
+	require.Less(t, time.Since(started), time.Second, "every fragment should only be walked once")
+}
+
+func TestPersistedQueries(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	swapiClient := NewMockSWAPIClient(searches, characters, vehicles, films)
+
+	svc := CharacterServiceImpl{
+		repository:  &repository,
+		swapiClient: swapiClient,
+	}
+
+	type response struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []struct {
+			Message    string                 `json:"message"`
+			Extensions map[string]interface{} `json:"extensions"`
+		} `json:"errors"`
+	}
+	request := func(h http.Handler, query string, hash string) (int, response) {
+		body := map[string]interface{}{}
+		if query != "" {
+			body["query"] = query
+		}
+		if hash != "" {
+			body["extensions"] = map[string]interface{}{
+				"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": hash},
+			}
+		}
+		data, _ := json.Marshal(body)
+
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(data)))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req)
+
+		var result response
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result), "responses should be JSON")
+		return recorder.Code, result
+	}
+	errorCode := func(result response) interface{} {
+		require.Len(t, result.Errors, 1, "there should be an error")
+		return result.Errors[0].Extensions["code"]
+	}
+
+	query := `query Film { film(id: "1") { title } }`
+	hash := queryHash(query)
+
+	// Automatic persisted queries: unknown hashes are registered by sending the query with them
+	persisted, err := NewPersistedQueries(PersistedQueryConfig{CacheSize: 1})
+	require.NoError(t, err, "error should be nil")
+	h := NewPersistedQueryMiddleware(persisted)(NewHandler(HandlerConfig{}, &svc))
+
+	code, result := request(h, "", hash)
+	require.Equal(t, http.StatusOK, code, "unknown hashes should be reported like GraphQL errors")
+	require.Equal(t, ErrCodePersistedQueryNotFound, errorCode(result), "unknown hashes should be reported")
+
+	code, _ = request(h, `{ me { Username } }`, hash)
+	require.Equal(t, http.StatusBadRequest, code, "queries should match their hash")
+
+	_, result = request(h, query, hash)
+	require.Empty(t, result.Errors, "registering a query should run it")
+	_, result = request(h, "", hash)
+	require.Empty(t, result.Errors, "registered queries should run by hash")
+	require.Equal(t, "A New Hope", result.Data["film"].(map[string]interface{})["title"], "registered queries should run by hash")
+
+	_, result = request(h, `{ me { Username } }`, queryHash(`{ me { Username } }`))
+	require.Empty(t, result.Errors, "any query may be registered outside allowlist mode")
+	_, result = request(h, "", hash)
+	require.Equal(t, ErrCodePersistedQueryNotFound, errorCode(result), "the least recently used query should be dropped")
+
+	// Allowlist mode only runs the operations of the manifest
+	path := t.TempDir() + "/persisted-queries.json"
+	manifest := fmt.Sprintf(`{"format": "apollo-persisted-query-manifest", "version": 1, "operations": [
+		{"id": %q, "name": "Film", "type": "query", "body": %q}]}`, hash, query)
+	require.NoError(t, os.WriteFile(path, []byte(manifest), 0600), "error should be nil")
+
+	_, err = NewPersistedQueries(PersistedQueryConfig{AllowlistOnly: true})
+	require.Error(t, err, "allowlist mode should need a manifest")
+	_, err = NewPersistedQueries(PersistedQueryConfig{ManifestPath: "../../persisted-queries.json", AllowlistOnly: true})
+	require.NoError(t, err, "the manifest of the UI operations should load")
+
+	persisted, err = NewPersistedQueries(PersistedQueryConfig{ManifestPath: path, AllowlistOnly: true})
+	require.NoError(t, err, "error should be nil")
+	h = NewPersistedQueryMiddleware(persisted)(NewHandler(HandlerConfig{}, &svc))
+
+	_, result = request(h, "", hash)
+	require.Empty(t, result.Errors, "manifest operations should run by hash")
+
+	// Apollo adds __typename fields and prints queries its own way
+	sent := "query Film {\n  film(id: \"1\") {\n    title\n    __typename\n  }\n}"
+	_, result = request(h, sent, queryHash(sent))
+	require.Empty(t, result.Errors, "manifest operations should run whatever the formatting")
+	_, result = request(h, "", queryHash(sent))
+	require.Empty(t, result.Errors, "manifest operations should be registered under the hash the client uses")
+
+	code, result = request(h, `{ me { Username } }`, "")
+	require.Equal(t, http.StatusForbidden, code, "other operations should be rejected")
+	require.Equal(t, ErrCodeOperationNotAllowed, errorCode(result), "other operations should be rejected")
+
+	code, result = request(h, `{ me { Username } }`, queryHash(`{ me { Username } }`))
+	require.Equal(t, http.StatusForbidden, code, "other operations shouldn't be registered")
+	require.Equal(t, ErrCodeOperationNotAllowed, errorCode(result), "other operations shouldn't be registered")
+}
+
+func TestErrorCodes(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	swapiClient := NewMockSWAPIClient(searches, characters, vehicles, films)
+
+	// The SWAPI is down, and so is the database of the degraded service
+	swapiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer swapiServer.Close()
+
+	svc := CharacterServiceImpl{
+		repository:  &repository,
+		swapiClient: swapiClient,
+	}
+	degraded := CharacterServiceImpl{
+		swapiClient: NewSWAPIClient(swapiServer.Client(), swapiServer.URL),
+	}
+	user := &User{ID: testUserID, Username: "luke", Role: RoleReader}
+
+	type graphqlError struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	}
+	query := func(svc CharacterService, production bool, user *User, query string) graphqlError {
+		h := NewHandler(HandlerConfig{Production: production}, svc)
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(query))
+		request.Header.Set("Content-Type", "application/graphql")
+		if user != nil {
+			request = request.WithContext(WithUser(request.Context(), user))
+		}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+
+		var response struct {
+			Errors []graphqlError `json:"errors"`
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response), "responses should be JSON")
+		require.NotEmpty(t, response.Errors, "there should be an error")
+		return response.Errors[0]
+	}
+
+	badID := `{ getSavedSearchesByID(searchID: "not-an-id") { name } }`
+	err := query(&svc, false, user, badID)
+	require.Equal(t, string(CodeInvalidArgument), err.Extensions["code"], "malformed IDs should be invalid arguments")
+	require.Equal(t, "failed to get search by ID: the provided hex string is not a valid ObjectID", err.Message, "details should be shown in development")
+	err = query(&svc, true, user, badID)
+	require.Equal(t, string(CodeInvalidArgument), err.Extensions["code"], "codes should be kept in production")
+	require.Equal(t, "invalid ID: the provided hex string is not a valid ObjectID", err.Message, "only the domain error should be shown in production")
+
+	err = query(&svc, true, nil, `{ getSavedSearches { ID } }`)
+	require.Equal(t, string(CodeUnauthorized), err.Extensions["code"], "anonymous users should be unauthorized")
+	require.Equal(t, ErrUnauthenticated.Error(), err.Message, "the reason should be shown")
+
+	err = query(&svc, true, nil, `{ sharedSearch(token: "bad") { __typename } }`)
+	require.Equal(t, string(CodeNotFound), err.Extensions["code"], "invalid share links should not be found")
+
+	err = query(&degraded, true, user, `{ getSavedSearches { ID } }`)
+	require.Equal(t, string(CodeStorageUnavailable), err.Extensions["code"], "outages should be reported")
+	require.Equal(t, ErrStorageUnavailable.Error(), err.Message, "outages should be explained")
+
+	upstream := `{ film(id: "1") { title } }`
+	err = query(&degraded, false, user, upstream)
+	require.Equal(t, string(CodeUpstreamUnavailable), err.Extensions["code"], "SWAPI failures should be reported")
+	require.Contains(t, err.Message, "unexpected status 502", "details should be shown in development")
+	err = query(&degraded, true, user, upstream)
+	require.Equal(t, upstreamUnavailableMessage, err.Message, "details should be redacted in production")
+
+	err = query(&svc, true, user, `{ getSavedSearches { unknownField } }`)
+	require.Equal(t, string(CodeGraphQLValidationFailed), err.Extensions["code"], "invalid documents should be reported")
+	require.Contains(t, err.Message, "unknownField", "validation errors should be shown in production")
+
+	code, message := ErrorCodeOf(fmt.Errorf("failed to connect to 10.0.0.1: %w", io.ErrUnexpectedEOF))
+	require.Equal(t, CodeInternal, code, "unknown errors should be internal")
+	require.Equal(t, internalErrorMessage, message, "internal details should be redacted")
+}
+
+// failingService fails to authenticate and to look up shared searches with an error that has internal details
+type failingService struct {
+	CharacterService
+	err error
+}
+
+func (s failingService) Authenticate(token string) (*User, error) {
+	return nil, s.err
+}
+
+func (s failingService) GetSharedSearch(token string) (*SharedSearch, error) {
+	return nil, s.err
+}
+
+func TestHandlerErrorsInProduction(t *testing.T) {
+	svc := failingService{err: fmt.Errorf("failed to get session: dial tcp 10.0.0.1:27017: %w", io.ErrUnexpectedEOF)}
+
+	for _, production := range []bool{false, true} {
+		cfg := HandlerConfig{Production: production}
+
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{ me { ID } }`))
+		request.Header.Set("Authorization", "Bearer token")
+		recorder := httptest.NewRecorder()
+		NewAuthMiddleware(cfg, svc)(NewHandler(cfg, svc)).ServeHTTP(recorder, request)
+		require.Equal(t, http.StatusInternalServerError, recorder.Code, "failing to authenticate should be an internal error")
+		if production {
+			require.NotContains(t, recorder.Body.String(), "10.0.0.1", "internal details should be redacted in production")
+			require.Contains(t, recorder.Body.String(), internalErrorMessage, "a safe message should be shown in production")
+		} else {
+			require.Contains(t, recorder.Body.String(), "10.0.0.1", "details should be shown in development")
+		}
+
+		recorder = httptest.NewRecorder()
+		NewSharedSearchHandler(cfg, svc).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/shared/token", nil))
+		require.Equal(t, http.StatusInternalServerError, recorder.Code, "failing to look up the link should be an internal error")
+		if production {
+			require.NotContains(t, recorder.Body.String(), "10.0.0.1", "internal details should be redacted in production")
+			require.Contains(t, recorder.Body.String(), internalErrorMessage, "a safe message should be shown in production")
+		} else {
+			require.Contains(t, recorder.Body.String(), "10.0.0.1", "details should be shown in development")
+		}
+	}
+
+	// Errors of the client keep their message
+	recorder := httptest.NewRecorder()
+	NewSharedSearchHandler(HandlerConfig{Production: true}, failingService{err: ErrInvalidShareLink}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/shared/token", nil))
+	require.Equal(t, http.StatusNotFound, recorder.Code, "invalid links should not be found")
+	require.Contains(t, recorder.Body.String(), ErrInvalidShareLink.Error(), "the reason should be shown in production")
+}
+
+func TestSchemaSnapshot(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	svc := CharacterServiceImpl{
+		repository:  &repository,
+		swapiClient: NewMockSWAPIClient(searches, characters, vehicles, films),
+	}
+
+	schema, err := NewSchema(&svc)
+	require.NoError(t, err, "there should be no error")
+	sdl := PrintSchema(&schema)
+	require.Equal(t, sdl, PrintSchema(&schema), "the SDL should be the same every time")
+
+	golden, err := os.ReadFile("../../schema.graphql")
+	require.NoError(t, err, "the schema should be committed")
+	require.Equal(t, string(golden), sdl, "the schema changed; check it with `go run ./cmd schema check` and update it with `go run ./cmd schema print -out schema.graphql`")
+
+	changes, err := FindBreakingChanges(string(golden), sdl)
+	require.NoError(t, err, "the SDL should parse")
+	require.Empty(t, changes, "there should be no changes")
+
+	recorder := httptest.NewRecorder()
+	NewSchemaHandler(&svc).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/schema.graphql", nil))
+	require.Equal(t, http.StatusOK, recorder.Code, "the schema should be served")
+	require.Equal(t, sdl, recorder.Body.String(), "the served schema should be the SDL")
+}
+
+func TestBreakingChanges(t *testing.T) {
+	baseline := `
+schema {
+  query: CharacterQuery
+}
+
+type CharacterQuery {
+  character(id: ID!, withFilms: Boolean): Character
+  characters(first: Int): [Character]
+  search(input: SearchInput): [Character!]
+}
+
+type Character {
+  name: String
+  films: [String]
+  side: Side
+}
+
+enum Side {
+  LIGHT
+  DARK
+}
+
+input SearchInput {
+  name: String
+  limit: Int!
+}
+`
+	changed := `
+schema {
+  query: CharacterQuery
+}
+
+"New fields, nullable arguments and non-null results don't break clients"
+type CharacterQuery {
+  character(id: ID, withFilms: Boolean, language: String): Character!
+  characters(first: Int!): [Character]
+  search(input: SearchInput): [Character]
+  film(id: ID!): String
+}
+
+type Character {
+  name: String!
+  films: String
+  side: Side
+}
+
+enum Side {
+  LIGHT
+}
+
+input SearchInput {
+  name: String!
+  limit: Int
+  sort: String!
+  page: Int! = 1
+}
+
+type Vehicle {
+  model: String
+}
+`
+	changes, err := FindBreakingChanges(baseline, changed)
+	require.NoError(t, err, "there should be no error")
+
+	var reported []string
+	for _, change := range changes {
+		reported = append(reported, change.String())
+	}
+	require.Equal(t, []string{
+		"Character.films: type changed from [String] to String",
+		"CharacterQuery.characters(first:): type changed from Int to Int!",
+		"CharacterQuery.search: type changed from [Character!] to [Character]",
+		"SearchInput.name: type changed from String to String!",
+		"SearchInput.sort: required input field was added",
+		"Side.DARK: enum value was removed",
+	}, reported, "only breaking changes should be reported")
+
+	changes, err = FindBreakingChanges(changed, baseline)
+	require.NoError(t, err, "there should be no error")
+	reported = nil
+	for _, change := range changes {
+		reported = append(reported, change.String())
+	}
+	require.Contains(t, reported, "CharacterQuery.film: field was removed", "removed fields should be reported")
+	require.Contains(t, reported, "CharacterQuery.character(language:): argument was removed", "removed arguments should be reported")
+	require.Contains(t, reported, "CharacterQuery.character(id:): type changed from ID to ID!", "arguments becoming required should be reported")
+	require.Contains(t, reported, "Vehicle: object was removed", "removed types should be reported")
+
+	_, err = FindBreakingChanges(baseline, "type {")
+	require.Error(t, err, "invalid SDL should be an error")
+}
+
+func TestBatchedOperations(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	svc := CharacterServiceImpl{
+		repository:  &repository,
+		swapiClient: NewMockSWAPIClient(searches, characters, vehicles, films),
+	}
+
+	// Records the loaders every operation runs with
+	var mu sync.Mutex
+	var loaders []*Loaders
+	recordLoaders := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			loaders = append(loaders, loadersFromContext(r.Context(), &svc))
+			mu.Unlock()
+			next.ServeHTTP(w, r)
+		})
+	}
+	limits := QueryLimits{MaxCost: 50, DefaultListSize: 10}
+	h := NewLoaderMiddleware(&svc)(NewBatchMiddleware(3)(NewQueryLimitMiddleware(HandlerConfig{}, &svc, limits)(recordLoaders(NewHandler(HandlerConfig{}, &svc)))))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		request = request.WithContext(WithUser(request.Context(), &User{ID: testUserID, Username: "luke", Role: RoleReader}))
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := post(fmt.Sprintf(`[
+		{"query": "{ getSavedSearches { ID } }"},
+		{"query": "query Search($id: String!) { getSavedSearchesByID(searchID: $id) { name } }", "variables": {"id": %q}},
+		{"query": "{ film(id: \"1\") { characters { appearsIn { characters { appearsIn { title } } } } } }"}
+	]`, searches[0].ID.Hex()))
+	require.Equal(t, http.StatusOK, recorder.Code, "batches should succeed")
+
+	var results []struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []struct {
+			Message    string                 `json:"message"`
+			Extensions map[string]interface{} `json:"extensions"`
+		} `json:"errors"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &results), "the response should be an array")
+	require.Len(t, results, 3, "there should be a result per operation")
+	require.Empty(t, results[0].Errors, "the first operation should succeed")
+	require.Len(t, results[0].Data["getSavedSearches"], 2, "the user of the batch should be used")
+	require.Empty(t, results[1].Errors, "the second operation should succeed")
+	require.Equal(t, []interface{}{map[string]interface{}{"name": "Luke Skywalker"}}, results[1].Data["getSavedSearchesByID"], "results should be in order")
+	require.Len(t, results[2].Errors, 1, "operations over the limits should be rejected on their own")
+	require.Equal(t, ErrCodeQueryTooComplex, results[2].Errors[0].Extensions["code"], "the error of the operation should be its result")
+
+	require.Len(t, loaders, 2, "operations that run should get loaders")
+	require.Same(t, loaders[0], loaders[1], "operations of a batch should share loaders")
+
+	recorder = post(`{"query": "{ getSavedSearches { ID } }"}`)
+	require.Equal(t, http.StatusOK, recorder.Code, "single operations should still work")
+	require.True(t, strings.HasPrefix(recorder.Body.String(), "{"), "single operations should get a single result")
+
+	recorder = post(`[{"query": "{ getSavedSearches { ID } }"}, {"query": "{ getSavedSearches { ID } }"}, {"query": "{ getSavedSearches { ID } }"}, {"query": "{ getSavedSearches { ID } }"}]`)
+	require.Equal(t, http.StatusBadRequest, recorder.Code, "batches over the limit should be rejected")
+	require.Contains(t, recorder.Body.String(), ErrCodeBatchTooLarge, "the error should have a code")
+
+	recorder = post(`[]`)
+	require.Equal(t, http.StatusBadRequest, recorder.Code, "empty batches should be rejected")
+
+	disabled := NewBatchMiddleware(0)(NewHandler(HandlerConfig{}, &svc))
+	recorder = httptest.NewRecorder()
+	disabled.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`[{"query": "{ getSavedSearches { ID } }"}]`)))
+	require.Equal(t, http.StatusBadRequest, recorder.Code, "batches should be rejected when batching is off")
+}
+
+func TestFederation(t *testing.T) {
+	searches, characters, vehicles, films := generateMockData()
+	repository := NewMockRepository(searches, characters, vehicles, films)
+	svc := CharacterServiceImpl{
+		repository:  &repository,
+		swapiClient: NewMockSWAPIClient(searches, characters, vehicles, films),
+	}
+	cfg := HandlerConfig{Federation: true}
+	h := NewLoaderMiddleware(&svc)(NewQueryLimitMiddleware(cfg, &svc, QueryLimits{MaxCost: 40})(NewHandler(cfg, &svc)))
+
+	// query sends an operation the way a federation router does
+	type graphqlResponse struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []struct {
+			Message    string                 `json:"message"`
+			Extensions map[string]interface{} `json:"extensions"`
+		} `json:"errors"`
+	}
+	query := func(h http.Handler, query string, variables map[string]interface{}) graphqlResponse {
+		body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body)))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+
+		var response graphqlResponse
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response), "responses should be JSON")
+		return response
+	}
+
+	// The router composes the SDL of the subgraph and learns the keys of its entities from it
+	response := query(h, `{ _service { sdl } }`, nil)
+	require.Empty(t, response.Errors, "_service should be resolved")
+	sdl := response.Data["_service"].(map[string]interface{})["sdl"].(string)
+	require.True(t, strings.HasPrefix(sdl, `extend schema @link(url: "https://specs.apollo.dev/federation/v2.0", import: ["@key"])`), "the SDL should opt into federation v2")
+	require.NotContains(t, sdl, "_entities", "the SDL should leave out the federation fields")
+
+	keys := map[string]string{}
+	for _, line := range strings.Split(sdl, "\n") {
+		var typename, key string
+		if _, err := fmt.Sscanf(line, "type %s @key(fields: %q) {", &typename, &key); err == nil {
+			keys[typename] = key
+		}
+	}
+	require.Equal(t, map[string]string{"Character": "id", "Film": "id", "Vehicle": "id"}, keys, "characters, films and vehicles should be entities")
+
+	// The router then resolves references to the entities by their keys, in one request for all of them
+	representations := []interface{}{
+		map[string]interface{}{"__typename": "Film", "id": "2"},
+		map[string]interface{}{"__typename": "Character", "id": "1"},
+		map[string]interface{}{"__typename": "Vehicle", "id": "3"},
+		map[string]interface{}{"__typename": "Character", "id": "missing"},
+		map[string]interface{}{"__typename": "Character", "id": "2"},
+	}
+	response = query(h, `query ($representations: [_Any!]!) {
+		_entities(representations: $representations) {
+			__typename
+			... on Character { id name }
+			... on Film { id title }
+			... on Vehicle { id model }
+		}
+	}`, map[string]interface{}{"representations": representations})
+	require.Empty(t, response.Errors, "_entities should be resolved")
+	require.Equal(t, []interface{}{
+		map[string]interface{}{"__typename": "Film", "id": "2", "title": "The Empire Strikes Back"},
+		map[string]interface{}{"__typename": "Character", "id": "1", "name": "Luke Skywalker"},
+		map[string]interface{}{"__typename": "Vehicle", "id": "3", "model": "TIE/LN starfighter"},
+		nil,
+		map[string]interface{}{"__typename": "Character", "id": "2", "name": "Darth Vader"},
+	}, response.Data["_entities"], "entities should be returned in the order of their representations")
+
+	response = query(h, `{ _entities(representations: [{__typename: "Character", id: "1"}]) { ... on Character { name } } }`, nil)
+	require.Empty(t, response.Errors, "representations should be accepted as literals")
+	require.Equal(t, []interface{}{map[string]interface{}{"name": "Luke Skywalker"}}, response.Data["_entities"], "the entity should be returned")
+
+	response = query(h, `{ _entities(representations: [{__typename: "Planet", id: "1"}]) { __typename } }`, nil)
+	require.Len(t, response.Errors, 1, "unknown entities should be rejected")
+	require.Equal(t, string(CodeInvalidArgument), response.Errors[0].Extensions["code"], "unknown entities should be invalid arguments")
+
+	// Every representation costs its selections, so routers can't get around the cost limit
+	many := []interface{}{}
+	for i := 0; i < 50; i++ {
+		many = append(many, map[string]interface{}{"__typename": "Character", "id": "1"})
+	}
+	response = query(h, `query ($representations: [_Any!]!) { _entities(representations: $representations) { ... on Character { name } } }`, map[string]interface{}{"representations": many})
+	require.Len(t, response.Errors, 1, "operations over the limits should be rejected")
+	require.Equal(t, ErrCodeQueryTooComplex, response.Errors[0].Extensions["code"], "the cost should count every representation")
+
+	response = query(NewHandler(HandlerConfig{}, &svc), `{ _service { sdl } }`, nil)
+	require.NotEmpty(t, response.Errors, "federation fields should only be there when federation is on")
+
+	// IDs are URLs of the Star Wars API; other URLs resolve to null and are never fetched
+	var swapiRequests, internalRequests int
+	swapiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		swapiRequests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer swapiServer.Close()
+	internalServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalRequests++
+		_, _ = w.Write([]byte(`{"name": "metadata", "title": "metadata", "model": "metadata"}`))
+	}))
+	defer internalServer.Close()
+
+	svc.swapiClient = NewSWAPIClient(swapiServer.Client(), swapiServer.URL)
+	response = query(h, `query ($representations: [_Any!]!) { _entities(representations: $representations) { __typename } }`, map[string]interface{}{
+		"representations": []interface{}{
+			map[string]interface{}{"__typename": "Character", "id": internalServer.URL + "/latest/meta-data/"},
+			map[string]interface{}{"__typename": "Film", "id": swapiServer.URL + "/people/1/"},
+			map[string]interface{}{"__typename": "Vehicle", "id": "../../admin"},
+		},
+	})
+	require.Empty(t, response.Errors, "invalid IDs should not fail the other entities")
+	require.Equal(t, []interface{}{nil, nil, nil}, response.Data["_entities"], "entities with invalid IDs should be null")
+	require.Zero(t, internalRequests, "URLs outside the Star Wars API should never be fetched")
+	require.Zero(t, swapiRequests, "IDs of another resource should not be looked up")
+}
+
+func TestFetchAllConcurrency(t *testing.T) {
+	ids := make([]string, 50)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i + 1)
+	}
+
+	var mu sync.Mutex
+	var running, maxRunning, fetched int
+	err := fetchAll(ids, func(id string) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		running--
+		fetched++
+		mu.Unlock()
+		return nil
+	})
+	require.NoError(t, err, "error should be nil")
+	require.Equal(t, len(ids), fetched, "every ID should be fetched")
+	require.LessOrEqual(t, maxRunning, maxConcurrentFetches, "fetches should be limited")
+	require.Greater(t, maxRunning, 1, "fetches should still run concurrently")
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	var reached int
+	h := NewBodyLimitMiddleware(64)(NewBatchMiddleware(10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached++
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ me { Username } }"}`)))
+	require.Equal(t, http.StatusOK, recorder.Code, "small bodies should be passed on")
+
+	large := `{"query":"` + strings.Repeat(" ", 100) + `{ me { Username } }"}`
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(large)))
+	require.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code, "bodies over the limit should be rejected")
+
+	// Without a Content-Length the body is only rejected once too much of it is read
+	request := httptest.NewRequest(http.MethodPost, "/graphql", io.MultiReader(strings.NewReader(large)))
+	request.ContentLength = -1
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code, "bodies over the limit should be rejected while reading")
+	require.Contains(t, recorder.Body.String(), "larger than the maximum of 64 bytes", "the limit should be in the error")
+	require.Equal(t, 1, reached, "only the small body should reach the handler")
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/models"
+)
+
+// ErrInvalidShareLink is returned for share link tokens that are malformed, expired or revoked
+var ErrInvalidShareLink error = newError(CodeNotFound, "share link is invalid, expired or revoked")
+
+// CreateShareLink - Creates a read-only link to a saved search
+// The link works until it's revoked or until expiresAt, if given
+func (c *CharacterServiceImpl) CreateShareLink(userID string, searchID string, expiresAt *time.Time) (*ShareLink, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, invalidArgument("expiresAt must be in the future")
+	}
+
+	search, err := c.getOwnedSearch(repo, userID, searchID)
+	if err != nil {
+		return nil, err
+	}
+
+	if search == nil {
+		return nil, notFound("search %s not found", searchID)
+	}
+
+	if !search.IsSaved() {
+		return nil, invalidArgument("only saved searches can be shared")
+	}
+
+	linkID := make([]byte, 8)
+	if _, err := rand.Read(linkID); err != nil {
+		return nil, fmt.Errorf("failed to generate share link ID: %w", err)
+	}
+
+	link := models.ShareLinkModel{
+		ID:        hex.EncodeToString(linkID),
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	result, err := repo.SearchRepository.AddShareLink(searchID, link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add share link: %w", c.checkStorage(err))
+	}
+
+	if !result {
+		return nil, notFound("search %s not found", searchID)
+	}
+
+	return c.toShareLink(searchID, link), nil
+}
+
+// GetShareLinks - Gets the share links of a search that can still be used
+func (c *CharacterServiceImpl) GetShareLinks(userID string, searchID string) ([]ShareLink, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if search, err := c.getOwnedSearch(repo, userID, searchID); err != nil || search == nil {
+		return nil, err
+	}
+
+	links, err := repo.SearchRepository.GetShareLinks(searchID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get share links: %w", c.checkStorage(err))
+	}
+
+	var results []ShareLink
+	for _, link := range links {
+		results = append(results, *c.toShareLink(searchID, link))
+	}
+
+	return results, nil
+}
+
+// RevokeShareLink - Revokes a share link so its token stops working
+func (c *CharacterServiceImpl) RevokeShareLink(userID string, searchID string, linkID string) (bool, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return false, err
+	}
+
+	if search, err := c.getOwnedSearch(repo, userID, searchID); err != nil || search == nil {
+		return false, err
+	}
+
+	result, err := repo.SearchRepository.RevokeShareLink(searchID, linkID)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke share link: %w", c.checkStorage(err))
+	}
+
+	return result, nil
+}
+
+// GetSharedSearch - Gets the saved search a share link token points to; no login is needed
+// The characters are returned from the snapshot, like the default view of a saved search
+func (c *CharacterServiceImpl) GetSharedSearch(token string) (*SharedSearch, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	searchID, linkID, err := c.verifyShareToken(token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature proves the token was issued here, the stored link that it wasn't revoked
+	links, err := repo.SearchRepository.GetShareLinks(searchID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get share links: %w", c.checkStorage(err))
+	}
+
+	active := false
+	for _, link := range links {
+		if link.ID == linkID {
+			active = true
+			break
+		}
+	}
+	if !active {
+		return nil, ErrInvalidShareLink
+	}
+
+	search, err := repo.SearchRepository.GetSearchesByID(searchID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get search by ID: %w", c.checkStorage(err))
+	}
+
+	if search == nil {
+		return nil, ErrInvalidShareLink
+	}
+
+	shared := &SharedSearch{
+		SearchKey: search.SearchKey,
+		Title:     search.Title,
+		Notes:     search.Notes,
+	}
+
+	if search.Snapshot != nil {
+		shared.SnapshotAt = &search.Snapshot.TakenAt
+		shared.Characters = fromSnapshot(*search.Snapshot)
+		return shared, nil
+	}
+
+	shared.Characters, err = c.hydrateCharacters(repo, search.Characters)
+	if err != nil {
+		return nil, err
+	}
+
+	return shared, nil
+}
+
+// toShareLink - Converts a share link model to the share link result, signing its token
+// Tokens aren't stored; signing the same link again gives the same token
+func (c *CharacterServiceImpl) toShareLink(searchID string, link models.ShareLinkModel) *ShareLink {
+	return &ShareLink{
+		ID:        link.ID,
+		Token:     c.signShareToken(searchID, link),
+		ExpiresAt: link.ExpiresAt,
+		CreatedAt: link.CreatedAt,
+	}
+}
+
+// signShareToken - Builds the token of a share link
+// The token is "<payload>.<signature>", both base64url encoded, where the payload is
+// "<searchID>:<linkID>:<expiry as unix seconds, 0 if none>"
+func (c *CharacterServiceImpl) signShareToken(searchID string, link models.ShareLinkModel) string {
+	var expiresAt int64
+	if link.ExpiresAt != nil {
+		expiresAt = link.ExpiresAt.Unix()
+	}
+
+	payload := fmt.Sprintf("%s:%s:%d", searchID, link.ID, expiresAt)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(c.shareSignature([]byte(payload)))
+}
+
+// verifyShareToken - Checks the signature and expiry of a share link token
+// Returns the search and link IDs it was issued for
+func (c *CharacterServiceImpl) verifyShareToken(token string, now time.Time) (string, string, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", "", ErrInvalidShareLink
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", "", ErrInvalidShareLink
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", "", ErrInvalidShareLink
+	}
+
+	if !hmac.Equal(signature, c.shareSignature(payload)) {
+		return "", "", ErrInvalidShareLink
+	}
+
+	parts := strings.Split(string(payload), ":")
+	if len(parts) != 3 {
+		return "", "", ErrInvalidShareLink
+	}
+
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", "", ErrInvalidShareLink
+	}
+
+	if expiresAt != 0 && !time.Unix(expiresAt, 0).After(now) {
+		return "", "", ErrInvalidShareLink
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// shareSignature - Returns the HMAC-SHA256 of a share link payload
+func (c *CharacterServiceImpl) shareSignature(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.shareLinkSecret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// NewSharedSearchHandler - Returns the public REST endpoint for share links
+// GET /shared/<token> responds with the saved search as JSON
+func NewSharedSearchHandler(cfg HandlerConfig, svc CharacterService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		token := strings.TrimPrefix(r.URL.Path, "/shared/")
+
+		shared, err := svc.GetSharedSearch(token)
+		switch {
+		case errors.Is(err, ErrInvalidShareLink):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": errorMessage(err, cfg.Production)})
+			return
+		case errors.Is(err, ErrStorageUnavailable):
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": errorMessage(err, cfg.Production)})
+			return
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": errorMessage(err, cfg.Production)})
+			return
+		}
+
+		type sharedCharacter struct {
+			Name          string   `json:"name"`
+			Films         []string `json:"films"`
+			VehicleModels []string `json:"vehicleModels"`
+		}
+
+		characters := []sharedCharacter{}
+		for _, character := range shared.Characters {
+			characters = append(characters, sharedCharacter{
+				Name:          character.Name,
+				Films:         character.Films,
+				VehicleModels: character.VehicleModels,
+			})
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"searchKey":  shared.SearchKey,
+			"title":      shared.Title,
+			"notes":      shared.Notes,
+			"snapshotAt": shared.SnapshotAt,
+			"characters": characters,
+		})
+	})
+}
+
+// writeJSON - Writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"time"
+
+	"alvinlucillo/swapi-app/internal/models"
+	"alvinlucillo/swapi-app/internal/repositories"
+)
+
+// takeSnapshot - Captures the fully hydrated result of a search
+func (c *CharacterServiceImpl) takeSnapshot(repo *repositories.Repository, search *models.SearchModel) (*models.SearchSnapshot, error) {
+	characters, err := c.hydrateCharacters(repo, search.Characters)
+	if err != nil {
+		return nil, err
+	}
+
+	return toSnapshot(characters, time.Now()), nil
+}
+
+// toSnapshot - Converts the characters into a snapshot taken at the given time
+func toSnapshot(characters []Character, takenAt time.Time) *models.SearchSnapshot {
+	snapshot := &models.SearchSnapshot{
+		TakenAt:    takenAt,
+		Characters: []models.SnapshotCharacter{},
+	}
+	for _, character := range characters {
+		snapshot.Characters = append(snapshot.Characters, models.SnapshotCharacter{
+			ID:            character.ID,
+			Name:          character.Name,
+			Films:         character.Films,
+			VehicleModels: character.VehicleModels,
+			FilmIDs:       character.FilmIDs,
+			VehicleIDs:    character.VehicleIDs,
+		})
+	}
+
+	return snapshot
+}
+
+// fromSnapshot - Converts a snapshot back into characters
+func fromSnapshot(snapshot models.SearchSnapshot) []Character {
+	var characters []Character
+	for _, character := range snapshot.Characters {
+		characters = append(characters, Character{
+			ID:            character.ID,
+			Name:          character.Name,
+			Films:         character.Films,
+			VehicleModels: character.VehicleModels,
+			FilmIDs:       character.FilmIDs,
+			VehicleIDs:    character.VehicleIDs,
+		})
+	}
+
+	return characters
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// ErrStorageUnavailable is returned by operations that need the database while it is down
+var ErrStorageUnavailable error = newError(CodeStorageUnavailable, "storage unavailable: saved searches can't be accessed right now, please try again later")
+
+// repo - Returns the repository, or ErrStorageUnavailable if the database is down
+func (c *CharacterServiceImpl) repo() (*repositories.Repository, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.storageDown || c.repository == nil {
+		return nil, ErrStorageUnavailable
+	}
+
+	return c.repository, nil
+}
+
+// checkStorage - Switches to degraded mode if the error means the database can't be reached.
+// Returns ErrStorageUnavailable in that case, otherwise the original error.
+func (c *CharacterServiceImpl) checkStorage(err error) error {
+	if !isStorageOutage(err) {
+		return err
+	}
+
+	c.setStorageDown(err)
+
+	return ErrStorageUnavailable
+}
+
+// setStorageDown - Switches to degraded mode
+func (c *CharacterServiceImpl) setStorageDown(reason error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.storageDown {
+		fmt.Printf("storage unavailable, switching to degraded mode: %v\n", reason)
+	}
+	c.storageDown = true
+}
+
+// isStorageOutage - Checks if the error is caused by the database being unreachable
+// rather than by the operation itself
+func isStorageOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrStorageUnavailable) {
+		return true
+	}
+
+	var selectionErr topology.ServerSelectionError
+	if errors.As(err, &selectionErr) {
+		return true
+	}
+
+	return mongo.IsNetworkError(err) ||
+		mongo.IsTimeout(err) ||
+		errors.Is(err, mongo.ErrClientDisconnected) ||
+		errors.Is(err, topology.ErrServerSelectionTimeout)
+}
+
+// MonitorStorage - Periodically pings the database and switches between normal and degraded mode.
+// When the database comes back, the repositories are initialized if they weren't yet.
+// Runs until the context is cancelled.
+func (c *CharacterServiceImpl) MonitorStorage(ctx context.Context) {
+	if c.mongoDB == nil {
+		return
+	}
+
+	interval := c.healthCheckInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.refreshStorage(ctx)
+		}
+	}
+}
+
+// refreshStorage - Updates the storage state based on a ping
+func (c *CharacterServiceImpl) refreshStorage(ctx context.Context) {
+	if err := c.mongoDB.Ping(ctx); err != nil {
+		c.setStorageDown(err)
+		return
+	}
+
+	c.mu.RLock()
+	down, repo := c.storageDown, c.repository
+	c.mu.RUnlock()
+
+	if !down && repo != nil {
+		return
+	}
+
+	// Indexes are created here if the server started while the database was down
+	if repo == nil {
+		var err error
+		repo, err = repositories.NewRepository(c.repoConfig)
+		if err != nil {
+			fmt.Printf("failed to initialize repositories: %v\n", err)
+			return
+		}
+	}
+
+	c.mu.Lock()
+	c.repository = repo
+	c.storageDown = false
+	c.mu.Unlock()
+
+	fmt.Println("storage available again, leaving degraded mode")
+}
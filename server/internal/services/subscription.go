@@ -0,0 +1,392 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// Subprotocol of the graphql-ws library, see
+// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+const graphqlTransportWSProtocol = "graphql-transport-ws"
+
+// How long a client has to send connection_init after connecting
+var subscriptionInitTimeout = 10 * time.Second
+
+// Close codes of the graphql-transport-ws protocol
+const (
+	closeInvalidMessage     = 4400
+	closeUnauthorized       = 4401
+	closeForbidden          = 4403
+	closeInitTimeout        = 4408
+	closeSubscriberExists   = 4409
+	closeTooManyInitRequest = 4429
+)
+
+// subscriptionMessage is a message of the graphql-transport-ws protocol, in either direction
+type subscriptionMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// subscribePayload is the payload of a subscribe message
+type subscribePayload struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+	Extensions    map[string]interface{} `json:"extensions"`
+}
+
+// NewSubscriptionHandler - Returns the handler of the graphql-transport-ws WebSocket endpoint.
+// Subscriptions, queries and mutations all run against the same schema as the HTTP handler.
+// Browsers can't set headers on WebSockets, so the token may also be sent in the connection_init
+// payload as {"Authorization": "Bearer <token>"} or {"token": "<token>"}; it should be wrapped in
+// the auth middleware for clients that can. Operations exceeding the limits get an error message,
+// and persisted queries are resolved like they are over HTTP unless persisted is nil.
+// Errors are formatted like the ones of the HTTP handler with the same config.
+func NewSubscriptionHandler(cfg HandlerConfig, svc CharacterService, limits QueryLimits, persisted *PersistedQueries) http.Handler {
+	schema, err := newHandlerSchema(cfg, svc)
+	if err != nil {
+		log.Printf("failed to build subscription schema: %v", err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgradeWebsocket(w, r, graphqlTransportWSProtocol)
+		if err != nil {
+			return
+		}
+
+		session := &subscriptionSession{
+			svc:           svc,
+			production:    cfg.Production,
+			schema:        schema,
+			limits:        limits,
+			persisted:     persisted,
+			conn:          conn,
+			ctx:           r.Context(),
+			subscriptions: map[string]context.CancelFunc{},
+		}
+		session.serve()
+	})
+}
+
+// subscriptionSession is the state of one graphql-transport-ws connection
+type subscriptionSession struct {
+	svc        CharacterService
+	production bool
+	schema     graphql.Schema
+	limits     QueryLimits
+	persisted  *PersistedQueries
+	conn       *websocketConn
+
+	// ctx carries the user once the connection is acknowledged
+	ctx context.Context
+
+	mu            sync.Mutex
+	initReceived  bool
+	acknowledged  bool
+	subscriptions map[string]context.CancelFunc
+	operations    sync.WaitGroup
+}
+
+// serve - Reads messages until the connection closes, then cancels every running operation
+func (s *subscriptionSession) serve() {
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.ctx = ctx
+	defer func() {
+		cancel()
+		s.operations.Wait()
+	}()
+
+	initTimer := time.AfterFunc(subscriptionInitTimeout, func() {
+		s.mu.Lock()
+		initReceived := s.initReceived
+		s.mu.Unlock()
+		if !initReceived {
+			s.conn.Close(closeInitTimeout, "Connection initialisation timeout")
+		}
+	})
+	defer initTimer.Stop()
+
+	for {
+		data, err := s.conn.ReadMessage()
+		if err != nil {
+			s.conn.Close(1000, "")
+			return
+		}
+
+		var message subscriptionMessage
+		if err := json.Unmarshal(data, &message); err != nil || message.Type == "" {
+			s.conn.Close(closeInvalidMessage, "Invalid message received")
+			return
+		}
+
+		if !s.handle(message) {
+			return
+		}
+	}
+}
+
+// handle - Handles one message; returns false once the connection was closed
+func (s *subscriptionSession) handle(message subscriptionMessage) bool {
+	switch message.Type {
+	case "connection_init":
+		return s.init(message.Payload)
+	case "ping":
+		s.send(subscriptionMessage{Type: "pong"})
+	case "pong":
+	case "subscribe":
+		return s.subscribe(message)
+	case "complete":
+		s.mu.Lock()
+		if cancel, ok := s.subscriptions[message.ID]; ok {
+			cancel()
+			delete(s.subscriptions, message.ID)
+		}
+		s.mu.Unlock()
+	default:
+		s.conn.Close(closeInvalidMessage, fmt.Sprintf("Unexpected message type %s", message.Type))
+		return false
+	}
+	return true
+}
+
+// init - Authenticates the optional token of connection_init and acknowledges the connection
+func (s *subscriptionSession) init(payload json.RawMessage) bool {
+	s.mu.Lock()
+	if s.initReceived {
+		s.mu.Unlock()
+		s.conn.Close(closeTooManyInitRequest, "Too many initialisation requests")
+		return false
+	}
+	s.initReceived = true
+	s.mu.Unlock()
+
+	var params map[string]interface{}
+	if len(payload) > 0 && string(payload) != "null" {
+		if err := json.Unmarshal(payload, &params); err != nil {
+			s.conn.Close(closeInvalidMessage, "Invalid connection_init payload")
+			return false
+		}
+	}
+
+	if token := initToken(params); token != "" {
+		user, err := s.svc.Authenticate(token)
+		switch {
+		case errors.Is(err, ErrStorageUnavailable):
+			// Same as the auth middleware: carry on anonymously while storage is down
+		case err != nil:
+			s.conn.Close(closeForbidden, "Forbidden")
+			return false
+		case user == nil:
+			s.conn.Close(closeForbidden, "Invalid, expired or revoked token")
+			return false
+		default:
+			s.ctx = withSessionToken(WithUser(s.ctx, user), token)
+		}
+	}
+
+	s.mu.Lock()
+	s.acknowledged = true
+	s.mu.Unlock()
+
+	return s.send(subscriptionMessage{Type: "connection_ack"}) == nil
+}
+
+// initToken - Returns the token of a connection_init payload, if any
+func initToken(params map[string]interface{}) string {
+	for _, key := range []string{"Authorization", "authorization"} {
+		if value, ok := params[key].(string); ok && value != "" {
+			if len(value) > len("Bearer ") && strings.EqualFold(value[:len("Bearer ")], "Bearer ") {
+				return strings.TrimSpace(value[len("Bearer "):])
+			}
+			return strings.TrimSpace(value)
+		}
+	}
+	if value, ok := params["token"].(string); ok {
+		return strings.TrimSpace(value)
+	}
+	return ""
+}
+
+// subscribe - Starts an operation; subscriptions stream until they end or the client completes them
+func (s *subscriptionSession) subscribe(message subscriptionMessage) bool {
+	s.mu.Lock()
+	acknowledged := s.acknowledged
+	s.mu.Unlock()
+	if !acknowledged {
+		s.conn.Close(closeUnauthorized, "Unauthorized")
+		return false
+	}
+
+	var payload subscribePayload
+	if message.ID == "" || json.Unmarshal(message.Payload, &payload) != nil || (payload.Query == "" && payload.Extensions == nil) {
+		s.conn.Close(closeInvalidMessage, "Invalid subscribe message")
+		return false
+	}
+
+	s.mu.Lock()
+	if _, ok := s.subscriptions[message.ID]; ok {
+		s.mu.Unlock()
+		s.conn.Close(closeSubscriberExists, fmt.Sprintf("Subscriber for %s already exists", message.ID))
+		return false
+	}
+	ctx, cancel := context.WithCancel(WithLoaders(s.ctx, NewLoaders(s.svc)))
+	s.subscriptions[message.ID] = cancel
+	s.mu.Unlock()
+
+	s.operations.Add(1)
+	go func() {
+		defer s.operations.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.subscriptions, message.ID)
+			s.mu.Unlock()
+			cancel()
+		}()
+
+		s.execute(ctx, message.ID, payload)
+	}()
+
+	return true
+}
+
+// execute - Runs an operation, sending its results as next messages followed by complete,
+// or an error message if the operation couldn't start
+func (s *subscriptionSession) execute(ctx context.Context, id string, payload subscribePayload) {
+	if s.persisted != nil {
+		query, err := s.persisted.Resolve(payload.Query, payload.Extensions)
+		if err != nil {
+			s.sendErrors(id, []gqlerrors.FormattedError{err.FormattedError()})
+			return
+		}
+		payload.Query = query
+	}
+
+	params := graphql.Params{
+		Schema:         s.schema,
+		RequestString:  payload.Query,
+		VariableValues: payload.Variables,
+		OperationName:  payload.OperationName,
+		Context:        ctx,
+	}
+
+	if _, err := s.limits.Check(&s.schema, payload.Query, payload.OperationName, payload.Variables); err != nil {
+		s.sendErrors(id, []gqlerrors.FormattedError{err.FormattedError()})
+		return
+	}
+
+	operation, err := operationType(payload.Query, payload.OperationName)
+	if err != nil {
+		s.sendErrors(id, formatErrors(gqlerrors.FormatErrors(err), s.production))
+		return
+	}
+
+	if operation != ast.OperationTypeSubscription {
+		s.sendNext(ctx, id, graphql.Do(params))
+		s.sendComplete(ctx, id)
+		return
+	}
+
+	results := graphql.Subscribe(params)
+	first := true
+	for result := range results {
+		// A subscription that failed to start has no data, only errors
+		if first && result.Data == nil && result.HasErrors() {
+			s.sendErrors(id, formatErrors(result.Errors, s.production))
+			// The executor closes the channel after an error; drain it so it never blocks
+			for range results {
+			}
+			return
+		}
+		first = false
+		s.sendNext(ctx, id, result)
+	}
+
+	s.sendComplete(ctx, id)
+}
+
+// operationType - Returns the type of the operation a request runs
+func operationType(query string, operationName string) (string, error) {
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(query)})})
+	if err != nil {
+		return "", err
+	}
+
+	var operations []*ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		if operation, ok := definition.(*ast.OperationDefinition); ok {
+			operations = append(operations, operation)
+		}
+	}
+
+	for _, operation := range operations {
+		if operationName == "" && len(operations) == 1 {
+			return operation.Operation, nil
+		}
+		if operation.Name != nil && operation.Name.Value == operationName {
+			return operation.Operation, nil
+		}
+	}
+
+	if operationName == "" {
+		return "", errors.New("must provide operation name if query contains multiple operations")
+	}
+	return "", fmt.Errorf("unknown operation named %q", operationName)
+}
+
+// sendNext - Sends a result unless the client completed the operation
+func (s *subscriptionSession) sendNext(ctx context.Context, id string, result *graphql.Result) {
+	if ctx.Err() != nil {
+		return
+	}
+	if len(result.Errors) > 0 {
+		result.Errors = formatErrors(result.Errors, s.production)
+	}
+	payload, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("failed to encode subscription result: %v", err)
+		return
+	}
+	s.send(subscriptionMessage{ID: id, Type: "next", Payload: payload})
+}
+
+// sendComplete - Tells the client the operation ended, unless the client ended it
+func (s *subscriptionSession) sendComplete(ctx context.Context, id string) {
+	if ctx.Err() != nil {
+		return
+	}
+	s.send(subscriptionMessage{ID: id, Type: "complete"})
+}
+
+// sendErrors - Sends the errors of an operation that couldn't run; no complete follows
+func (s *subscriptionSession) sendErrors(id string, errs []gqlerrors.FormattedError) {
+	payload, err := json.Marshal(errs)
+	if err != nil {
+		log.Printf("failed to encode subscription errors: %v", err)
+		return
+	}
+	s.send(subscriptionMessage{ID: id, Type: "error", Payload: payload})
+}
+
+// send - Writes a message to the client
+func (s *subscriptionSession) send(message subscriptionMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteText(data)
+}
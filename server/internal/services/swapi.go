@@ -5,8 +5,13 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 )
 
+// ErrSWAPINotFound is returned when the Star Wars API has no resource at a URL
+var ErrSWAPINotFound error = newError(CodeNotFound, "not found in the Star Wars API")
+
 // SWAPI is a client for the Star Wars API
 type SWAPIClient struct {
 	client  *http.Client
@@ -16,8 +21,10 @@ type SWAPIClient struct {
 // SWAPIQueryer is an interface for querying the Star Wars API
 type SWAPIQueryer interface {
 	QueryPeople(name string) ([]PeopleResult, error)
+	QueryPerson(personID string) (PeopleResult, error)
 	QueryFilm(filmID string) (FilmResult, error)
 	QueryVehicle(vehicleID string) (VehicleResult, error)
+	ResourceURL(resource string, id string) (string, error)
 }
 
 type PeopleResult struct {
@@ -28,13 +35,23 @@ type PeopleResult struct {
 }
 
 type VehicleResult struct {
-	Model string `json:"model"`
-	URL   string `json:"url"`
+	Name         string   `json:"name"`
+	Model        string   `json:"model"`
+	Manufacturer string   `json:"manufacturer"`
+	VehicleClass string   `json:"vehicle_class"`
+	URL          string   `json:"url"`
+	Pilots       []string `json:"pilots"`
+	Films        []string `json:"films"`
 }
 
 type FilmResult struct {
-	Title string `json:"title"`
-	URL   string `json:"url"`
+	Title       string   `json:"title"`
+	EpisodeID   int      `json:"episode_id"`
+	Director    string   `json:"director"`
+	ReleaseDate string   `json:"release_date"`
+	URL         string   `json:"url"`
+	Characters  []string `json:"characters"`
+	Vehicles    []string `json:"vehicles"`
 }
 
 func NewSWAPIClient(client *http.Client, baseURL string) SWAPIClient {
@@ -61,19 +78,45 @@ func (s SWAPIClient) QueryPeople(name string) ([]PeopleResult, error) {
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, upstreamUnavailable(fmt.Errorf("failed to send request: %w", err))
 	}
 	defer resp.Body.Close()
 
 	var response PeopleResponse
 	err = json.NewDecoder(resp.Body).Decode(&response)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		return nil, upstreamUnavailable(fmt.Errorf("failed to decode response: %w", err))
 	}
 
 	return response.Results, nil
 }
 
+// QueryPerson - queries the Star Wars API for a person with the given ID
+func (s SWAPIClient) QueryPerson(sourceUrl string) (PeopleResult, error) {
+	req, err := http.NewRequest(http.MethodGet, sourceUrl, nil)
+	if err != nil {
+		return PeopleResult{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return PeopleResult{}, upstreamUnavailable(fmt.Errorf("failed to send request: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if err := checkSWAPIStatus(resp, sourceUrl); err != nil {
+		return PeopleResult{}, err
+	}
+
+	var result PeopleResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return PeopleResult{}, upstreamUnavailable(fmt.Errorf("failed to decode response: %w", err))
+	}
+
+	return result, nil
+}
+
 // QueryFilm - queries the Star Wars API for a film with the given ID
 func (s SWAPIClient) QueryFilm(sourceUrl string) (FilmResult, error) {
 	var response FilmResult
@@ -85,13 +128,17 @@ func (s SWAPIClient) QueryFilm(sourceUrl string) (FilmResult, error) {
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return response, fmt.Errorf("failed to send request: %w", err)
+		return response, upstreamUnavailable(fmt.Errorf("failed to send request: %w", err))
 	}
 	defer resp.Body.Close()
 
+	if err := checkSWAPIStatus(resp, sourceUrl); err != nil {
+		return response, err
+	}
+
 	err = json.NewDecoder(resp.Body).Decode(&response)
 	if err != nil {
-		return response, fmt.Errorf("failed to decode response: %w", err)
+		return response, upstreamUnavailable(fmt.Errorf("failed to decode response: %w", err))
 	}
 
 	return response, nil
@@ -106,15 +153,42 @@ func (s SWAPIClient) QueryVehicle(sourceUrl string) (VehicleResult, error) {
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return VehicleResult{}, fmt.Errorf("failed to send request: %w", err)
+		return VehicleResult{}, upstreamUnavailable(fmt.Errorf("failed to send request: %w", err))
 	}
 	defer resp.Body.Close()
 
+	if err := checkSWAPIStatus(resp, sourceUrl); err != nil {
+		return VehicleResult{}, err
+	}
+
 	var result VehicleResult
 	err = json.NewDecoder(resp.Body).Decode(&result)
 	if err != nil {
-		return VehicleResult{}, fmt.Errorf("failed to decode response: %w", err)
+		return VehicleResult{}, upstreamUnavailable(fmt.Errorf("failed to decode response: %w", err))
 	}
 
 	return result, nil
 }
+
+// ResourceURL - Returns the URL of a resource of the Star Wars API, e.g. films, from its number or its URL
+// Anything else is rejected so IDs from clients can't make the server fetch other URLs
+func (s SWAPIClient) ResourceURL(resource string, id string) (string, error) {
+	prefix := s.baseURL + "/" + resource + "/"
+	number := strings.TrimSuffix(strings.TrimPrefix(id, prefix), "/")
+	if _, err := strconv.ParseUint(number, 10, 32); err != nil {
+		return "", invalidArgument("invalid %s ID %q", resource, id)
+	}
+
+	return prefix + number + "/", nil
+}
+
+// checkSWAPIStatus - Returns an error if the Star Wars API didn't return the resource at the URL
+func checkSWAPIStatus(resp *http.Response, sourceUrl string) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrSWAPINotFound, sourceUrl)
+	case resp.StatusCode >= 300:
+		return upstreamUnavailable(fmt.Errorf("unexpected status %d for %s", resp.StatusCode, sourceUrl))
+	}
+	return nil
+}
@@ -1,13 +1,363 @@
 package services
 
+import (
+	"time"
+)
+
 type Character struct {
 	ID            string
 	Name          string
 	VehicleModels []string
 	Films         []string
+	FilmIDs       []string
+	VehicleIDs    []string
+}
+
+// Film is a Star Wars film; its ID is its URL in the Star Wars API
+type Film struct {
+	ID           string
+	Title        string
+	EpisodeID    int
+	Director     string
+	ReleaseDate  string
+	CharacterIDs []string
+	VehicleIDs   []string
+}
+
+// Vehicle is a Star Wars vehicle; its ID is its URL in the Star Wars API
+type Vehicle struct {
+	ID           string
+	Name         string
+	Model        string
+	Manufacturer string
+	VehicleClass string
+	PilotIDs     []string
+	FilmIDs      []string
 }
 
 type Search struct {
 	ID        string
 	SearchKey string
+	Title     string
+	Notes     string
+	Tags      []string
+	Saved     bool
+	ExpiresAt *time.Time
+	DeletedAt *time.Time
+	// When the snapshot of the search was taken; empty if it has none
+	SnapshotAt *time.Time
+	CreatedAt  time.Time
+}
+
+// SearchView selects how the characters of a saved search are returned
+type SearchView string
+
+const (
+	// SearchViewSnapshot returns the characters as they were when the search was saved
+	SearchViewSnapshot SearchView = "SNAPSHOT"
+	// SearchViewLive rebuilds the characters from the current cached data
+	SearchViewLive SearchView = "LIVE"
+)
+
+// SearchExpiry is either an absolute expiration or a TTL; at most one can be set
+type SearchExpiry struct {
+	ExpiresAt *time.Time
+	TTL       time.Duration
+}
+
+// IsZero - Checks if neither an expiration nor a TTL was given
+func (e SearchExpiry) IsZero() bool {
+	return e.ExpiresAt == nil && e.TTL == 0
+}
+
+// resolve - Returns the expiration time relative to now
+func (e SearchExpiry) resolve(now time.Time) (time.Time, error) {
+	if e.ExpiresAt != nil && e.TTL != 0 {
+		return time.Time{}, invalidArgument("only one of expiresAt and ttl can be given")
+	}
+
+	if e.ExpiresAt != nil {
+		if !e.ExpiresAt.After(now) {
+			return time.Time{}, invalidArgument("expiresAt must be in the future")
+		}
+		return *e.ExpiresAt, nil
+	}
+
+	if e.TTL <= 0 {
+		return time.Time{}, invalidArgument("ttl must be positive")
+	}
+	return now.Add(e.TTL), nil
+}
+
+// SearchUpdateInput holds the user-editable fields of a search; nil fields are left unchanged
+type SearchUpdateInput struct {
+	Title *string
+	Notes *string
+	Tags  []string
+}
+
+// Collection groups saved searches around a topic
+type Collection struct {
+	ID          string
+	Name        string
+	Description string
+	Position    int
+	SearchIDs   []string
+	Searches    []Search
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// CollectionInput holds the editable fields of a collection; nil fields are left unchanged
+type CollectionInput struct {
+	Name        *string
+	Description *string
+	SearchIDs   []string
+}
+
+// SearchDiff is the difference between the stored result of a search and the result of running it again
+type SearchDiff struct {
+	SearchID  string
+	SearchKey string
+	// Which stored result the new one was compared to: the snapshot, or the live view if the search has none
+	ComparedTo SearchView
+	RanAt      time.Time
+	Added      []Character
+	Removed    []Character
+	Changed    []CharacterDiff
+	HasChanges bool
+}
+
+// CharacterDiff lists the films and vehicles a character gained or lost between two results
+type CharacterDiff struct {
+	ID              string
+	Name            string
+	FilmsAdded      []string
+	FilmsRemoved    []string
+	VehiclesAdded   []string
+	VehiclesRemoved []string
+}
+
+// User is an account that owns saved searches and collections
+type User struct {
+	ID       string
+	Username string
+	Role     Role
+}
+
+// Role decides what a user may do; each role can do everything the roles below it can
+type Role string
+
+const (
+	// RoleReader can query searches and collections
+	RoleReader Role = "reader"
+	// RoleEditor can also create and change searches and collections
+	RoleEditor Role = "editor"
+	// RoleAdmin can also manage API keys
+	RoleAdmin Role = "admin"
+)
+
+// APIKey is a key for scripts and services that acts as a user, limited to a role
+// The key itself is only shown once when it's created
+type APIKey struct {
+	ID        string
+	Name      string
+	Prefix    string
+	UserID    string
+	Role      Role
+	CreatedAt time.Time
+	RevokedAt *time.Time
+}
+
+// AuthSession is returned when a user registers or logs in
+// The token is sent back as "Authorization: Bearer <token>" on later requests
+type AuthSession struct {
+	Token     string
+	ExpiresAt time.Time
+	User      User
+}
+
+// ShareLink is a read-only link to a saved search for people without access to it
+type ShareLink struct {
+	ID string
+	// HMAC-signed token used with the sharedSearch query or the /shared/<token> endpoint
+	Token     string
+	ExpiresAt *time.Time
+	CreatedAt time.Time
+}
+
+// SharedSearch is what a share link shows: the saved search and its characters, without anything editable
+type SharedSearch struct {
+	SearchKey  string
+	Title      string
+	Notes      string
+	SnapshotAt *time.Time
+	Characters []Character
+}
+
+// AuditEntry is an entry of the audit log; arguments and state are JSON encoded
+type AuditEntry struct {
+	ID        string
+	ActorID   string
+	ActorName string
+	Operation string
+	TargetID  string
+	Arguments string
+	Before    string
+	After     string
+	Error     string
+	CreatedAt time.Time
+}
+
+// AuditFilter narrows down the audit log; empty fields match everything
+type AuditFilter struct {
+	ActorID   string
+	Operation string
+	TargetID  string
+	Since     *time.Time
+	Until     *time.Time
+}
+
+// AuditLogPage is a page of the audit log, newest first
+type AuditLogPage struct {
+	Entries []AuditEntry
+	// Pass as after to get the next page
+	EndCursor   string
+	HasNextPage bool
+}
+
+// SearchExport is a saved search with its characters, ready to be written to a file
+type SearchExport struct {
+	Search     Search
+	Characters []Character
+}
+
+// ImportConflict decides what happens to an imported search if the user already has it
+type ImportConflict string
+
+const (
+	// ImportConflictSkip keeps the existing search
+	ImportConflictSkip ImportConflict = "SKIP"
+	// ImportConflictOverwrite replaces the existing search with the imported one
+	ImportConflictOverwrite ImportConflict = "OVERWRITE"
+	// ImportConflictDuplicate imports the search as a new search next to the existing one
+	ImportConflictDuplicate ImportConflict = "DUPLICATE"
+)
+
+// ImportAction is what happened to an imported search
+type ImportAction string
+
+const (
+	ImportActionCreated     ImportAction = "CREATED"
+	ImportActionOverwritten ImportAction = "OVERWRITTEN"
+	ImportActionSkipped     ImportAction = "SKIPPED"
+)
+
+// ImportReport says what an import did, or would do for a dry run
+type ImportReport struct {
+	DryRun      bool
+	Created     int
+	Overwritten int
+	Skipped     int
+	Searches    []ImportedSearch
+}
+
+// ImportedSearch is what happened to a search of a bundle
+type ImportedSearch struct {
+	// ID of the search in the bundle
+	SourceID string
+	// ID of the search after the import; differs from SourceID if it was duplicated
+	SearchID  string
+	SearchKey string
+	Action    ImportAction
+}
+
+// Webhook is a URL notified when the results of saved searches change
+type Webhook struct {
+	ID  string
+	URL string
+	// Watched searches; all saved searches if empty
+	SearchIDs []string
+	// Only set when the webhook is created
+	Secret    string
+	CreatedAt time.Time
+}
+
+// WebhookDelivery is a payload sent to a webhook with every attempt made to deliver it
+type WebhookDelivery struct {
+	ID        string
+	WebhookID string
+	SearchID  string
+	Event     string
+	Payload   string
+	Succeeded bool
+	Attempts  []WebhookAttempt
+	CreatedAt time.Time
+}
+
+// WebhookAttempt is one try to deliver a payload
+type WebhookAttempt struct {
+	At         time.Time
+	StatusCode int
+	Error      string
+}
+
+// SearchSort is the order saved searches are listed in
+type SearchSort string
+
+const (
+	SearchSortCreated   SearchSort = "CREATED"
+	SearchSortTitle     SearchSort = "TITLE"
+	SearchSortSearchKey SearchSort = "SEARCH_KEY"
+)
+
+// SearchConnectionArgs selects a page of saved searches; empty fields match every search
+type SearchConnectionArgs struct {
+	First int
+	// EndCursor of the previous page
+	After string
+	Sort  SearchSort
+	// Newest first for CREATED and ascending for the other sorts if nil
+	Descending *bool
+	// Substring of the search key, ignoring case
+	SearchKey     string
+	Tag           string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// SearchConnection is a Relay connection of saved searches
+// The json tags name the fields the way Relay clients expect
+type SearchConnection struct {
+	Edges      []SearchEdge `json:"edges"`
+	PageInfo   PageInfo     `json:"pageInfo"`
+	TotalCount int          `json:"totalCount"`
+}
+
+// SearchEdge is a saved search in a connection with its cursor
+type SearchEdge struct {
+	Cursor string `json:"cursor"`
+	Node   Search `json:"node"`
+}
+
+// PageInfo is the Relay page info of a connection
+// HasPreviousPage is only whether the page was asked for after a cursor; it's not checked that searches come before it
+type PageInfo struct {
+	HasNextPage     bool   `json:"hasNextPage"`
+	HasPreviousPage bool   `json:"hasPreviousPage"`
+	StartCursor     string `json:"startCursor"`
+	EndCursor       string `json:"endCursor"`
+}
+
+// SearchProgress is an event of a search streamed by the searchProgress subscription
+// Every character found is sent once it's hydrated, then a last event with Done set
+type SearchProgress struct {
+	// The character that was hydrated; nil on the last event
+	Character *Character
+	// Number of characters hydrated so far, and found by the search
+	Count int
+	Total int
+	Done  bool
+	// ID of the recorded search on the last event; empty for anonymous users and while storage is unavailable
+	SearchID string
 }
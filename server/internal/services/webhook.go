@@ -0,0 +1,552 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"alvinlucillo/swapi-app/internal/models"
+	"alvinlucillo/swapi-app/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Event sent when the result of a saved search changes
+	webhookEventSearchChanged = "search.changed"
+	webhookSecretSize         = 32
+	// Defaults used if the watcher isn't configured
+	defaultWebhookWatchInterval = time.Hour
+	defaultWebhookMaxAttempts   = 3
+	defaultWebhookRetryDelay    = 5 * time.Second
+	defaultWebhookTimeout       = 10 * time.Second
+	// How long resolving the host of a new webhook may take
+	webhookResolveTimeout = 5 * time.Second
+	// Number of deliveries returned if no limit is given, and the most that can be asked for
+	defaultWebhookDeliveries = 20
+	maxWebhookDeliveries     = 100
+)
+
+// ErrWebhookAddressNotAllowed is returned for webhook URLs that point into the server's own network
+var ErrWebhookAddressNotAllowed error = newError(CodeInvalidArgument, "webhook URL must not point to a loopback, link-local or private address")
+
+// CreateWebhook - Registers a URL that is notified when the results of the user's saved searches change
+// Only the given searches are watched, or all saved searches if none are given.
+// The secret the payloads are signed with is only returned here.
+func (c *CharacterServiceImpl) CreateWebhook(userID string, rawURL string, searchIDs []string) (*Webhook, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if userID == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, invalidArgument("webhook URL must be an absolute http or https URL")
+	}
+
+	if err := c.checkWebhookHost(parsed.Hostname()); err != nil {
+		return nil, err
+	}
+
+	for _, searchID := range searchIDs {
+		search, err := c.getOwnedSearch(repo, userID, searchID)
+		if err != nil {
+			return nil, err
+		}
+		if search == nil {
+			return nil, fmt.Errorf("%w: %s", ErrSearchNotFound, searchID)
+		}
+	}
+
+	secretBytes := make([]byte, webhookSecretSize)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+
+	webhook := models.WebhookModel{
+		ID:        primitive.NewObjectID(),
+		Owner:     userID,
+		URL:       parsed.String(),
+		Secret:    hex.EncodeToString(secretBytes),
+		SearchIDs: searchIDs,
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := repo.WebhookRepository.AddWebhook(webhook); err != nil {
+		return nil, fmt.Errorf("failed to add webhook: %w", c.checkStorage(err))
+	}
+
+	result := toWebhook(webhook)
+	result.Secret = webhook.Secret
+	return &result, nil
+}
+
+// checkWebhookHost - Checks that every address of the host of a webhook is public, unless private networks are allowed
+// Deliveries check the address again when they connect, see newWebhookClient, since DNS may change in between.
+func (c *CharacterServiceImpl) checkWebhookHost(host string) error {
+	if c.webhookAllowPrivate {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookResolveTimeout)
+	defer cancel()
+
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addresses) == 0 {
+		return invalidArgument("webhook host %s can't be resolved", host)
+	}
+
+	for _, address := range addresses {
+		if !isPublicIP(address.IP) {
+			return ErrWebhookAddressNotAllowed
+		}
+	}
+
+	return nil
+}
+
+// isPublicIP - Checks if an IP address is outside the loopback, link-local, private and unspecified ranges
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast()
+}
+
+// newWebhookClient - Returns the client webhooks are delivered with
+// Unless private networks are allowed, it refuses to connect to addresses that aren't public, which also covers
+// hosts that resolve to another address than when the webhook was created and redirects into the network.
+// It doesn't use a proxy, so the address checked is the one of the webhook.
+func newWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return ErrWebhookAddressNotAllowed
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// GetWebhooks - Gets the webhooks of a user, without their secrets
+func (c *CharacterServiceImpl) GetWebhooks(userID string) ([]Webhook, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if userID == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	webhooks, err := repo.WebhookRepository.GetWebhooks(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %w", c.checkStorage(err))
+	}
+
+	results := []Webhook{}
+	for _, webhook := range webhooks {
+		results = append(results, toWebhook(webhook))
+	}
+
+	return results, nil
+}
+
+// DeleteWebhook - Deletes a webhook of a user
+func (c *CharacterServiceImpl) DeleteWebhook(userID string, webhookID string) (bool, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return false, err
+	}
+
+	if userID == "" {
+		return false, ErrUnauthenticated
+	}
+
+	result, err := repo.WebhookRepository.DeleteWebhook(webhookID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete webhook: %w", c.checkStorage(err))
+	}
+
+	return result, nil
+}
+
+// GetWebhookDeliveries - Gets the latest deliveries of a webhook of the user, newest first
+func (c *CharacterServiceImpl) GetWebhookDeliveries(userID string, webhookID string, limit int) ([]WebhookDelivery, error) {
+	repo, err := c.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err := c.getOwnedWebhook(repo, userID, webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	if webhook == nil {
+		return nil, notFound("webhook %s not found", webhookID)
+	}
+
+	if limit <= 0 {
+		limit = defaultWebhookDeliveries
+	}
+	if limit > maxWebhookDeliveries {
+		return nil, invalidArgument("limit can't be more than %d", maxWebhookDeliveries)
+	}
+
+	deliveries, err := repo.WebhookRepository.GetDeliveries(webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook deliveries: %w", c.checkStorage(err))
+	}
+
+	results := []WebhookDelivery{}
+	for _, delivery := range deliveries {
+		result := WebhookDelivery{
+			ID:        delivery.ID.Hex(),
+			WebhookID: delivery.WebhookID,
+			SearchID:  delivery.SearchID,
+			Event:     delivery.Event,
+			Payload:   delivery.Payload,
+			Succeeded: delivery.Succeeded,
+			CreatedAt: delivery.CreatedAt,
+		}
+		for _, attempt := range delivery.Attempts {
+			result.Attempts = append(result.Attempts, WebhookAttempt{
+				At:         attempt.At,
+				StatusCode: attempt.StatusCode,
+				Error:      attempt.Error,
+			})
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// getOwnedWebhook - Returns a webhook if it belongs to the user, or nil if it doesn't exist or belongs to someone else
+func (c *CharacterServiceImpl) getOwnedWebhook(repo *repositories.Repository, userID string, webhookID string) (*models.WebhookModel, error) {
+	if userID == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	webhooks, err := repo.WebhookRepository.GetWebhooks(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %w", c.checkStorage(err))
+	}
+
+	for i := range webhooks {
+		if webhooks[i].ID.Hex() == webhookID {
+			return &webhooks[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// WatchSearches - Periodically re-runs the saved searches that have webhooks and notifies them of changes.
+// Runs until the context is cancelled.
+func (c *CharacterServiceImpl) WatchSearches(ctx context.Context) {
+	interval := c.webhookWatchInterval
+	if interval <= 0 {
+		interval = defaultWebhookWatchInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.CheckWatchedSearches(ctx); err != nil && !errors.Is(err, ErrStorageUnavailable) {
+				fmt.Printf("failed to check watched searches: %v\n", err)
+			}
+		}
+	}
+}
+
+// CheckWatchedSearches - Re-runs every saved search watched by a webhook once and notifies its webhooks
+// if the result changed. Each change is only sent once: the new result is kept as the pending re-run
+// of the search, and a later run that finds the same result isn't reported again.
+func (c *CharacterServiceImpl) CheckWatchedSearches(ctx context.Context) error {
+	repo, err := c.repo()
+	if err != nil {
+		return err
+	}
+
+	if c.swapiClient == nil {
+		return fmt.Errorf("the SWAPI is not configured")
+	}
+
+	webhooks, err := repo.WebhookRepository.GetAllWebhooks()
+	if err != nil {
+		return fmt.Errorf("failed to get webhooks: %w", c.checkStorage(err))
+	}
+
+	webhooksByOwner := map[string][]models.WebhookModel{}
+	var owners []string
+	for _, webhook := range webhooks {
+		if _, ok := webhooksByOwner[webhook.Owner]; !ok {
+			owners = append(owners, webhook.Owner)
+		}
+		webhooksByOwner[webhook.Owner] = append(webhooksByOwner[webhook.Owner], webhook)
+	}
+
+	for _, owner := range owners {
+		searches, err := repo.SearchRepository.GetSearches(repositories.SearchFilter{Owner: owner})
+		if err != nil {
+			return fmt.Errorf("failed to get searches: %w", c.checkStorage(err))
+		}
+
+		for _, search := range searches {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			var watchers []models.WebhookModel
+			for _, webhook := range webhooksByOwner[owner] {
+				if watchesSearch(webhook, search.ID.Hex()) {
+					watchers = append(watchers, webhook)
+				}
+			}
+			if len(watchers) == 0 {
+				continue
+			}
+
+			diff, result, err := c.rerunSearch(repo, search)
+			if err != nil {
+				// One search failing upstream shouldn't stop the others from being checked
+				fmt.Printf("failed to re-run search %s: %v\n", search.ID.Hex(), err)
+				continue
+			}
+
+			// The watcher keeps its own last result so a change is reported once,
+			// whatever the user does with their pending re-run
+			isNew := search.WatchedResult == nil || diffCharacters(fromSnapshot(*search.WatchedResult), fromSnapshot(*result)).HasChanges
+			if _, err := repo.SearchRepository.SetWatchedResult(search.ID.Hex(), *result); err != nil {
+				fmt.Printf("failed to store watched result of search %s: %v\n", search.ID.Hex(), c.checkStorage(err))
+				continue
+			}
+			if !diff.HasChanges || !isNew {
+				continue
+			}
+
+			for _, webhook := range watchers {
+				c.deliverWebhook(ctx, repo, webhook, search, *diff)
+			}
+		}
+	}
+
+	return nil
+}
+
+// watchesSearch - Checks if a webhook is notified of changes to the search
+func watchesSearch(webhook models.WebhookModel, searchID string) bool {
+	if len(webhook.SearchIDs) == 0 {
+		return true
+	}
+	for _, id := range webhook.SearchIDs {
+		if id == searchID {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverWebhook - POSTs the change of a search to a webhook, retrying failed attempts, and logs the delivery
+func (c *CharacterServiceImpl) deliverWebhook(ctx context.Context, repo *repositories.Repository, webhook models.WebhookModel, search models.SearchModel, diff SearchDiff) {
+	delivery := models.WebhookDeliveryModel{
+		ID:        primitive.NewObjectID(),
+		WebhookID: webhook.ID.Hex(),
+		SearchID:  search.ID.Hex(),
+		Event:     webhookEventSearchChanged,
+		CreatedAt: time.Now(),
+	}
+
+	payload, err := json.Marshal(newWebhookPayload(delivery, search, diff))
+	if err != nil {
+		fmt.Printf("failed to encode webhook payload: %v\n", err)
+		return
+	}
+	delivery.Payload = string(payload)
+
+	maxAttempts := c.webhookMaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultWebhookMaxAttempts
+	}
+	delay := c.webhookRetryDelay
+	if delay <= 0 {
+		delay = defaultWebhookRetryDelay
+	}
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		result, retry := c.postWebhook(ctx, webhook, delivery, payload)
+		delivery.Attempts = append(delivery.Attempts, result)
+		if !retry {
+			delivery.Succeeded = result.Error == ""
+			break
+		}
+
+		if attempt == maxAttempts {
+			break
+		}
+
+		// Back off exponentially between attempts
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay << (attempt - 1)):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if err := repo.WebhookRepository.AddDelivery(delivery); err != nil {
+		fmt.Printf("failed to log webhook delivery: %v\n", c.checkStorage(err))
+	}
+}
+
+// postWebhook - Makes one attempt to deliver a payload
+// Network errors, 429 and 5xx responses are retried; other responses are final
+func (c *CharacterServiceImpl) postWebhook(ctx context.Context, webhook models.WebhookModel, delivery models.WebhookDeliveryModel, payload []byte) (models.WebhookAttempt, bool) {
+	attempt := models.WebhookAttempt{At: time.Now()}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+
+	timestamp := strconv.FormatInt(attempt.At.Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Webhook-Event", delivery.Event)
+	request.Header.Set("X-Webhook-Delivery", delivery.ID.Hex())
+	request.Header.Set("X-Webhook-Timestamp", timestamp)
+	request.Header.Set("X-Webhook-Signature", "sha256="+SignWebhookPayload(webhook.Secret, timestamp, payload))
+
+	client := c.webhookClient
+	if client == nil {
+		client = newWebhookClient(defaultWebhookTimeout, c.webhookAllowPrivate)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		attempt.Error = err.Error()
+		// The address won't become public by retrying
+		return attempt, !errors.Is(err, ErrWebhookAddressNotAllowed)
+	}
+	defer response.Body.Close()
+
+	attempt.StatusCode = response.StatusCode
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return attempt, false
+	}
+
+	attempt.Error = response.Status
+	return attempt, response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+}
+
+// SignWebhookPayload - Returns the hex encoded HMAC-SHA256 of "<timestamp>.<payload>" with the webhook secret
+// Receivers compute the same and compare it with the X-Webhook-Signature header
+func SignWebhookPayload(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookPayload - Builds the JSON body sent to webhooks when a search changes
+func newWebhookPayload(delivery models.WebhookDeliveryModel, search models.SearchModel, diff SearchDiff) interface{} {
+	type payloadCharacter struct {
+		ID            string   `json:"id"`
+		Name          string   `json:"name"`
+		Films         []string `json:"films"`
+		VehicleModels []string `json:"vehicleModels"`
+	}
+
+	type payloadChange struct {
+		ID              string   `json:"id"`
+		Name            string   `json:"name"`
+		FilmsAdded      []string `json:"filmsAdded"`
+		FilmsRemoved    []string `json:"filmsRemoved"`
+		VehiclesAdded   []string `json:"vehiclesAdded"`
+		VehiclesRemoved []string `json:"vehiclesRemoved"`
+	}
+
+	toCharacters := func(characters []Character) []payloadCharacter {
+		result := []payloadCharacter{}
+		for _, character := range characters {
+			result = append(result, payloadCharacter{
+				ID:            character.ID,
+				Name:          character.Name,
+				Films:         nonNilStrings(character.Films),
+				VehicleModels: nonNilStrings(character.VehicleModels),
+			})
+		}
+		return result
+	}
+
+	changed := []payloadChange{}
+	for _, change := range diff.Changed {
+		changed = append(changed, payloadChange{
+			ID:              change.ID,
+			Name:            change.Name,
+			FilmsAdded:      nonNilStrings(change.FilmsAdded),
+			FilmsRemoved:    nonNilStrings(change.FilmsRemoved),
+			VehiclesAdded:   nonNilStrings(change.VehiclesAdded),
+			VehiclesRemoved: nonNilStrings(change.VehiclesRemoved),
+		})
+	}
+
+	return map[string]interface{}{
+		"event":      delivery.Event,
+		"deliveryID": delivery.ID.Hex(),
+		"webhookID":  delivery.WebhookID,
+		"search": map[string]string{
+			"id":        search.ID.Hex(),
+			"searchKey": search.SearchKey,
+			"title":     search.Title,
+		},
+		"comparedTo": diff.ComparedTo,
+		"ranAt":      diff.RanAt.UTC(),
+		"added":      toCharacters(diff.Added),
+		"removed":    toCharacters(diff.Removed),
+		"changed":    changed,
+	}
+}
+
+// toWebhook - Converts a webhook model to the webhook result, without its secret
+func toWebhook(webhook models.WebhookModel) Webhook {
+	return Webhook{
+		ID:        webhook.ID.Hex(),
+		URL:       webhook.URL,
+		SearchIDs: webhook.SearchIDs,
+		CreatedAt: webhook.CreatedAt,
+	}
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The subset of RFC 6455 the subscription handler needs: text messages, ping/pong and close.
+// Extensions such as compression are never negotiated.
+
+const (
+	// GUID every server appends to the client key to prove it understands WebSocket
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// Largest message accepted from a client; GraphQL documents are far smaller
+	maxWebsocketMessageSize = 1 << 20
+	websocketWriteTimeout   = 10 * time.Second
+
+	websocketOpContinuation = 0x0
+	websocketOpText         = 0x1
+	websocketOpBinary       = 0x2
+	websocketOpClose        = 0x8
+	websocketOpPing         = 0x9
+	websocketOpPong         = 0xA
+)
+
+// errWebsocketClosed is returned by ReadMessage once the client closed the connection
+var errWebsocketClosed = errors.New("websocket closed")
+
+// websocketConn is a server side WebSocket connection
+// Reads must come from a single goroutine; writes are safe from many
+type websocketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+// upgradeWebsocket - Completes the WebSocket handshake with the given subprotocol
+// The client must have asked for the subprotocol; otherwise a 400 response is written
+func upgradeWebsocket(w http.ResponseWriter, r *http.Request, subprotocol string) (*websocketConn, error) {
+	fail := func(message string) (*websocketConn, error) {
+		http.Error(w, message, http.StatusBadRequest)
+		return nil, errors.New(message)
+	}
+
+	if r.Method != http.MethodGet {
+		return fail("websocket upgrades must use GET")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return fail("expected a websocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return fail("missing Sec-WebSocket-Key")
+	}
+	if !headerContains(r.Header, "Sec-WebSocket-Protocol", subprotocol) {
+		return fail(fmt.Sprintf("unsupported subprotocol, expected %s", subprotocol))
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websockets are not supported", http.StatusInternalServerError)
+		return nil, errors.New("response writer can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hijack connection: %w", err)
+	}
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n" +
+		"Sec-WebSocket-Protocol: " + subprotocol + "\r\n\r\n"
+
+	conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write handshake: %w", err)
+	}
+	// Deadlines the HTTP server set no longer apply to a long lived connection
+	conn.SetDeadline(time.Time{})
+
+	return &websocketConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// headerContains - Checks if a comma separated header has the token, ignoring case
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage - Reads the next text or binary message, answering pings on the way
+// Returns errWebsocketClosed once the client closes the connection
+func (c *websocketConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	started := false
+
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case websocketOpPing:
+			if err := c.writeFrame(websocketOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case websocketOpPong:
+			continue
+		case websocketOpClose:
+			code := uint16(1000)
+			if len(payload) >= 2 {
+				code = binary.BigEndian.Uint16(payload)
+			}
+			c.Close(code, "")
+			return nil, errWebsocketClosed
+		case websocketOpText, websocketOpBinary:
+			if started {
+				return nil, c.protocolError("new message before the previous one ended")
+			}
+			started = true
+		case websocketOpContinuation:
+			if !started {
+				return nil, c.protocolError("continuation without a message")
+			}
+		default:
+			return nil, c.protocolError(fmt.Sprintf("unknown opcode %d", opcode))
+		}
+
+		if len(message)+len(payload) > maxWebsocketMessageSize {
+			c.Close(1009, "message too big")
+			return nil, errors.New("websocket message too big")
+		}
+		message = append(message, payload...)
+
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// readFrame - Reads one frame and unmasks its payload
+func (c *websocketConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.protocolError("reserved bits set without an extension")
+	}
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	if !masked {
+		return false, 0, nil, c.protocolError("client frames must be masked")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	if opcode >= websocketOpClose && (length > 125 || !fin) {
+		return false, 0, nil, c.protocolError("invalid control frame")
+	}
+	if length > maxWebsocketMessageSize {
+		c.Close(1009, "message too big")
+		return false, 0, nil, errors.New("websocket frame too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// protocolError - Closes the connection because the client broke the WebSocket protocol
+func (c *websocketConn) protocolError(reason string) error {
+	c.Close(1002, reason)
+	return fmt.Errorf("websocket protocol error: %s", reason)
+}
+
+// WriteText - Sends a text message in a single frame
+func (c *websocketConn) WriteText(message []byte) error {
+	return c.writeFrame(websocketOpText, message)
+}
+
+// writeFrame - Writes one unmasked frame, as servers must
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closed {
+		return errWebsocketClosed
+	}
+
+	frame := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	frame = append(frame, payload...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close - Sends a close frame with the code and reason, then closes the connection
+// Closing more than once does nothing
+func (c *websocketConn) Close(code uint16, reason string) {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	// Control frames carry at most 125 bytes
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload = append(payload, reason...)
+
+	// Best effort; the connection is closed either way
+	c.writeFrame(websocketOpClose, payload)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.conn.Close()
+	}
+}
@@ -1,7 +1,56 @@
-import { ApolloClient, InMemoryCache } from "@apollo/client/core";
+import { ApolloClient, ApolloLink, InMemoryCache } from "@apollo/client/core";
+import { BatchHttpLink } from "@apollo/client/link/batch-http";
+import { createPersistedQueryLink } from "@apollo/client/link/persisted-queries";
+import { onError } from "@apollo/client/link/error";
+import { sessionToken, setToken } from "./token";
+
+// Hash queries with the Web Crypto API so only their hash is sent once the server knows them
+const sha256 = async (query: string): Promise<string> => {
+  const digest = await crypto.subtle.digest(
+    "SHA-256",
+    new TextEncoder().encode(query)
+  );
+  return Array.from(new Uint8Array(digest))
+    .map((byte) => byte.toString(16).padStart(2, "0"))
+    .join("");
+};
+
+// Operations sent within a few milliseconds of each other share a request, up to the server's MAX_BATCH_SIZE
+const httpLink = new BatchHttpLink({
+  uri: process.env.GRAPHQL_URI || "http://localhost:8080/graphql",
+  batchMax: 10,
+  batchInterval: 20,
+});
+
+// Send the session token of the logged in user, if there's one, with every operation
+const authLink = new ApolloLink((operation, forward) => {
+  const token = sessionToken.value;
+  if (token) {
+    operation.setContext(({ headers = {} }) => ({
+      headers: { ...headers, Authorization: `Bearer ${token}` },
+    }));
+  }
+  return forward(operation);
+});
+
+// The server rejects expired or revoked tokens with a 401; the session is dropped so the next operations are anonymous
+const expiredSessionLink = onError(({ networkError }) => {
+  if (
+    networkError &&
+    "statusCode" in networkError &&
+    networkError.statusCode === 401
+  ) {
+    setToken(null);
+  }
+});
 
 // Create the apollo client for querying the graphql server
 export const apolloClient = new ApolloClient({
-  uri: process.env.GRAPHQL_URI || "http://localhost:8080/graphql",
+  link: ApolloLink.from([
+    expiredSessionLink,
+    authLink,
+    createPersistedQueryLink({ sha256 }),
+    httpLink,
+  ]),
   cache: new InMemoryCache(),
 });
@@ -0,0 +1,133 @@
+import { defineStore } from "pinia";
+import { gql } from "graphql-tag";
+import { computed, ref } from "vue";
+import { apolloClient } from "./apollo";
+import { sessionToken, setToken } from "./token";
+
+export interface User {
+  ID: string;
+  Username: string;
+  Role: string;
+}
+
+export interface AuthSession {
+  Token: string;
+  ExpiresAt: string;
+  User: User;
+}
+
+export const useAuthStore = defineStore("auth", () => {
+  // The logged in user; undefined when anonymous
+  const user = ref<User>();
+  // A token the server rejected is dropped by the apollo client, which logs the user out
+  const isLoggedIn = computed(
+    () => user.value !== undefined && sessionToken.value !== null
+  );
+
+  // Keeps the session and drops the results cached for the previous user
+  const startSession = async (session: AuthSession) => {
+    setToken(session.Token);
+    user.value = session.User;
+    await apolloClient.clearStore();
+  };
+
+  const register = async (username: string, password: string) => {
+    const mutation = gql`
+      mutation Register($username: String!, $password: String!) {
+        register(username: $username, password: $password) {
+          Token
+          ExpiresAt
+          User {
+            ID
+            Username
+            Role
+          }
+        }
+      }
+    `;
+    const { data } = await apolloClient.mutate({
+      mutation: mutation,
+      variables: {
+        username: username,
+        password: password,
+      },
+    });
+
+    await startSession(data.register);
+  };
+
+  const login = async (username: string, password: string) => {
+    const mutation = gql`
+      mutation Login($username: String!, $password: String!) {
+        login(username: $username, password: $password) {
+          Token
+          ExpiresAt
+          User {
+            ID
+            Username
+            Role
+          }
+        }
+      }
+    `;
+    const { data } = await apolloClient.mutate({
+      mutation: mutation,
+      variables: {
+        username: username,
+        password: password,
+      },
+    });
+
+    await startSession(data.login);
+  };
+
+  const logout = async () => {
+    const mutation = gql`
+      mutation Logout {
+        logout
+      }
+    `;
+    try {
+      await apolloClient.mutate({ mutation: mutation });
+    } finally {
+      // The session is dropped locally even if the server already expired it
+      setToken(null);
+      user.value = undefined;
+      await apolloClient.clearStore();
+    }
+  };
+
+  // Restores the user of a token kept from a previous visit; expired tokens are dropped
+  const loadUser = async () => {
+    if (!sessionToken.value) return;
+
+    const query = gql`
+      query Me {
+        me {
+          ID
+          Username
+          Role
+        }
+      }
+    `;
+    try {
+      const { data } = await apolloClient.query({
+        query: query,
+        fetchPolicy: "network-only",
+      });
+      if (data.me) user.value = data.me;
+      else setToken(null);
+    } catch {
+      user.value = undefined;
+    }
+  };
+
+  return {
+    user,
+    isLoggedIn,
+    register,
+    login,
+    logout,
+    loadUser,
+  };
+});
@@ -0,0 +1,13 @@
+import { ref } from "vue";
+
+// The session token is kept in local storage so the user stays logged in across reloads
+const tokenKey = "swapi-session-token";
+
+// The token of the logged in user; null when anonymous
+export const sessionToken = ref<string | null>(localStorage.getItem(tokenKey));
+
+export const setToken = (token: string | null) => {
+  sessionToken.value = token;
+  if (token) localStorage.setItem(tokenKey, token);
+  else localStorage.removeItem(tokenKey);
+};